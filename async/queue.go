@@ -0,0 +1,153 @@
+// Copyright (c) 2022 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package async
+
+import (
+	"sync"
+	"time"
+
+	"go.uber.org/atomic"
+)
+
+// queue is a bounded ring buffer of reports with a configurable policy
+// for when it is full.
+type queue struct {
+	mu       sync.Mutex
+	items    []report
+	head     int
+	size     int
+	closed   bool
+	policy   Policy
+	timeout  time.Duration
+	dropped  atomic.Int64
+	notEmpty chan struct{}
+	notFull  chan struct{}
+	donech   chan struct{}
+}
+
+func newQueue(opts Options) *queue {
+	return &queue{
+		items:    make([]report, opts.QueueSize),
+		policy:   opts.Policy,
+		timeout:  opts.BlockTimeout,
+		notEmpty: make(chan struct{}, 1),
+		notFull:  make(chan struct{}, 1),
+		donech:   make(chan struct{}),
+	}
+}
+
+func (q *queue) push(rep report) {
+	var timeout <-chan time.Time
+	for {
+		q.mu.Lock()
+		if q.closed {
+			q.mu.Unlock()
+			q.dropped.Inc()
+			return
+		}
+
+		if q.size < len(q.items) {
+			q.items[(q.head+q.size)%len(q.items)] = rep
+			q.size++
+			hasRoom := q.size < len(q.items)
+			q.mu.Unlock()
+
+			signal(q.notEmpty)
+			if hasRoom {
+				// Wake up any other blocked writer.
+				signal(q.notFull)
+			}
+			return
+		}
+
+		switch q.policy {
+		case DropOldest:
+			q.items[q.head] = rep
+			q.head = (q.head + 1) % len(q.items)
+			q.mu.Unlock()
+			q.dropped.Inc()
+			signal(q.notEmpty)
+			return
+		case Block:
+			q.mu.Unlock()
+			if timeout == nil && q.timeout > 0 {
+				t := time.NewTimer(q.timeout)
+				defer t.Stop()
+				timeout = t.C
+			}
+
+			select {
+			case <-q.notFull:
+				continue
+			case <-timeout:
+			case <-q.donech:
+			}
+			q.dropped.Inc()
+			return
+		default:
+			q.mu.Unlock()
+			q.dropped.Inc()
+			return
+		}
+	}
+}
+
+// popAll appends all queued reports to dst in the order they were pushed.
+func (q *queue) popAll(dst []report) []report {
+	q.mu.Lock()
+	for i := 0; i < q.size; i++ {
+		idx := (q.head + i) % len(q.items)
+		dst = append(dst, q.items[idx])
+		q.items[idx] = report{}
+	}
+	n := q.size
+	q.head = 0
+	q.size = 0
+	q.mu.Unlock()
+
+	if n > 0 {
+		signal(q.notFull)
+	}
+	return dst
+}
+
+func (q *queue) len() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.size
+}
+
+func (q *queue) close() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.closed {
+		return
+	}
+	q.closed = true
+	close(q.donech)
+}
+
+func signal(ch chan struct{}) {
+	select {
+	case ch <- struct{}{}:
+	default:
+	}
+}
@@ -0,0 +1,489 @@
+// Copyright (c) 2022 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package async
+
+import (
+	"io"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	tally "github.com/uber-go/tally/v4"
+	"go.uber.org/atomic"
+)
+
+// Policy describes what the reporter does with a report when its queue
+// is full.
+type Policy int
+
+const (
+	// DropNewest discards the report being enqueued when the queue is full.
+	DropNewest Policy = iota
+
+	// DropOldest evicts the oldest queued report to make room for the
+	// report being enqueued.
+	DropOldest
+
+	// Block waits for room in the queue for up to Options.BlockTimeout
+	// before discarding the report being enqueued.
+	Block
+)
+
+const (
+	// DefaultQueueSize is the default async reporter queue size.
+	DefaultQueueSize = 4096
+)
+
+var errAlreadyClosed = errors.New("reporter already closed")
+
+// Options is a set of options for the async reporters.
+type Options struct {
+	// QueueSize is the maximum number of reports buffered before the
+	// Policy is applied. By default this will be set to DefaultQueueSize.
+	QueueSize int
+
+	// Policy is what to do when the queue is full, by default the
+	// newest report is dropped.
+	Policy Policy
+
+	// BlockTimeout is how long to wait for room in the queue when using
+	// the Block policy. Use zero to wait indefinitely.
+	BlockTimeout time.Duration
+}
+
+// QueueStats describes the state of an async reporter queue.
+type QueueStats interface {
+	// QueueDepth returns the number of reports waiting to be delivered.
+	QueueDepth() int
+
+	// Dropped returns the total number of reports dropped due to
+	// the queue being full or the reporter being closed.
+	Dropped() int64
+}
+
+// Reporter is an asynchronous tally.StatsReporter.
+type Reporter interface {
+	tally.StatsReporter
+	QueueStats
+	io.Closer
+}
+
+// CachedReporter is an asynchronous tally.CachedStatsReporter.
+type CachedReporter interface {
+	tally.CachedStatsReporter
+	QueueStats
+	io.Closer
+}
+
+// NewReporter wraps a tally.StatsReporter so that all reports are placed
+// on a bounded queue and delivered to it from a background goroutine.
+func NewReporter(r tally.StatsReporter, opts Options) Reporter {
+	return &reporter{
+		processor: newProcessor(r, r, opts),
+	}
+}
+
+// NewCachedReporter wraps a tally.CachedStatsReporter so that all reports
+// are placed on a bounded queue and delivered to it from a background
+// goroutine.
+func NewCachedReporter(r tally.CachedStatsReporter, opts Options) CachedReporter {
+	return &cachedReporter{
+		processor: newProcessor(r, nil, opts),
+		cached:    r,
+	}
+}
+
+type reportType int
+
+const (
+	counterReport reportType = iota + 1
+	gaugeReport
+	timerReport
+	histogramValueReport
+	histogramDurationReport
+	cachedCountReport
+	cachedGaugeReport
+	cachedTimerReport
+	cachedSamplesReport
+)
+
+type report struct {
+	rtype              reportType
+	name               string
+	tags               map[string]string
+	buckets            tally.Buckets
+	valueLowerBound    float64
+	valueUpperBound    float64
+	durationLowerBound time.Duration
+	durationUpperBound time.Duration
+	count              int64
+	gauge              float64
+	interval           time.Duration
+	cachedCount        tally.CachedCount
+	cachedGauge        tally.CachedGauge
+	cachedTimer        tally.CachedTimer
+	cachedBucket       tally.CachedHistogramBucket
+}
+
+type processor struct {
+	base     tally.BaseStatsReporter
+	reporter tally.StatsReporter
+	queue    *queue
+	closed   atomic.Bool
+	flushReq atomic.Bool
+	flushCh  chan struct{}
+	donech   chan struct{}
+	wg       sync.WaitGroup
+}
+
+func newProcessor(
+	base tally.BaseStatsReporter,
+	r tally.StatsReporter,
+	opts Options,
+) *processor {
+	if opts.QueueSize <= 0 {
+		opts.QueueSize = DefaultQueueSize
+	}
+
+	p := &processor{
+		base:     base,
+		reporter: r,
+		queue:    newQueue(opts),
+		flushCh:  make(chan struct{}, 1),
+		donech:   make(chan struct{}),
+	}
+
+	p.wg.Add(1)
+	go func() {
+		defer p.wg.Done()
+		p.process()
+	}()
+
+	return p
+}
+
+func (p *processor) process() {
+	batch := make([]report, 0, len(p.queue.items))
+	for {
+		batch = p.drain(batch)
+
+		select {
+		case <-p.queue.notEmpty:
+		case <-p.flushCh:
+		case <-p.donech:
+			// Final drain and flush.
+			p.flushReq.Store(true)
+			p.drain(batch)
+			return
+		}
+	}
+}
+
+func (p *processor) drain(batch []report) []report {
+	// A flush requested before the queue is popped follows the reports
+	// popped, one requested later waits for the next drain so that the
+	// reports queued before it are delivered first.
+	flush := p.flushReq.Swap(false)
+	batch = p.queue.popAll(batch)
+	for i := range batch {
+		p.deliver(&batch[i])
+		batch[i] = report{}
+	}
+
+	if flush {
+		p.base.Flush()
+	}
+	return batch[:0]
+}
+
+func (p *processor) deliver(rep *report) {
+	switch rep.rtype {
+	case counterReport:
+		p.reporter.ReportCounter(rep.name, rep.tags, rep.count)
+	case gaugeReport:
+		p.reporter.ReportGauge(rep.name, rep.tags, rep.gauge)
+	case timerReport:
+		p.reporter.ReportTimer(rep.name, rep.tags, rep.interval)
+	case histogramValueReport:
+		p.reporter.ReportHistogramValueSamples(
+			rep.name,
+			rep.tags,
+			rep.buckets,
+			rep.valueLowerBound,
+			rep.valueUpperBound,
+			rep.count,
+		)
+	case histogramDurationReport:
+		p.reporter.ReportHistogramDurationSamples(
+			rep.name,
+			rep.tags,
+			rep.buckets,
+			rep.durationLowerBound,
+			rep.durationUpperBound,
+			rep.count,
+		)
+	case cachedCountReport:
+		rep.cachedCount.ReportCount(rep.count)
+	case cachedGaugeReport:
+		rep.cachedGauge.ReportGauge(rep.gauge)
+	case cachedTimerReport:
+		rep.cachedTimer.ReportTimer(rep.interval)
+	case cachedSamplesReport:
+		rep.cachedBucket.ReportSamples(rep.count)
+	}
+}
+
+func (p *processor) Capabilities() tally.Capabilities {
+	return p.base.Capabilities()
+}
+
+// Flush asks the background goroutine to flush the underlying reporter
+// once every report queued so far has been delivered.
+func (p *processor) Flush() {
+	if p.closed.Load() {
+		return
+	}
+
+	p.flushReq.Store(true)
+	select {
+	case p.flushCh <- struct{}{}:
+	default:
+	}
+}
+
+// Close delivers any queued reports, flushes and then closes the
+// underlying reporter if it implements io.Closer.
+func (p *processor) Close() error {
+	if !p.closed.CAS(false, true) {
+		return errAlreadyClosed
+	}
+
+	p.queue.close()
+	close(p.donech)
+	p.wg.Wait()
+
+	if closer, ok := p.base.(io.Closer); ok {
+		return closer.Close()
+	}
+	return nil
+}
+
+func (p *processor) QueueDepth() int {
+	return p.queue.len()
+}
+
+func (p *processor) Dropped() int64 {
+	return p.queue.dropped.Load()
+}
+
+type reporter struct {
+	*processor
+}
+
+func (r *reporter) ReportCounter(
+	name string,
+	tags map[string]string,
+	value int64,
+) {
+	r.queue.push(report{
+		rtype: counterReport,
+		name:  name,
+		tags:  tags,
+		count: value,
+	})
+}
+
+func (r *reporter) ReportGauge(
+	name string,
+	tags map[string]string,
+	value float64,
+) {
+	r.queue.push(report{
+		rtype: gaugeReport,
+		name:  name,
+		tags:  tags,
+		gauge: value,
+	})
+}
+
+func (r *reporter) ReportTimer(
+	name string,
+	tags map[string]string,
+	interval time.Duration,
+) {
+	r.queue.push(report{
+		rtype:    timerReport,
+		name:     name,
+		tags:     tags,
+		interval: interval,
+	})
+}
+
+func (r *reporter) ReportHistogramValueSamples(
+	name string,
+	tags map[string]string,
+	buckets tally.Buckets,
+	bucketLowerBound,
+	bucketUpperBound float64,
+	samples int64,
+) {
+	r.queue.push(report{
+		rtype:           histogramValueReport,
+		name:            name,
+		tags:            tags,
+		buckets:         buckets,
+		valueLowerBound: bucketLowerBound,
+		valueUpperBound: bucketUpperBound,
+		count:           samples,
+	})
+}
+
+func (r *reporter) ReportHistogramDurationSamples(
+	name string,
+	tags map[string]string,
+	buckets tally.Buckets,
+	bucketLowerBound,
+	bucketUpperBound time.Duration,
+	samples int64,
+) {
+	r.queue.push(report{
+		rtype:              histogramDurationReport,
+		name:               name,
+		tags:               tags,
+		buckets:            buckets,
+		durationLowerBound: bucketLowerBound,
+		durationUpperBound: bucketUpperBound,
+		count:              samples,
+	})
+}
+
+type cachedReporter struct {
+	*processor
+	cached tally.CachedStatsReporter
+}
+
+func (r *cachedReporter) AllocateCounter(
+	name string,
+	tags map[string]string,
+) tally.CachedCount {
+	return cachedMetric{
+		queue:   r.queue,
+		counter: r.cached.AllocateCounter(name, tags),
+	}
+}
+
+func (r *cachedReporter) AllocateGauge(
+	name string,
+	tags map[string]string,
+) tally.CachedGauge {
+	return cachedMetric{
+		queue: r.queue,
+		gauge: r.cached.AllocateGauge(name, tags),
+	}
+}
+
+func (r *cachedReporter) AllocateTimer(
+	name string,
+	tags map[string]string,
+) tally.CachedTimer {
+	return cachedMetric{
+		queue: r.queue,
+		timer: r.cached.AllocateTimer(name, tags),
+	}
+}
+
+func (r *cachedReporter) AllocateHistogram(
+	name string,
+	tags map[string]string,
+	buckets tally.Buckets,
+) tally.CachedHistogram {
+	return cachedHistogram{
+		queue:     r.queue,
+		histogram: r.cached.AllocateHistogram(name, tags, buckets),
+	}
+}
+
+type cachedMetric struct {
+	queue   *queue
+	counter tally.CachedCount
+	gauge   tally.CachedGauge
+	timer   tally.CachedTimer
+}
+
+func (m cachedMetric) ReportCount(value int64) {
+	m.queue.push(report{
+		rtype:       cachedCountReport,
+		cachedCount: m.counter,
+		count:       value,
+	})
+}
+
+func (m cachedMetric) ReportGauge(value float64) {
+	m.queue.push(report{
+		rtype:       cachedGaugeReport,
+		cachedGauge: m.gauge,
+		gauge:       value,
+	})
+}
+
+func (m cachedMetric) ReportTimer(interval time.Duration) {
+	m.queue.push(report{
+		rtype:       cachedTimerReport,
+		cachedTimer: m.timer,
+		interval:    interval,
+	})
+}
+
+type cachedHistogram struct {
+	queue     *queue
+	histogram tally.CachedHistogram
+}
+
+func (h cachedHistogram) ValueBucket(
+	bucketLowerBound, bucketUpperBound float64,
+) tally.CachedHistogramBucket {
+	return cachedHistogramBucket{
+		queue:  h.queue,
+		bucket: h.histogram.ValueBucket(bucketLowerBound, bucketUpperBound),
+	}
+}
+
+func (h cachedHistogram) DurationBucket(
+	bucketLowerBound, bucketUpperBound time.Duration,
+) tally.CachedHistogramBucket {
+	return cachedHistogramBucket{
+		queue:  h.queue,
+		bucket: h.histogram.DurationBucket(bucketLowerBound, bucketUpperBound),
+	}
+}
+
+type cachedHistogramBucket struct {
+	queue  *queue
+	bucket tally.CachedHistogramBucket
+}
+
+func (b cachedHistogramBucket) ReportSamples(value int64) {
+	b.queue.push(report{
+		rtype:        cachedSamplesReport,
+		cachedBucket: b.bucket,
+		count:        value,
+	})
+}
@@ -0,0 +1,342 @@
+// Copyright (c) 2022 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package async
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	tally "github.com/uber-go/tally/v4"
+)
+
+func TestReporterDeliversInOrder(t *testing.T) {
+	base := newCapturingReporter()
+	r := NewReporter(base, Options{})
+
+	tags := map[string]string{"foo": "bar"}
+	valueBuckets := tally.MustMakeLinearValueBuckets(0, 2, 5)
+	durationBuckets := tally.MustMakeLinearDurationBuckets(0, time.Second, 5)
+
+	r.ReportCounter("counter", tags, 1)
+	r.ReportCounter("counter", tags, 2)
+	r.ReportGauge("gauge", tags, 42)
+	r.ReportTimer("timer", tags, time.Millisecond)
+	r.ReportHistogramValueSamples("value", tags, valueBuckets, 2, 4, 3)
+	r.ReportHistogramDurationSamples("duration", tags, durationBuckets,
+		time.Second, 2*time.Second, 5)
+	r.Flush()
+	require.NoError(t, r.Close())
+
+	base.Lock()
+	defer base.Unlock()
+	assert.Equal(t, []string{
+		"counter:1", "counter:2", "gauge:42", "timer:1ms",
+		"value:2-4:3", "duration:1s-2s:5",
+	}, base.reports)
+	assert.Equal(t, tags, base.tags[0])
+	assert.True(t, base.flushes >= 1)
+	assert.Equal(t, 0, r.QueueDepth())
+	assert.Equal(t, int64(0), r.Dropped())
+	assert.Error(t, r.Close())
+}
+
+func TestReporterQueuePolicies(t *testing.T) {
+	tests := []struct {
+		name     string
+		opts     Options
+		expected []string
+	}{
+		{
+			name:     "drop newest",
+			opts:     Options{QueueSize: 2, Policy: DropNewest},
+			expected: []string{"c:0", "c:1", "c:2"},
+		},
+		{
+			name:     "drop oldest",
+			opts:     Options{QueueSize: 2, Policy: DropOldest},
+			expected: []string{"c:0", "c:2", "c:3"},
+		},
+		{
+			name: "block with timeout",
+			opts: Options{
+				QueueSize:    2,
+				Policy:       Block,
+				BlockTimeout: 10 * time.Millisecond,
+			},
+			expected: []string{"c:0", "c:1", "c:2"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			base := newCapturingReporter()
+			base.gate = make(chan struct{})
+			r := NewReporter(base, tt.opts)
+
+			// The first report is taken off the queue and blocks delivery
+			// until the gate is opened.
+			r.ReportCounter("c", nil, 0)
+			require.Eventually(t, func() bool {
+				return r.QueueDepth() == 0
+			}, time.Second, time.Millisecond)
+
+			r.ReportCounter("c", nil, 1)
+			r.ReportCounter("c", nil, 2)
+			r.ReportCounter("c", nil, 3)
+			assert.Equal(t, 2, r.QueueDepth())
+			assert.Equal(t, int64(1), r.Dropped())
+
+			close(base.gate)
+			require.NoError(t, r.Close())
+
+			base.Lock()
+			defer base.Unlock()
+			assert.Equal(t, tt.expected, base.reports)
+		})
+	}
+}
+
+func TestReporterBlockWaitsForRoom(t *testing.T) {
+	base := newCapturingReporter()
+	base.gate = make(chan struct{})
+	r := NewReporter(base, Options{QueueSize: 1, Policy: Block})
+
+	r.ReportCounter("c", nil, 0)
+	require.Eventually(t, func() bool {
+		return r.QueueDepth() == 0
+	}, time.Second, time.Millisecond)
+	r.ReportCounter("c", nil, 1)
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		r.ReportCounter("c", nil, 2)
+	}()
+
+	select {
+	case <-done:
+		require.FailNow(t, "expected report to block")
+	case <-time.After(10 * time.Millisecond):
+	}
+
+	close(base.gate)
+	<-done
+	require.NoError(t, r.Close())
+
+	base.Lock()
+	defer base.Unlock()
+	assert.Equal(t, []string{"c:0", "c:1", "c:2"}, base.reports)
+	assert.Equal(t, int64(0), r.Dropped())
+}
+
+func TestReporterFlushWhileDelivering(t *testing.T) {
+	base := newCapturingReporter()
+	base.gate = make(chan struct{})
+	r := NewReporter(base, Options{})
+
+	r.ReportCounter("c", nil, 0)
+	require.Eventually(t, func() bool {
+		return r.QueueDepth() == 0
+	}, time.Second, time.Millisecond)
+
+	// The flush requested while c:0 is delivered follows c:1.
+	r.ReportCounter("c", nil, 1)
+	r.Flush()
+	close(base.gate)
+	require.Eventually(t, func() bool {
+		base.Lock()
+		defer base.Unlock()
+		return base.flushes == 1
+	}, time.Second, time.Millisecond)
+	require.NoError(t, r.Close())
+
+	base.Lock()
+	defer base.Unlock()
+	assert.Equal(t, []string{"c:0", "c:1"}, base.reports)
+	assert.Equal(t, 2, base.flushed[0])
+}
+
+func TestCachedReporter(t *testing.T) {
+	base := newCapturingReporter()
+	r := NewCachedReporter(base, Options{})
+
+	r.AllocateCounter("counter", nil).ReportCount(3)
+	r.AllocateGauge("gauge", nil).ReportGauge(1.5)
+	r.AllocateTimer("timer", nil).ReportTimer(time.Second)
+	h := r.AllocateHistogram("value", nil, tally.ValueBuckets{1, 2})
+	h.ValueBucket(1, 2).ReportSamples(4)
+	h.DurationBucket(time.Second, 2*time.Second).ReportSamples(5)
+	require.NoError(t, r.Close())
+
+	base.Lock()
+	defer base.Unlock()
+	assert.Equal(t, []string{
+		"counter:3", "gauge:1.5", "timer:1s",
+		"value:1-2:4", "value:1s-2s:5",
+	}, base.reports)
+	assert.True(t, base.closed)
+}
+
+func TestReporterDropsAfterClose(t *testing.T) {
+	r := NewReporter(newCapturingReporter(), Options{})
+	require.NoError(t, r.Close())
+
+	r.ReportCounter("c", nil, 1)
+	r.Flush()
+	assert.Equal(t, int64(1), r.Dropped())
+}
+
+type capturingReporter struct {
+	sync.Mutex
+	gate    chan struct{}
+	reports []string
+	tags    []map[string]string
+	flushes int
+	// flushed is the number of reports delivered at each flush.
+	flushed []int
+	closed  bool
+}
+
+func newCapturingReporter() *capturingReporter {
+	return &capturingReporter{}
+}
+
+func (r *capturingReporter) capture(tags map[string]string, format string, args ...interface{}) {
+	if r.gate != nil {
+		<-r.gate
+	}
+	r.Lock()
+	defer r.Unlock()
+	r.reports = append(r.reports, fmt.Sprintf(format, args...))
+	r.tags = append(r.tags, tags)
+}
+
+func (r *capturingReporter) ReportCounter(name string, tags map[string]string, value int64) {
+	r.capture(tags, "%s:%d", name, value)
+}
+
+func (r *capturingReporter) ReportGauge(name string, tags map[string]string, value float64) {
+	r.capture(tags, "%s:%v", name, value)
+}
+
+func (r *capturingReporter) ReportTimer(name string, tags map[string]string, interval time.Duration) {
+	r.capture(tags, "%s:%v", name, interval)
+}
+
+func (r *capturingReporter) ReportHistogramValueSamples(
+	name string,
+	tags map[string]string,
+	buckets tally.Buckets,
+	bucketLowerBound,
+	bucketUpperBound float64,
+	samples int64,
+) {
+	r.capture(tags, "%s:%v-%v:%d", name, bucketLowerBound, bucketUpperBound, samples)
+}
+
+func (r *capturingReporter) ReportHistogramDurationSamples(
+	name string,
+	tags map[string]string,
+	buckets tally.Buckets,
+	bucketLowerBound,
+	bucketUpperBound time.Duration,
+	samples int64,
+) {
+	r.capture(tags, "%s:%v-%v:%d", name, bucketLowerBound, bucketUpperBound, samples)
+}
+
+func (r *capturingReporter) AllocateCounter(name string, tags map[string]string) tally.CachedCount {
+	return capturingMetric{r: r, name: name, tags: tags}
+}
+
+func (r *capturingReporter) AllocateGauge(name string, tags map[string]string) tally.CachedGauge {
+	return capturingMetric{r: r, name: name, tags: tags}
+}
+
+func (r *capturingReporter) AllocateTimer(name string, tags map[string]string) tally.CachedTimer {
+	return capturingMetric{r: r, name: name, tags: tags}
+}
+
+func (r *capturingReporter) AllocateHistogram(
+	name string,
+	tags map[string]string,
+	buckets tally.Buckets,
+) tally.CachedHistogram {
+	return capturingMetric{r: r, name: name, tags: tags}
+}
+
+func (r *capturingReporter) Capabilities() tally.Capabilities {
+	return tally.NullStatsReporter.Capabilities()
+}
+
+func (r *capturingReporter) Flush() {
+	r.Lock()
+	defer r.Unlock()
+	r.flushes++
+	r.flushed = append(r.flushed, len(r.reports))
+}
+
+func (r *capturingReporter) Close() error {
+	r.Lock()
+	defer r.Unlock()
+	r.closed = true
+	return nil
+}
+
+type capturingMetric struct {
+	r    *capturingReporter
+	name string
+	tags map[string]string
+}
+
+func (m capturingMetric) ReportCount(value int64) {
+	m.r.ReportCounter(m.name, m.tags, value)
+}
+
+func (m capturingMetric) ReportGauge(value float64) {
+	m.r.ReportGauge(m.name, m.tags, value)
+}
+
+func (m capturingMetric) ReportTimer(interval time.Duration) {
+	m.r.ReportTimer(m.name, m.tags, interval)
+}
+
+func (m capturingMetric) ValueBucket(lower, upper float64) tally.CachedHistogramBucket {
+	return reportSamplesFunc(func(value int64) {
+		m.r.ReportHistogramValueSamples(m.name, m.tags, nil, lower, upper, value)
+	})
+}
+
+func (m capturingMetric) DurationBucket(lower, upper time.Duration) tally.CachedHistogramBucket {
+	return reportSamplesFunc(func(value int64) {
+		m.r.ReportHistogramDurationSamples(m.name, m.tags, nil, lower, upper, value)
+	})
+}
+
+type reportSamplesFunc func(value int64)
+
+func (f reportSamplesFunc) ReportSamples(value int64) {
+	f(value)
+}
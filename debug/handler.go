@@ -0,0 +1,430 @@
+// Copyright (c) 2022 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+// Package debug provides an HTTP handler to inspect the metrics of a live
+// tally scope without going through a metrics backend.
+package debug
+
+import (
+	"encoding/json"
+	"errors"
+	"html/template"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	tally "github.com/uber-go/tally/v4"
+)
+
+const (
+	// DefaultCardinalityDepth is the default number of name segments used
+	// to group series when computing cardinality per prefix.
+	DefaultCardinalityDepth = 1
+
+	_infinity = "+Inf"
+)
+
+var errInvalidDepth = errors.New("depth must be a positive integer")
+
+// Options is a set of options for the debug handler.
+type Options struct {
+	// Separator is the separator used to join nested scope names, it is
+	// used to compute cardinality per prefix. By default this will be set
+	// to tally.DefaultSeparator.
+	Separator string
+}
+
+type handler struct {
+	snapshotter tally.Snapshotter
+	separator   string
+}
+
+// NewHandler returns an http.Handler that renders the scopes and metrics
+// of the given root scope as HTML, or as JSON when requested with
+// "?format=json" or an "Accept: application/json" header.
+//
+// The following query parameters filter what is rendered:
+//   - prefix: only include metrics and scopes whose name starts with prefix.
+//   - tag: only include metrics and scopes with the tag, either "key" or
+//     "key:value", may be repeated.
+//   - depth: the number of name segments to group series by when computing
+//     cardinality per prefix.
+//
+// If s also implements tally.ScopeLister then all of its subscopes are
+// listed too.
+//
+// Timers only compute the last reported aggregates rendered once the scope
+// is snapshotted, so s is snapshotted when creating the handler. Create
+// it before recording timer values for their first report to be
+// aggregated.
+func NewHandler(s tally.Snapshotter, opts Options) http.Handler {
+	if opts.Separator == "" {
+		opts.Separator = tally.DefaultSeparator
+	}
+	s.Snapshot()
+	return &handler{
+		snapshotter: s,
+		separator:   opts.Separator,
+	}
+}
+
+func (h *handler) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	f, err := parseFilter(req)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	p := h.page(f)
+
+	if wantsJSON(req) {
+		w.Header().Set("Content-Type", "application/json")
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		_ = enc.Encode(p)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	_ = pageTemplate.Execute(w, p)
+}
+
+func wantsJSON(req *http.Request) bool {
+	switch req.URL.Query().Get("format") {
+	case "json":
+		return true
+	case "html":
+		return false
+	}
+	return strings.Contains(req.Header.Get("Accept"), "application/json")
+}
+
+type tagFilter struct {
+	key      string
+	value    string
+	hasValue bool
+}
+
+type filter struct {
+	prefix string
+	tags   []tagFilter
+	depth  int
+}
+
+func parseFilter(req *http.Request) (filter, error) {
+	q := req.URL.Query()
+	f := filter{
+		prefix: q.Get("prefix"),
+		depth:  DefaultCardinalityDepth,
+	}
+
+	for _, t := range q["tag"] {
+		tf := tagFilter{key: t}
+		if idx := strings.IndexByte(t, ':'); idx >= 0 {
+			tf = tagFilter{key: t[:idx], value: t[idx+1:], hasValue: true}
+		}
+		f.tags = append(f.tags, tf)
+	}
+
+	if d := q.Get("depth"); d != "" {
+		depth, err := strconv.Atoi(d)
+		if err != nil || depth < 1 {
+			return filter{}, errInvalidDepth
+		}
+		f.depth = depth
+	}
+
+	return f, nil
+}
+
+func (f filter) matchTags(tags map[string]string) bool {
+	for _, tf := range f.tags {
+		v, ok := tags[tf.key]
+		if !ok || (tf.hasValue && v != tf.value) {
+			return false
+		}
+	}
+	return true
+}
+
+func (f filter) matchMetric(name string, tags map[string]string) bool {
+	return strings.HasPrefix(name, f.prefix) && f.matchTags(tags)
+}
+
+func (f filter) matchScope(prefix string, tags map[string]string) bool {
+	// A scope may hold matching metrics if either prefix contains the other.
+	return (strings.HasPrefix(prefix, f.prefix) || strings.HasPrefix(f.prefix, prefix)) &&
+		f.matchTags(tags)
+}
+
+type page struct {
+	Scopes      []tally.ScopeInfo `json:"scopes,omitempty"`
+	Counters    []counter         `json:"counters"`
+	Gauges      []gauge           `json:"gauges"`
+	Timers      []timer           `json:"timers"`
+	Histograms  []histogram       `json:"histograms"`
+	Cardinality []cardinality     `json:"cardinality"`
+}
+
+type counter struct {
+	Name  string            `json:"name"`
+	Tags  map[string]string `json:"tags"`
+	Value int64             `json:"value"`
+}
+
+type gauge struct {
+	Name  string            `json:"name"`
+	Tags  map[string]string `json:"tags"`
+	Value gaugeValue        `json:"value"`
+}
+
+// gaugeValue encodes NaN and infinities as JSON strings.
+type gaugeValue float64
+
+func (v gaugeValue) MarshalJSON() ([]byte, error) {
+	f := float64(v)
+	if math.IsNaN(f) || math.IsInf(f, 0) {
+		return json.Marshal(strconv.FormatFloat(f, 'g', -1, 64))
+	}
+	return json.Marshal(f)
+}
+
+type timer struct {
+	Name         string                `json:"name"`
+	Tags         map[string]string     `json:"tags"`
+	Values       int                   `json:"values"`
+	LastReported tally.TimerAggregates `json:"lastReported"`
+}
+
+type histogram struct {
+	Name    string            `json:"name"`
+	Tags    map[string]string `json:"tags"`
+	Buckets []bucket          `json:"buckets"`
+}
+
+type bucket struct {
+	UpperBound string `json:"upperBound"`
+	Samples    int64  `json:"samples"`
+}
+
+type cardinality struct {
+	Prefix string `json:"prefix"`
+	Series int    `json:"series"`
+}
+
+func (h *handler) page(f filter) page {
+	var (
+		p      page
+		snap   = h.snapshotter.Snapshot()
+		series = make(map[string]int)
+	)
+
+	if lister, ok := h.snapshotter.(tally.ScopeLister); ok {
+		for _, s := range lister.Scopes() {
+			if f.matchScope(s.Prefix, s.Tags) {
+				p.Scopes = append(p.Scopes, s)
+			}
+		}
+	}
+
+	for _, c := range snap.Counters() {
+		if f.matchMetric(c.Name(), c.Tags()) {
+			p.Counters = append(p.Counters, counter{c.Name(), c.Tags(), c.Value()})
+			series[h.namePrefix(c.Name(), f.depth)]++
+		}
+	}
+	for _, g := range snap.Gauges() {
+		if f.matchMetric(g.Name(), g.Tags()) {
+			p.Gauges = append(p.Gauges, gauge{g.Name(), g.Tags(), gaugeValue(g.Value())})
+			series[h.namePrefix(g.Name(), f.depth)]++
+		}
+	}
+	for _, t := range snap.Timers() {
+		if f.matchMetric(t.Name(), t.Tags()) {
+			var lastReported tally.TimerAggregates
+			if agg, ok := t.(tally.TimerAggregatesSnapshot); ok {
+				lastReported = agg.LastReported()
+			}
+			p.Timers = append(p.Timers, timer{
+				Name:         t.Name(),
+				Tags:         t.Tags(),
+				Values:       len(t.Values()),
+				LastReported: lastReported,
+			})
+			series[h.namePrefix(t.Name(), f.depth)]++
+		}
+	}
+	for _, hs := range snap.Histograms() {
+		if f.matchMetric(hs.Name(), hs.Tags()) {
+			p.Histograms = append(p.Histograms, histogram{
+				Name:    hs.Name(),
+				Tags:    hs.Tags(),
+				Buckets: buckets(hs),
+			})
+			series[h.namePrefix(hs.Name(), f.depth)]++
+		}
+	}
+
+	sort.Slice(p.Counters, func(i, j int) bool {
+		return seriesLess(p.Counters[i].Name, p.Counters[i].Tags, p.Counters[j].Name, p.Counters[j].Tags)
+	})
+	sort.Slice(p.Gauges, func(i, j int) bool {
+		return seriesLess(p.Gauges[i].Name, p.Gauges[i].Tags, p.Gauges[j].Name, p.Gauges[j].Tags)
+	})
+	sort.Slice(p.Timers, func(i, j int) bool {
+		return seriesLess(p.Timers[i].Name, p.Timers[i].Tags, p.Timers[j].Name, p.Timers[j].Tags)
+	})
+	sort.Slice(p.Histograms, func(i, j int) bool {
+		return seriesLess(p.Histograms[i].Name, p.Histograms[i].Tags, p.Histograms[j].Name, p.Histograms[j].Tags)
+	})
+
+	for prefix, n := range series {
+		p.Cardinality = append(p.Cardinality, cardinality{Prefix: prefix, Series: n})
+	}
+	sort.Slice(p.Cardinality, func(i, j int) bool {
+		if p.Cardinality[i].Series != p.Cardinality[j].Series {
+			return p.Cardinality[i].Series > p.Cardinality[j].Series
+		}
+		return p.Cardinality[i].Prefix < p.Cardinality[j].Prefix
+	})
+
+	return p
+}
+
+// namePrefix returns the first depth segments of name.
+func (h *handler) namePrefix(name string, depth int) string {
+	idx := 0
+	for i := 0; i < depth; i++ {
+		next := strings.Index(name[idx:], h.separator)
+		if next < 0 {
+			return name
+		}
+		idx += next + len(h.separator)
+	}
+	return name[:idx-len(h.separator)]
+}
+
+func seriesLess(nameA string, tagsA map[string]string, nameB string, tagsB map[string]string) bool {
+	return tally.KeyForPrefixedStringMap(nameA, tagsA) < tally.KeyForPrefixedStringMap(nameB, tagsB)
+}
+
+func buckets(h tally.HistogramSnapshot) []bucket {
+	var result []bucket
+	if values := h.Values(); len(values) > 0 {
+		bounds := make([]float64, 0, len(values))
+		for b := range values {
+			bounds = append(bounds, b)
+		}
+		sort.Float64s(bounds)
+		for _, b := range bounds {
+			upper := strconv.FormatFloat(b, 'g', -1, 64)
+			if b == math.MaxFloat64 {
+				upper = _infinity
+			}
+			result = append(result, bucket{UpperBound: upper, Samples: values[b]})
+		}
+	}
+	if durations := h.Durations(); len(durations) > 0 {
+		bounds := make([]time.Duration, 0, len(durations))
+		for b := range durations {
+			bounds = append(bounds, b)
+		}
+		sort.Slice(bounds, func(i, j int) bool { return bounds[i] < bounds[j] })
+		for _, b := range bounds {
+			upper := b.String()
+			if b == time.Duration(math.MaxInt64) {
+				upper = _infinity
+			}
+			result = append(result, bucket{UpperBound: upper, Samples: durations[b]})
+		}
+	}
+	return result
+}
+
+var pageTemplate = template.Must(template.New("page").Funcs(template.FuncMap{
+	"tags": formatTags,
+}).Parse(`<!DOCTYPE html>
+<html>
+<head><title>tally</title></head>
+<body>
+{{- if .Scopes}}
+<h2>Scopes</h2>
+<table>
+<tr><th>Prefix</th><th>Tags</th><th>Counters</th><th>Gauges</th><th>Timers</th><th>Histograms</th></tr>
+{{- range .Scopes}}
+<tr><td>{{.Prefix}}</td><td>{{tags .Tags}}</td><td>{{.Counters}}</td><td>{{.Gauges}}</td><td>{{.Timers}}</td><td>{{.Histograms}}</td></tr>
+{{- end}}
+</table>
+{{- end}}
+<h2>Cardinality</h2>
+<table>
+<tr><th>Prefix</th><th>Series</th></tr>
+{{- range .Cardinality}}
+<tr><td>{{.Prefix}}</td><td>{{.Series}}</td></tr>
+{{- end}}
+</table>
+<h2>Counters</h2>
+<table>
+<tr><th>Name</th><th>Tags</th><th>Value</th></tr>
+{{- range .Counters}}
+<tr><td>{{.Name}}</td><td>{{tags .Tags}}</td><td>{{.Value}}</td></tr>
+{{- end}}
+</table>
+<h2>Gauges</h2>
+<table>
+<tr><th>Name</th><th>Tags</th><th>Value</th></tr>
+{{- range .Gauges}}
+<tr><td>{{.Name}}</td><td>{{tags .Tags}}</td><td>{{.Value}}</td></tr>
+{{- end}}
+</table>
+<h2>Timers</h2>
+<table>
+<tr><th>Name</th><th>Tags</th><th>Values</th><th>Last Count</th><th>Last Sum</th><th>Last Min</th><th>Last Max</th></tr>
+{{- range .Timers}}
+<tr><td>{{.Name}}</td><td>{{tags .Tags}}</td><td>{{.Values}}</td><td>{{.LastReported.Count}}</td><td>{{.LastReported.Sum}}</td><td>{{.LastReported.Min}}</td><td>{{.LastReported.Max}}</td></tr>
+{{- end}}
+</table>
+<h2>Histograms</h2>
+<table>
+<tr><th>Name</th><th>Tags</th><th>Buckets</th></tr>
+{{- range .Histograms}}
+<tr><td>{{.Name}}</td><td>{{tags .Tags}}</td><td>{{range $i, $b := .Buckets}}{{if $i}}, {{end}}&le;{{$b.UpperBound}}: {{$b.Samples}}{{end}}</td></tr>
+{{- end}}
+</table>
+</body>
+</html>
+`))
+
+func formatTags(tags map[string]string) string {
+	keys := make([]string, 0, len(tags))
+	for k := range tags {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	pairs := make([]string, 0, len(keys))
+	for _, k := range keys {
+		pairs = append(pairs, k+"="+tags[k])
+	}
+	return strings.Join(pairs, ",")
+}
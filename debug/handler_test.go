@@ -0,0 +1,133 @@
+// Copyright (c) 2022 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package debug
+
+import (
+	"encoding/json"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	tally "github.com/uber-go/tally/v4"
+)
+
+func newTestHandler() http.Handler {
+	s := tally.NewTestScope("svc", map[string]string{"env": "test"})
+	s.Counter("requests").Inc(3)
+	s.SubScope("db").Tagged(map[string]string{"table": "users"}).Counter("queries").Inc(2)
+	s.SubScope("db").Tagged(map[string]string{"table": "orders"}).Counter("queries").Inc(1)
+	s.Gauge("queue").Update(math.Inf(1))
+	s.Timer("latency").Record(time.Second)
+	s.Histogram("sizes", tally.ValueBuckets{1, 10}).RecordValue(5)
+	return NewHandler(s, Options{})
+}
+
+func get(t *testing.T, h http.Handler, target string, accept string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodGet, target, nil)
+	if accept != "" {
+		req.Header.Set("Accept", accept)
+	}
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	return w
+}
+
+func decode(t *testing.T, w *httptest.ResponseRecorder) map[string]interface{} {
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "application/json", w.Header().Get("Content-Type"))
+
+	var result map[string]interface{}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &result))
+	return result
+}
+
+func TestHandlerJSON(t *testing.T) {
+	h := newTestHandler()
+
+	for _, w := range []*httptest.ResponseRecorder{
+		get(t, h, "/?format=json", ""),
+		get(t, h, "/", "application/json"),
+	} {
+		result := decode(t, w)
+		assert.Len(t, result["scopes"], 4)
+		assert.Len(t, result["counters"], 3)
+		assert.Equal(t, []interface{}{map[string]interface{}{
+			"name":  "svc.queue",
+			"tags":  map[string]interface{}{"env": "test"},
+			"value": "+Inf",
+		}}, result["gauges"])
+		assert.Equal(t, float64(1), result["timers"].([]interface{})[0].(map[string]interface{})["values"])
+		assert.Equal(t, []interface{}{
+			map[string]interface{}{"upperBound": "1", "samples": float64(0)},
+			map[string]interface{}{"upperBound": "10", "samples": float64(1)},
+			map[string]interface{}{"upperBound": "+Inf", "samples": float64(0)},
+		}, result["histograms"].([]interface{})[0].(map[string]interface{})["buckets"])
+		assert.Equal(t, []interface{}{
+			map[string]interface{}{"prefix": "svc", "series": float64(6)},
+		}, result["cardinality"])
+	}
+}
+
+func TestHandlerFilters(t *testing.T) {
+	h := newTestHandler()
+
+	result := decode(t, get(t, h, "/?format=json&prefix=svc.db&depth=2", ""))
+	assert.Len(t, result["scopes"], 4)
+	assert.Len(t, result["counters"], 2)
+	assert.Nil(t, result["gauges"])
+	assert.Equal(t, []interface{}{
+		map[string]interface{}{"prefix": "svc.db", "series": float64(2)},
+	}, result["cardinality"])
+
+	result = decode(t, get(t, h, "/?format=json&tag=table:users", ""))
+	assert.Len(t, result["scopes"], 1)
+	require.Len(t, result["counters"], 1)
+	assert.Equal(t, "svc.db.queries",
+		result["counters"].([]interface{})[0].(map[string]interface{})["name"])
+
+	result = decode(t, get(t, h, "/?format=json&tag=table", ""))
+	assert.Len(t, result["counters"], 2)
+
+	w := get(t, h, "/?depth=0", "")
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+}
+
+func TestHandlerHTML(t *testing.T) {
+	w := get(t, newTestHandler(), "/?tag=table:users", "text/html")
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "text/html; charset=utf-8", w.Header().Get("Content-Type"))
+
+	body := w.Body.String()
+	assert.Contains(t, body, "<td>svc.db.queries</td><td>env=test,table=users</td><td>2</td>")
+	assert.NotContains(t, body, "table=orders")
+}
+
+func TestNamePrefix(t *testing.T) {
+	h := &handler{separator: "_"}
+	assert.Equal(t, "a", h.namePrefix("a_b_c", 1))
+	assert.Equal(t, "a_b", h.namePrefix("a_b_c", 2))
+	assert.Equal(t, "a_b_c", h.namePrefix("a_b_c", 3))
+	assert.Equal(t, "a_b_c", h.namePrefix("a_b_c", 5))
+}
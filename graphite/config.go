@@ -0,0 +1,103 @@
+// Copyright (c) 2022 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package graphite
+
+import (
+	"fmt"
+	"time"
+)
+
+// Configuration is a configuration for a Graphite reporter.
+type Configuration struct {
+	// Address is the host:port of the Carbon receiver.
+	Address string `yaml:"address" validate:"nonzero"`
+
+	// Protocol is one of "tcp" (the default), "udp" or "pickle".
+	Protocol string `yaml:"protocol"`
+
+	// Prefix if specified is prepended to every metric name.
+	Prefix string `yaml:"prefix"`
+
+	// Template if specified flattens tags into the dotted path, for
+	// example "{service}.{host}.{name}", instead of using tagged series.
+	Template string `yaml:"template"`
+
+	// MissingTagValue is used for tags referenced by the template that
+	// a metric does not have.
+	MissingTagValue string `yaml:"missingTagValue"`
+
+	// CommonTags are added to every metric.
+	CommonTags map[string]string `yaml:"commonTags"`
+
+	// MaxBatchBytes is the maximum size of a batch.
+	MaxBatchBytes int `yaml:"maxBatchBytes"`
+
+	// PoolSize is the number of TCP connections.
+	PoolSize int `yaml:"poolSize"`
+
+	// QueueSize is the number of batches buffered.
+	QueueSize int `yaml:"queueSize"`
+
+	// DialTimeout and WriteTimeout are the timeouts of connecting and
+	// of writing a batch.
+	DialTimeout  time.Duration `yaml:"dialTimeout"`
+	WriteTimeout time.Duration `yaml:"writeTimeout"`
+
+	// MinReconnectBackoff and MaxReconnectBackoff bound the wait before
+	// reconnecting after a failure to connect.
+	MinReconnectBackoff time.Duration `yaml:"minReconnectBackoff"`
+	MaxReconnectBackoff time.Duration `yaml:"maxReconnectBackoff"`
+}
+
+// NewReporter creates a new Graphite reporter from this configuration.
+func (c Configuration) NewReporter() (Reporter, error) {
+	opts := Options{
+		Address:             c.Address,
+		Prefix:              c.Prefix,
+		Template:            c.Template,
+		MissingTagValue:     c.MissingTagValue,
+		CommonTags:          c.CommonTags,
+		MaxBatchBytes:       c.MaxBatchBytes,
+		PoolSize:            c.PoolSize,
+		QueueSize:           c.QueueSize,
+		DialTimeout:         c.DialTimeout,
+		WriteTimeout:        c.WriteTimeout,
+		MinReconnectBackoff: c.MinReconnectBackoff,
+		MaxReconnectBackoff: c.MaxReconnectBackoff,
+	}
+
+	switch c.Protocol {
+	case "", "tcp":
+		opts.Protocol = TCP
+	case "udp":
+		opts.Protocol = UDP
+	case "pickle":
+		opts.Protocol = Pickle
+	default:
+		return nil, fmt.Errorf("unknown protocol %q", c.Protocol)
+	}
+
+	if c.Template != "" {
+		opts.TagMode = Flatten
+	}
+
+	return NewReporter(opts)
+}
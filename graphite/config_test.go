@@ -0,0 +1,51 @@
+// Copyright (c) 2022 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package graphite
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestConfigSimple(t *testing.T) {
+	c := Configuration{
+		Address:  "127.0.0.1:2004",
+		Protocol: "pickle",
+		Template: "{service}.{name}",
+		PoolSize: 2,
+	}
+	r, err := c.NewReporter()
+	require.NoError(t, err)
+	defer r.Close()
+
+	reporter := r.(*reporter)
+	assert.Equal(t, Pickle, reporter.opts.Protocol)
+	assert.Equal(t, Flatten, reporter.opts.TagMode)
+	assert.Len(t, reporter.pool.conns, 2)
+	assert.Equal(t, DefaultStreamBatchBytes, reporter.opts.MaxBatchBytes)
+}
+
+func TestConfigUnknownProtocol(t *testing.T) {
+	_, err := Configuration{Address: "127.0.0.1:2003", Protocol: "http"}.NewReporter()
+	assert.Error(t, err)
+}
@@ -0,0 +1,89 @@
+// Copyright (c) 2022 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package graphite
+
+import (
+	"encoding/binary"
+	"math"
+)
+
+// Pickle opcodes of protocol 2 used to encode a list of metrics as
+// expected by the Carbon pickle receiver:
+// [(path, (timestamp, value)), ...]
+const (
+	pickleProto      = 0x80
+	pickleEmptyList  = ']'
+	pickleMark       = '('
+	pickleAppends    = 'e'
+	pickleBinUnicode = 'X'
+	pickleBinInt     = 'J'
+	pickleLong1      = 0x8a
+	pickleBinFloat   = 'G'
+	pickleTuple2     = 0x86
+	pickleStop       = '.'
+)
+
+// appendPickle appends the length prefixed pickle payload of points.
+func appendPickle(b []byte, points []point) []byte {
+	start := len(b)
+	b = append(b, 0, 0, 0, 0)
+
+	b = append(b, pickleProto, 2, pickleEmptyList, pickleMark)
+	for _, p := range points {
+		b = append(b, pickleBinUnicode)
+		b = appendUint32LE(b, uint32(len(p.path)))
+		b = append(b, p.path...)
+
+		b = appendPickleInt(b, p.timestamp)
+		b = append(b, pickleBinFloat)
+		b = appendUint64BE(b, math.Float64bits(p.value))
+		b = append(b, pickleTuple2, pickleTuple2)
+	}
+	b = append(b, pickleAppends, pickleStop)
+
+	binary.BigEndian.PutUint32(b[start:], uint32(len(b)-start-4))
+	return b
+}
+
+func appendPickleInt(b []byte, v int64) []byte {
+	if v >= math.MinInt32 && v <= math.MaxInt32 {
+		b = append(b, pickleBinInt)
+		return appendUint32LE(b, uint32(int32(v)))
+	}
+	// Two's complement little endian, which for timestamps always fits
+	// in 8 bytes.
+	b = append(b, pickleLong1, 8)
+	var buf [8]byte
+	binary.LittleEndian.PutUint64(buf[:], uint64(v))
+	return append(b, buf[:]...)
+}
+
+func appendUint32LE(b []byte, v uint32) []byte {
+	var buf [4]byte
+	binary.LittleEndian.PutUint32(buf[:], v)
+	return append(b, buf[:]...)
+}
+
+func appendUint64BE(b []byte, v uint64) []byte {
+	var buf [8]byte
+	binary.BigEndian.PutUint64(buf[:], v)
+	return append(b, buf[:]...)
+}
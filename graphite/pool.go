@@ -0,0 +1,173 @@
+// Copyright (c) 2022 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package graphite
+
+import (
+	"net"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+var errReconnectBackoff = errors.New("waiting to reconnect, dropping batch")
+
+// conn is a lazily dialed connection that is reset on write failures and
+// redialed with exponential backoff.
+type conn struct {
+	network string
+	address string
+	opts    Options
+
+	conn     net.Conn
+	backoff  time.Duration
+	nextDial time.Time
+}
+
+func newConn(network string, opts Options) *conn {
+	return &conn{
+		network: network,
+		address: opts.Address,
+		opts:    opts,
+	}
+}
+
+func (c *conn) write(b []byte) error {
+	if err := c.ensureConnected(); err != nil {
+		return err
+	}
+
+	if err := c.conn.SetWriteDeadline(time.Now().Add(c.opts.WriteTimeout)); err != nil {
+		return c.reset(err)
+	}
+	if _, err := c.conn.Write(b); err != nil {
+		return c.reset(err)
+	}
+	return nil
+}
+
+func (c *conn) ensureConnected() error {
+	if c.conn != nil {
+		return nil
+	}
+
+	now := time.Now()
+	if now.Before(c.nextDial) {
+		return errReconnectBackoff
+	}
+
+	conn, err := net.DialTimeout(c.network, c.address, c.opts.DialTimeout)
+	if err != nil {
+		if c.backoff *= 2; c.backoff == 0 {
+			c.backoff = c.opts.MinReconnectBackoff
+		}
+		if c.backoff > c.opts.MaxReconnectBackoff {
+			c.backoff = c.opts.MaxReconnectBackoff
+		}
+		c.nextDial = now.Add(c.backoff)
+		return errors.Wrap(err, "failed to connect")
+	}
+
+	c.conn = conn
+	c.backoff = 0
+	return nil
+}
+
+func (c *conn) reset(err error) error {
+	c.conn.Close()
+	c.conn = nil
+	return err
+}
+
+func (c *conn) close() error {
+	if c.conn == nil {
+		return nil
+	}
+	return c.conn.Close()
+}
+
+// pool sends batches from background goroutines, each owning one of
+// PoolSize connections.
+type pool struct {
+	conns   []*conn
+	onError func(err error)
+
+	batchCh chan []byte
+	wg      sync.WaitGroup
+}
+
+func newPool(network string, size int, opts Options) *pool {
+	p := &pool{
+		conns:   make([]*conn, size),
+		onError: opts.OnError,
+		batchCh: make(chan []byte, opts.QueueSize),
+	}
+	for i := range p.conns {
+		p.conns[i] = newConn(network, opts)
+		p.wg.Add(1)
+		go p.process(p.conns[i])
+	}
+	return p
+}
+
+func (p *pool) enqueue(batch []byte) {
+	select {
+	case p.batchCh <- batch:
+	default:
+		p.error(errQueueFull)
+	}
+}
+
+func (p *pool) process(c *conn) {
+	defer p.wg.Done()
+
+	for batch := range p.batchCh {
+		wasConnected := c.conn != nil
+		err := c.write(batch)
+		if err != nil && wasConnected {
+			// A stale connection typically only fails on write, so
+			// retry once on a fresh connection.
+			err = c.write(batch)
+		}
+		if err != nil {
+			p.error(errors.WithMessage(err, "failed to write batch"))
+		}
+	}
+}
+
+func (p *pool) error(err error) {
+	if p.onError != nil {
+		p.onError(err)
+	}
+}
+
+func (p *pool) close() error {
+	close(p.batchCh)
+	p.wg.Wait()
+
+	var err error
+	for _, c := range p.conns {
+		if cerr := c.close(); cerr != nil && err == nil {
+			err = cerr
+		}
+	}
+	return err
+}
@@ -0,0 +1,560 @@
+// Copyright (c) 2022 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package graphite
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	tally "github.com/uber-go/tally/v4"
+	"github.com/uber-go/tally/v4/internal/cache"
+)
+
+// Protocol describes how metrics are sent to Carbon.
+type Protocol int
+
+const (
+	// TCP sends the plaintext protocol over TCP.
+	TCP Protocol = iota
+
+	// UDP sends the plaintext protocol over UDP.
+	UDP
+
+	// Pickle sends the pickle protocol over TCP.
+	Pickle
+)
+
+// TagMode describes how tags are encoded in metric paths.
+type TagMode int
+
+const (
+	// TaggedSeries encodes tags with the Graphite 1.1 tagged series
+	// syntax, such as "requests;env=prod;host=a".
+	TaggedSeries TagMode = iota
+
+	// Flatten encodes tags in the dotted path using Options.Template.
+	Flatten
+)
+
+const (
+	// DefaultDatagramBatchBytes is the default maximum batch size of
+	// UDP, chosen to fit a single packet on most networks.
+	DefaultDatagramBatchBytes = 1432
+
+	// DefaultStreamBatchBytes is the default maximum batch size of TCP
+	// and Pickle.
+	DefaultStreamBatchBytes = 64 * 1024
+
+	// DefaultPoolSize is the default number of TCP connections.
+	DefaultPoolSize = 1
+
+	// DefaultQueueSize is the default number of batches buffered while
+	// previous batches are being sent.
+	DefaultQueueSize = 64
+
+	// DefaultDialTimeout is the default timeout of connecting.
+	DefaultDialTimeout = 5 * time.Second
+
+	// DefaultWriteTimeout is the default timeout of writing a batch.
+	DefaultWriteTimeout = 5 * time.Second
+
+	// DefaultMinReconnectBackoff is the default wait before reconnecting
+	// after a failure to connect.
+	DefaultMinReconnectBackoff = 100 * time.Millisecond
+
+	// DefaultMaxReconnectBackoff is the default upper limit of the wait
+	// before reconnecting.
+	DefaultMaxReconnectBackoff = 30 * time.Second
+
+	// DefaultTemplate is the default template of the Flatten tag mode.
+	DefaultTemplate = "{name}"
+
+	// DefaultMissingTagValue is the default path segment used for tags
+	// referenced by the template that a metric does not have.
+	DefaultMissingTagValue = "none"
+
+	// BucketTagName is the tag holding the upper bound of a histogram
+	// bucket.
+	BucketTagName = "le"
+
+	nameKey = "name"
+)
+
+var (
+	errNoAddress     = errors.New("no address specified")
+	errQueueFull     = errors.New("graphite write queue full, dropping batch")
+	errAlreadyClosed = errors.New("reporter already closed")
+
+	// DefaultSanitizerOpts are the options for the default Graphite
+	// sanitizer, tag keys and values may not contain dots so that they
+	// can be flattened into a path.
+	DefaultSanitizerOpts = tally.SanitizeOptions{
+		NameCharacters: tally.ValidCharacters{
+			Ranges:     tally.AlphanumericRange,
+			Characters: tally.UnderscoreDashDotCharacters,
+		},
+		KeyCharacters: tally.ValidCharacters{
+			Ranges:     tally.AlphanumericRange,
+			Characters: tally.UnderscoreDashCharacters,
+		},
+		ValueCharacters: tally.ValidCharacters{
+			Ranges:     tally.AlphanumericRange,
+			Characters: tally.UnderscoreDashCharacters,
+		},
+		ReplacementCharacter: tally.DefaultReplacementCharacter,
+	}
+)
+
+// Reporter is a tally.StatsReporter that writes to Carbon.
+type Reporter interface {
+	tally.StatsReporter
+	io.Closer
+}
+
+// Options is a set of options for the Graphite reporter.
+type Options struct {
+	// Address is the host:port of the Carbon receiver.
+	Address string
+
+	// Protocol is how metrics are sent, by default plaintext over TCP.
+	Protocol Protocol
+
+	// Prefix if specified is prepended to every metric name with a dot.
+	Prefix string
+
+	// TagMode is how tags are encoded, by default tagged series.
+	TagMode TagMode
+
+	// Template is the path of a metric in the Flatten tag mode. Use
+	// "{name}" for the metric name and "{key}" for the value of tag key,
+	// for example "{service}.{host}.{name}". Tags not referenced are
+	// appended as "key.value" segments sorted by key. By default this
+	// will be set to DefaultTemplate.
+	Template string
+
+	// MissingTagValue is used for tags referenced by the template that
+	// a metric does not have, by default this will be set to
+	// DefaultMissingTagValue.
+	MissingTagValue string
+
+	// CommonTags are added to every metric.
+	CommonTags map[string]string
+
+	// MaxBatchBytes is the maximum size of a batch, by default this will
+	// be set to DefaultDatagramBatchBytes for UDP and to
+	// DefaultStreamBatchBytes otherwise.
+	MaxBatchBytes int
+
+	// PoolSize is the number of TCP connections batches are sent over
+	// concurrently, by default this will be set to DefaultPoolSize.
+	PoolSize int
+
+	// QueueSize is the number of batches buffered while previous batches
+	// are being sent, by default this will be set to DefaultQueueSize.
+	QueueSize int
+
+	// DialTimeout and WriteTimeout are the timeouts of connecting and of
+	// writing a batch, by default these will be set to DefaultDialTimeout
+	// and DefaultWriteTimeout.
+	DialTimeout  time.Duration
+	WriteTimeout time.Duration
+
+	// MinReconnectBackoff and MaxReconnectBackoff bound the exponential
+	// wait before reconnecting after a failure to connect, batches sent
+	// in the meantime are dropped. By default these will be set to
+	// DefaultMinReconnectBackoff and DefaultMaxReconnectBackoff.
+	MinReconnectBackoff time.Duration
+	MaxReconnectBackoff time.Duration
+
+	// SanitizeOptions if not nil will be used to sanitize names, tag
+	// keys and tag values, by default DefaultSanitizerOpts is used.
+	SanitizeOptions *tally.SanitizeOptions
+
+	// OnError is called with any error encountered sending metrics, by
+	// default errors are ignored.
+	OnError func(err error)
+}
+
+type point struct {
+	path      string
+	value     float64
+	timestamp int64
+}
+
+type pathKey struct {
+	name   string
+	tags   uint64
+	bucket string
+}
+
+// templatePart is either a literal or a placeholder of a template.
+type templatePart struct {
+	literal     string
+	placeholder string
+}
+
+type reporter struct {
+	opts      Options
+	pool      *pool
+	sanitizer tally.Sanitizer
+	template  []templatePart
+	common    map[string]string
+
+	pathsMu sync.RWMutex
+	paths   map[pathKey]string
+
+	sync.Mutex
+	points []point
+	size   int
+	closed bool
+}
+
+// NewReporter returns a new Graphite reporter.
+func NewReporter(opts Options) (Reporter, error) {
+	if opts.Address == "" {
+		return nil, errNoAddress
+	}
+	if opts.MaxBatchBytes <= 0 {
+		opts.MaxBatchBytes = DefaultStreamBatchBytes
+		if opts.Protocol == UDP {
+			opts.MaxBatchBytes = DefaultDatagramBatchBytes
+		}
+	}
+	if opts.PoolSize <= 0 {
+		opts.PoolSize = DefaultPoolSize
+	}
+	if opts.QueueSize <= 0 {
+		opts.QueueSize = DefaultQueueSize
+	}
+	if opts.DialTimeout <= 0 {
+		opts.DialTimeout = DefaultDialTimeout
+	}
+	if opts.WriteTimeout <= 0 {
+		opts.WriteTimeout = DefaultWriteTimeout
+	}
+	if opts.MinReconnectBackoff <= 0 {
+		opts.MinReconnectBackoff = DefaultMinReconnectBackoff
+	}
+	if opts.MaxReconnectBackoff <= 0 {
+		opts.MaxReconnectBackoff = DefaultMaxReconnectBackoff
+	}
+	if opts.Template == "" {
+		opts.Template = DefaultTemplate
+	}
+	if opts.MissingTagValue == "" {
+		opts.MissingTagValue = DefaultMissingTagValue
+	}
+	if opts.SanitizeOptions == nil {
+		opts.SanitizeOptions = &DefaultSanitizerOpts
+	}
+
+	template, err := parseTemplate(opts.Template)
+	if err != nil {
+		return nil, err
+	}
+
+	var p *pool
+	switch opts.Protocol {
+	case TCP, Pickle:
+		p = newPool("tcp", opts.PoolSize, opts)
+	case UDP:
+		p = newPool("udp", 1, opts)
+	default:
+		return nil, fmt.Errorf("unknown protocol %d", opts.Protocol)
+	}
+
+	return &reporter{
+		opts:      opts,
+		pool:      p,
+		sanitizer: tally.NewSanitizer(*opts.SanitizeOptions),
+		template:  template,
+		common:    opts.CommonTags,
+		paths:     make(map[pathKey]string),
+	}, nil
+}
+
+// parseTemplate splits a template into literals and placeholders.
+func parseTemplate(template string) ([]templatePart, error) {
+	var parts []templatePart
+	for template != "" {
+		start := strings.IndexByte(template, '{')
+		if start < 0 {
+			parts = append(parts, templatePart{literal: template})
+			break
+		}
+		end := strings.IndexByte(template[start:], '}')
+		if end < 0 {
+			return nil, fmt.Errorf("unterminated placeholder in template %q", template)
+		}
+		end += start
+		if start > 0 {
+			parts = append(parts, templatePart{literal: template[:start]})
+		}
+		placeholder := template[start+1 : end]
+		if placeholder == "" {
+			return nil, errors.New("empty placeholder in template")
+		}
+		parts = append(parts, templatePart{placeholder: placeholder})
+		template = template[end+1:]
+	}
+	return parts, nil
+}
+
+func (r *reporter) Capabilities() tally.Capabilities {
+	return r
+}
+
+func (r *reporter) Reporting() bool {
+	return true
+}
+
+func (r *reporter) Tagging() bool {
+	return true
+}
+
+func (r *reporter) ReportCounter(
+	name string,
+	tags map[string]string,
+	value int64,
+) {
+	r.report(r.path(name, tags, ""), float64(value))
+}
+
+func (r *reporter) ReportGauge(
+	name string,
+	tags map[string]string,
+	value float64,
+) {
+	r.report(r.path(name, tags, ""), value)
+}
+
+// ReportTimer reports the interval in milliseconds, as is the Graphite
+// convention.
+func (r *reporter) ReportTimer(
+	name string,
+	tags map[string]string,
+	interval time.Duration,
+) {
+	r.report(r.path(name, tags, ""), float64(interval)/float64(time.Millisecond))
+}
+
+func (r *reporter) ReportHistogramValueSamples(
+	name string,
+	tags map[string]string,
+	buckets tally.Buckets,
+	bucketLowerBound float64,
+	bucketUpperBound float64,
+	samples int64,
+) {
+	bound := "inf"
+	if bucketUpperBound != math.MaxFloat64 {
+		bound = strconv.FormatFloat(bucketUpperBound, 'f', -1, 64)
+	}
+	r.report(r.path(name, tags, bound), float64(samples))
+}
+
+func (r *reporter) ReportHistogramDurationSamples(
+	name string,
+	tags map[string]string,
+	buckets tally.Buckets,
+	bucketLowerBound time.Duration,
+	bucketUpperBound time.Duration,
+	samples int64,
+) {
+	bound := "inf"
+	if bucketUpperBound != time.Duration(math.MaxInt64) {
+		bound = bucketUpperBound.String()
+	}
+	r.report(r.path(name, tags, bound), float64(samples))
+}
+
+// path returns the cached path of a metric, with the histogram bucket
+// upper bound as the BucketTagName tag if not empty.
+func (r *reporter) path(name string, tags map[string]string, bucket string) string {
+	key := pathKey{name: name, tags: cache.TagMapKey(tags), bucket: bucket}
+
+	r.pathsMu.RLock()
+	path, ok := r.paths[key]
+	r.pathsMu.RUnlock()
+	if ok {
+		return path
+	}
+
+	merged := make(map[string]string, len(r.common)+len(tags)+1)
+	for k, v := range r.common {
+		merged[r.sanitizer.Key(k)] = r.sanitizer.Value(v)
+	}
+	for k, v := range tags {
+		merged[r.sanitizer.Key(k)] = r.sanitizer.Value(v)
+	}
+	if bucket != "" {
+		merged[BucketTagName] = r.sanitizer.Value(bucket)
+	}
+
+	name = r.sanitizer.Name(name)
+	if r.opts.Prefix != "" {
+		name = r.opts.Prefix + "." + name
+	}
+
+	if r.opts.TagMode == Flatten {
+		path = r.flatten(name, merged)
+	} else {
+		path = tagged(name, merged)
+	}
+
+	r.pathsMu.Lock()
+	r.paths[key] = path
+	r.pathsMu.Unlock()
+	return path
+}
+
+func tagged(name string, tags map[string]string) string {
+	var sb strings.Builder
+	sb.WriteString(name)
+	for _, k := range sortedKeys(tags) {
+		if tags[k] == "" {
+			// Graphite does not accept empty tag values.
+			continue
+		}
+		sb.WriteByte(';')
+		sb.WriteString(k)
+		sb.WriteByte('=')
+		sb.WriteString(tags[k])
+	}
+	return sb.String()
+}
+
+func (r *reporter) flatten(name string, tags map[string]string) string {
+	var (
+		sb   strings.Builder
+		used = make(map[string]struct{}, len(tags))
+	)
+	for _, part := range r.template {
+		switch {
+		case part.placeholder == nameKey:
+			sb.WriteString(name)
+		case part.placeholder != "":
+			used[part.placeholder] = struct{}{}
+			if v := tags[part.placeholder]; v != "" {
+				sb.WriteString(v)
+			} else {
+				sb.WriteString(r.opts.MissingTagValue)
+			}
+		default:
+			sb.WriteString(part.literal)
+		}
+	}
+	for _, k := range sortedKeys(tags) {
+		if _, ok := used[k]; ok || tags[k] == "" {
+			continue
+		}
+		sb.WriteByte('.')
+		sb.WriteString(k)
+		sb.WriteByte('.')
+		sb.WriteString(tags[k])
+	}
+	return sb.String()
+}
+
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func (r *reporter) report(path string, value float64) {
+	p := point{path: path, value: value, timestamp: time.Now().Unix()}
+	// An upper bound of the encoded size of the value and timestamp in
+	// either protocol.
+	size := len(path) + 48
+
+	r.Lock()
+	defer r.Unlock()
+
+	if r.closed {
+		return
+	}
+	if len(r.points) > 0 && r.size+size > r.opts.MaxBatchBytes {
+		r.flushWithLock()
+	}
+	r.points = append(r.points, p)
+	r.size += size
+}
+
+// Flush sends the current batch.
+func (r *reporter) Flush() {
+	r.Lock()
+	defer r.Unlock()
+
+	r.flushWithLock()
+}
+
+func (r *reporter) flushWithLock() {
+	if len(r.points) == 0 {
+		return
+	}
+
+	var batch []byte
+	if r.opts.Protocol == Pickle {
+		batch = appendPickle(batch, r.points)
+	} else {
+		batch = appendPlaintext(batch, r.points)
+	}
+	r.pool.enqueue(batch)
+	r.points = r.points[:0]
+	r.size = 0
+}
+
+func appendPlaintext(b []byte, points []point) []byte {
+	for _, p := range points {
+		b = append(b, p.path...)
+		b = append(b, ' ')
+		b = strconv.AppendFloat(b, p.value, 'f', -1, 64)
+		b = append(b, ' ')
+		b = strconv.AppendInt(b, p.timestamp, 10)
+		b = append(b, '\n')
+	}
+	return b
+}
+
+// Close sends the current batch and waits for queued batches to be sent.
+func (r *reporter) Close() error {
+	r.Lock()
+	if r.closed {
+		r.Unlock()
+		return errAlreadyClosed
+	}
+	r.flushWithLock()
+	r.closed = true
+	r.Unlock()
+
+	return r.pool.close()
+}
@@ -0,0 +1,372 @@
+// Copyright (c) 2022 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package graphite
+
+import (
+	"bufio"
+	"encoding/binary"
+	"io"
+	"math"
+	"net"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// carbon is a stand-in for a Carbon plaintext or pickle receiver.
+type carbon struct {
+	listener net.Listener
+	conns    chan net.Conn
+	lines    chan string
+	batches  chan []byte
+}
+
+func newCarbon(t *testing.T, pickle bool) *carbon {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	c := &carbon{
+		listener: listener,
+		conns:    make(chan net.Conn, 10),
+		lines:    make(chan string, 100),
+		batches:  make(chan []byte, 100),
+	}
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			c.conns <- conn
+			if pickle {
+				go c.readPickle(conn)
+			} else {
+				go c.readLines(conn)
+			}
+		}
+	}()
+	return c
+}
+
+func (c *carbon) readLines(conn net.Conn) {
+	scanner := bufio.NewScanner(conn)
+	for scanner.Scan() {
+		c.lines <- scanner.Text()
+	}
+}
+
+func (c *carbon) readPickle(conn net.Conn) {
+	for {
+		var header [4]byte
+		if _, err := io.ReadFull(conn, header[:]); err != nil {
+			return
+		}
+		payload := make([]byte, binary.BigEndian.Uint32(header[:]))
+		if _, err := io.ReadFull(conn, payload); err != nil {
+			return
+		}
+		c.batches <- payload
+	}
+}
+
+func (c *carbon) close() {
+	c.listener.Close()
+}
+
+func receive(t *testing.T, ch chan string, n int) []string {
+	var result []string
+	for i := 0; i < n; i++ {
+		select {
+		case line := <-ch:
+			result = append(result, line)
+		case <-time.After(5 * time.Second):
+			t.Fatalf("timed out waiting for %d lines, got %v", n, result)
+		}
+	}
+	return result
+}
+
+// withoutTimestamps strips the trailing timestamp of plaintext lines.
+func withoutTimestamps(t *testing.T, lines []string) []string {
+	var result []string
+	for _, line := range lines {
+		idx := strings.LastIndexByte(line, ' ')
+		require.True(t, idx > 0)
+		result = append(result, line[:idx])
+	}
+	return result
+}
+
+func TestReporterTaggedSeries(t *testing.T) {
+	c := newCarbon(t, false)
+	defer c.close()
+
+	r, err := NewReporter(Options{
+		Address:    c.listener.Addr().String(),
+		Prefix:     "app",
+		CommonTags: map[string]string{"service": "api"},
+	})
+	require.NoError(t, err)
+
+	tags := map[string]string{"host": "a.b", "dc": "east"}
+	r.ReportCounter("requests", tags, 3)
+	r.ReportGauge("queue.depth", tags, 1.5)
+	r.ReportTimer("latency", nil, 1500*time.Microsecond)
+	r.ReportHistogramValueSamples("sizes", nil, nil, 0, 0.5, 4)
+	r.ReportHistogramDurationSamples("waits", nil, nil, time.Second, math.MaxInt64, 2)
+	require.NoError(t, r.Close())
+
+	assert.Equal(t, []string{
+		"app.requests;dc=east;host=a_b;service=api 3",
+		"app.queue.depth;dc=east;host=a_b;service=api 1.5",
+		"app.latency;service=api 1.5",
+		"app.sizes;le=0_5;service=api 4",
+		"app.waits;le=inf;service=api 2",
+	}, withoutTimestamps(t, receive(t, c.lines, 5)))
+}
+
+func TestReporterFlattenTemplate(t *testing.T) {
+	c := newCarbon(t, false)
+	defer c.close()
+
+	r, err := NewReporter(Options{
+		Address:  c.listener.Addr().String(),
+		TagMode:  Flatten,
+		Template: "servers.{host}.{name}",
+	})
+	require.NoError(t, err)
+
+	r.ReportCounter("requests", map[string]string{"host": "web-1", "env": "prod"}, 1)
+	r.ReportCounter("requests", nil, 2)
+	r.ReportHistogramDurationSamples("waits", map[string]string{"host": "web-1"},
+		nil, 0, 10*time.Millisecond, 3)
+	require.NoError(t, r.Close())
+
+	assert.Equal(t, []string{
+		"servers.web-1.requests.env.prod 1",
+		"servers.none.requests 2",
+		"servers.web-1.waits.le.10ms 3",
+	}, withoutTimestamps(t, receive(t, c.lines, 3)))
+}
+
+func TestReporterTimestamps(t *testing.T) {
+	c := newCarbon(t, false)
+	defer c.close()
+
+	r, err := NewReporter(Options{Address: c.listener.Addr().String()})
+	require.NoError(t, err)
+
+	before := time.Now().Unix()
+	r.ReportGauge("queue", nil, 1)
+	require.NoError(t, r.Close())
+
+	fields := strings.Fields(receive(t, c.lines, 1)[0])
+	require.Len(t, fields, 3)
+	assert.Equal(t, "1", fields[1])
+	timestamp, err := strconv.ParseInt(fields[2], 10, 64)
+	require.NoError(t, err)
+	assert.True(t, timestamp >= before && timestamp <= time.Now().Unix())
+}
+
+func TestReporterUDP(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer conn.Close()
+
+	r, err := NewReporter(Options{
+		Address:       conn.LocalAddr().String(),
+		Protocol:      UDP,
+		MaxBatchBytes: 100,
+	})
+	require.NoError(t, err)
+
+	for i := 0; i < 5; i++ {
+		r.ReportCounter("requests", nil, int64(i))
+	}
+	require.NoError(t, r.Close())
+
+	var lines []string
+	buf := make([]byte, 65536)
+	for len(lines) < 5 {
+		require.NoError(t, conn.SetReadDeadline(time.Now().Add(5*time.Second)))
+		n, _, err := conn.ReadFrom(buf)
+		require.NoError(t, err)
+		assert.True(t, n <= 100, "datagram too large: %d", n)
+		lines = append(lines, strings.Split(strings.TrimSuffix(string(buf[:n]), "\n"), "\n")...)
+	}
+	assert.Equal(t, []string{
+		"requests 0", "requests 1", "requests 2", "requests 3", "requests 4",
+	}, withoutTimestamps(t, lines))
+}
+
+// unpickle decodes the subset of pickle protocol 2 written by the
+// reporter into points.
+func unpickle(t *testing.T, b []byte) []point {
+	require.Equal(t, []byte{pickleProto, 2, pickleEmptyList, pickleMark}, b[:4])
+	b = b[4:]
+
+	var points []point
+	for b[0] != pickleAppends {
+		var p point
+		require.Equal(t, byte(pickleBinUnicode), b[0])
+		n := binary.LittleEndian.Uint32(b[1:])
+		p.path = string(b[5 : 5+n])
+		b = b[5+n:]
+
+		switch b[0] {
+		case pickleBinInt:
+			p.timestamp = int64(int32(binary.LittleEndian.Uint32(b[1:])))
+			b = b[5:]
+		case pickleLong1:
+			require.Equal(t, byte(8), b[1])
+			p.timestamp = int64(binary.LittleEndian.Uint64(b[2:]))
+			b = b[10:]
+		default:
+			t.Fatalf("unexpected opcode %x", b[0])
+		}
+
+		require.Equal(t, byte(pickleBinFloat), b[0])
+		p.value = math.Float64frombits(binary.BigEndian.Uint64(b[1:]))
+		require.Equal(t, []byte{pickleTuple2, pickleTuple2}, b[9:11])
+		b = b[11:]
+		points = append(points, p)
+	}
+	require.Equal(t, []byte{pickleAppends, pickleStop}, b)
+	return points
+}
+
+func TestReporterPickle(t *testing.T) {
+	c := newCarbon(t, true)
+	defer c.close()
+
+	r, err := NewReporter(Options{
+		Address:  c.listener.Addr().String(),
+		Protocol: Pickle,
+	})
+	require.NoError(t, err)
+
+	r.ReportCounter("requests", map[string]string{"env": "prod"}, 3)
+	r.ReportGauge("queue", nil, -1.25)
+	require.NoError(t, r.Close())
+
+	var batch []byte
+	select {
+	case batch = <-c.batches:
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for pickle batch")
+	}
+
+	points := unpickle(t, batch)
+	require.Len(t, points, 2)
+	assert.Equal(t, "requests;env=prod", points[0].path)
+	assert.Equal(t, 3.0, points[0].value)
+	assert.Equal(t, "queue", points[1].path)
+	assert.Equal(t, -1.25, points[1].value)
+	assert.InDelta(t, time.Now().Unix(), points[0].timestamp, 10)
+}
+
+func TestAppendPickleInt(t *testing.T) {
+	assert.Equal(t, []byte{pickleBinInt, 0xff, 0xff, 0xff, 0x7f}, appendPickleInt(nil, math.MaxInt32))
+	assert.Equal(t,
+		[]byte{pickleLong1, 8, 0, 0, 0, 0x80, 0, 0, 0, 0},
+		appendPickleInt(nil, math.MaxInt32+1))
+}
+
+func TestReporterReconnects(t *testing.T) {
+	c := newCarbon(t, false)
+	defer c.close()
+
+	var errs []error
+	r, err := NewReporter(Options{
+		Address: c.listener.Addr().String(),
+		OnError: func(err error) {
+			errs = append(errs, err)
+		},
+	})
+	require.NoError(t, err)
+	rep := r.(*reporter)
+
+	r.ReportCounter("requests", nil, 1)
+	r.Flush()
+	receive(t, c.lines, 1)
+
+	// Break the client side of the connection, the next batch is
+	// retried on a new connection.
+	rep.pool.conns[0].conn.Close()
+
+	r.ReportCounter("requests", nil, 2)
+	require.NoError(t, r.Close())
+
+	assert.Equal(t, []string{"requests 2"}, withoutTimestamps(t, receive(t, c.lines, 1)))
+	assert.Len(t, c.conns, 2)
+	assert.Empty(t, errs)
+}
+
+func TestReporterReconnectBackoff(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	addr := listener.Addr().String()
+	require.NoError(t, listener.Close())
+
+	errCh := make(chan error, 10)
+	r, err := NewReporter(Options{
+		Address:             addr,
+		MinReconnectBackoff: time.Hour,
+		OnError: func(err error) {
+			errCh <- err
+		},
+	})
+	require.NoError(t, err)
+
+	r.ReportCounter("requests", nil, 1)
+	r.Flush()
+	r.ReportCounter("requests", nil, 2)
+	require.NoError(t, r.Close())
+
+	require.Len(t, errCh, 2)
+	assert.Contains(t, (<-errCh).Error(), "failed to connect")
+	assert.Contains(t, (<-errCh).Error(), errReconnectBackoff.Error())
+}
+
+func TestParseTemplate(t *testing.T) {
+	parts, err := parseTemplate("a.{host}-x.{name}")
+	require.NoError(t, err)
+	assert.Equal(t, []templatePart{
+		{literal: "a."},
+		{placeholder: "host"},
+		{literal: "-x."},
+		{placeholder: "name"},
+	}, parts)
+
+	_, err = parseTemplate("a.{host")
+	assert.Error(t, err)
+	_, err = parseTemplate("a.{}")
+	assert.Error(t, err)
+}
+
+func TestReporterRequiresAddress(t *testing.T) {
+	_, err := NewReporter(Options{})
+	assert.Equal(t, errNoAddress, err)
+}
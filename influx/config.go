@@ -0,0 +1,107 @@
+// Copyright (c) 2022 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package influx
+
+import (
+	"fmt"
+	"time"
+)
+
+// Configuration is a configuration for an InfluxDB reporter.
+type Configuration struct {
+	// Protocol is one of "udp" (the default), "http", "httpv2" or "unix".
+	Protocol string `yaml:"protocol"`
+
+	// Address is the host:port for udp, the base URL of the InfluxDB
+	// server for http and httpv2 and the socket path for unix.
+	Address string `yaml:"address" validate:"nonzero"`
+
+	// Database and RetentionPolicy are used with http.
+	Database        string `yaml:"database"`
+	RetentionPolicy string `yaml:"retentionPolicy"`
+
+	// Username and Password are used for basic auth with http.
+	Username string `yaml:"username"`
+	Password string `yaml:"password"`
+
+	// Organization, Bucket and Token are used with httpv2.
+	Organization string `yaml:"organization"`
+	Bucket       string `yaml:"bucket"`
+	Token        string `yaml:"token"`
+
+	// CommonTags are added to every line.
+	CommonTags map[string]string `yaml:"commonTags"`
+
+	// MaxBatchBytes is the maximum size of a batch.
+	MaxBatchBytes int `yaml:"maxBatchBytes"`
+
+	// QueueSize is the number of batches buffered while a previous
+	// batch is being sent.
+	QueueSize int `yaml:"queueSize"`
+
+	// Timeout is the timeout of writing a single batch.
+	Timeout time.Duration `yaml:"timeout"`
+
+	// HistogramMode is one of "tag" (the default) or "fields".
+	HistogramMode string `yaml:"histogramMode"`
+}
+
+// NewReporter creates a new InfluxDB reporter from this configuration.
+func (c Configuration) NewReporter() (Reporter, error) {
+	opts := Options{
+		Address:         c.Address,
+		Database:        c.Database,
+		RetentionPolicy: c.RetentionPolicy,
+		Username:        c.Username,
+		Password:        c.Password,
+		Organization:    c.Organization,
+		Bucket:          c.Bucket,
+		Token:           c.Token,
+		CommonTags:      c.CommonTags,
+		MaxBatchBytes:   c.MaxBatchBytes,
+		QueueSize:       c.QueueSize,
+		Timeout:         c.Timeout,
+	}
+
+	switch c.Protocol {
+	case "", "udp":
+		opts.Protocol = UDP
+	case "http":
+		opts.Protocol = HTTP
+	case "httpv2":
+		opts.Protocol = HTTPv2
+	case "unix":
+		opts.Protocol = Unix
+	default:
+		return nil, fmt.Errorf("unknown protocol %q", c.Protocol)
+	}
+
+	switch c.HistogramMode {
+	case "", "tag":
+		opts.HistogramMode = BucketTag
+	case "fields":
+		opts.HistogramMode = BucketFields
+	default:
+		return nil, fmt.Errorf("unknown histogram mode %q", c.HistogramMode)
+	}
+
+	return NewReporter(opts)
+}
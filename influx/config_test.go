@@ -0,0 +1,58 @@
+// Copyright (c) 2022 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package influx
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestConfigSimple(t *testing.T) {
+	c := Configuration{
+		Protocol:      "httpv2",
+		Address:       "http://localhost:8086",
+		Organization:  "org",
+		Bucket:        "bucket",
+		HistogramMode: "fields",
+	}
+	r, err := c.NewReporter()
+	require.NoError(t, err)
+	defer r.Close()
+
+	reporter := r.(*reporter)
+	assert.Equal(t, BucketFields, reporter.mode)
+	assert.Equal(t, DefaultStreamBatchBytes, reporter.maxBatch)
+
+	w, ok := reporter.writer.writer.(*httpWriter)
+	require.True(t, ok)
+	assert.Equal(t,
+		"http://localhost:8086/api/v2/write?bucket=bucket&org=org&precision=ns", w.url)
+}
+
+func TestConfigUnknownValues(t *testing.T) {
+	_, err := Configuration{Address: "localhost:8089", Protocol: "tcp"}.NewReporter()
+	assert.Error(t, err)
+
+	_, err = Configuration{Address: "localhost:8089", HistogramMode: "le"}.NewReporter()
+	assert.Error(t, err)
+}
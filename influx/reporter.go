@@ -0,0 +1,453 @@
+// Copyright (c) 2022 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package influx
+
+import (
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	tally "github.com/uber-go/tally/v4"
+	"github.com/uber-go/tally/v4/internal/cache"
+)
+
+// Protocol describes how line protocol batches are sent to InfluxDB.
+type Protocol int
+
+const (
+	// UDP sends batches as datagrams to a host:port.
+	UDP Protocol = iota
+
+	// HTTP sends batches to the InfluxDB 1.x /write API.
+	HTTP
+
+	// HTTPv2 sends batches to the InfluxDB 2.x /api/v2/write API.
+	HTTPv2
+
+	// Unix sends batches over a Unix stream socket, such as the one of
+	// a Telegraf socket listener.
+	Unix
+)
+
+// HistogramMode describes how histogram buckets are written.
+type HistogramMode int
+
+const (
+	// BucketTag writes a line per bucket with the bucket upper bound as
+	// the "le" tag and its sample count as the "count" field.
+	BucketTag HistogramMode = iota
+
+	// BucketFields writes a field per bucket named after the bucket
+	// upper bound, such as "le_10", holding its sample count.
+	BucketFields
+)
+
+const (
+	// DefaultDatagramBatchBytes is the default maximum batch size of
+	// UDP, chosen to fit a single packet on most networks.
+	DefaultDatagramBatchBytes = 1432
+
+	// DefaultStreamBatchBytes is the default maximum batch size of the
+	// HTTP and Unix socket protocols.
+	DefaultStreamBatchBytes = 512 * 1024
+
+	// DefaultQueueSize is the default number of batches buffered while
+	// a previous batch is being sent.
+	DefaultQueueSize = 64
+
+	// DefaultTimeout is the default timeout of writing a single batch.
+	DefaultTimeout = 5 * time.Second
+
+	// BucketTagName is the tag holding the bucket upper bound in the
+	// BucketTag histogram mode.
+	BucketTagName = "le"
+)
+
+var (
+	errNoAddress     = errors.New("no address specified")
+	errQueueFull     = errors.New("influx write queue full, dropping batch")
+	errAlreadyClosed = errors.New("reporter already closed")
+
+	// DefaultSanitizerOpts are the options for the default InfluxDB
+	// sanitizer.
+	DefaultSanitizerOpts = tally.SanitizeOptions{
+		NameCharacters: tally.ValidCharacters{
+			Ranges:     tally.AlphanumericRange,
+			Characters: tally.UnderscoreDashDotCharacters,
+		},
+		KeyCharacters: tally.ValidCharacters{
+			Ranges:     tally.AlphanumericRange,
+			Characters: tally.UnderscoreDashCharacters,
+		},
+		ValueCharacters: tally.ValidCharacters{
+			Ranges:     tally.AlphanumericRange,
+			Characters: tally.UnderscoreDashDotCharacters,
+		},
+		ReplacementCharacter: tally.DefaultReplacementCharacter,
+	}
+
+	// escaper escapes measurements, tag keys, tag values and field keys
+	// so that any sanitizer may be used.
+	escaper = strings.NewReplacer(`,`, `\,`, ` `, `\ `, `=`, `\=`)
+)
+
+// Reporter is a tally.StatsReporter that writes InfluxDB line protocol.
+type Reporter interface {
+	tally.StatsReporter
+	io.Closer
+}
+
+// Options is a set of options for the InfluxDB reporter.
+type Options struct {
+	// Protocol is how batches are sent, by default UDP.
+	Protocol Protocol
+
+	// Address is the host:port for UDP, the base URL of the InfluxDB
+	// server for HTTP and HTTPv2 and the socket path for Unix.
+	Address string
+
+	// Database and RetentionPolicy are the database and optional
+	// retention policy written to with HTTP.
+	Database        string
+	RetentionPolicy string
+
+	// Username and Password if specified are used for basic auth
+	// with HTTP.
+	Username string
+	Password string
+
+	// Organization, Bucket and Token are the organization, bucket and
+	// API token used with HTTPv2.
+	Organization string
+	Bucket       string
+	Token        string
+
+	// CommonTags are added to every line.
+	CommonTags map[string]string
+
+	// MaxBatchBytes is the maximum size of a batch, by default this will
+	// be set to DefaultDatagramBatchBytes for UDP and to
+	// DefaultStreamBatchBytes otherwise.
+	MaxBatchBytes int
+
+	// QueueSize is the number of batches buffered while a previous batch
+	// is being sent, by default this will be set to DefaultQueueSize.
+	QueueSize int
+
+	// Timeout is the timeout of writing a single batch, by default this
+	// will be set to DefaultTimeout.
+	Timeout time.Duration
+
+	// HistogramMode is how histogram buckets are written, by default
+	// the BucketTag mode.
+	HistogramMode HistogramMode
+
+	// SanitizeOptions if not nil will be used to sanitize names, tag
+	// keys and tag values, by default DefaultSanitizerOpts is used.
+	SanitizeOptions *tally.SanitizeOptions
+
+	// HTTPClient is the client used with HTTP and HTTPv2, by default a
+	// client with Timeout is used.
+	HTTPClient HTTPClient
+
+	// OnError is called with any error encountered writing batches, by
+	// default errors are ignored.
+	OnError func(err error)
+}
+
+type reporter struct {
+	writer    *batchWriter
+	sanitizer tally.Sanitizer
+	mode      HistogramMode
+	maxBatch  int
+	common    map[string]string
+
+	stringInterner *cache.StringInterner
+	tagSetCache    *cache.TagSetCache
+
+	sync.Mutex
+	buf       []byte
+	line      []byte
+	cycleTime int64
+	closed    bool
+}
+
+// NewReporter returns a new InfluxDB line protocol reporter.
+func NewReporter(opts Options) (Reporter, error) {
+	if opts.Address == "" {
+		return nil, errNoAddress
+	}
+	if opts.MaxBatchBytes <= 0 {
+		opts.MaxBatchBytes = DefaultStreamBatchBytes
+		if opts.Protocol == UDP {
+			opts.MaxBatchBytes = DefaultDatagramBatchBytes
+		}
+	}
+	if opts.QueueSize <= 0 {
+		opts.QueueSize = DefaultQueueSize
+	}
+	if opts.Timeout <= 0 {
+		opts.Timeout = DefaultTimeout
+	}
+	if opts.SanitizeOptions == nil {
+		opts.SanitizeOptions = &DefaultSanitizerOpts
+	}
+
+	w, err := newWriter(opts)
+	if err != nil {
+		return nil, err
+	}
+
+	return &reporter{
+		writer:         newBatchWriter(w, opts),
+		sanitizer:      tally.NewSanitizer(*opts.SanitizeOptions),
+		mode:           opts.HistogramMode,
+		maxBatch:       opts.MaxBatchBytes,
+		common:         opts.CommonTags,
+		stringInterner: cache.NewStringInterner(),
+		tagSetCache:    cache.NewTagSetCache(),
+	}, nil
+}
+
+func (r *reporter) Capabilities() tally.Capabilities {
+	return r
+}
+
+func (r *reporter) Reporting() bool {
+	return true
+}
+
+func (r *reporter) Tagging() bool {
+	return true
+}
+
+func (r *reporter) ReportCounter(
+	name string,
+	tags map[string]string,
+	value int64,
+) {
+	r.Lock()
+	r.line = r.appendSeries(r.line[:0], name, tags)
+	r.line = append(r.line, " value="...)
+	r.line = strconv.AppendInt(r.line, value, 10)
+	r.line = append(r.line, 'i')
+	r.writeLineWithLock(r.cycleTimeWithLock())
+	r.Unlock()
+}
+
+func (r *reporter) ReportGauge(
+	name string,
+	tags map[string]string,
+	value float64,
+) {
+	r.Lock()
+	r.line = r.appendSeries(r.line[:0], name, tags)
+	r.line = append(r.line, " value="...)
+	r.line = strconv.AppendFloat(r.line, value, 'f', -1, 64)
+	r.writeLineWithLock(r.cycleTimeWithLock())
+	r.Unlock()
+}
+
+// ReportTimer writes the interval in nanoseconds. Unlike other metrics
+// timers are timestamped with the time they are reported, as they are
+// reported immediately and values sharing a timestamp overwrite each
+// other.
+func (r *reporter) ReportTimer(
+	name string,
+	tags map[string]string,
+	interval time.Duration,
+) {
+	r.Lock()
+	r.line = r.appendSeries(r.line[:0], name, tags)
+	r.line = append(r.line, " value="...)
+	r.line = strconv.AppendInt(r.line, int64(interval), 10)
+	r.line = append(r.line, 'i')
+	r.writeLineWithLock(time.Now().UnixNano())
+	r.Unlock()
+}
+
+func (r *reporter) ReportHistogramValueSamples(
+	name string,
+	tags map[string]string,
+	buckets tally.Buckets,
+	bucketLowerBound float64,
+	bucketUpperBound float64,
+	samples int64,
+) {
+	bound := "+Inf"
+	if bucketUpperBound != math.MaxFloat64 {
+		bound = strconv.FormatFloat(bucketUpperBound, 'f', -1, 64)
+	}
+	r.reportBucket(name, tags, bound, samples)
+}
+
+func (r *reporter) ReportHistogramDurationSamples(
+	name string,
+	tags map[string]string,
+	buckets tally.Buckets,
+	bucketLowerBound time.Duration,
+	bucketUpperBound time.Duration,
+	samples int64,
+) {
+	bound := "+Inf"
+	if bucketUpperBound != time.Duration(math.MaxInt64) {
+		bound = bucketUpperBound.String()
+	}
+	r.reportBucket(name, tags, bound, samples)
+}
+
+// reportBucket writes the samples of a bucket, all buckets of a flush
+// share a timestamp so that the BucketFields mode results in a single
+// point per histogram.
+func (r *reporter) reportBucket(
+	name string,
+	tags map[string]string,
+	bound string,
+	samples int64,
+) {
+	r.Lock()
+	defer r.Unlock()
+
+	r.line = r.appendSeries(r.line[:0], name, tags)
+	switch r.mode {
+	case BucketFields:
+		r.line = append(r.line, " le_"...)
+		r.line = append(r.line, escaper.Replace(bound)...)
+		r.line = append(r.line, '=')
+	default:
+		r.line = append(r.line, ","+BucketTagName+"="...)
+		r.line = append(r.line, escaper.Replace(bound)...)
+		r.line = append(r.line, " count="...)
+	}
+	r.line = strconv.AppendInt(r.line, samples, 10)
+	r.line = append(r.line, 'i')
+	r.writeLineWithLock(r.cycleTimeWithLock())
+}
+
+// appendSeries appends the measurement and tag set of a line.
+func (r *reporter) appendSeries(
+	b []byte,
+	name string,
+	tags map[string]string,
+) []byte {
+	b = append(b, escaper.Replace(r.sanitizer.Name(name))...)
+	return append(b, r.tagSet(tags)...)
+}
+
+// tagSet returns the common tags merged with tags, sanitized, escaped
+// and sorted by key as recommended by InfluxDB.
+func (r *reporter) tagSet(tags map[string]string) string {
+	key := cache.TagMapKey(tags)
+	if tagSet, ok := r.tagSetCache.Get(key); ok {
+		return tagSet
+	}
+
+	merged := make(map[string]string, len(r.common)+len(tags))
+	for k, v := range r.common {
+		merged[r.sanitizer.Key(k)] = r.sanitizer.Value(v)
+	}
+	for k, v := range tags {
+		merged[r.sanitizer.Key(k)] = r.sanitizer.Value(v)
+	}
+
+	keys := make([]string, 0, len(merged))
+	for k := range merged {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var sb strings.Builder
+	for _, k := range keys {
+		v := merged[k]
+		if k == "" || v == "" {
+			// Empty tag keys and values are not valid line protocol.
+			continue
+		}
+		sb.WriteByte(',')
+		sb.WriteString(escaper.Replace(k))
+		sb.WriteByte('=')
+		sb.WriteString(escaper.Replace(v))
+	}
+	return r.tagSetCache.Set(key, r.stringInterner.Intern(sb.String()))
+}
+
+// cycleTimeWithLock returns the timestamp shared by all metrics reported
+// until the next flush.
+func (r *reporter) cycleTimeWithLock() int64 {
+	if r.cycleTime == 0 {
+		r.cycleTime = time.Now().UnixNano()
+	}
+	return r.cycleTime
+}
+
+// writeLineWithLock appends the timestamp to the current line and adds it
+// to the batch, sending the batch first if the line would not fit.
+func (r *reporter) writeLineWithLock(timestamp int64) {
+	if r.closed {
+		return
+	}
+
+	r.line = append(r.line, ' ')
+	r.line = strconv.AppendInt(r.line, timestamp, 10)
+	r.line = append(r.line, '\n')
+
+	if len(r.buf) > 0 && len(r.buf)+len(r.line) > r.maxBatch {
+		r.writer.enqueue(r.buf)
+		r.buf = nil
+	}
+	r.buf = append(r.buf, r.line...)
+}
+
+// Flush sends the current batch.
+func (r *reporter) Flush() {
+	r.Lock()
+	defer r.Unlock()
+
+	r.flushWithLock()
+}
+
+func (r *reporter) flushWithLock() {
+	r.cycleTime = 0
+	if len(r.buf) == 0 {
+		return
+	}
+	r.writer.enqueue(r.buf)
+	r.buf = nil
+}
+
+// Close sends the current batch and waits for queued batches to be sent.
+func (r *reporter) Close() error {
+	r.Lock()
+	if r.closed {
+		r.Unlock()
+		return errAlreadyClosed
+	}
+	r.flushWithLock()
+	r.closed = true
+	r.Unlock()
+
+	return r.writer.close()
+}
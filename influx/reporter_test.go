@@ -0,0 +1,356 @@
+// Copyright (c) 2022 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package influx
+
+import (
+	"bufio"
+	"io/ioutil"
+	"math"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	tally "github.com/uber-go/tally/v4"
+)
+
+var timestampRegexp = regexp.MustCompile(` (\d+)$`)
+
+// withoutTimestamps strips and returns the timestamps of lines.
+func withoutTimestamps(t *testing.T, lines []string) ([]string, []string) {
+	var stripped, timestamps []string
+	for _, line := range lines {
+		m := timestampRegexp.FindStringSubmatch(line)
+		require.NotNil(t, m, "missing timestamp: %q", line)
+		stripped = append(stripped, strings.TrimSuffix(line, m[0]))
+		timestamps = append(timestamps, m[1])
+	}
+	return stripped, timestamps
+}
+
+func splitLines(batch string) []string {
+	return strings.Split(strings.TrimSuffix(batch, "\n"), "\n")
+}
+
+func newUDPListener(t *testing.T) net.PacketConn {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	require.NoError(t, err)
+	return conn
+}
+
+func readDatagram(t *testing.T, conn net.PacketConn) string {
+	buf := make([]byte, 65536)
+	require.NoError(t, conn.SetReadDeadline(time.Now().Add(5*time.Second)))
+	n, _, err := conn.ReadFrom(buf)
+	require.NoError(t, err)
+	return string(buf[:n])
+}
+
+func TestReporterUDP(t *testing.T) {
+	conn := newUDPListener(t)
+	defer conn.Close()
+
+	r, err := NewReporter(Options{
+		Address:    conn.LocalAddr().String(),
+		CommonTags: map[string]string{"service": "api"},
+	})
+	require.NoError(t, err)
+	defer r.Close()
+
+	tags := map[string]string{"host": "a", "dc": "east"}
+	r.ReportCounter("requests", tags, 3)
+	r.ReportGauge("queue.depth", tags, 1.5)
+	r.ReportTimer("latency", tags, 15*time.Millisecond)
+	r.ReportHistogramValueSamples("sizes", tags, nil, 0, 10, 4)
+	r.ReportHistogramDurationSamples("waits", nil, nil,
+		time.Second, time.Duration(1<<63-1), 2)
+	r.Flush()
+
+	lines, timestamps := withoutTimestamps(t, splitLines(readDatagram(t, conn)))
+	assert.Equal(t, []string{
+		"requests,dc=east,host=a,service=api value=3i",
+		"queue.depth,dc=east,host=a,service=api value=1.5",
+		"latency,dc=east,host=a,service=api value=15000000i",
+		"sizes,dc=east,host=a,service=api,le=10 count=4i",
+		"waits,service=api,le=+Inf count=2i",
+	}, lines)
+
+	// All but the timer share the timestamp of the flush.
+	assert.Equal(t, timestamps[0], timestamps[1])
+	assert.Equal(t, timestamps[0], timestamps[3])
+	assert.Equal(t, timestamps[0], timestamps[4])
+	assert.Len(t, timestamps[0], len("1600000000000000000"))
+}
+
+func TestReporterBucketFields(t *testing.T) {
+	conn := newUDPListener(t)
+	defer conn.Close()
+
+	r, err := NewReporter(Options{
+		Address:       conn.LocalAddr().String(),
+		HistogramMode: BucketFields,
+	})
+	require.NoError(t, err)
+	defer r.Close()
+
+	r.ReportHistogramDurationSamples("waits", nil, nil, 0, 10*time.Millisecond, 1)
+	r.ReportHistogramValueSamples("sizes", nil, nil, 10, math.MaxFloat64, 2)
+	r.Flush()
+
+	lines, _ := withoutTimestamps(t, splitLines(readDatagram(t, conn)))
+	assert.Equal(t, []string{
+		"waits le_10ms=1i",
+		"sizes le_+Inf=2i",
+	}, lines)
+}
+
+func TestReporterBatchesToMaxBytes(t *testing.T) {
+	conn := newUDPListener(t)
+	defer conn.Close()
+
+	r, err := NewReporter(Options{
+		Address:       conn.LocalAddr().String(),
+		MaxBatchBytes: 100,
+	})
+	require.NoError(t, err)
+	defer r.Close()
+
+	for i := 0; i < 10; i++ {
+		r.ReportCounter("requests", nil, int64(i))
+	}
+	r.Flush()
+
+	var count int
+	for count < 10 {
+		datagram := readDatagram(t, conn)
+		assert.True(t, len(datagram) <= 100, "datagram too large: %d", len(datagram))
+		count += len(splitLines(datagram))
+	}
+	assert.Equal(t, 10, count)
+}
+
+func TestReporterEscapes(t *testing.T) {
+	conn := newUDPListener(t)
+	defer conn.Close()
+
+	chars := tally.ValidCharacters{
+		Ranges:     tally.AlphanumericRange,
+		Characters: []rune{' ', ',', '='},
+	}
+	r, err := NewReporter(Options{
+		Address: conn.LocalAddr().String(),
+		SanitizeOptions: &tally.SanitizeOptions{
+			NameCharacters:       chars,
+			KeyCharacters:        chars,
+			ValueCharacters:      chars,
+			ReplacementCharacter: '_',
+		},
+	})
+	require.NoError(t, err)
+	defer r.Close()
+
+	r.ReportGauge("my gauge,x", map[string]string{"a=b": "c d", "empty": ""}, 1)
+	r.Flush()
+
+	lines, _ := withoutTimestamps(t, splitLines(readDatagram(t, conn)))
+	assert.Equal(t, []string{`my\ gauge\,x,a\=b=c\ d value=1`}, lines)
+}
+
+func TestReporterDefaultSanitizer(t *testing.T) {
+	conn := newUDPListener(t)
+	defer conn.Close()
+
+	r, err := NewReporter(Options{Address: conn.LocalAddr().String()})
+	require.NoError(t, err)
+	defer r.Close()
+
+	r.ReportCounter("my counter", map[string]string{"a:b": "c/d"}, 1)
+	r.Flush()
+
+	lines, _ := withoutTimestamps(t, splitLines(readDatagram(t, conn)))
+	assert.Equal(t, []string{"my_counter,a_b=c_d value=1i"}, lines)
+}
+
+type influxServer struct {
+	sync.Mutex
+	requests []*http.Request
+	bodies   []string
+	status   int
+}
+
+func (s *influxServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.Lock()
+	defer s.Unlock()
+
+	body, _ := ioutil.ReadAll(r.Body)
+	s.requests = append(s.requests, r)
+	s.bodies = append(s.bodies, string(body))
+	if s.status != 0 {
+		w.WriteHeader(s.status)
+		_, _ = w.Write([]byte(`{"error":"bad request"}`))
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func TestReporterHTTP(t *testing.T) {
+	s := &influxServer{}
+	server := httptest.NewServer(s)
+	defer server.Close()
+
+	r, err := NewReporter(Options{
+		Protocol:        HTTP,
+		Address:         server.URL + "/",
+		Database:        "metrics",
+		RetentionPolicy: "week",
+		Username:        "user",
+		Password:        "secret",
+	})
+	require.NoError(t, err)
+
+	r.ReportCounter("requests", nil, 1)
+	require.NoError(t, r.Close())
+
+	s.Lock()
+	defer s.Unlock()
+	require.Len(t, s.requests, 1)
+	req := s.requests[0]
+	assert.Equal(t, "/write", req.URL.Path)
+	assert.Equal(t, "metrics", req.URL.Query().Get("db"))
+	assert.Equal(t, "week", req.URL.Query().Get("rp"))
+	assert.Equal(t, "ns", req.URL.Query().Get("precision"))
+	user, pass, ok := req.BasicAuth()
+	assert.True(t, ok)
+	assert.Equal(t, "user", user)
+	assert.Equal(t, "secret", pass)
+	lines, _ := withoutTimestamps(t, splitLines(s.bodies[0]))
+	assert.Equal(t, []string{"requests value=1i"}, lines)
+}
+
+func TestReporterHTTPv2(t *testing.T) {
+	s := &influxServer{}
+	server := httptest.NewServer(s)
+	defer server.Close()
+
+	r, err := NewReporter(Options{
+		Protocol:     HTTPv2,
+		Address:      server.URL,
+		Organization: "org",
+		Bucket:       "bucket",
+		Token:        "token",
+	})
+	require.NoError(t, err)
+
+	r.ReportGauge("queue", nil, 2)
+	require.NoError(t, r.Close())
+
+	s.Lock()
+	defer s.Unlock()
+	require.Len(t, s.requests, 1)
+	req := s.requests[0]
+	assert.Equal(t, "/api/v2/write", req.URL.Path)
+	assert.Equal(t, "org", req.URL.Query().Get("org"))
+	assert.Equal(t, "bucket", req.URL.Query().Get("bucket"))
+	assert.Equal(t, "ns", req.URL.Query().Get("precision"))
+	assert.Equal(t, "Token token", req.Header.Get("Authorization"))
+}
+
+func TestReporterHTTPError(t *testing.T) {
+	s := &influxServer{status: http.StatusBadRequest}
+	server := httptest.NewServer(s)
+	defer server.Close()
+
+	var errs []error
+	r, err := NewReporter(Options{
+		Protocol: HTTP,
+		Address:  server.URL,
+		Database: "metrics",
+		OnError: func(err error) {
+			errs = append(errs, err)
+		},
+	})
+	require.NoError(t, err)
+
+	r.ReportGauge("queue", nil, 2)
+	require.NoError(t, r.Close())
+	assert.Error(t, r.Close())
+
+	require.Len(t, errs, 1)
+	assert.Contains(t, errs[0].Error(), "400")
+	assert.Contains(t, errs[0].Error(), "bad request")
+}
+
+func TestReporterUnix(t *testing.T) {
+	dir, err := ioutil.TempDir("", "tally-test-influx")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "influx.sock")
+	listener, err := net.Listen("unix", path)
+	require.NoError(t, err)
+	defer listener.Close()
+
+	linesCh := make(chan string, 10)
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		scanner := bufio.NewScanner(conn)
+		for scanner.Scan() {
+			linesCh <- scanner.Text()
+		}
+	}()
+
+	r, err := NewReporter(Options{Protocol: Unix, Address: path})
+	require.NoError(t, err)
+
+	r.ReportCounter("requests", nil, 1)
+	r.Flush()
+	r.ReportCounter("requests", nil, 2)
+	require.NoError(t, r.Close())
+
+	var lines []string
+	for i := 0; i < 2; i++ {
+		select {
+		case line := <-linesCh:
+			lines = append(lines, line)
+		case <-time.After(5 * time.Second):
+			t.Fatal("timed out waiting for lines")
+		}
+	}
+	lines, _ = withoutTimestamps(t, lines)
+	assert.Equal(t, []string{"requests value=1i", "requests value=2i"}, lines)
+}
+
+func TestReporterRequiresAddress(t *testing.T) {
+	_, err := NewReporter(Options{})
+	assert.Equal(t, errNoAddress, err)
+}
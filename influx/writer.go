@@ -0,0 +1,251 @@
+// Copyright (c) 2022 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package influx
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// HTTPClient is the subset of *http.Client used to send batches.
+type HTTPClient interface {
+	Do(req *http.Request) (*http.Response, error)
+}
+
+// writer sends a batch of lines to InfluxDB.
+type writer interface {
+	write(batch []byte) error
+	close() error
+}
+
+func newWriter(opts Options) (writer, error) {
+	switch opts.Protocol {
+	case UDP:
+		conn, err := net.Dial("udp", opts.Address)
+		if err != nil {
+			return nil, err
+		}
+		return &datagramWriter{conn: conn}, nil
+	case Unix:
+		return &streamWriter{
+			network: "unix",
+			address: opts.Address,
+			timeout: opts.Timeout,
+		}, nil
+	case HTTP, HTTPv2:
+		return newHTTPWriter(opts)
+	}
+	return nil, fmt.Errorf("unknown protocol %d", opts.Protocol)
+}
+
+// datagramWriter sends every batch as a single datagram.
+type datagramWriter struct {
+	conn net.Conn
+}
+
+func (w *datagramWriter) write(batch []byte) error {
+	_, err := w.conn.Write(batch)
+	return err
+}
+
+func (w *datagramWriter) close() error {
+	return w.conn.Close()
+}
+
+// streamWriter writes batches to a stream connection, reconnecting on
+// the next write after a failure.
+type streamWriter struct {
+	network string
+	address string
+	timeout time.Duration
+	conn    net.Conn
+}
+
+func (w *streamWriter) write(batch []byte) error {
+	if w.conn == nil {
+		conn, err := net.DialTimeout(w.network, w.address, w.timeout)
+		if err != nil {
+			return err
+		}
+		w.conn = conn
+	}
+
+	if err := w.conn.SetWriteDeadline(time.Now().Add(w.timeout)); err != nil {
+		return w.reset(err)
+	}
+	if _, err := w.conn.Write(batch); err != nil {
+		return w.reset(err)
+	}
+	return nil
+}
+
+func (w *streamWriter) reset(err error) error {
+	w.conn.Close()
+	w.conn = nil
+	return err
+}
+
+func (w *streamWriter) close() error {
+	if w.conn == nil {
+		return nil
+	}
+	return w.conn.Close()
+}
+
+// httpWriter posts batches to the 1.x or 2.x write API.
+type httpWriter struct {
+	url    string
+	header http.Header
+	client HTTPClient
+}
+
+func newHTTPWriter(opts Options) (*httpWriter, error) {
+	base, err := url.Parse(strings.TrimSuffix(opts.Address, "/"))
+	if err != nil {
+		return nil, errors.Wrap(err, "invalid address")
+	}
+
+	var (
+		query  = url.Values{"precision": []string{"ns"}}
+		header = make(http.Header)
+	)
+	if opts.Protocol == HTTPv2 {
+		base.Path += "/api/v2/write"
+		query.Set("org", opts.Organization)
+		query.Set("bucket", opts.Bucket)
+		if opts.Token != "" {
+			header.Set("Authorization", "Token "+opts.Token)
+		}
+	} else {
+		base.Path += "/write"
+		query.Set("db", opts.Database)
+		if opts.RetentionPolicy != "" {
+			query.Set("rp", opts.RetentionPolicy)
+		}
+		if opts.Username != "" || opts.Password != "" {
+			auth := opts.Username + ":" + opts.Password
+			header.Set("Authorization",
+				"Basic "+base64.StdEncoding.EncodeToString([]byte(auth)))
+		}
+	}
+	base.RawQuery = query.Encode()
+	header.Set("Content-Type", "text/plain; charset=utf-8")
+
+	client := opts.HTTPClient
+	if client == nil {
+		client = &http.Client{Timeout: opts.Timeout}
+	}
+
+	return &httpWriter{
+		url:    base.String(),
+		header: header,
+		client: client,
+	}, nil
+}
+
+func (w *httpWriter) write(batch []byte) error {
+	req, err := http.NewRequest(http.MethodPost, w.url, bytes.NewReader(batch))
+	if err != nil {
+		return err
+	}
+	for k, v := range w.header {
+		req.Header[k] = v
+	}
+
+	resp, err := w.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		_, _ = io.Copy(ioutil.Discard, resp.Body)
+		return nil
+	}
+	body, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 512))
+	return fmt.Errorf("influx write failed: %s: %s", resp.Status, bytes.TrimSpace(body))
+}
+
+func (w *httpWriter) close() error {
+	return nil
+}
+
+// batchWriter sends batches from a background goroutine so that slow
+// writes never block reporting.
+type batchWriter struct {
+	writer  writer
+	onError func(err error)
+
+	batchCh chan []byte
+	wg      sync.WaitGroup
+}
+
+func newBatchWriter(w writer, opts Options) *batchWriter {
+	bw := &batchWriter{
+		writer:  w,
+		onError: opts.OnError,
+		batchCh: make(chan []byte, opts.QueueSize),
+	}
+	bw.wg.Add(1)
+	go bw.process()
+	return bw
+}
+
+func (w *batchWriter) enqueue(batch []byte) {
+	select {
+	case w.batchCh <- batch:
+	default:
+		w.error(errQueueFull)
+	}
+}
+
+func (w *batchWriter) process() {
+	defer w.wg.Done()
+
+	for batch := range w.batchCh {
+		if err := w.writer.write(batch); err != nil {
+			w.error(errors.WithMessage(err, "failed to write batch"))
+		}
+	}
+}
+
+func (w *batchWriter) error(err error) {
+	if w.onError != nil {
+		w.onError(err)
+	}
+}
+
+func (w *batchWriter) close() error {
+	close(w.batchCh)
+	w.wg.Wait()
+	return w.writer.close()
+}
@@ -67,6 +67,14 @@ func (c *TagCache) Set(key uint64, tslice []m3thrift.MetricTag) []m3thrift.Metri
 	return tslice
 }
 
+// Delete removes the cached value for key.
+func (c *TagCache) Delete(key uint64) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	delete(c.entries, key)
+}
+
 // Len returns the size of the cache,
 func (c *TagCache) Len() int {
 	c.mtx.RLock()
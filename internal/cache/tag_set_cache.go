@@ -0,0 +1,73 @@
+// Copyright (c) 2022 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cache
+
+import (
+	"sync"
+)
+
+// TagSetCache is an identity.Accumulator-based cache of tags encoded as a
+// string, for reporters whose wire format carries tags as text.
+type TagSetCache struct {
+	entries map[uint64]string
+	mtx     sync.RWMutex
+}
+
+// NewTagSetCache creates a new TagSetCache.
+func NewTagSetCache() *TagSetCache {
+	return &TagSetCache{
+		entries: make(map[uint64]string),
+	}
+}
+
+// Get returns the cached value for key.
+func (c *TagSetCache) Get(key uint64) (string, bool) {
+	c.mtx.RLock()
+	defer c.mtx.RUnlock()
+
+	entry, ok := c.entries[key]
+	return entry, ok
+}
+
+// Set attempts to set the value of key as tagSet, returning either tagSet
+// or the pre-existing value if found.
+func (c *TagSetCache) Set(key uint64, tagSet string) string {
+	c.mtx.RLock()
+	existing, ok := c.entries[key]
+	c.mtx.RUnlock()
+
+	if ok {
+		return existing
+	}
+
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	c.entries[key] = tagSet
+	return tagSet
+}
+
+// Len returns the size of the cache.
+func (c *TagSetCache) Len() int {
+	c.mtx.RLock()
+	defer c.mtx.RUnlock()
+	return len(c.entries)
+}
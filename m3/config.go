@@ -20,6 +20,13 @@
 
 package m3
 
+import (
+	"fmt"
+	"time"
+
+	"github.com/uber-go/tally/v4/m3/thrifttcp"
+)
+
 // Configuration is a configuration for a M3 reporter.
 type Configuration struct {
 	// HostPort is the host and port of the M3 server.
@@ -40,6 +47,14 @@ type Configuration struct {
 	// Queue is the maximum metric queue size of client.
 	Queue int `yaml:"queue"`
 
+	// QueuePolicy is what to do with metrics reported while the queue is
+	// full, either block, drop-newest or drop-oldest, by default block.
+	QueuePolicy string `yaml:"queuePolicy"`
+
+	// QueueTimeout if specified is how long to block reporting a metric
+	// while the queue is full before dropping it.
+	QueueTimeout time.Duration `yaml:"queueTimeout"`
+
 	// PacketSize is the maximum packet size for a batch of metrics.
 	PacketSize int32 `yaml:"packetSize"`
 
@@ -49,6 +64,74 @@ type Configuration struct {
 	// HistogramBucketTagPrecision is precision to use when formatting the metric tag
 	// with the histogram bucket bound values.
 	HistogramBucketTagPrecision uint `yaml:"histogramBucketTagPrecision"`
+
+	// Protocol is the thrift protocol, either compact or binary, by
+	// default compact.
+	Protocol string `yaml:"protocol"`
+
+	// ProtocolVersion is the version of the M3 service protocol, either 1
+	// for legacy collectors or 2, by default 2.
+	ProtocolVersion int `yaml:"protocolVersion"`
+
+	// Transport is the transport, either udp or tcp, by default udp.
+	Transport string `yaml:"transport"`
+
+	// ResolveInterval if specified is the interval on which the host ports
+	// of the udp transport are resolved again, to follow changing IPs.
+	ResolveInterval time.Duration `yaml:"resolveInterval"`
+
+	// TCP is the configuration of the tcp transport.
+	TCP TCPConfiguration `yaml:"tcp"`
+
+	// Sharded is whether to send each series to one of the host ports,
+	// picked by consistent hashing, rather than to all of them.
+	Sharded bool `yaml:"sharded"`
+
+	// UnhealthyTimeout is how long a host port failing a write is skipped
+	// for when sharded, its series being sent to the others.
+	UnhealthyTimeout time.Duration `yaml:"unhealthyTimeout"`
+
+	// TimerAggregation if specified aggregates the samples of each timer
+	// on every flush rather than emitting each of them.
+	TimerAggregation *TimerAggregationConfiguration `yaml:"timerAggregation"`
+}
+
+// TimerAggregationConfiguration is a configuration of the aggregation of
+// timers.
+type TimerAggregationConfiguration struct {
+	// Percentiles are the percentiles to emit.
+	Percentiles []float64 `yaml:"percentiles"`
+
+	// Naming is how the aggregates are named, either suffix or tag, by
+	// default suffix.
+	Naming string `yaml:"naming"`
+
+	// Separator is the separator of the suffix of the aggregates.
+	Separator string `yaml:"separator"`
+
+	// TagName is the name of the tag of the aggregates.
+	TagName string `yaml:"tagName"`
+
+	// MaxSamples is the max number of samples per interval the
+	// percentiles are computed from.
+	MaxSamples int `yaml:"maxSamples"`
+}
+
+// TCPConfiguration is a configuration of the M3 TCP transport.
+type TCPConfiguration struct {
+	// PoolSize is the number of connections to each host.
+	PoolSize int `yaml:"poolSize"`
+
+	// DialTimeout is the timeout to connect.
+	DialTimeout time.Duration `yaml:"dialTimeout"`
+
+	// WriteTimeout is the timeout to write a batch.
+	WriteTimeout time.Duration `yaml:"writeTimeout"`
+
+	// MinBackoff and MaxBackoff bound the time to wait before reconnecting
+	// after a connection failed.
+	MinBackoff time.Duration `yaml:"minBackoff"`
+	MaxBackoff time.Duration `yaml:"maxBackoff"`
 }
 
 // NewReporter creates a new M3 reporter from this configuration.
@@ -57,14 +140,93 @@ func (c Configuration) NewReporter() (Reporter, error) {
 	if len(hostPorts) == 0 {
 		hostPorts = []string{c.HostPort}
 	}
+
+	var protocol Protocol
+	switch c.Protocol {
+	case "", "compact":
+		protocol = Compact
+	case "binary":
+		protocol = Binary
+	default:
+		return nil, fmt.Errorf("unknown protocol %q", c.Protocol)
+	}
+
+	var version ProtocolVersion
+	switch c.ProtocolVersion {
+	case 0, 2:
+		version = ProtocolV2
+	case 1:
+		version = ProtocolV1
+	default:
+		return nil, fmt.Errorf("unknown protocol version %d", c.ProtocolVersion)
+	}
+
+	var transport Transport
+	switch c.Transport {
+	case "", "udp":
+		transport = UDP
+	case "tcp":
+		transport = TCP
+	default:
+		return nil, fmt.Errorf("unknown transport %q", c.Transport)
+	}
+
+	var queuePolicy QueuePolicy
+	switch c.QueuePolicy {
+	case "", "block":
+		queuePolicy = QueueBlock
+	case "drop-newest":
+		queuePolicy = QueueDropNewest
+	case "drop-oldest":
+		queuePolicy = QueueDropOldest
+	default:
+		return nil, fmt.Errorf("unknown queue policy %q", c.QueuePolicy)
+	}
+
+	var timerAggregation *TimerAggregationOptions
+	if c.TimerAggregation != nil {
+		var naming TimerAggregationNaming
+		switch c.TimerAggregation.Naming {
+		case "", "suffix":
+			naming = TimerAggregationSuffix
+		case "tag":
+			naming = TimerAggregationTag
+		default:
+			return nil, fmt.Errorf("unknown timer aggregation naming %q", c.TimerAggregation.Naming)
+		}
+		timerAggregation = &TimerAggregationOptions{
+			Percentiles: c.TimerAggregation.Percentiles,
+			Naming:      naming,
+			Separator:   c.TimerAggregation.Separator,
+			TagName:     c.TimerAggregation.TagName,
+			MaxSamples:  c.TimerAggregation.MaxSamples,
+		}
+	}
+
 	return NewReporter(Options{
 		HostPorts:                   hostPorts,
 		Service:                     c.Service,
 		Env:                         c.Env,
 		CommonTags:                  c.CommonTags,
 		MaxQueueSize:                c.Queue,
+		QueuePolicy:                 queuePolicy,
+		QueueTimeout:                c.QueueTimeout,
 		MaxPacketSizeBytes:          c.PacketSize,
 		IncludeHost:                 c.IncludeHost,
 		HistogramBucketTagPrecision: c.HistogramBucketTagPrecision,
+		Protocol:                    protocol,
+		ProtocolVersion:             version,
+		Transport:                   transport,
+		ResolveInterval:             c.ResolveInterval,
+		Sharded:                     c.Sharded,
+		UnhealthyTimeout:            c.UnhealthyTimeout,
+		TimerAggregation:            timerAggregation,
+		TCPOptions: thrifttcp.Options{
+			PoolSize:     c.TCP.PoolSize,
+			DialTimeout:  c.TCP.DialTimeout,
+			WriteTimeout: c.TCP.WriteTimeout,
+			MinBackoff:   c.TCP.MinBackoff,
+			MaxBackoff:   c.TCP.MaxBackoff,
+		},
 	})
 }
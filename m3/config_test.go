@@ -22,10 +22,13 @@ package m3
 
 import (
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+	"github.com/uber-go/tally/v4/m3/thrifttcp"
 	"github.com/uber-go/tally/v4/m3/thriftudp"
+	"github.com/uber-go/tally/v4/thirdparty/github.com/apache/thrift/lib/go/thrift"
 )
 
 func TestConfigSimple(t *testing.T) {
@@ -38,7 +41,7 @@ func TestConfigSimple(t *testing.T) {
 	require.NoError(t, err)
 
 	reporter := r.(*reporter)
-	_, ok := reporter.client.Transport.(*thriftudp.TUDPTransport)
+	_, ok := reporter.destinations[0].client.Transport.(*thriftudp.TUDPTransport)
 	assert.True(t, ok)
 	assert.True(t, tagEquals(reporter.commonTags, "service", "my-service"))
 	assert.True(t, tagEquals(reporter.commonTags, "env", "test"))
@@ -54,8 +57,133 @@ func TestConfigMulti(t *testing.T) {
 	require.NoError(t, err)
 
 	reporter := r.(*reporter)
-	_, ok := reporter.client.Transport.(*thriftudp.TMultiUDPTransport)
+	_, ok := reporter.destinations[0].client.Transport.(*thriftudp.TMultiUDPTransport)
 	assert.True(t, ok)
 	assert.True(t, tagEquals(reporter.commonTags, "service", "my-service"))
 	assert.True(t, tagEquals(reporter.commonTags, "env", "test"))
 }
+
+func TestConfigTCP(t *testing.T) {
+	c := Configuration{
+		HostPort:  "127.0.0.1:9052",
+		Service:   "my-service",
+		Env:       "test",
+		Protocol:  "binary",
+		Transport: "tcp",
+		TCP:       TCPConfiguration{PoolSize: 2},
+	}
+	r, err := c.NewReporter()
+	require.NoError(t, err)
+	defer r.Close()
+
+	client := r.(*reporter).destinations[0].client
+	_, ok := client.Transport.(*thrift.TFramedTransport)
+	assert.True(t, ok)
+	_, ok = client.OutputProtocol.(*thrift.TBinaryProtocol)
+	assert.True(t, ok)
+
+	c.HostPorts = []string{"127.0.0.1:9052", "127.0.0.1:9062"}
+	r, err = c.NewReporter()
+	require.NoError(t, err)
+	defer r.Close()
+
+	client = r.(*reporter).destinations[0].client
+	_, ok = client.Transport.(*thrifttcp.TMultiTCPTransport)
+	assert.True(t, ok)
+
+	c.Transport = "sctp"
+	_, err = c.NewReporter()
+	assert.Error(t, err)
+
+	c.Transport, c.Protocol = "tcp", "json"
+	_, err = c.NewReporter()
+	assert.Error(t, err)
+}
+
+func TestConfigProtocolVersion(t *testing.T) {
+	c := Configuration{
+		HostPort:        "127.0.0.1:9052",
+		Service:         "my-service",
+		Env:             "test",
+		ProtocolVersion: 1,
+	}
+	r, err := c.NewReporter()
+	require.NoError(t, err)
+	defer r.Close()
+	assert.NotNil(t, r.(*reporter).destinations[0].legacyClient)
+
+	c.ProtocolVersion = 3
+	_, err = c.NewReporter()
+	assert.Error(t, err)
+}
+
+func TestConfigSharded(t *testing.T) {
+	c := Configuration{
+		HostPorts:        []string{"127.0.0.1:9052", "127.0.0.1:9062"},
+		Service:          "my-service",
+		Env:              "test",
+		Sharded:          true,
+		UnhealthyTimeout: time.Minute,
+	}
+	r, err := c.NewReporter()
+	require.NoError(t, err)
+	defer r.Close()
+
+	reporter := r.(*reporter)
+	require.Equal(t, 2, len(reporter.destinations))
+	for _, d := range reporter.destinations {
+		_, ok := d.client.Transport.(*thriftudp.TUDPTransport)
+		assert.True(t, ok)
+	}
+	assert.NotNil(t, reporter.ring)
+	assert.Equal(t, time.Minute, reporter.unhealthyTimeout)
+}
+
+func TestConfigQueuePolicy(t *testing.T) {
+	c := Configuration{
+		HostPort:     "127.0.0.1:9052",
+		Service:      "my-service",
+		Env:          "test",
+		QueuePolicy:  "drop-oldest",
+		QueueTimeout: time.Second,
+	}
+	r, err := c.NewReporter()
+	require.NoError(t, err)
+	defer r.Close()
+
+	reporter := r.(*reporter)
+	assert.Equal(t, QueueDropOldest, reporter.queuePolicy)
+	assert.Equal(t, time.Second, reporter.queueTimeout)
+
+	c.QueuePolicy = "drop-all"
+	_, err = c.NewReporter()
+	assert.Error(t, err)
+}
+
+func TestConfigTimerAggregation(t *testing.T) {
+	c := Configuration{
+		HostPort: "127.0.0.1:9052",
+		Service:  "my-service",
+		Env:      "test",
+		TimerAggregation: &TimerAggregationConfiguration{
+			Naming:     "tag",
+			TagName:    "aggregate",
+			MaxSamples: 100,
+		},
+	}
+	r, err := c.NewReporter()
+	require.NoError(t, err)
+	defer r.Close()
+
+	assert.Equal(t, &TimerAggregationOptions{
+		Percentiles: DefaultTimerAggregationPercentiles,
+		Naming:      TimerAggregationTag,
+		Separator:   DefaultTimerAggregationSeparator,
+		TagName:     "aggregate",
+		MaxSamples:  100,
+	}, r.(*reporter).timerAggregation)
+
+	c.TimerAggregation.Naming = "prefix"
+	_, err = c.NewReporter()
+	assert.Error(t, err)
+}
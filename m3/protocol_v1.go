@@ -0,0 +1,92 @@
+// Copyright (c) 2022 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package m3
+
+import (
+	m3thriftv1 "github.com/uber-go/tally/v4/m3/thrift/v1"
+	m3thrift "github.com/uber-go/tally/v4/m3/thrift/v2"
+)
+
+// ProtocolVersion describes a M3 thrift service protocol version.
+type ProtocolVersion int
+
+// ProtocolV2 and ProtocolV1 represent the emitMetricBatchV2 and the
+// legacy emitMetricBatch protocol versions respectively.
+const (
+	ProtocolV2 ProtocolVersion = iota
+	ProtocolV1
+)
+
+// newV1Batch converts a batch of metrics to the v1 protocol.
+func newV1Batch(
+	mets []m3thrift.Metric,
+	commonTags map[*m3thriftv1.MetricTag]bool,
+) *m3thriftv1.MetricBatch {
+	batch := &m3thriftv1.MetricBatch{
+		Metrics:    make([]*m3thriftv1.Metric, 0, len(mets)),
+		CommonTags: commonTags,
+	}
+	for i := range mets {
+		batch.Metrics = append(batch.Metrics, newV1Metric(mets[i]))
+	}
+	return batch
+}
+
+// newV1Metric converts a metric to the v1 protocol, in which values are
+// unions of their type and tags are sets.
+func newV1Metric(m m3thrift.Metric) *m3thriftv1.Metric {
+	var (
+		timestamp = m.Timestamp
+		value     = &m3thriftv1.MetricValue{}
+	)
+	switch m.Value.MetricType {
+	case m3thrift.MetricType_COUNTER:
+		count := m.Value.Count
+		value.Count = &m3thriftv1.CountValue{I64Value: &count}
+	case m3thrift.MetricType_GAUGE:
+		gauge := m.Value.Gauge
+		value.Gauge = &m3thriftv1.GaugeValue{DValue: &gauge}
+	case m3thrift.MetricType_TIMER:
+		timer := m.Value.Timer
+		value.Timer = &m3thriftv1.TimerValue{I64Value: &timer}
+	}
+
+	return &m3thriftv1.Metric{
+		Name:        m.Name,
+		MetricValue: value,
+		Timestamp:   &timestamp,
+		Tags:        newV1Tags(m.Tags),
+	}
+}
+
+func newV1Tags(tags []m3thrift.MetricTag) map[*m3thriftv1.MetricTag]bool {
+	if len(tags) == 0 {
+		return nil
+	}
+	v1Tags := make(map[*m3thriftv1.MetricTag]bool, len(tags))
+	for i := range tags {
+		v1Tags[&m3thriftv1.MetricTag{
+			TagName:  tags[i].Name,
+			TagValue: &tags[i].Value,
+		}] = true
+	}
+	return v1Tags
+}
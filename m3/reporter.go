@@ -35,7 +35,9 @@ import (
 	tally "github.com/uber-go/tally/v4"
 	"github.com/uber-go/tally/v4/internal/cache"
 	customtransport "github.com/uber-go/tally/v4/m3/customtransports"
+	m3thriftv1 "github.com/uber-go/tally/v4/m3/thrift/v1"
 	m3thrift "github.com/uber-go/tally/v4/m3/thrift/v2"
+	"github.com/uber-go/tally/v4/m3/thrifttcp"
 	"github.com/uber-go/tally/v4/m3/thriftudp"
 	"github.com/uber-go/tally/v4/thirdparty/github.com/apache/thrift/lib/go/thrift"
 	"go.uber.org/atomic"
@@ -51,6 +53,29 @@ const (
 	Binary
 )
 
+// Transport describes a M3 transport.
+type Transport int
+
+// UDP and TCP represent the UDP transport, sending each batch in a
+// packet, and the TCP transport, sending each batch in a thrift frame.
+const (
+	UDP Transport = iota
+	TCP
+)
+
+// QueuePolicy describes what the reporter does with metrics reported
+// while its queue is full.
+type QueuePolicy int
+
+// QueueBlock blocks reporting until the queue has room, or drops the
+// metric after the queue timeout if one is set. QueueDropNewest drops the
+// metric reported and QueueDropOldest drops the oldest metric queued.
+const (
+	QueueBlock QueuePolicy = iota
+	QueueDropNewest
+	QueueDropOldest
+)
+
 const (
 	// ServiceTag is the name of the M3 service tag.
 	ServiceTag = "service"
@@ -70,6 +95,9 @@ const (
 	// precision to use when formatting the metric tag
 	// with the histogram bucket bound values.
 	DefaultHistogramBucketTagPrecision = uint(6)
+	// DefaultUnhealthyTimeout is the default time a host port is skipped
+	// for after a failed write when sharding.
+	DefaultUnhealthyTimeout = 10 * time.Second
 
 	_emitMetricBatchOverhead    = 19
 	_minMetricBucketIDTagLength = 4
@@ -87,8 +115,16 @@ const (
 	counterType metricType = iota + 1
 	timerType
 	gaugeType
+	histogramType
 )
 
+var metricTypeNames = map[metricType]string{
+	counterType:   "counter",
+	timerType:     "timer",
+	gaugeType:     "gauge",
+	histogramType: "histogram",
+}
+
 var (
 	errNoHostPorts   = errors.New("at least one entry for HostPorts is required")
 	errCommonTagSize = errors.New("common tags serialized size exceeds packet size")
@@ -103,28 +139,37 @@ type Reporter interface {
 
 // reporter is a metrics backend that reports metrics to a local or
 // remote M3 collector, metrics are batched together and emitted
-// via either thrift compact or binary protocol in batch UDP packets.
+// via either thrift compact or binary protocol in batch UDP packets
+// or TCP frames.
 type reporter struct {
-	bucketIDTagName string
-	bucketTagName   string
-	bucketValFmt    string
-	buckets         []tally.BucketPair
-	calc            *customtransport.TCalcTransport
-	calcLock        sync.Mutex
-	calcProto       thrift.TProtocol
-	client          *m3thrift.M3Client
-	commonTags      []m3thrift.MetricTag
-	done            atomic.Bool
-	donech          chan struct{}
-	freeBytes       int32
-	metCh           chan sizedMetric
-	now             atomic.Int64
-	overheadBytes   int32
-	pending         atomic.Uint64
-	resourcePool    *resourcePool
-	stringInterner  *cache.StringInterner
-	tagCache        *cache.TagCache
-	wg              sync.WaitGroup
+	bucketIDTagName  string
+	bucketTagName    string
+	bucketValFmt     string
+	buckets          []tally.BucketPair
+	calc             *customtransport.TCalcTransport
+	calcLock         sync.Mutex
+	calcProto        thrift.TProtocol
+	commonTags       []m3thrift.MetricTag
+	destinations     []*destination
+	legacy           bool
+	legacyCommonTags map[*m3thriftv1.MetricTag]bool
+	done             atomic.Bool
+	donech           chan struct{}
+	flushPending     atomic.Bool
+	freeBytes        int32
+	metCh            chan sizedMetric
+	now              atomic.Int64
+	overheadBytes    int32
+	pending          atomic.Uint64
+	queuePolicy      QueuePolicy
+	queueTimeout     time.Duration
+	resourcePool     *resourcePool
+	ring             *hashRing
+	stringInterner   *cache.StringInterner
+	tagCache         *cache.TagCache
+	timerAggregation *TimerAggregationOptions
+	unhealthyTimeout time.Duration
+	wg               sync.WaitGroup
 
 	batchSizeHistogram    tally.CachedHistogram
 	numBatches            atomic.Int64
@@ -134,6 +179,39 @@ type reporter struct {
 	numWriteErrors        atomic.Int64
 	numWriteErrorsCounter tally.CachedCount
 	numTagCacheCounter    tally.CachedCount
+
+	queueHighWatermark      atomic.Int64
+	queueHighWatermarkGauge tally.CachedGauge
+
+	// Only set when the queue policy may drop metrics.
+	numDropped        [histogramType + 1]atomic.Int64
+	numDroppedCounter [histogramType + 1]tally.CachedCount
+
+	// Only set when resolving the host ports again on an interval.
+	resolveStats              func() (changes int64, failures int64)
+	numResolveChanges         atomic.Int64
+	numResolveChangesCounter  tally.CachedCount
+	numResolveFailures        atomic.Int64
+	numResolveFailuresCounter tally.CachedCount
+
+	// Only set when sharding.
+	numFailovers        atomic.Int64
+	numFailoversCounter tally.CachedCount
+
+	// Only set when aggregating timers.
+	aggregatedTimers     map[uint64]*aggregatedTimer
+	aggregatedTimersLock sync.Mutex
+}
+
+// destination is where batches are emitted to, all the host ports or
+// one of them when sharding, with the batch pending for it.
+type destination struct {
+	client         *m3thrift.M3Client
+	legacyClient   *m3thriftv1.M3Client
+	mets           []m3thrift.Metric
+	bytes          int32
+	borrowedTags   [][]m3thrift.MetricTag
+	unhealthyUntil int64
 }
 
 // Options is a set of options for the M3 reporter.
@@ -144,11 +222,20 @@ type Options struct {
 	CommonTags                  map[string]string
 	IncludeHost                 bool
 	Protocol                    Protocol
+	ProtocolVersion             ProtocolVersion
+	Transport                   Transport
+	TCPOptions                  thrifttcp.Options
+	ResolveInterval             time.Duration
+	Sharded                     bool
+	UnhealthyTimeout            time.Duration
 	MaxQueueSize                int
+	QueuePolicy                 QueuePolicy
+	QueueTimeout                time.Duration
 	MaxPacketSizeBytes          int32
 	HistogramBucketIDName       string
 	HistogramBucketName         string
 	HistogramBucketTagPrecision uint
+	TimerAggregation            *TimerAggregationOptions
 }
 
 // NewReporter creates a new M3 reporter.
@@ -168,18 +255,52 @@ func NewReporter(opts Options) (Reporter, error) {
 	if opts.HistogramBucketTagPrecision == 0 {
 		opts.HistogramBucketTagPrecision = DefaultHistogramBucketTagPrecision
 	}
-
-	// Create M3 thrift client
-	var trans thrift.TTransport
-	var err error
+	if opts.UnhealthyTimeout <= 0 {
+		opts.UnhealthyTimeout = DefaultUnhealthyTimeout
+	}
 	if len(opts.HostPorts) == 0 {
-		err = errNoHostPorts
-	} else if len(opts.HostPorts) == 1 {
-		trans, err = thriftudp.NewTUDPClientTransport(opts.HostPorts[0], "")
+		return nil, errNoHostPorts
+	}
+	if opts.TimerAggregation != nil {
+		aggregation := *opts.TimerAggregation
+		if aggregation.Percentiles == nil {
+			aggregation.Percentiles = DefaultTimerAggregationPercentiles
+		}
+		if aggregation.Separator == "" {
+			aggregation.Separator = DefaultTimerAggregationSeparator
+		}
+		if aggregation.TagName == "" {
+			aggregation.TagName = DefaultTimerAggregationTagName
+		}
+		if aggregation.MaxSamples <= 0 {
+			aggregation.MaxSamples = DefaultTimerAggregationMaxSamples
+		}
+		opts.TimerAggregation = &aggregation
+	}
+
+	// Create M3 thrift transports, one per host port when sharding
+	var (
+		transports []thrift.TTransport
+		err        error
+	)
+	if opts.Sharded {
+		for _, hostPort := range opts.HostPorts {
+			var trans thrift.TTransport
+			trans, err = newTransport([]string{hostPort}, opts)
+			if err != nil {
+				break
+			}
+			transports = append(transports, trans)
+		}
 	} else {
-		trans, err = thriftudp.NewTMultiUDPClientTransport(opts.HostPorts, "")
+		var trans thrift.TTransport
+		trans, err = newTransport(opts.HostPorts, opts)
+		if err == nil {
+			transports = append(transports, trans)
+		}
 	}
 	if err != nil {
+		closeTransports(transports) //nolint:errcheck
 		return nil, err
 	}
 
@@ -191,7 +312,8 @@ func NewReporter(opts Options) (Reporter, error) {
 	}
 
 	var (
-		client       = m3thrift.NewM3ClientFactory(trans, protocolFactory)
+		legacy       = opts.ProtocolVersion == ProtocolV1
+		destinations = make([]*destination, 0, len(transports))
 		resourcePool = newResourcePool(protocolFactory)
 		tagm         = make(map[string]string)
 		tags         = resourcePool.getMetricTagSlice()
@@ -204,6 +326,7 @@ func NewReporter(opts Options) (Reporter, error) {
 
 	if opts.CommonTags[ServiceTag] == "" {
 		if opts.Service == "" {
+			closeTransports(transports) //nolint:errcheck
 			return nil, fmt.Errorf("%s common tag is required", ServiceTag)
 		}
 		tagm[ServiceTag] = opts.Service
@@ -211,6 +334,7 @@ func NewReporter(opts Options) (Reporter, error) {
 
 	if opts.CommonTags[EnvTag] == "" {
 		if opts.Env == "" {
+			closeTransports(transports) //nolint:errcheck
 			return nil, fmt.Errorf("%s common tag is required", EnvTag)
 		}
 		tagm[EnvTag] = opts.Env
@@ -220,6 +344,7 @@ func NewReporter(opts Options) (Reporter, error) {
 		if opts.CommonTags[HostTag] == "" {
 			hostname, err := os.Hostname()
 			if err != nil {
+				closeTransports(transports) //nolint:errcheck
 				return nil, errors.WithMessage(err, "error resolving host tag")
 			}
 			tagm[HostTag] = hostname
@@ -239,10 +364,18 @@ func NewReporter(opts Options) (Reporter, error) {
 			Metrics:    resourcePool.getMetricSlice(),
 			CommonTags: tags,
 		}
-		proto = resourcePool.getProto()
+		proto            = resourcePool.getProto()
+		legacyCommonTags map[*m3thriftv1.MetricTag]bool
 	)
 
-	if err := batch.Write(proto); err != nil {
+	if legacy {
+		legacyCommonTags = newV1Tags(tags)
+		err = newV1Batch(nil, legacyCommonTags).Write(proto)
+	} else {
+		err = batch.Write(proto)
+	}
+	if err != nil {
+		closeTransports(transports) //nolint:errcheck
 		return nil, errors.WithMessage(
 			err,
 			"failed to write to proto for size calculation",
@@ -259,30 +392,55 @@ func NewReporter(opts Options) (Reporter, error) {
 	calc.ResetCount()
 
 	if freeBytes <= 0 {
+		closeTransports(transports) //nolint:errcheck
 		return nil, errCommonTagSize
 	}
 
+	for _, trans := range transports {
+		d := &destination{
+			mets: make([]m3thrift.Metric, 0, freeBytes/10),
+		}
+		if legacy {
+			d.legacyClient = m3thriftv1.NewM3ClientFactory(trans, protocolFactory)
+		} else {
+			d.client = m3thrift.NewM3ClientFactory(trans, protocolFactory)
+		}
+		destinations = append(destinations, d)
+	}
+
 	buckets := tally.ValueBuckets(append(
 		[]float64{0.0},
 		tally.MustMakeExponentialValueBuckets(2.0, 2.0, 11)...,
 	))
 
 	r := &reporter{
-		buckets:         tally.BucketPairs(buckets),
-		bucketIDTagName: opts.HistogramBucketIDName,
-		bucketTagName:   opts.HistogramBucketName,
-		bucketValFmt:    "%." + strconv.Itoa(int(opts.HistogramBucketTagPrecision)) + "f",
-		calc:            calc,
-		calcProto:       proto,
-		client:          client,
-		commonTags:      tags,
-		donech:          make(chan struct{}),
-		freeBytes:       freeBytes,
-		metCh:           make(chan sizedMetric, opts.MaxQueueSize),
-		overheadBytes:   numOverheadBytes,
-		resourcePool:    resourcePool,
-		stringInterner:  cache.NewStringInterner(),
-		tagCache:        cache.NewTagCache(),
+		buckets:          tally.BucketPairs(buckets),
+		bucketIDTagName:  opts.HistogramBucketIDName,
+		bucketTagName:    opts.HistogramBucketName,
+		bucketValFmt:     "%." + strconv.Itoa(int(opts.HistogramBucketTagPrecision)) + "f",
+		calc:             calc,
+		calcProto:        proto,
+		commonTags:       tags,
+		destinations:     destinations,
+		legacy:           legacy,
+		legacyCommonTags: legacyCommonTags,
+		donech:           make(chan struct{}),
+		freeBytes:        freeBytes,
+		metCh:            make(chan sizedMetric, opts.MaxQueueSize),
+		overheadBytes:    numOverheadBytes,
+		queuePolicy:      opts.QueuePolicy,
+		queueTimeout:     opts.QueueTimeout,
+		resourcePool:     resourcePool,
+		stringInterner:   cache.NewStringInterner(),
+		tagCache:         cache.NewTagCache(),
+		timerAggregation: opts.TimerAggregation,
+		unhealthyTimeout: opts.UnhealthyTimeout,
+	}
+	if opts.Sharded {
+		r.ring = newHashRing(opts.HostPorts)
+	}
+	if opts.TimerAggregation != nil {
+		r.aggregatedTimers = make(map[uint64]*aggregatedTimer)
 	}
 
 	internalTags := map[string]string{
@@ -293,6 +451,28 @@ func NewReporter(opts Options) (Reporter, error) {
 	r.numMetricsCounter = r.AllocateCounter("tally.internal.num-metrics", internalTags)
 	r.numWriteErrorsCounter = r.AllocateCounter("tally.internal.num-write-errors", internalTags)
 	r.numTagCacheCounter = r.AllocateCounter("tally.internal.num-tag-cache", internalTags)
+	// The queue high watermark is reported whatever the queue policy, so
+	// that queues close to full can be alerted on, which adds an internal
+	// metric to the batches of every reporter.
+	r.queueHighWatermarkGauge = r.AllocateGauge("tally.internal.queue-high-watermark", internalTags)
+	if r.queuePolicy != QueueBlock || r.queueTimeout > 0 {
+		for t, name := range metricTypeNames {
+			r.numDroppedCounter[t] = r.AllocateCounter("tally.internal.num-dropped-metrics", map[string]string{
+				"version": tally.Version,
+				"type":    name,
+			})
+		}
+	}
+	if r.ring != nil {
+		r.numFailoversCounter = r.AllocateCounter("tally.internal.num-failovers", internalTags)
+	}
+	if resolveStats := newResolveStats(transports); resolveStats != nil && opts.ResolveInterval > 0 {
+		r.resolveStats = resolveStats
+		r.numResolveChangesCounter = r.AllocateCounter(
+			"tally.internal.num-resolve-changes", internalTags)
+		r.numResolveFailuresCounter = r.AllocateCounter(
+			"tally.internal.num-resolve-failures", internalTags)
+	}
 	r.wg.Add(1)
 	go func() {
 		defer r.wg.Done()
@@ -320,16 +500,7 @@ func (r *reporter) allocateCounter(
 	name string,
 	tags map[string]string,
 ) cachedMetric {
-	var (
-		counter = r.newMetric(name, tags, counterType)
-		size    = r.calculateSize(counter)
-	)
-
-	return cachedMetric{
-		metric:   counter,
-		reporter: r,
-		size:     size,
-	}
+	return r.allocateMetric(name, tags, counterType)
 }
 
 // AllocateGauge implements tally.CachedStatsReporter.
@@ -337,16 +508,7 @@ func (r *reporter) AllocateGauge(
 	name string,
 	tags map[string]string,
 ) tally.CachedGauge {
-	var (
-		gauge = r.newMetric(name, tags, gaugeType)
-		size  = r.calculateSize(gauge)
-	)
-
-	return cachedMetric{
-		metric:   gauge,
-		reporter: r,
-		size:     size,
-	}
+	return r.allocateMetric(name, tags, gaugeType)
 }
 
 // AllocateTimer implements tally.CachedStatsReporter.
@@ -354,15 +516,27 @@ func (r *reporter) AllocateTimer(
 	name string,
 	tags map[string]string,
 ) tally.CachedTimer {
+	if r.timerAggregation != nil {
+		return r.allocateAggregatedTimer(name, tags)
+	}
+	return r.allocateMetric(name, tags, timerType)
+}
+
+func (r *reporter) allocateMetric(
+	name string,
+	tags map[string]string,
+	t metricType,
+) cachedMetric {
 	var (
-		timer = r.newMetric(name, tags, timerType)
-		size  = r.calculateSize(timer)
+		metric = r.newMetric(name, tags, t)
+		size   = r.calculateSize(metric)
 	)
 
 	return cachedMetric{
-		metric:   timer,
+		metric:   metric,
 		reporter: r,
 		size:     size,
+		id:       seriesID(name, tags),
 	}
 }
 
@@ -385,6 +559,7 @@ func (r *reporter) AllocateHistogram(
 
 	var (
 		mtags        = r.convertTags(tags)
+		id           = seriesID(name, tags)
 		prevDuration = time.Duration(math.MinInt64)
 		prevValue    = -math.MaxFloat64
 	)
@@ -397,11 +572,12 @@ func (r *reporter) AllocateHistogram(
 				durationUpperBound: pair.UpperBoundDuration(),
 				metric:             &counter,
 			}
-			delta = len(r.bucketIDTagName) + len(r.bucketTagName) + len(hbucket.bucketID)
 		)
 
+		// n.b. The buckets of a histogram are sent to the same host port
+		//      when sharding so that it can be aggregated as a whole.
 		hbucket.metric.metric.Tags = mtags
-		hbucket.metric.size = r.calculateSize(hbucket.metric.metric)
+		hbucket.metric.id = id
 
 		if isDuration {
 			bname := r.stringInterner.Intern(
@@ -409,7 +585,7 @@ func (r *reporter) AllocateHistogram(
 					r.durationBucketString(pair.UpperBoundDuration()),
 			)
 			hbucket.bucket = bname
-			hbucket.metric.size += int32(delta + len(bname))
+			hbucket.metric.size = r.calculateBucketSize(hbucket)
 			cachedDurationBuckets = append(cachedDurationBuckets, hbucket)
 		} else {
 			bname := r.stringInterner.Intern(
@@ -417,7 +593,7 @@ func (r *reporter) AllocateHistogram(
 					r.valueBucketString(pair.UpperBoundValue()),
 			)
 			hbucket.bucket = bname
-			hbucket.metric.size += int32(delta + len(bname))
+			hbucket.metric.size = r.calculateBucketSize(hbucket)
 			cachedValueBuckets = append(cachedValueBuckets, hbucket)
 		}
 
@@ -488,16 +664,33 @@ func (r *reporter) newMetric(
 
 func (r *reporter) calculateSize(m m3thrift.Metric) int32 {
 	r.calcLock.Lock()
-	m.Write(r.calcProto) //nolint:errcheck
+	if r.legacy {
+		newV1Metric(m).Write(r.calcProto) //nolint:errcheck
+	} else {
+		m.Write(r.calcProto) //nolint:errcheck
+	}
 	size := r.calc.GetCount()
 	r.calc.ResetCount()
 	r.calcLock.Unlock()
 	return size
 }
 
+// calculateBucketSize returns the size of the counter of a histogram
+// bucket with the bucket tags added to it on report.
+func (r *reporter) calculateBucketSize(b cachedHistogramBucket) int32 {
+	m := b.metric.metric
+	m.Tags = append(
+		append(make([]m3thrift.MetricTag, 0, len(m.Tags)+2), m.Tags...),
+		m3thrift.MetricTag{Name: r.bucketIDTagName, Value: b.bucketID},
+		m3thrift.MetricTag{Name: r.bucketTagName, Value: b.bucket},
+	)
+	return r.calculateSize(m)
+}
+
 func (r *reporter) reportCopyMetric(
 	m m3thrift.Metric,
 	size int32,
+	id uint64,
 	bucket string,
 	bucketID string,
 ) {
@@ -513,14 +706,91 @@ func (r *reporter) reportCopyMetric(
 	sm := sizedMetric{
 		m:        m,
 		size:     size,
+		id:       id,
 		set:      true,
 		bucket:   bucket,
 		bucketID: bucketID,
 	}
 
+	r.enqueue(sm)
+	r.updateQueueHighWatermark()
+}
+
+// enqueue queues a metric for processing, applying the queue policy when
+// the queue is full.
+func (r *reporter) enqueue(sm sizedMetric) {
 	select {
 	case r.metCh <- sm:
-	case <-r.donech:
+		return
+	default:
+	}
+
+	switch r.queuePolicy {
+	case QueueDropNewest:
+		r.drop(sm)
+	case QueueDropOldest:
+		for {
+			select {
+			case r.metCh <- sm:
+				return
+			default:
+			}
+
+			select {
+			case oldest := <-r.metCh:
+				if oldest.set {
+					r.drop(oldest)
+				} else {
+					// Flushes are never dropped, but queuing one again
+					// would put it behind newer metrics. Mark it pending
+					// instead, processing the next metric flushes first.
+					r.flushPending.Store(true)
+				}
+			default:
+			}
+		}
+	default:
+		if r.queueTimeout <= 0 {
+			select {
+			case r.metCh <- sm:
+			case <-r.donech:
+			}
+			return
+		}
+
+		timer := time.NewTimer(r.queueTimeout)
+		defer timer.Stop()
+		select {
+		case r.metCh <- sm:
+		case <-timer.C:
+			r.drop(sm)
+		case <-r.donech:
+		}
+	}
+}
+
+func (r *reporter) drop(sm sizedMetric) {
+	t := histogramType
+	if len(sm.bucket) == 0 {
+		switch sm.m.Value.MetricType {
+		case m3thrift.MetricType_COUNTER:
+			t = counterType
+		case m3thrift.MetricType_GAUGE:
+			t = gaugeType
+		default:
+			t = timerType
+		}
+	}
+	r.numDropped[t].Inc()
+}
+
+func (r *reporter) updateQueueHighWatermark() {
+	depth := int64(len(r.metCh))
+	for {
+		highWatermark := r.queueHighWatermark.Load()
+		if depth <= highWatermark || r.queueHighWatermark.CAS(highWatermark, depth) {
+			return
+		}
 	}
 }
 
@@ -533,6 +803,9 @@ func (r *reporter) Flush() {
 		return
 	}
 
+	if r.timerAggregation != nil {
+		r.reportAggregatedTimers()
+	}
 	r.reportInternalMetrics()
 	r.metCh <- sizedMetric{}
 }
@@ -552,7 +825,11 @@ func (r *reporter) Close() (err error) {
 	close(r.metCh)
 	r.wg.Wait()
 
-	return nil
+	transports := make([]thrift.TTransport, 0, len(r.destinations))
+	for _, d := range r.destinations {
+		transports = append(transports, d.transport())
+	}
+	return closeTransports(transports)
 }
 
 func (r *reporter) Capabilities() tally.Capabilities {
@@ -568,36 +845,27 @@ func (r *reporter) Tagging() bool {
 }
 
 func (r *reporter) process() {
-	var (
-		extraTags = sync.Pool{
-			New: func() interface{} {
-				return make([]m3thrift.MetricTag, 0, 8)
-			},
-		}
-		borrowedTags = make([][]m3thrift.MetricTag, 0, 128)
-		mets         = make([]m3thrift.Metric, 0, r.freeBytes/10)
-		bytes        int32
-	)
+	extraTags := &sync.Pool{
+		New: func() interface{} {
+			return make([]m3thrift.MetricTag, 0, 8)
+		},
+	}
 
 	for smet := range r.metCh {
-		flush := !smet.set && len(mets) > 0
-		if flush || bytes+smet.size > r.freeBytes {
-			r.numMetrics.Add(int64(len(mets)))
-			mets = r.flush(mets)
-			bytes = 0
-
-			if len(borrowedTags) > 0 {
-				for i := range borrowedTags {
-					extraTags.Put(borrowedTags[i][:0])
-				}
-				borrowedTags = borrowedTags[:0]
+		if r.flushPending.CAS(true, false) || !smet.set {
+			for _, d := range r.destinations {
+				r.flush(d, extraTags)
 			}
 		}
-
 		if !smet.set {
 			continue
 		}
 
+		d := r.destination(smet.id)
+		if d.bytes+smet.size > r.freeBytes {
+			r.flush(d, extraTags)
+		}
+
 		m := smet.m
 		if len(smet.bucket) > 0 {
 			tags := extraTags.Get().([]m3thrift.MetricTag)
@@ -613,40 +881,144 @@ func (r *reporter) process() {
 					Value: smet.bucket,
 				},
 			)
-			borrowedTags = append(borrowedTags, tags)
+			d.borrowedTags = append(d.borrowedTags, tags)
 			m.Tags = tags
 		}
 
-		mets = append(mets, m)
-		bytes += smet.size
+		d.mets = append(d.mets, m)
+		d.bytes += smet.size
 	}
 
 	// Final flush
-	r.flush(mets)
+	for _, d := range r.destinations {
+		r.flush(d, extraTags)
+	}
+}
+
+// destination returns the destination of the series with the given ID,
+// failing over to the next healthy host port on the ring when sharding.
+func (r *reporter) destination(id uint64) *destination {
+	if r.ring == nil {
+		return r.destinations[0]
+	}
+
+	now := r.now.Load()
+	owner, failover := r.ring.lookup(id, func(i int) bool {
+		return r.destinations[i].unhealthyUntil <= now
+	})
+	if failover {
+		r.numFailovers.Inc()
+	}
+	return r.destinations[owner]
 }
 
-func (r *reporter) flush(mets []m3thrift.Metric) []m3thrift.Metric {
-	if len(mets) == 0 {
-		return mets
+func (r *reporter) flush(d *destination, extraTags *sync.Pool) {
+	if len(d.mets) == 0 {
+		return
 	}
 
 	r.numBatches.Inc()
+	r.numMetrics.Add(int64(len(d.mets)))
 
-	err := r.client.EmitMetricBatchV2(m3thrift.MetricBatch{
-		Metrics:    mets,
-		CommonTags: r.commonTags,
-	})
+	var err error
+	if d.legacyClient != nil {
+		err = d.legacyClient.EmitMetricBatch(newV1Batch(d.mets, r.legacyCommonTags))
+	} else {
+		err = d.client.EmitMetricBatchV2(m3thrift.MetricBatch{
+			Metrics:    d.mets,
+			CommonTags: r.commonTags,
+		})
+	}
 	if err != nil {
 		r.numWriteErrors.Inc()
+		// Fail over the series of this host port to the others for a
+		// while, this has no effect unless sharding.
+		d.unhealthyUntil = time.Now().Add(r.unhealthyTimeout).UnixNano()
 	}
 
 	// n.b. In the event that we had allocated additional tag storage in
 	//      process(), clear it so that it can be reclaimed. This does not
 	//      affect allocated metrics' tags.
-	for i := range mets {
-		mets[i].Tags = nil
+	for i := range d.mets {
+		d.mets[i].Tags = nil
+	}
+	d.mets = d.mets[:0]
+	d.bytes = 0
+
+	for i := range d.borrowedTags {
+		extraTags.Put(d.borrowedTags[i][:0])
+	}
+	d.borrowedTags = d.borrowedTags[:0]
+}
+
+func (d *destination) transport() thrift.TTransport {
+	if d.legacyClient != nil {
+		return d.legacyClient.Transport
+	}
+	return d.client.Transport
+}
+
+// newTransport returns a transport sending the batches to all the given
+// host ports.
+func newTransport(hostPorts []string, opts Options) (thrift.TTransport, error) {
+	switch {
+	case opts.Transport == TCP:
+		return newTCPTransport(hostPorts, opts.TCPOptions)
+	case len(hostPorts) == 1:
+		return thriftudp.NewTUDPClientTransportWithResolveInterval(
+			hostPorts[0], "", opts.ResolveInterval)
+	default:
+		return thriftudp.NewTMultiUDPClientTransportWithResolveInterval(
+			hostPorts, "", opts.ResolveInterval)
+	}
+}
+
+func closeTransports(transports []thrift.TTransport) error {
+	var firstErr error
+	for _, trans := range transports {
+		if err := trans.Close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
 	}
-	return mets[:0]
+	return firstErr
+}
+
+// newResolveStats returns the sum of the resolve stats of the transports,
+// or nil if they do not resolve their host ports again.
+func newResolveStats(transports []thrift.TTransport) func() (int64, int64) {
+	type resolver interface {
+		ResolveStats() (int64, int64)
+	}
+
+	resolvers := make([]resolver, 0, len(transports))
+	for _, trans := range transports {
+		res, ok := trans.(resolver)
+		if !ok {
+			return nil
+		}
+		resolvers = append(resolvers, res)
+	}
+
+	return func() (changes int64, failures int64) {
+		for _, res := range resolvers {
+			c, f := res.ResolveStats()
+			changes += c
+			failures += f
+		}
+		return changes, failures
+	}
+}
+
+// newTCPTransport returns a transport framing the batches over TCP.
+func newTCPTransport(hostPorts []string, opts thrifttcp.Options) (thrift.TTransport, error) {
+	if len(hostPorts) > 1 {
+		return thrifttcp.NewTMultiTCPClientTransport(hostPorts, opts)
+	}
+	trans, err := thrifttcp.NewTTCPClientTransport(hostPorts[0], opts)
+	if err != nil {
+		return nil, err
+	}
+	return thrift.NewTFramedTransport(trans), nil
 }
 
 func (r *reporter) convertTags(tags map[string]string) []m3thrift.MetricTag {
@@ -691,6 +1063,23 @@ func (r *reporter) reportInternalMetrics() {
 	r.numMetricsCounter.ReportCount(metrics)
 	r.numWriteErrorsCounter.ReportCount(writeErrors)
 	r.numTagCacheCounter.ReportCount(int64(r.tagCache.Len()))
+	r.queueHighWatermarkGauge.ReportGauge(
+		float64(r.queueHighWatermark.Swap(int64(len(r.metCh)))))
+	for t, counter := range r.numDroppedCounter {
+		if counter != nil {
+			counter.ReportCount(r.numDropped[t].Swap(0))
+		}
+	}
+
+	if r.resolveStats != nil {
+		changes, failures := r.resolveStats()
+		r.numResolveChangesCounter.ReportCount(changes - r.numResolveChanges.Swap(changes))
+		r.numResolveFailuresCounter.ReportCount(failures - r.numResolveFailures.Swap(failures))
+	}
+
+	if r.ring != nil {
+		r.numFailoversCounter.ReportCount(r.numFailovers.Swap(0))
+	}
 }
 
 func (r *reporter) timeLoop() {
@@ -710,21 +1099,22 @@ type cachedMetric struct {
 	metric   m3thrift.Metric
 	reporter *reporter
 	size     int32
+	id       uint64
 }
 
 func (c cachedMetric) ReportCount(value int64) {
 	c.metric.Value.Count = value
-	c.reporter.reportCopyMetric(c.metric, c.size, "", "")
+	c.reporter.reportCopyMetric(c.metric, c.size, c.id, "", "")
 }
 
 func (c cachedMetric) ReportGauge(value float64) {
 	c.metric.Value.Gauge = value
-	c.reporter.reportCopyMetric(c.metric, c.size, "", "")
+	c.reporter.reportCopyMetric(c.metric, c.size, c.id, "", "")
 }
 
 func (c cachedMetric) ReportTimer(interval time.Duration) {
 	c.metric.Value.Timer = int64(interval)
-	c.reporter.reportCopyMetric(c.metric, c.size, "", "")
+	c.reporter.reportCopyMetric(c.metric, c.size, c.id, "", "")
 }
 
 type noopMetric struct{}
@@ -761,6 +1151,7 @@ func (h cachedHistogram) ValueBucket(
 		cm       = b.metric
 		m        = cm.metric
 		size     = cm.size
+		id       = cm.id
 		bucket   = b.bucket
 		bucketID = b.bucketID
 		rep      = cm.reporter
@@ -768,7 +1159,7 @@ func (h cachedHistogram) ValueBucket(
 
 	return reportSamplesFunc(func(value int64) {
 		m.Value.Count = value
-		rep.reportCopyMetric(m, size, bucket, bucketID)
+		rep.reportCopyMetric(m, size, id, bucket, bucketID)
 	})
 }
 
@@ -792,6 +1183,7 @@ func (h cachedHistogram) DurationBucket(
 		cm       = b.metric
 		m        = cm.metric
 		size     = cm.size
+		id       = cm.id
 		bucket   = b.bucket
 		bucketID = b.bucketID
 		rep      = cm.reporter
@@ -799,7 +1191,7 @@ func (h cachedHistogram) DurationBucket(
 
 	return reportSamplesFunc(func(value int64) {
 		m.Value.Count = value
-		rep.reportCopyMetric(m, size, bucket, bucketID)
+		rep.reportCopyMetric(m, size, id, bucket, bucketID)
 	})
 }
 
@@ -820,6 +1212,7 @@ func (f reportSamplesFunc) ReportSamples(value int64) {
 type sizedMetric struct {
 	m        m3thrift.Metric
 	size     int32
+	id       uint64
 	set      bool
 	bucket   string
 	bucketID string
@@ -34,7 +34,9 @@ import (
 
 	tally "github.com/uber-go/tally/v4"
 	customtransport "github.com/uber-go/tally/v4/m3/customtransports"
+	m3thriftv1 "github.com/uber-go/tally/v4/m3/thrift/v1"
 	m3thrift "github.com/uber-go/tally/v4/m3/thrift/v2"
+	"github.com/uber-go/tally/v4/m3/thrifttcp"
 	"github.com/uber-go/tally/v4/m3/thriftudp"
 	"github.com/uber-go/tally/v4/thirdparty/github.com/apache/thrift/lib/go/thrift"
 
@@ -56,7 +58,7 @@ var (
 
 var protocols = []Protocol{Compact, Binary}
 
-const internalMetrics = 5    // Additional metrics the reporter sends in a batch - use this, not a magic number.
+const internalMetrics = 6    // Additional metrics the reporter sends in a batch - use this, not a magic number.
 const cardinalityMetrics = 3 // Additional metrics emitted by the scope registry.
 
 // TestReporter tests the reporter works as expected with both compact and binary protocols
@@ -146,6 +148,463 @@ func TestReporter(t *testing.T) {
 	}
 }
 
+// TestReporterTCP tests the reporter works over TCP, reconnecting when the
+// server restarts
+func TestReporterTCP(t *testing.T) {
+	for _, protocol := range protocols {
+		var wg sync.WaitGroup
+		server := newFakeM3TCPServer(t, &wg, "127.0.0.1:0", protocol)
+		addr := server.Addr
+
+		r, err := NewReporter(Options{
+			HostPorts:  []string{addr},
+			Service:    "test-service",
+			CommonTags: defaultCommonTags,
+			Protocol:   protocol,
+			Transport:  TCP,
+			TCPOptions: thrifttcp.Options{MinBackoff: time.Millisecond},
+		})
+		require.NoError(t, err)
+
+		wg.Add(1)
+		r.AllocateCounter("my-counter", nil).ReportCount(10)
+		r.Flush()
+		wg.Wait()
+
+		batches := server.Service.getBatches()
+		require.Equal(t, 1, len(batches))
+		require.Equal(t, internalMetrics+1, len(batches[0].GetMetrics()))
+		require.Equal(t, "my-counter", batches[0].GetMetrics()[0].GetName())
+		require.NoError(t, server.Close())
+
+		// Batches are dropped while the server is down, until reconnected.
+		server = newFakeM3TCPServer(t, nil, addr, protocol)
+		require.Eventually(t, func() bool {
+			r.AllocateCounter("my-counter", nil).ReportCount(20)
+			r.Flush()
+			return len(server.Service.getMetrics()) > 0
+		}, 5*time.Second, 10*time.Millisecond)
+
+		require.NoError(t, r.Close())
+		require.NoError(t, server.Close())
+	}
+}
+
+// TestReporterProtocolV1 tests the reporter emits v1 batches with both
+// compact and binary protocols
+func TestReporterProtocolV1(t *testing.T) {
+	for _, protocol := range protocols {
+		var wg sync.WaitGroup
+		server := newFakeM3V1Server(t, &wg, protocol)
+		go server.Serve()
+		defer server.Close()
+
+		r, err := NewReporter(Options{
+			HostPorts:       []string{server.Addr},
+			Service:         "test-service",
+			CommonTags:      defaultCommonTags,
+			Protocol:        protocol,
+			ProtocolVersion: ProtocolV1,
+		})
+		require.NoError(t, err)
+
+		tags := map[string]string{"testTag": "TestValue"}
+		wg.Add(1)
+		r.AllocateCounter("my-counter", tags).ReportCount(10)
+		r.AllocateGauge("my-gauge", nil).ReportGauge(1.5)
+		r.AllocateTimer("my-timer", tags).ReportTimer(5 * time.Millisecond)
+		r.AllocateHistogram("my-histogram", tags, tally.ValueBuckets{0, 10}).
+			ValueBucket(0, 10).ReportSamples(3)
+		r.Flush()
+		wg.Wait()
+
+		batches := server.V1Service.getBatches()
+		require.Equal(t, 1, len(batches))
+		commonTags := make(map[string]string)
+		for tag := range batches[0].GetCommonTags() {
+			commonTags[tag.GetTagName()] = tag.GetTagValue()
+		}
+		assert.Equal(t, "test-service", commonTags[ServiceTag])
+		assert.Equal(t, "test", commonTags[EnvTag])
+
+		metrics := make(map[string]*m3thriftv1.Metric)
+		for _, metric := range batches[0].GetMetrics() {
+			metrics[metric.GetName()] = metric
+		}
+		require.Equal(t, internalMetrics+4, len(metrics))
+
+		counter := metrics["my-counter"]
+		require.NotNil(t, counter)
+		assert.Equal(t, int64(10), counter.GetMetricValue().Count.GetI64Value())
+		require.Equal(t, 1, len(counter.GetTags()))
+		for tag := range counter.GetTags() {
+			assert.Equal(t, "testTag", tag.GetTagName())
+			assert.Equal(t, "TestValue", tag.GetTagValue())
+		}
+
+		assert.Equal(t, 1.5, metrics["my-gauge"].GetMetricValue().Gauge.GetDValue())
+		assert.Empty(t, metrics["my-gauge"].GetTags())
+		assert.Equal(t, int64(5*time.Millisecond),
+			metrics["my-timer"].GetMetricValue().Timer.GetI64Value())
+
+		histogram := metrics["my-histogram"]
+		require.NotNil(t, histogram)
+		assert.Equal(t, int64(3), histogram.GetMetricValue().Count.GetI64Value())
+		histogramTags := make(map[string]string)
+		for tag := range histogram.GetTags() {
+			histogramTags[tag.GetTagName()] = tag.GetTagValue()
+		}
+		assert.Equal(t, map[string]string{
+			"testTag":  "TestValue",
+			"bucketid": "0001",
+			"bucket":   "0.000000-10.000000",
+		}, histogramTags)
+
+		require.NoError(t, r.Close())
+	}
+}
+
+// TestBatchSizesProtocolV1 tests the v1 batches fit in the packet size
+func TestBatchSizesProtocolV1(t *testing.T) {
+	server := newFakeM3V1Server(t, nil, Compact)
+	go server.Serve()
+	defer server.Close()
+
+	maxPacketSize := int32(512)
+	r, err := NewReporter(Options{
+		HostPorts:          []string{server.Addr},
+		Service:            "test-service",
+		CommonTags:         defaultCommonTags,
+		MaxPacketSizeBytes: maxPacketSize,
+		ProtocolVersion:    ProtocolV1,
+	})
+	require.NoError(t, err)
+
+	const numMetrics = 200
+	for i := 0; i < numMetrics; i++ {
+		tags := map[string]string{"t1": "val" + strconv.Itoa(i)}
+		r.AllocateCounter("size.test.counter", tags).ReportCount(int64(i))
+		r.AllocateHistogram("size.test.histogram", tags, tally.ValueBuckets{0, 10}).
+			ValueBucket(0, 10).ReportSamples(1)
+	}
+	require.NoError(t, r.Close())
+
+	require.Eventually(t, func() bool {
+		var n int
+		for _, batch := range server.V1Service.getBatches() {
+			n += len(batch.GetMetrics())
+		}
+		return n >= 2*numMetrics
+	}, 5*time.Second, 10*time.Millisecond)
+
+	packets := server.Packets()
+	require.True(t, len(packets) > 1)
+	for _, packet := range packets {
+		assert.True(t, len(packet) <= int(maxPacketSize),
+			"packet of %d bytes exceeds %d", len(packet), maxPacketSize)
+	}
+}
+
+// TestCalculateSizeProtocolV1 tests the sizes of v1 metrics are those of
+// their v1 encoding
+func TestCalculateSizeProtocolV1(t *testing.T) {
+	r, err := NewReporter(Options{
+		HostPorts:       []string{"127.0.0.1:9052"},
+		Service:         "test-service",
+		CommonTags:      defaultCommonTags,
+		ProtocolVersion: ProtocolV1,
+	})
+	require.NoError(t, err)
+	defer r.Close()
+
+	var (
+		tags    = map[string]string{"testTag": "TestValue"}
+		counter = r.(*reporter).allocateCounter("my-counter", tags)
+		calc    = &customtransport.TCalcTransport{}
+	)
+	require.NoError(t, newV1Metric(counter.metric).Write(thrift.NewTCompactProtocol(calc)))
+	assert.Equal(t, calc.GetCount(), counter.size)
+
+	calc.ResetCount()
+	require.NoError(t, counter.metric.Write(thrift.NewTCompactProtocol(calc)))
+	assert.NotEqual(t, calc.GetCount(), counter.size)
+}
+
+// TestReporterResolveStats tests the reporter emits the changes and failures
+// of resolving the host ports again
+func TestReporterResolveStats(t *testing.T) {
+	var wg sync.WaitGroup
+	server := newFakeM3Server(t, &wg, true, Compact)
+	go server.Serve()
+	defer server.Close()
+
+	r, err := NewReporter(Options{
+		HostPorts:       []string{server.Addr},
+		Service:         "test-service",
+		CommonTags:      defaultCommonTags,
+		ResolveInterval: time.Hour,
+	})
+	require.NoError(t, err)
+	defer r.Close()
+
+	var changes, failures int64 = 2, 3
+	r.(*reporter).resolveStats = func() (int64, int64) {
+		return changes, failures
+	}
+
+	wg.Add(2)
+	r.Flush()
+	changes, failures = 3, 3
+	r.Flush()
+	wg.Wait()
+
+	batches := server.Service.getBatches()
+	require.Equal(t, 2, len(batches))
+	for i, expected := range []map[string]int64{
+		{"tally.internal.num-resolve-changes": 2, "tally.internal.num-resolve-failures": 3},
+		{"tally.internal.num-resolve-changes": 1, "tally.internal.num-resolve-failures": 0},
+	} {
+		require.Equal(t, internalMetrics+2, len(batches[i].GetMetrics()))
+		for _, metric := range batches[i].GetMetrics() {
+			if value, ok := expected[metric.GetName()]; ok {
+				metricValue := metric.GetValue()
+				assert.Equal(t, value, metricValue.GetCount(), metric.GetName())
+			}
+		}
+	}
+}
+
+// TestReporterSharded tests each series is sent to one of the host ports
+func TestReporterSharded(t *testing.T) {
+	servers := []*fakeM3Server{
+		newFakeM3Server(t, nil, false, Compact),
+		newFakeM3Server(t, nil, false, Compact),
+	}
+	for _, server := range servers {
+		go server.Serve()
+		defer server.Close()
+	}
+
+	r, err := NewReporter(Options{
+		HostPorts:  []string{servers[0].Addr, servers[1].Addr},
+		Service:    "test-service",
+		CommonTags: defaultCommonTags,
+		Sharded:    true,
+	})
+	require.NoError(t, err)
+	defer r.Close()
+
+	const numCounters = 100
+	for i := 0; i < numCounters; i++ {
+		r.AllocateCounter("my-counter-"+strconv.Itoa(i), nil).ReportCount(1)
+	}
+	r.Flush()
+
+	require.Eventually(t, func() bool {
+		return len(servers[0].Service.getMetrics())+
+			len(servers[1].Service.getMetrics()) == numCounters+internalMetrics+1
+	}, 5*time.Second, 10*time.Millisecond)
+
+	seen := make(map[string]int)
+	for _, server := range servers {
+		require.NotEmpty(t, server.Service.getMetrics())
+		for _, metric := range server.Service.getMetrics() {
+			seen[metric.GetName()]++
+		}
+	}
+	for i := 0; i < numCounters; i++ {
+		assert.Equal(t, 1, seen["my-counter-"+strconv.Itoa(i)])
+	}
+}
+
+// TestReporterShardedFailover tests the series of a host port failing
+// writes are sent to the others
+func TestReporterShardedFailover(t *testing.T) {
+	server := newFakeM3TCPServer(t, nil, "127.0.0.1:0", Compact)
+	defer server.Close()
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	unavailable := listener.Addr().String()
+	require.NoError(t, listener.Close())
+
+	r, err := NewReporter(Options{
+		HostPorts:  []string{server.Addr, unavailable},
+		Service:    "test-service",
+		CommonTags: defaultCommonTags,
+		Transport:  TCP,
+		Sharded:    true,
+	})
+	require.NoError(t, err)
+	defer r.Close()
+
+	const numCounters = 50
+	counters := make([]tally.CachedCount, numCounters)
+	for i := range counters {
+		counters[i] = r.AllocateCounter("my-counter-"+strconv.Itoa(i), nil)
+	}
+
+	received := func(value int64) int {
+		var n int
+		for _, metric := range server.Service.getMetrics() {
+			if strings.HasPrefix(metric.GetName(), "my-counter-") &&
+				metric.GetValue().Count == value {
+				n++
+			}
+		}
+		return n
+	}
+
+	// The series of the unavailable host port are lost until its write
+	// fails, then they are sent to the available one.
+	for _, value := range []int64{1, 2} {
+		for _, counter := range counters {
+			counter.ReportCount(value)
+		}
+		r.Flush()
+	}
+	require.Eventually(t, func() bool {
+		return received(2) == numCounters
+	}, 5*time.Second, 10*time.Millisecond)
+	assert.True(t, received(1) < numCounters)
+}
+
+// TestReporterQueuePolicy tests the metrics dropped by each queue policy
+// when the queue is full
+func TestReporterQueuePolicy(t *testing.T) {
+	var (
+		counter = sizedMetric{set: true, m: m3thrift.Metric{Name: "counter",
+			Value: m3thrift.MetricValue{MetricType: m3thrift.MetricType_COUNTER}}}
+		gauge = sizedMetric{set: true, m: m3thrift.Metric{Name: "gauge",
+			Value: m3thrift.MetricValue{MetricType: m3thrift.MetricType_GAUGE}}}
+		timer = sizedMetric{set: true, m: m3thrift.Metric{Name: "timer",
+			Value: m3thrift.MetricValue{MetricType: m3thrift.MetricType_TIMER}}}
+		bucket = sizedMetric{set: true, bucket: "0-10", m: m3thrift.Metric{Name: "histogram",
+			Value: m3thrift.MetricValue{MetricType: m3thrift.MetricType_COUNTER}}}
+		flush = sizedMetric{}
+	)
+
+	tests := []struct {
+		name         string
+		policy       QueuePolicy
+		timeout      time.Duration
+		queued       []sizedMetric
+		expected     []sizedMetric
+		dropped      metricType
+		flushPending bool
+	}{
+		{
+			name:     "drop newest",
+			policy:   QueueDropNewest,
+			queued:   []sizedMetric{counter, gauge},
+			expected: []sizedMetric{counter, gauge},
+			dropped:  timerType,
+		},
+		{
+			name:     "drop oldest",
+			policy:   QueueDropOldest,
+			queued:   []sizedMetric{counter, gauge},
+			expected: []sizedMetric{gauge, timer},
+			dropped:  counterType,
+		},
+		{
+			name:         "drop oldest keeps flushes pending",
+			policy:       QueueDropOldest,
+			queued:       []sizedMetric{flush, bucket},
+			expected:     []sizedMetric{bucket, timer},
+			flushPending: true,
+		},
+		{
+			name:     "block with timeout",
+			policy:   QueueBlock,
+			timeout:  10 * time.Millisecond,
+			queued:   []sizedMetric{gauge, counter},
+			expected: []sizedMetric{gauge, counter},
+			dropped:  timerType,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := &reporter{
+				metCh:        make(chan sizedMetric, len(tt.queued)),
+				queuePolicy:  tt.policy,
+				queueTimeout: tt.timeout,
+			}
+			for _, sm := range tt.queued {
+				r.enqueue(sm)
+			}
+			r.enqueue(timer)
+
+			close(r.metCh)
+			var queued []sizedMetric
+			for sm := range r.metCh {
+				queued = append(queued, sm)
+			}
+			assert.Equal(t, tt.expected, queued)
+			assert.Equal(t, tt.flushPending, r.flushPending.Load())
+
+			for mt := range r.numDropped {
+				if tt.dropped != 0 && metricType(mt) == tt.dropped {
+					assert.Equal(t, int64(1), r.numDropped[mt].Load())
+				} else {
+					assert.Equal(t, int64(0), r.numDropped[mt].Load())
+				}
+			}
+		})
+	}
+}
+
+// TestReporterQueueMetrics tests the reporter emits the queue high watermark
+// and, with a queue policy dropping metrics, the number of metrics dropped
+func TestReporterQueueMetrics(t *testing.T) {
+	var wg sync.WaitGroup
+	server := newFakeM3Server(t, &wg, true, Compact)
+	go server.Serve()
+	defer server.Close()
+
+	r, err := NewReporter(Options{
+		HostPorts:   []string{server.Addr},
+		Service:     "test-service",
+		CommonTags:  defaultCommonTags,
+		QueuePolicy: QueueDropNewest,
+	})
+	require.NoError(t, err)
+	defer r.Close()
+
+	r.(*reporter).numDropped[timerType].Store(3)
+	r.(*reporter).queueHighWatermark.Store(42)
+
+	wg.Add(1)
+	r.Flush()
+	wg.Wait()
+
+	batches := server.Service.getBatches()
+	require.Equal(t, 1, len(batches))
+	require.Equal(t, internalMetrics+len(metricTypeNames), len(batches[0].GetMetrics()))
+
+	dropped := make(map[string]int64)
+	for _, metric := range batches[0].GetMetrics() {
+		value := metric.GetValue()
+		switch metric.GetName() {
+		case "tally.internal.num-dropped-metrics":
+			for _, tag := range metric.GetTags() {
+				if tag.GetName() == "type" {
+					dropped[tag.GetValue()] = value.GetCount()
+				}
+			}
+		case "tally.internal.queue-high-watermark":
+			assert.Equal(t, float64(42), value.GetGauge())
+		}
+	}
+	assert.Equal(t, map[string]int64{
+		"counter":   0,
+		"gauge":     0,
+		"timer":     3,
+		"histogram": 0,
+	}, dropped)
+}
+
 // TestMultiReporter tests the multi Reporter works as expected
 func TestMultiReporter(t *testing.T) {
 	dests := []string{"127.0.0.1:9052", "127.0.0.1:9053"}
@@ -166,7 +625,7 @@ func TestMultiReporter(t *testing.T) {
 
 	reporter, ok := r.(*reporter)
 	require.True(t, ok)
-	multitransport, ok := reporter.client.Transport.(*thriftudp.TMultiUDPTransport)
+	multitransport, ok := reporter.destinations[0].client.Transport.(*thriftudp.TMultiUDPTransport)
 	require.NotNil(t, multitransport)
 	require.True(t, ok)
 }
@@ -576,6 +1035,7 @@ func TestReporterHasReportingAndTaggingCapability(t *testing.T) {
 type fakeM3Server struct {
 	t         *testing.T
 	Service   *fakeM3Service
+	V1Service *fakeM3V1Service
 	Addr      string
 	protocol  Protocol
 	processor thrift.TProcessor
@@ -605,6 +1065,14 @@ func newFakeM3Server(t *testing.T, wg *sync.WaitGroup, countBatches bool, protoc
 	}
 }
 
+func newFakeM3V1Server(t *testing.T, wg *sync.WaitGroup, protocol Protocol) *fakeM3Server {
+	f := newFakeM3Server(t, wg, true, protocol)
+	f.Service = nil
+	f.V1Service = &fakeM3V1Service{wg: wg}
+	f.processor = m3thriftv1.NewM3Processor(f.V1Service)
+	return f
+}
+
 func (f *fakeM3Server) Serve() {
 	readBuf := make([]byte, 64000)
 	for f.conn != nil {
@@ -651,6 +1119,75 @@ func (f *fakeM3Server) Packets() [][]byte {
 	return packets
 }
 
+// fakeM3TCPServer serves the M3 service over framed TCP connections.
+type fakeM3TCPServer struct {
+	t        *testing.T
+	Service  *fakeM3Service
+	Addr     string
+	listener net.Listener
+	lock     sync.Mutex
+	conns    []net.Conn
+}
+
+func newFakeM3TCPServer(
+	t *testing.T,
+	wg *sync.WaitGroup,
+	addr string,
+	protocol Protocol,
+) *fakeM3TCPServer {
+	listener, err := net.Listen("tcp", addr)
+	require.NoError(t, err, "Listen failed")
+
+	f := &fakeM3TCPServer{
+		t:        t,
+		Service:  newFakeM3Service(wg, true),
+		Addr:     listener.Addr().String(),
+		listener: listener,
+	}
+	go f.serve(protocol)
+	return f
+}
+
+func (f *fakeM3TCPServer) serve(protocol Protocol) {
+	processor := m3thrift.NewM3Processor(f.Service)
+	for {
+		conn, err := f.listener.Accept()
+		if err != nil {
+			return
+		}
+		f.lock.Lock()
+		f.conns = append(f.conns, conn)
+		f.lock.Unlock()
+
+		go func() {
+			trans := thrift.NewTFramedTransport(thrift.NewTSocketFromConnTimeout(conn, 0))
+			var proto thrift.TProtocol
+			if protocol == Compact {
+				proto = thrift.NewTCompactProtocol(trans)
+			} else {
+				proto = thrift.NewTBinaryProtocolTransport(trans)
+			}
+			for {
+				_, err := processor.Process(proto, proto)
+				// The service always returns a "complete" error.
+				if err != nil && err.Error() != "complete" {
+					return
+				}
+			}
+		}()
+	}
+}
+
+func (f *fakeM3TCPServer) Close() error {
+	err := f.listener.Close()
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	for _, conn := range f.conns {
+		conn.Close()
+	}
+	return err
+}
+
 func newFakeM3Service(wg *sync.WaitGroup, countBatches bool) *fakeM3Service {
 	return &fakeM3Service{wg: wg, countBatches: countBatches}
 }
@@ -693,6 +1230,28 @@ func (m *fakeM3Service) EmitMetricBatchV2(batch m3thrift.MetricBatch) (err error
 	return thrift.NewTTransportException(thrift.END_OF_FILE, "complete")
 }
 
+type fakeM3V1Service struct {
+	lock    sync.RWMutex
+	batches []*m3thriftv1.MetricBatch
+	wg      *sync.WaitGroup
+}
+
+func (m *fakeM3V1Service) getBatches() []*m3thriftv1.MetricBatch {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+	return m.batches
+}
+
+func (m *fakeM3V1Service) EmitMetricBatch(batch *m3thriftv1.MetricBatch) (err error) {
+	m.lock.Lock()
+	m.batches = append(m.batches, batch)
+	if m.wg != nil {
+		m.wg.Done()
+	}
+	m.lock.Unlock()
+	return thrift.NewTTransportException(thrift.END_OF_FILE, "complete")
+}
+
 func hostname() string {
 	host, err := os.Hostname()
 	if err != nil {
@@ -0,0 +1,98 @@
+// Copyright (c) 2022 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package m3
+
+import (
+	"sort"
+	"strconv"
+
+	"github.com/twmb/murmur3"
+	"github.com/uber-go/tally/v4/internal/cache"
+	"github.com/uber-go/tally/v4/internal/identity"
+)
+
+// _hashRingReplicas is the number of points of each host on the hash
+// ring, spreading the series of a host evenly over the others when it
+// is unhealthy.
+const _hashRingReplicas = 128
+
+// hashRing consistently maps series IDs to the indexes of host ports, so
+// that adding or removing a host only moves the series of that host.
+type hashRing struct {
+	hashes []uint64
+	owners []int
+}
+
+func newHashRing(hostPorts []string) *hashRing {
+	type point struct {
+		hash  uint64
+		owner int
+	}
+
+	points := make([]point, 0, len(hostPorts)*_hashRingReplicas)
+	for i, hostPort := range hostPorts {
+		for j := 0; j < _hashRingReplicas; j++ {
+			points = append(points, point{
+				hash:  murmur3.StringSum64(hostPort + "-" + strconv.Itoa(j)),
+				owner: i,
+			})
+		}
+	}
+	sort.Slice(points, func(i, j int) bool {
+		return points[i].hash < points[j].hash
+	})
+
+	r := &hashRing{
+		hashes: make([]uint64, len(points)),
+		owners: make([]int, len(points)),
+	}
+	for i, p := range points {
+		r.hashes[i] = p.hash
+		r.owners[i] = p.owner
+	}
+	return r
+}
+
+// lookup returns the owner of id, the first healthy one clockwise on the
+// ring, and whether it failed over from an unhealthy one. If no owner is
+// healthy the first owner is returned regardless.
+func (r *hashRing) lookup(id uint64, healthy func(owner int) bool) (int, bool) {
+	n := len(r.hashes)
+	start := sort.Search(n, func(i int) bool {
+		return r.hashes[i] >= id
+	})
+
+	for i := 0; i < n; i++ {
+		if owner := r.owners[(start+i)%n]; healthy(owner) {
+			return owner, owner != r.owners[start%n]
+		}
+	}
+	return r.owners[start%n], false
+}
+
+// seriesID returns the ID of the series of a metric, used to pick the
+// host port it is sent to when sharding.
+func seriesID(name string, tags map[string]string) uint64 {
+	return identity.NewAccumulator().
+		AddString(name).
+		AddUint64(cache.TagMapKey(tags)).
+		Value()
+}
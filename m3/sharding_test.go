@@ -0,0 +1,112 @@
+// Copyright (c) 2022 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package m3
+
+import (
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func allHealthy(int) bool {
+	return true
+}
+
+func TestHashRingLookup(t *testing.T) {
+	hostPorts := []string{"10.0.0.1:9052", "10.0.0.2:9052", "10.0.0.3:9052"}
+	ring := newHashRing(hostPorts)
+
+	counts := make([]int, len(hostPorts))
+	for i := 0; i < 3000; i++ {
+		id := seriesID("metric-"+strconv.Itoa(i), map[string]string{"a": "b"})
+		owner, failover := ring.lookup(id, allHealthy)
+		require.False(t, failover)
+		counts[owner]++
+
+		// Lookups are stable.
+		again, _ := newHashRing(hostPorts).lookup(id, allHealthy)
+		require.Equal(t, owner, again)
+	}
+
+	for _, count := range counts {
+		assert.InDelta(t, 1000, count, 250)
+	}
+}
+
+func TestHashRingRemoveHostPort(t *testing.T) {
+	var (
+		ring    = newHashRing([]string{"10.0.0.1:9052", "10.0.0.2:9052", "10.0.0.3:9052"})
+		removed = newHashRing([]string{"10.0.0.1:9052", "10.0.0.2:9052"})
+	)
+
+	for i := 0; i < 1000; i++ {
+		id := seriesID("metric-"+strconv.Itoa(i), nil)
+		owner, _ := ring.lookup(id, allHealthy)
+		if owner == 2 {
+			continue
+		}
+		// Only the series of the removed host port move.
+		newOwner, _ := removed.lookup(id, allHealthy)
+		require.Equal(t, owner, newOwner)
+	}
+}
+
+func TestHashRingFailover(t *testing.T) {
+	ring := newHashRing([]string{"10.0.0.1:9052", "10.0.0.2:9052", "10.0.0.3:9052"})
+
+	var moved int
+	for i := 0; i < 1000; i++ {
+		id := seriesID("metric-"+strconv.Itoa(i), nil)
+		owner, _ := ring.lookup(id, allHealthy)
+
+		newOwner, failover := ring.lookup(id, func(i int) bool {
+			return i != 1
+		})
+		require.NotEqual(t, 1, newOwner)
+		if owner == 1 {
+			require.True(t, failover)
+			moved++
+		} else {
+			require.False(t, failover)
+			require.Equal(t, owner, newOwner)
+		}
+
+		// Without a healthy host port the series stays with its owner.
+		newOwner, failover = ring.lookup(id, func(int) bool {
+			return false
+		})
+		require.False(t, failover)
+		require.Equal(t, owner, newOwner)
+	}
+	assert.True(t, moved > 0)
+}
+
+func TestSeriesID(t *testing.T) {
+	assert.Equal(t,
+		seriesID("foo", map[string]string{"a": "b", "c": "d"}),
+		seriesID("foo", map[string]string{"c": "d", "a": "b"}))
+	assert.NotEqual(t,
+		seriesID("foo", map[string]string{"a": "b"}),
+		seriesID("foo", map[string]string{"a": "c"}))
+	assert.NotEqual(t, seriesID("foo", nil), seriesID("bar", nil))
+}
@@ -0,0 +1,73 @@
+// Copyright (c) 2022 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package thrifttcp
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewTMultiTCPClientTransportBadAddress(t *testing.T) {
+	trans, err := NewTMultiTCPClientTransport([]string{"not an address"}, Options{})
+	assert.Nil(t, trans)
+	assert.Error(t, err)
+}
+
+func TestTMultiTCPTransportWritesToAll(t *testing.T) {
+	first, second := newFrameServer(t, "127.0.0.1:0"), newFrameServer(t, "127.0.0.1:0")
+	defer first.close()
+	defer second.close()
+
+	trans, err := NewTMultiTCPClientTransport([]string{
+		first.listener.Addr().String(),
+		second.listener.Addr().String(),
+	}, Options{})
+	require.NoError(t, err)
+	assert.True(t, trans.IsOpen())
+
+	require.NoError(t, writeFrame(trans, "frame"))
+	assert.Equal(t, "frame", first.frame(t))
+	assert.Equal(t, "frame", second.frame(t))
+
+	require.NoError(t, trans.Close())
+	assert.False(t, trans.IsOpen())
+}
+
+func TestTMultiTCPTransportFlushesAll(t *testing.T) {
+	server := newFrameServer(t, "127.0.0.1:0")
+	defer server.close()
+
+	// The first host is down, the frame is still written to the second.
+	trans, err := NewTMultiTCPClientTransport([]string{
+		"127.0.0.1:1",
+		server.listener.Addr().String(),
+	}, Options{})
+	require.NoError(t, err)
+	defer trans.Close()
+
+	assert.Error(t, writeFrame(trans, "frame"))
+	assert.Equal(t, "frame", server.frame(t))
+	_, err = trans.Read(nil)
+	assert.Error(t, err)
+	assert.Zero(t, trans.RemainingBytes())
+}
@@ -0,0 +1,285 @@
+// Copyright (c) 2022 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package thrifttcp
+
+import (
+	"bytes"
+	"errors"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/uber-go/tally/v4/thirdparty/github.com/apache/thrift/lib/go/thrift"
+)
+
+const (
+	// DefaultPoolSize is the default number of connections of a transport.
+	DefaultPoolSize = 1
+	// DefaultDialTimeout is the default timeout to connect.
+	DefaultDialTimeout = time.Second
+	// DefaultWriteTimeout is the default timeout to write a frame.
+	DefaultWriteTimeout = time.Second
+	// DefaultMinBackoff is the default min time to wait before reconnecting.
+	DefaultMinBackoff = 100 * time.Millisecond
+	// DefaultMaxBackoff is the default max time to wait before reconnecting.
+	DefaultMaxBackoff = 10 * time.Second
+)
+
+var errNoConnection = errors.New("no connection available")
+
+// Options is a set of options for a TCP transport.
+type Options struct {
+	// PoolSize is the number of connections flushes are spread across,
+	// by default DefaultPoolSize.
+	PoolSize int
+
+	// DialTimeout is the timeout to connect, by default DefaultDialTimeout.
+	DialTimeout time.Duration
+
+	// WriteTimeout is the timeout to write the buffer on flush, by default
+	// DefaultWriteTimeout.
+	WriteTimeout time.Duration
+
+	// MinBackoff and MaxBackoff bound the time a connection waits before
+	// reconnecting after failing, doubling on every consecutive failure,
+	// by default DefaultMinBackoff and DefaultMaxBackoff.
+	MinBackoff time.Duration
+	MaxBackoff time.Duration
+}
+
+// TTCPTransport does TCP as a thrift.TTransport, writing the buffer on
+// flush with one write to one of a pool of connections. Connections are
+// established lazily and reestablished with a backoff after failing.
+// It is meant to be wrapped with a thrift.TFramedTransport, the buffer
+// being written at once a frame is never partially written on a
+// connection that keeps being used.
+type TTCPTransport struct {
+	sync.Mutex
+	addr     string
+	opts     Options
+	conns    []*pooledConn
+	next     int
+	writeBuf bytes.Buffer
+	closed   bool
+	dial     func(network, addr string, timeout time.Duration) (net.Conn, error)
+	now      func() time.Time
+}
+
+type pooledConn struct {
+	conn    net.Conn
+	backoff time.Duration
+	retryAt time.Time
+}
+
+// NewTTCPClientTransport creates a TTransport writing to destHostPort
+// over TCP for Thrift clients.
+// Example:
+//
+//	trans, err := thrifttcp.NewTTCPClientTransport("192.168.1.1:9090", thrifttcp.Options{})
+func NewTTCPClientTransport(destHostPort string, opts Options) (*TTCPTransport, error) {
+	if _, _, err := net.SplitHostPort(destHostPort); err != nil {
+		return nil, thrift.NewTTransportException(thrift.NOT_OPEN, err.Error())
+	}
+	if opts.PoolSize <= 0 {
+		opts.PoolSize = DefaultPoolSize
+	}
+	if opts.DialTimeout <= 0 {
+		opts.DialTimeout = DefaultDialTimeout
+	}
+	if opts.WriteTimeout <= 0 {
+		opts.WriteTimeout = DefaultWriteTimeout
+	}
+	if opts.MinBackoff <= 0 {
+		opts.MinBackoff = DefaultMinBackoff
+	}
+	if opts.MaxBackoff < opts.MinBackoff {
+		opts.MaxBackoff = DefaultMaxBackoff
+		if opts.MaxBackoff < opts.MinBackoff {
+			opts.MaxBackoff = opts.MinBackoff
+		}
+	}
+
+	conns := make([]*pooledConn, opts.PoolSize)
+	for i := range conns {
+		conns[i] = &pooledConn{}
+	}
+	return &TTCPTransport{
+		addr:  destHostPort,
+		opts:  opts,
+		conns: conns,
+		dial:  net.DialTimeout,
+		now:   time.Now,
+	}, nil
+}
+
+// Open does nothing as connections are opened on flush
+// Required to maintain thrift.TTransport interface
+func (p *TTCPTransport) Open() error {
+	return nil
+}
+
+// IsOpen returns true if the transport is not closed
+func (p *TTCPTransport) IsOpen() bool {
+	p.Lock()
+	defer p.Unlock()
+	return !p.closed
+}
+
+// Close closes the transport and the underlying connections.
+func (p *TTCPTransport) Close() error {
+	p.Lock()
+	defer p.Unlock()
+
+	if p.closed {
+		return nil
+	}
+	p.closed = true
+
+	var err error
+	for _, c := range p.conns {
+		if c.conn != nil {
+			if closeErr := c.conn.Close(); closeErr != nil && err == nil {
+				err = closeErr
+			}
+			c.conn = nil
+		}
+	}
+	return err
+}
+
+// Addr returns the address that the transport is writing to
+func (p *TTCPTransport) Addr() string {
+	return p.addr
+}
+
+// Read is not supported, clients of the transport only send oneway
+// messages
+func (p *TTCPTransport) Read(buf []byte) (int, error) {
+	return 0, thrift.NewTTransportException(thrift.UNKNOWN_TRANSPORT_EXCEPTION, "not supported")
+}
+
+// RemainingBytes is not supported, clients of the transport only send
+// oneway messages
+func (p *TTCPTransport) RemainingBytes() uint64 {
+	return 0
+}
+
+// Write writes specified buf to the write buffer
+func (p *TTCPTransport) Write(buf []byte) (int, error) {
+	p.Lock()
+	defer p.Unlock()
+
+	if p.closed {
+		return 0, thrift.NewTTransportException(thrift.NOT_OPEN, "Connection not open")
+	}
+	n, err := p.writeBuf.Write(buf)
+	return n, thrift.NewTTransportExceptionFromError(err)
+}
+
+// Flush writes the write buffer to the next available connection of the
+// pool, reconnecting it if needed
+func (p *TTCPTransport) Flush() error {
+	p.Lock()
+	defer p.Unlock()
+
+	// Always reset the buffer, even in case of an error, so that a failed
+	// frame is not prepended to the next one.
+	defer p.writeBuf.Reset()
+
+	if p.closed {
+		return thrift.NewTTransportException(thrift.NOT_OPEN, "Connection not open")
+	}
+	if p.writeBuf.Len() == 0 {
+		return nil
+	}
+
+	now := p.now()
+	for i := 0; i < len(p.conns); i++ {
+		c := p.conns[p.next]
+		p.next = (p.next + 1) % len(p.conns)
+		if c.conn == nil && now.Before(c.retryAt) {
+			continue
+		}
+		return thrift.NewTTransportExceptionFromError(p.write(c, now))
+	}
+	return thrift.NewTTransportException(thrift.NOT_OPEN, errNoConnection.Error())
+}
+
+// write writes the buffer to the connection, connecting it if needed.
+// A write failing on an established connection is retried once on a new
+// connection, as the peer may have closed an idle connection.
+func (p *TTCPTransport) write(c *pooledConn, now time.Time) error {
+	established := c.conn != nil
+	if !established {
+		if err := p.connect(c, now); err != nil {
+			return err
+		}
+	}
+
+	err := p.writeConn(c, now)
+	if err == nil || !established {
+		return err
+	}
+	if err := p.connect(c, now); err != nil {
+		return err
+	}
+	return p.writeConn(c, now)
+}
+
+func (p *TTCPTransport) connect(c *pooledConn, now time.Time) error {
+	conn, err := p.dial("tcp", p.addr, p.opts.DialTimeout)
+	if err != nil {
+		p.fail(c, now)
+		return err
+	}
+	c.conn = conn
+	return nil
+}
+
+func (p *TTCPTransport) writeConn(c *pooledConn, now time.Time) error {
+	if err := c.conn.SetWriteDeadline(now.Add(p.opts.WriteTimeout)); err != nil {
+		p.fail(c, now)
+		return err
+	}
+	if _, err := c.conn.Write(p.writeBuf.Bytes()); err != nil {
+		p.fail(c, now)
+		return err
+	}
+	c.backoff = 0
+	return nil
+}
+
+// fail closes the connection and backs off before reconnecting it.
+func (p *TTCPTransport) fail(c *pooledConn, now time.Time) {
+	if c.conn != nil {
+		c.conn.Close() //nolint:errcheck
+		c.conn = nil
+	}
+
+	c.backoff *= 2
+	if c.backoff < p.opts.MinBackoff {
+		c.backoff = p.opts.MinBackoff
+	}
+	if c.backoff > p.opts.MaxBackoff {
+		c.backoff = p.opts.MaxBackoff
+	}
+	c.retryAt = now.Add(c.backoff)
+}
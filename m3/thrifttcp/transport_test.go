@@ -0,0 +1,192 @@
+// Copyright (c) 2022 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package thrifttcp
+
+import (
+	"errors"
+	"io"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/uber-go/tally/v4/thirdparty/github.com/apache/thrift/lib/go/thrift"
+)
+
+// frameServer accepts connections and reads the frames written to them.
+type frameServer struct {
+	listener net.Listener
+	mu       sync.Mutex
+	conns    []net.Conn
+	frames   chan string
+}
+
+func newFrameServer(t *testing.T, addr string) *frameServer {
+	listener, err := net.Listen("tcp", addr)
+	require.NoError(t, err)
+	s := &frameServer{listener: listener, frames: make(chan string, 16)}
+	go s.serve()
+	return s
+}
+
+func (s *frameServer) serve() {
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+		s.mu.Lock()
+		s.conns = append(s.conns, conn)
+		s.mu.Unlock()
+
+		go func() {
+			trans := thrift.NewTFramedTransport(thrift.NewTSocketFromConnTimeout(conn, 0))
+			buf := make([]byte, 5)
+			for {
+				if _, err := io.ReadFull(trans, buf); err != nil {
+					return
+				}
+				s.frames <- string(buf)
+			}
+		}()
+	}
+}
+
+func (s *frameServer) numConns() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.conns)
+}
+
+func (s *frameServer) close() {
+	s.listener.Close()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, conn := range s.conns {
+		conn.Close()
+	}
+}
+
+func (s *frameServer) frame(t *testing.T) string {
+	select {
+	case frame := <-s.frames:
+		return frame
+	case <-time.After(5 * time.Second):
+		require.FailNow(t, "timed out waiting for frame")
+		return ""
+	}
+}
+
+func writeFrame(trans thrift.TTransport, frame string) error {
+	if _, err := trans.Write([]byte(frame)); err != nil {
+		return err
+	}
+	return trans.Flush()
+}
+
+func TestNewTTCPClientTransportBadAddress(t *testing.T) {
+	trans, err := NewTTCPClientTransport("not an address", Options{})
+	assert.Nil(t, trans)
+	assert.Error(t, err)
+}
+
+func TestTTCPTransportFramedWrites(t *testing.T) {
+	server := newFrameServer(t, "127.0.0.1:0")
+	defer server.close()
+
+	tcp, err := NewTTCPClientTransport(server.listener.Addr().String(), Options{PoolSize: 2})
+	require.NoError(t, err)
+	trans := thrift.NewTFramedTransport(tcp)
+	assert.True(t, trans.IsOpen())
+
+	for _, frame := range []string{"frame", "other", "third"} {
+		require.NoError(t, writeFrame(trans, frame))
+		assert.Equal(t, frame, server.frame(t))
+	}
+	// The frames are spread across the pool.
+	assert.Equal(t, 2, server.numConns())
+
+	require.NoError(t, trans.Close())
+	assert.False(t, trans.IsOpen())
+	assert.Error(t, writeFrame(trans, "frame"))
+}
+
+func TestTTCPTransportReconnect(t *testing.T) {
+	server := newFrameServer(t, "127.0.0.1:0")
+	addr := server.listener.Addr().String()
+
+	tcp, err := NewTTCPClientTransport(addr, Options{MinBackoff: time.Millisecond})
+	require.NoError(t, err)
+	trans := thrift.NewTFramedTransport(tcp)
+	defer trans.Close()
+
+	require.NoError(t, writeFrame(trans, "first"))
+	assert.Equal(t, "first", server.frame(t))
+
+	// Frames are dropped while the server is down, then written once
+	// reconnected.
+	server.close()
+	require.Eventually(t, func() bool {
+		return writeFrame(trans, "lost!") != nil
+	}, 5*time.Second, time.Millisecond)
+
+	server = newFrameServer(t, addr)
+	defer server.close()
+	require.Eventually(t, func() bool {
+		return writeFrame(trans, "again") == nil
+	}, 5*time.Second, time.Millisecond)
+	assert.Equal(t, "again", server.frame(t))
+}
+
+func TestTTCPTransportBackoff(t *testing.T) {
+	tcp, err := NewTTCPClientTransport("127.0.0.1:9090", Options{
+		MinBackoff: time.Second,
+		MaxBackoff: 4 * time.Second,
+	})
+	require.NoError(t, err)
+
+	var (
+		now   = time.Unix(0, 0)
+		dials int
+	)
+	tcp.now = func() time.Time { return now }
+	tcp.dial = func(network, addr string, timeout time.Duration) (net.Conn, error) {
+		dials++
+		return nil, errors.New("refused")
+	}
+
+	for i, backoff := range []time.Duration{time.Second, 2 * time.Second, 4 * time.Second, 4 * time.Second} {
+		assert.Error(t, writeFrame(tcp, "frame"))
+		assert.Equal(t, i+1, dials)
+
+		// No connection is attempted until the backoff elapsed.
+		now = now.Add(backoff - time.Millisecond)
+		err := writeFrame(tcp, "frame")
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), errNoConnection.Error())
+		assert.Equal(t, i+1, dials)
+		assert.Zero(t, tcp.writeBuf.Len())
+
+		now = now.Add(time.Millisecond)
+	}
+}
@@ -22,6 +22,7 @@ package thriftudp
 
 import (
 	"fmt"
+	"time"
 
 	"github.com/uber-go/tally/v4/thirdparty/github.com/apache/thrift/lib/go/thrift"
 )
@@ -39,10 +40,22 @@ type TMultiUDPTransport struct {
 func NewTMultiUDPClientTransport(
 	destHostPorts []string,
 	locHostPort string,
+) (*TMultiUDPTransport, error) {
+	return NewTMultiUDPClientTransportWithResolveInterval(destHostPorts, locHostPort, 0)
+}
+
+// NewTMultiUDPClientTransportWithResolveInterval creates a set of net.UDPConn-backed
+// TTransports for Thrift clients, like NewTMultiUDPClientTransport, each resolving
+// its destination again on the interval if positive.
+func NewTMultiUDPClientTransportWithResolveInterval(
+	destHostPorts []string,
+	locHostPort string,
+	resolveInterval time.Duration,
 ) (*TMultiUDPTransport, error) {
 	var transports []thrift.TTransport
 	for i := range destHostPorts {
-		trans, err := NewTUDPClientTransport(destHostPorts[i], locHostPort)
+		trans, err := NewTUDPClientTransportWithResolveInterval(
+			destHostPorts[i], locHostPort, resolveInterval)
 		if err != nil {
 			return nil, err
 		}
@@ -52,6 +65,19 @@ func NewTMultiUDPClientTransport(
 	return &TMultiUDPTransport{transports: transports}, nil
 }
 
+// ResolveStats returns the number of times the destination addresses of the
+// underlying transports changed and failed to be resolved again.
+func (p *TMultiUDPTransport) ResolveStats() (changes int64, failures int64) {
+	for _, trans := range p.transports {
+		if t, ok := trans.(*TUDPTransport); ok {
+			c, f := t.ResolveStats()
+			changes += c
+			failures += f
+		}
+	}
+	return changes, failures
+}
+
 // Open the connections of the underlying transports
 func (p *TMultiUDPTransport) Open() error {
 	for _, trans := range p.transports {
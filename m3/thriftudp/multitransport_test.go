@@ -23,8 +23,10 @@ package thriftudp
 import (
 	"fmt"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 	"github.com/uber-go/tally/v4/thirdparty/github.com/apache/thrift/lib/go/thrift"
 )
 
@@ -201,3 +203,17 @@ func (m *mockTransport) Flush() error {
 	}
 	return fmt.Errorf("no mock Flush implementation")
 }
+
+func TestTMultiUDPTransportResolveStats(t *testing.T) {
+	trans, err := NewTMultiUDPClientTransportWithResolveInterval(
+		[]string{"127.0.0.1:9090", "127.0.0.1:9091"}, "", time.Hour)
+	require.NoError(t, err)
+	defer trans.Close()
+
+	for _, tr := range trans.transports {
+		tr.(*TUDPTransport).resolveFailures.Inc()
+	}
+	changes, failures := trans.ResolveStats()
+	assert.Equal(t, int64(0), changes)
+	assert.Equal(t, int64(2), failures)
+}
@@ -24,6 +24,8 @@ import (
 	"bytes"
 	"context"
 	"net"
+	"sync"
+	"time"
 
 	"github.com/uber-go/tally/v4/thirdparty/github.com/apache/thrift/lib/go/thrift"
 	"go.uber.org/atomic"
@@ -43,6 +45,15 @@ type TUDPTransport struct {
 	writeBuf    bytes.Buffer
 	readByteBuf []byte
 	closed      atomic.Bool
+
+	// Only set for client transports re-resolving their destination.
+	connLock        sync.RWMutex
+	destHostPort    string
+	locAddr         *net.UDPAddr
+	resolveChanges  atomic.Int64
+	resolveFailures atomic.Int64
+	resolveUDPAddr  func(network, address string) (*net.UDPAddr, error)
+	stop            chan struct{}
 }
 
 // NewTUDPClientTransport creates a net.UDPConn-backed TTransport for Thrift clients
@@ -51,6 +62,18 @@ type TUDPTransport struct {
 // Example:
 // 	trans, err := thriftudp.NewTUDPClientTransport("192.168.1.1:9090", "")
 func NewTUDPClientTransport(destHostPort string, locHostPort string) (*TUDPTransport, error) {
+	return NewTUDPClientTransportWithResolveInterval(destHostPort, locHostPort, 0)
+}
+
+// NewTUDPClientTransportWithResolveInterval creates a net.UDPConn-backed TTransport
+// for Thrift clients, like NewTUDPClientTransport, that resolves destHostPort again
+// on the interval if positive. When the address changes the transport switches to
+// the new address, the last address resolved is kept when resolving fails.
+func NewTUDPClientTransportWithResolveInterval(
+	destHostPort string,
+	locHostPort string,
+	resolveInterval time.Duration,
+) (*TUDPTransport, error) {
 	destAddr, err := net.ResolveUDPAddr("udp", destHostPort)
 	if err != nil {
 		return nil, thrift.NewTTransportException(thrift.NOT_OPEN, err.Error())
@@ -69,11 +92,75 @@ func NewTUDPClientTransport(destHostPort string, locHostPort string) (*TUDPTrans
 		return nil, thrift.NewTTransportException(thrift.NOT_OPEN, err.Error())
 	}
 
-	return &TUDPTransport{
-		addr:        destAddr,
-		conn:        conn,
-		readByteBuf: make([]byte, 1),
-	}, nil
+	p := &TUDPTransport{
+		addr:           destAddr,
+		conn:           conn,
+		readByteBuf:    make([]byte, 1),
+		destHostPort:   destHostPort,
+		locAddr:        locAddr,
+		resolveUDPAddr: net.ResolveUDPAddr,
+	}
+	if resolveInterval > 0 {
+		p.stop = make(chan struct{})
+		go p.resolveLoop(resolveInterval)
+	}
+	return p, nil
+}
+
+func (p *TUDPTransport) resolveLoop(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			p.resolve()
+		case <-p.stop:
+			return
+		}
+	}
+}
+
+// resolve resolves the destination address again, switching to a new
+// connection when it changed.
+func (p *TUDPTransport) resolve() {
+	destAddr, err := p.resolveUDPAddr("udp", p.destHostPort)
+	if err != nil {
+		p.resolveFailures.Inc()
+		return
+	}
+
+	p.connLock.RLock()
+	addr := p.addr
+	p.connLock.RUnlock()
+	if addr.String() == destAddr.String() {
+		return
+	}
+
+	conn, err := net.DialUDP(destAddr.Network(), p.locAddr, destAddr)
+	if err != nil {
+		p.resolveFailures.Inc()
+		return
+	}
+
+	p.connLock.Lock()
+	if p.closed.Load() {
+		p.connLock.Unlock()
+		conn.Close() //nolint:errcheck
+		return
+	}
+	prev := p.conn
+	p.conn, p.addr = conn, destAddr
+	p.connLock.Unlock()
+
+	prev.Close() //nolint:errcheck
+	p.resolveChanges.Inc()
+}
+
+// ResolveStats returns the number of times the destination address changed
+// and failed to be resolved again since the transport was created.
+func (p *TUDPTransport) ResolveStats() (changes int64, failures int64) {
+	return p.resolveChanges.Load(), p.resolveFailures.Load()
 }
 
 // NewTUDPServerTransport creates a net.UDPConn-backed TTransport for Thrift servers
@@ -116,6 +203,8 @@ func (p *TUDPTransport) Open() error {
 
 // Conn retrieves the underlying net.UDPConn
 func (p *TUDPTransport) Conn() *net.UDPConn {
+	p.connLock.RLock()
+	defer p.connLock.RUnlock()
 	return p.conn
 }
 
@@ -127,7 +216,13 @@ func (p *TUDPTransport) IsOpen() bool {
 // Close closes the transport and the underlying connection.
 // Note: the current implementation allows Close to be called multiple times without an error.
 func (p *TUDPTransport) Close() error {
+	p.connLock.Lock()
+	defer p.connLock.Unlock()
+
 	if closed := p.closed.Swap(true); !closed {
+		if p.stop != nil {
+			close(p.stop)
+		}
 		return p.conn.Close()
 	}
 	return nil
@@ -135,6 +230,8 @@ func (p *TUDPTransport) Close() error {
 
 // Addr returns the address that the transport is listening on or writing to
 func (p *TUDPTransport) Addr() net.Addr {
+	p.connLock.RLock()
+	defer p.connLock.RUnlock()
 	return p.addr
 }
 
@@ -212,7 +309,9 @@ func (p *TUDPTransport) Flush() error {
 		return thrift.NewTTransportException(thrift.NOT_OPEN, "Connection not open")
 	}
 
+	p.connLock.RLock()
 	_, err := p.conn.Write(p.writeBuf.Bytes())
+	p.connLock.RUnlock()
 	p.writeBuf.Reset() // always reset the buffer, even in case of an error
 	return err
 }
@@ -21,6 +21,7 @@
 package thriftudp
 
 import (
+	"errors"
 	"net"
 	"strings"
 	"sync"
@@ -329,3 +330,65 @@ func withLocalServer(t *testing.T, f func(addr string)) {
 	f(conn.LocalAddr().String())
 	require.NoError(t, conn.Close(), "Close failed")
 }
+
+func TestResolveSwitchesAddress(t *testing.T) {
+	withLocalServer(t, func(addr string) {
+		trans, err := NewTUDPClientTransport(addr, "")
+		require.NoError(t, err)
+		defer trans.Close()
+
+		server, err := net.ListenUDP(localListenAddr.Network(), localListenAddr)
+		require.NoError(t, err)
+		defer server.Close()
+
+		var resolveErr error
+		trans.resolveUDPAddr = func(network, address string) (*net.UDPAddr, error) {
+			assert.Equal(t, addr, address)
+			return server.LocalAddr().(*net.UDPAddr), resolveErr
+		}
+
+		trans.resolve()
+		assert.Equal(t, server.LocalAddr().String(), trans.Addr().String())
+		changes, failures := trans.ResolveStats()
+		assert.Equal(t, int64(1), changes)
+		assert.Equal(t, int64(0), failures)
+
+		// The same address does not change the connection.
+		conn := trans.Conn()
+		trans.resolve()
+		assert.Equal(t, conn, trans.Conn())
+
+		// The last address is kept when resolving fails.
+		resolveErr = errors.New("no such host")
+		trans.resolve()
+		assert.Equal(t, server.LocalAddr().String(), trans.Addr().String())
+		changes, failures = trans.ResolveStats()
+		assert.Equal(t, int64(1), changes)
+		assert.Equal(t, int64(1), failures)
+
+		_, err = trans.Write([]byte("packet"))
+		require.NoError(t, err)
+		require.NoError(t, trans.Flush())
+
+		buf := make([]byte, 16)
+		require.NoError(t, server.SetReadDeadline(time.Now().Add(5*time.Second)))
+		n, err := server.Read(buf)
+		require.NoError(t, err)
+		assert.Equal(t, "packet", string(buf[:n]))
+	})
+}
+
+func TestResolveInterval(t *testing.T) {
+	withLocalServer(t, func(addr string) {
+		trans, err := NewTUDPClientTransportWithResolveInterval(addr, "", time.Millisecond)
+		require.NoError(t, err)
+
+		time.Sleep(10 * time.Millisecond)
+		changes, failures := trans.ResolveStats()
+		assert.Equal(t, int64(0), changes)
+		assert.Equal(t, int64(0), failures)
+
+		require.NoError(t, trans.Close())
+		require.NoError(t, trans.Close())
+	})
+}
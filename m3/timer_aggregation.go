@@ -0,0 +1,293 @@
+// Copyright (c) 2022 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package m3
+
+import (
+	"math"
+	"math/rand"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/uber-go/tally/v4/internal/cache"
+)
+
+// TimerAggregationNaming describes how the aggregates of a timer are named.
+type TimerAggregationNaming int
+
+// TimerAggregationSuffix names the aggregates of a timer with a suffix,
+// e.g. latency.p99, and TimerAggregationTag with a tag, e.g. latency
+// tagged with stat p99.
+const (
+	TimerAggregationSuffix TimerAggregationNaming = iota
+	TimerAggregationTag
+)
+
+const (
+	// DefaultTimerAggregationSeparator is the default separator of the
+	// name of a timer and the suffix of its aggregates.
+	DefaultTimerAggregationSeparator = "."
+	// DefaultTimerAggregationTagName is the default name of the tag of
+	// the aggregates of a timer.
+	DefaultTimerAggregationTagName = "stat"
+	// DefaultTimerAggregationMaxSamples is the default max number of
+	// samples per interval the percentiles of a timer are computed from.
+	DefaultTimerAggregationMaxSamples = 1024
+)
+
+// DefaultTimerAggregationPercentiles are the default percentiles of the
+// aggregated timers.
+var DefaultTimerAggregationPercentiles = []float64{0.5, 0.95, 0.99}
+
+// TimerAggregationOptions are the options to aggregate the samples of each
+// timer on every flush rather than emitting each of them. The count and
+// sum of a timer are emitted as counters, its min, max and percentiles as
+// gauges, all in nanoseconds.
+type TimerAggregationOptions struct {
+	// Percentiles are the percentiles to emit, by default
+	// DefaultTimerAggregationPercentiles.
+	Percentiles []float64
+
+	// Naming is how the aggregates are named.
+	Naming TimerAggregationNaming
+
+	// Separator is the separator of the suffix of the aggregates when
+	// named with a suffix, by default DefaultTimerAggregationSeparator.
+	Separator string
+
+	// TagName is the name of the tag of the aggregates when named with a
+	// tag, by default DefaultTimerAggregationTagName.
+	TagName string
+
+	// MaxSamples is the max number of samples per interval the percentiles
+	// are computed from, samples beyond it are sampled uniformly. By
+	// default DefaultTimerAggregationMaxSamples.
+	MaxSamples int
+}
+
+// aggregatedTimer aggregates the samples of a timer until reported. The
+// timers allocated for the same series share an aggregated timer, which is
+// dropped after it is reported once all of them are released.
+type aggregatedTimer struct {
+	reporter *reporter
+	id       uint64
+	refs     int
+
+	sync.Mutex
+	count      int64
+	sum        int64
+	min        time.Duration
+	max        time.Duration
+	samples    []time.Duration
+	maxSamples int
+
+	countMetric       cachedMetric
+	sumMetric         cachedMetric
+	minMetric         cachedMetric
+	maxMetric         cachedMetric
+	percentiles       []float64
+	percentileMetrics []cachedMetric
+
+	// statTagKeys are the tag cache keys of the tags of the aggregates when
+	// named with a tag, which only they use.
+	statTagKeys []uint64
+}
+
+func (r *reporter) allocateAggregatedTimer(
+	name string,
+	tags map[string]string,
+) *aggregatedTimer {
+	var (
+		opts = r.timerAggregation
+		id   = seriesID(name, tags)
+	)
+
+	r.aggregatedTimersLock.Lock()
+	defer r.aggregatedTimersLock.Unlock()
+
+	if t, ok := r.aggregatedTimers[id]; ok {
+		t.refs++
+		return t
+	}
+
+	var statTagKeys []uint64
+	allocate := func(stat string, t metricType) cachedMetric {
+		var m cachedMetric
+		switch opts.Naming {
+		case TimerAggregationTag:
+			statTags := make(map[string]string, len(tags)+1)
+			for k, v := range tags {
+				statTags[k] = v
+			}
+			statTags[opts.TagName] = stat
+			m = r.allocateMetric(name, statTags, t)
+			statTagKeys = append(statTagKeys, cache.TagMapKey(statTags))
+		default:
+			m = r.allocateMetric(name+opts.Separator+stat, tags, t)
+		}
+		// n.b. The aggregates of a timer are sent to the same host port
+		//      when sharding, as its samples would be.
+		m.id = id
+		return m
+	}
+
+	t := &aggregatedTimer{
+		reporter:    r,
+		id:          id,
+		refs:        1,
+		samples:     make([]time.Duration, 0, 16),
+		maxSamples:  opts.MaxSamples,
+		countMetric: allocate("count", counterType),
+		sumMetric:   allocate("sum", counterType),
+		minMetric:   allocate("min", gaugeType),
+		maxMetric:   allocate("max", gaugeType),
+		percentiles: opts.Percentiles,
+	}
+	for _, p := range opts.Percentiles {
+		t.percentileMetrics = append(t.percentileMetrics,
+			allocate(percentileStat(p), gaugeType))
+	}
+	t.statTagKeys = statTagKeys
+
+	r.aggregatedTimers[id] = t
+	return t
+}
+
+// reportAggregatedTimers reports the aggregates of the timers with samples
+// since they were last reported, dropping the timers released since.
+func (r *reporter) reportAggregatedTimers() {
+	var dropped []*aggregatedTimer
+	r.aggregatedTimersLock.Lock()
+	timers := make([]*aggregatedTimer, 0, len(r.aggregatedTimers))
+	for id, t := range r.aggregatedTimers {
+		timers = append(timers, t)
+		if t.refs == 0 {
+			delete(r.aggregatedTimers, id)
+			dropped = append(dropped, t)
+		}
+	}
+	r.aggregatedTimersLock.Unlock()
+
+	var samples []time.Duration
+	for _, t := range timers {
+		samples = t.report(samples[:0])
+	}
+	for _, t := range dropped {
+		t.release()
+	}
+}
+
+// Release implements tally.CachedMetricReleaser.
+func (t *aggregatedTimer) Release() {
+	t.reporter.aggregatedTimersLock.Lock()
+	t.refs--
+	t.reporter.aggregatedTimersLock.Unlock()
+}
+
+// release releases the cached metrics of the aggregates once the timer is
+// dropped, removing their tags from the tag cache. The metrics already
+// reported keep referencing the tags so they are not returned to the pool.
+func (t *aggregatedTimer) release() {
+	for _, key := range t.statTagKeys {
+		t.reporter.tagCache.Delete(key)
+	}
+}
+
+func (t *aggregatedTimer) ReportTimer(interval time.Duration) {
+	t.Lock()
+	defer t.Unlock()
+
+	t.count++
+	t.sum += int64(interval)
+	if t.count == 1 || interval < t.min {
+		t.min = interval
+	}
+	if t.count == 1 || interval > t.max {
+		t.max = interval
+	}
+
+	// Keep a uniform sample of the values for the percentiles.
+	if len(t.samples) < t.maxSamples {
+		t.samples = append(t.samples, interval)
+	} else if i := rand.Int63n(t.count); i < int64(t.maxSamples) {
+		t.samples[i] = interval
+	}
+}
+
+// report reports the aggregates of the samples of the timer and resets
+// them, reusing the given slice to sort the samples.
+func (t *aggregatedTimer) report(samples []time.Duration) []time.Duration {
+	t.Lock()
+	var (
+		count   = t.count
+		sum     = t.sum
+		minimum = t.min
+		maximum = t.max
+	)
+	samples = append(samples, t.samples...)
+	t.count, t.sum, t.min, t.max = 0, 0, 0, 0
+	t.samples = t.samples[:0]
+	t.Unlock()
+
+	if count == 0 {
+		return samples
+	}
+
+	t.countMetric.ReportCount(count)
+	t.sumMetric.ReportCount(sum)
+	t.minMetric.ReportGauge(float64(minimum))
+	t.maxMetric.ReportGauge(float64(maximum))
+
+	sort.Slice(samples, func(i, j int) bool {
+		return samples[i] < samples[j]
+	})
+	for i, p := range t.percentiles {
+		t.percentileMetrics[i].ReportGauge(float64(percentile(samples, p)))
+	}
+	return samples
+}
+
+// percentile returns the nearest rank percentile of the sorted samples.
+func percentile(samples []time.Duration, p float64) time.Duration {
+	i := int(math.Ceil(p*float64(len(samples)))) - 1
+	if i < 0 {
+		i = 0
+	}
+	if i >= len(samples) {
+		i = len(samples) - 1
+	}
+	return samples[i]
+}
+
+// percentileStat returns the name of the aggregate of a percentile, e.g.
+// p50 for 0.5, p99 for 0.99 and p999 for 0.999.
+func percentileStat(p float64) string {
+	if p >= 1 {
+		return "p100"
+	}
+	digits := strings.TrimPrefix(strconv.FormatFloat(p, 'f', -1, 64), "0.")
+	if len(digits) == 1 {
+		digits += "0"
+	}
+	return "p" + digits
+}
@@ -0,0 +1,235 @@
+// Copyright (c) 2022 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package m3
+
+import (
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	tally "github.com/uber-go/tally/v4"
+	m3thrift "github.com/uber-go/tally/v4/m3/thrift/v2"
+)
+
+func TestTimerAggregation(t *testing.T) {
+	tests := []struct {
+		name     string
+		naming   TimerAggregationNaming
+		expected map[string]string
+	}{
+		{
+			name:   "suffix",
+			naming: TimerAggregationSuffix,
+			expected: map[string]string{
+				"latency.count": "",
+				"latency.sum":   "",
+				"latency.min":   "",
+				"latency.max":   "",
+				"latency.p50":   "",
+				"latency.p99":   "",
+				"latency.p999":  "",
+			},
+		},
+		{
+			name:   "tag",
+			naming: TimerAggregationTag,
+			expected: map[string]string{
+				"latency.count": "count",
+				"latency.sum":   "sum",
+				"latency.min":   "min",
+				"latency.max":   "max",
+				"latency.p50":   "p50",
+				"latency.p99":   "p99",
+				"latency.p999":  "p999",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var wg sync.WaitGroup
+			server := newFakeM3Server(t, &wg, true, Compact)
+			go server.Serve()
+			defer server.Close()
+
+			r, err := NewReporter(Options{
+				HostPorts:  []string{server.Addr},
+				Service:    "test-service",
+				CommonTags: defaultCommonTags,
+				TimerAggregation: &TimerAggregationOptions{
+					Percentiles: []float64{0.5, 0.99, 0.999},
+					Naming:      tt.naming,
+				},
+			})
+			require.NoError(t, err)
+			defer r.Close()
+
+			timer := r.AllocateTimer("latency", map[string]string{"handler": "foo"})
+			for i := 1000; i > 0; i-- {
+				timer.ReportTimer(time.Duration(i) * time.Millisecond)
+			}
+
+			wg.Add(2)
+			r.Flush()
+			// Nothing is emitted for the timer without samples.
+			r.Flush()
+			wg.Wait()
+
+			batches := server.Service.getBatches()
+			require.Equal(t, 2, len(batches))
+			require.Equal(t, internalMetrics+len(tt.expected), len(batches[0].GetMetrics()))
+			require.Equal(t, internalMetrics, len(batches[1].GetMetrics()))
+
+			values := make(map[string]m3thrift.MetricValue)
+			for _, metric := range batches[0].GetMetrics() {
+				name := metric.GetName()
+				if !strings.HasPrefix(name, "latency") {
+					continue
+				}
+				require.True(t, tagEquals(metric.GetTags(), "handler", "foo"))
+				for suffix, stat := range tt.expected {
+					if stat != "" && tagEquals(metric.GetTags(), "stat", stat) {
+						require.Equal(t, "latency", name)
+						name = suffix
+					}
+				}
+				values[name] = metric.GetValue()
+			}
+			require.Equal(t, len(tt.expected), len(values))
+
+			assert.Equal(t, m3thrift.MetricType_COUNTER, values["latency.count"].MetricType)
+			assert.Equal(t, int64(1000), values["latency.count"].Count)
+			assert.Equal(t, int64(500500*time.Millisecond), values["latency.sum"].Count)
+			assert.Equal(t, m3thrift.MetricType_GAUGE, values["latency.min"].MetricType)
+			assert.Equal(t, float64(time.Millisecond), values["latency.min"].Gauge)
+			assert.Equal(t, float64(1000*time.Millisecond), values["latency.max"].Gauge)
+			assert.Equal(t, float64(500*time.Millisecond), values["latency.p50"].Gauge)
+			assert.Equal(t, float64(990*time.Millisecond), values["latency.p99"].Gauge)
+			assert.Equal(t, float64(999*time.Millisecond), values["latency.p999"].Gauge)
+		})
+	}
+}
+
+func TestAggregatedTimerSharedAndReleased(t *testing.T) {
+	var wg sync.WaitGroup
+	server := newFakeM3Server(t, &wg, true, Compact)
+	go server.Serve()
+	defer server.Close()
+
+	r, err := NewReporter(Options{
+		HostPorts:  []string{server.Addr},
+		Service:    "test-service",
+		CommonTags: defaultCommonTags,
+		TimerAggregation: &TimerAggregationOptions{
+			Percentiles: []float64{0.5},
+		},
+	})
+	require.NoError(t, err)
+	defer r.Close()
+	rep := r.(*reporter)
+
+	tags := map[string]string{"handler": "foo"}
+	first := r.AllocateTimer("latency", tags)
+	second := r.AllocateTimer("latency", tags)
+	require.True(t, first == second, "the timers of a series must be shared")
+
+	first.ReportTimer(time.Millisecond)
+	second.ReportTimer(time.Millisecond)
+	first.(tally.CachedMetricReleaser).Release()
+	second.(tally.CachedMetricReleaser).Release()
+
+	// The released timer is reported once more before it is dropped.
+	wg.Add(1)
+	r.Flush()
+	wg.Wait()
+
+	var counts []int64
+	for _, metric := range server.Service.getBatches()[0].GetMetrics() {
+		if metric.GetName() == "latency.count" {
+			counts = append(counts, metric.GetValue().Count)
+		}
+	}
+	assert.Equal(t, []int64{2}, counts)
+
+	rep.aggregatedTimersLock.Lock()
+	assert.Empty(t, rep.aggregatedTimers)
+	rep.aggregatedTimersLock.Unlock()
+}
+
+func TestAggregatedTimerReleasesStatTags(t *testing.T) {
+	var wg sync.WaitGroup
+	server := newFakeM3Server(t, &wg, true, Compact)
+	go server.Serve()
+	defer server.Close()
+
+	r, err := NewReporter(Options{
+		HostPorts:  []string{server.Addr},
+		Service:    "test-service",
+		CommonTags: defaultCommonTags,
+		TimerAggregation: &TimerAggregationOptions{
+			Percentiles: []float64{0.5},
+			Naming:      TimerAggregationTag,
+		},
+	})
+	require.NoError(t, err)
+	defer r.Close()
+	rep := r.(*reporter)
+
+	cached := rep.tagCache.Len()
+	timer := r.AllocateTimer("latency", map[string]string{"handler": "foo"})
+	// The count, sum, min, max and p50 aggregates are each tagged.
+	assert.Equal(t, cached+5, rep.tagCache.Len())
+
+	timer.ReportTimer(time.Millisecond)
+	timer.(tally.CachedMetricReleaser).Release()
+	wg.Add(1)
+	r.Flush()
+	wg.Wait()
+	assert.Equal(t, cached, rep.tagCache.Len())
+}
+
+func TestAggregatedTimerMaxSamples(t *testing.T) {
+	timer := &aggregatedTimer{maxSamples: 10}
+	for i := 0; i < 1000; i++ {
+		timer.ReportTimer(time.Duration(i))
+	}
+
+	assert.Equal(t, int64(1000), timer.count)
+	assert.Equal(t, time.Duration(0), timer.min)
+	assert.Equal(t, time.Duration(999), timer.max)
+	assert.Equal(t, 10, len(timer.samples))
+}
+
+func TestPercentileStat(t *testing.T) {
+	for p, expected := range map[float64]string{
+		0.05:  "p05",
+		0.5:   "p50",
+		0.75:  "p75",
+		0.99:  "p99",
+		0.999: "p999",
+		1:     "p100",
+	} {
+		assert.Equal(t, expected, percentileStat(p))
+	}
+}
@@ -0,0 +1,78 @@
+// Copyright (c) 2022 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package otlp
+
+import (
+	"time"
+)
+
+// Configuration is a configuration for an OTLP reporter.
+type Configuration struct {
+	// Endpoint is the full URL of the collector's OTLP/HTTP metrics
+	// endpoint, for example http://localhost:4318/v1/metrics.
+	Endpoint string `yaml:"endpoint"`
+
+	// Headers are added to every export request.
+	Headers map[string]string `yaml:"headers"`
+
+	// ResourceAttributes describe the entity producing the metrics.
+	ResourceAttributes map[string]string `yaml:"resourceAttributes"`
+
+	// DisableCompression disables gzip compression of export requests.
+	DisableCompression bool `yaml:"disableCompression"`
+
+	// Timeout is the timeout of a single export attempt.
+	Timeout time.Duration `yaml:"timeout"`
+
+	// MaxRetries is the number of times a failed export is retried,
+	// use a negative value to disable retries.
+	MaxRetries int `yaml:"maxRetries"`
+
+	// InitialBackoff is the wait before the first retry.
+	InitialBackoff time.Duration `yaml:"initialBackoff"`
+
+	// MaxBackoff is the upper limit of the wait between retries.
+	MaxBackoff time.Duration `yaml:"maxBackoff"`
+
+	// QueueSize is the number of export requests buffered while a
+	// previous export is in flight.
+	QueueSize int `yaml:"queueSize"`
+
+	// TimerBuckets if specified will set the buckets timers are
+	// exported with.
+	TimerBuckets []time.Duration `yaml:"timerBuckets"`
+}
+
+// NewReporter creates a new OTLP reporter from this configuration.
+func (c Configuration) NewReporter() (Reporter, error) {
+	return NewReporter(Options{
+		Endpoint:           c.Endpoint,
+		Headers:            c.Headers,
+		ResourceAttributes: c.ResourceAttributes,
+		DisableCompression: c.DisableCompression,
+		Timeout:            c.Timeout,
+		MaxRetries:         c.MaxRetries,
+		InitialBackoff:     c.InitialBackoff,
+		MaxBackoff:         c.MaxBackoff,
+		QueueSize:          c.QueueSize,
+		TimerBuckets:       c.TimerBuckets,
+	})
+}
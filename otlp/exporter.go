@@ -0,0 +1,209 @@
+// Copyright (c) 2022 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package otlp
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// HTTPClient is the subset of *http.Client used to send export requests.
+type HTTPClient interface {
+	Do(req *http.Request) (*http.Response, error)
+}
+
+// exporter sends encoded export requests from a background goroutine so
+// that retries never block a flush.
+type exporter struct {
+	opts   Options
+	client HTTPClient
+
+	reqCh  chan []byte
+	donech chan struct{}
+	wg     sync.WaitGroup
+	once   sync.Once
+}
+
+func newExporter(opts Options) (*exporter, error) {
+	if _, err := http.NewRequest(http.MethodPost, opts.Endpoint, nil); err != nil {
+		return nil, errors.Wrap(err, "invalid endpoint")
+	}
+
+	client := opts.HTTPClient
+	if client == nil {
+		client = &http.Client{Timeout: opts.Timeout}
+	}
+
+	e := &exporter{
+		opts:   opts,
+		client: client,
+		reqCh:  make(chan []byte, opts.QueueSize),
+		donech: make(chan struct{}),
+	}
+	e.wg.Add(1)
+	go e.process()
+	return e, nil
+}
+
+func (e *exporter) enqueue(req []byte) {
+	select {
+	case e.reqCh <- req:
+	default:
+		e.onError(errQueueFull)
+	}
+}
+
+func (e *exporter) close() {
+	e.once.Do(func() {
+		close(e.donech)
+		close(e.reqCh)
+	})
+	e.wg.Wait()
+}
+
+func (e *exporter) process() {
+	defer e.wg.Done()
+
+	for req := range e.reqCh {
+		if err := e.export(req); err != nil {
+			e.onError(err)
+		}
+	}
+}
+
+func (e *exporter) onError(err error) {
+	if e.opts.OnError != nil {
+		e.opts.OnError(err)
+	}
+}
+
+// export sends a request, retrying on network errors and on the status
+// codes the OTLP specification marks as retryable. The wait before a retry
+// is at most MaxBackoff, even if the server asks to retry later, and no
+// more retries are made once the exporter is closed.
+func (e *exporter) export(req []byte) error {
+	body, encoding, err := e.compress(req)
+	if err != nil {
+		return err
+	}
+
+	backoff := e.opts.InitialBackoff
+	for attempt := 0; ; attempt++ {
+		retryAfter, err := e.send(body, encoding)
+		if err == nil {
+			return nil
+		}
+		if retryAfter < 0 || attempt >= e.opts.MaxRetries {
+			return err
+		}
+
+		wait := backoff
+		if retryAfter > 0 {
+			wait = retryAfter
+		}
+		if wait > e.opts.MaxBackoff {
+			wait = e.opts.MaxBackoff
+		}
+		timer := time.NewTimer(wait)
+		select {
+		case <-timer.C:
+		case <-e.donech:
+			timer.Stop()
+			return err
+		}
+
+		if backoff *= 2; backoff > e.opts.MaxBackoff {
+			backoff = e.opts.MaxBackoff
+		}
+	}
+}
+
+func (e *exporter) compress(req []byte) ([]byte, string, error) {
+	if e.opts.DisableCompression {
+		return req, "", nil
+	}
+
+	var buf bytes.Buffer
+	w := gzip.NewWriter(&buf)
+	if _, err := w.Write(req); err != nil {
+		return nil, "", errors.Wrap(err, "failed to compress export request")
+	}
+	if err := w.Close(); err != nil {
+		return nil, "", errors.Wrap(err, "failed to compress export request")
+	}
+	return buf.Bytes(), "gzip", nil
+}
+
+// send makes a single export attempt. On failure it returns how long to
+// wait before retrying, zero to use the regular backoff and a negative
+// value if the request must not be retried.
+func (e *exporter) send(body []byte, encoding string) (time.Duration, error) {
+	req, err := http.NewRequest(http.MethodPost, e.opts.Endpoint, bytes.NewReader(body))
+	if err != nil {
+		return -1, errors.Wrap(err, "failed to create export request")
+	}
+	req.Header.Set("Content-Type", "application/x-protobuf")
+	if encoding != "" {
+		req.Header.Set("Content-Encoding", encoding)
+	}
+	for k, v := range e.opts.Headers {
+		req.Header.Set(k, v)
+	}
+
+	resp, err := e.client.Do(req)
+	if err != nil {
+		return 0, errors.Wrap(err, "failed to export metrics")
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(ioutil.Discard, resp.Body)
+
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return 0, nil
+	}
+
+	err = fmt.Errorf("failed to export metrics: %s", resp.Status)
+	switch resp.StatusCode {
+	case http.StatusTooManyRequests,
+		http.StatusBadGateway,
+		http.StatusServiceUnavailable,
+		http.StatusGatewayTimeout:
+		return retryAfter(resp.Header.Get("Retry-After")), err
+	}
+	return -1, err
+}
+
+// retryAfter parses the delay-seconds form of a Retry-After header.
+func retryAfter(value string) time.Duration {
+	seconds, err := strconv.Atoi(value)
+	if err != nil || seconds <= 0 {
+		return 0
+	}
+	return time.Duration(seconds) * time.Second
+}
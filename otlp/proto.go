@@ -0,0 +1,149 @@
+// Copyright (c) 2022 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package otlp
+
+import (
+	"math"
+
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// Field numbers of the OTLP metrics protobuf messages, see
+// https://github.com/open-telemetry/opentelemetry-proto/blob/main/opentelemetry/proto/metrics/v1/metrics.proto
+const (
+	// ExportMetricsServiceRequest.
+	exportRequestResourceMetrics protowire.Number = 1
+
+	// ResourceMetrics.
+	resourceMetricsResource     protowire.Number = 1
+	resourceMetricsScopeMetrics protowire.Number = 2
+
+	// Resource.
+	resourceAttributes protowire.Number = 1
+
+	// ScopeMetrics.
+	scopeMetricsScope   protowire.Number = 1
+	scopeMetricsMetrics protowire.Number = 2
+
+	// InstrumentationScope.
+	scopeName    protowire.Number = 1
+	scopeVersion protowire.Number = 2
+
+	// Metric.
+	metricName      protowire.Number = 1
+	metricUnit      protowire.Number = 3
+	metricGauge     protowire.Number = 5
+	metricSum       protowire.Number = 7
+	metricHistogram protowire.Number = 9
+
+	// Gauge, Sum and Histogram.
+	dataDataPoints             protowire.Number = 1
+	dataAggregationTemporality protowire.Number = 2
+	sumIsMonotonic             protowire.Number = 3
+
+	// NumberDataPoint.
+	numberStartTimeUnixNano protowire.Number = 2
+	numberTimeUnixNano      protowire.Number = 3
+	numberAsDouble          protowire.Number = 4
+	numberAsInt             protowire.Number = 6
+	numberAttributes        protowire.Number = 7
+
+	// HistogramDataPoint.
+	histogramStartTimeUnixNano protowire.Number = 2
+	histogramTimeUnixNano      protowire.Number = 3
+	histogramCount             protowire.Number = 4
+	histogramSum               protowire.Number = 5
+	histogramBucketCounts      protowire.Number = 6
+	histogramExplicitBounds    protowire.Number = 7
+	histogramAttributes        protowire.Number = 9
+	histogramMin               protowire.Number = 11
+	histogramMax               protowire.Number = 12
+
+	// KeyValue.
+	keyValueKey   protowire.Number = 1
+	keyValueValue protowire.Number = 2
+
+	// AnyValue.
+	anyValueString protowire.Number = 1
+
+	// AggregationTemporality.
+	aggregationTemporalityDelta = 1
+)
+
+func appendMessage(b []byte, num protowire.Number, msg []byte) []byte {
+	b = protowire.AppendTag(b, num, protowire.BytesType)
+	return protowire.AppendBytes(b, msg)
+}
+
+func appendString(b []byte, num protowire.Number, s string) []byte {
+	b = protowire.AppendTag(b, num, protowire.BytesType)
+	return protowire.AppendString(b, s)
+}
+
+func appendVarint(b []byte, num protowire.Number, v uint64) []byte {
+	b = protowire.AppendTag(b, num, protowire.VarintType)
+	return protowire.AppendVarint(b, v)
+}
+
+func appendFixed64(b []byte, num protowire.Number, v uint64) []byte {
+	b = protowire.AppendTag(b, num, protowire.Fixed64Type)
+	return protowire.AppendFixed64(b, v)
+}
+
+func appendDouble(b []byte, num protowire.Number, v float64) []byte {
+	return appendFixed64(b, num, math.Float64bits(v))
+}
+
+func appendPackedFixed64(b []byte, num protowire.Number, values []uint64) []byte {
+	b = protowire.AppendTag(b, num, protowire.BytesType)
+	b = protowire.AppendVarint(b, uint64(len(values)*8))
+	for _, v := range values {
+		b = protowire.AppendFixed64(b, v)
+	}
+	return b
+}
+
+func appendPackedDouble(b []byte, num protowire.Number, values []float64) []byte {
+	b = protowire.AppendTag(b, num, protowire.BytesType)
+	b = protowire.AppendVarint(b, uint64(len(values)*8))
+	for _, v := range values {
+		b = protowire.AppendFixed64(b, math.Float64bits(v))
+	}
+	return b
+}
+
+// encodeAttributes encodes tags as a list of KeyValue messages.
+func encodeAttributes(tags map[string]string) [][]byte {
+	attrs := make([][]byte, 0, len(tags))
+	for k, v := range tags {
+		kv := appendString(nil, keyValueKey, k)
+		kv = appendMessage(kv, keyValueValue, appendString(nil, anyValueString, v))
+		attrs = append(attrs, kv)
+	}
+	return attrs
+}
+
+func appendAttributes(b []byte, num protowire.Number, attrs [][]byte) []byte {
+	for _, kv := range attrs {
+		b = appendMessage(b, num, kv)
+	}
+	return b
+}
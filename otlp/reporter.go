@@ -0,0 +1,596 @@
+// Copyright (c) 2022 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package otlp
+
+import (
+	"io"
+	"math"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	tally "github.com/uber-go/tally/v4"
+	"go.uber.org/atomic"
+)
+
+const (
+	// DefaultEndpoint is the default OTLP/HTTP metrics endpoint.
+	DefaultEndpoint = "http://localhost:4318/v1/metrics"
+
+	// DefaultTimeout is the default timeout of a single export request.
+	DefaultTimeout = 10 * time.Second
+
+	// DefaultMaxRetries is the default number of times a failed export
+	// is retried before it is dropped.
+	DefaultMaxRetries = 5
+
+	// DefaultInitialBackoff is the default wait before the first retry.
+	DefaultInitialBackoff = 100 * time.Millisecond
+
+	// DefaultMaxBackoff is the default upper limit of the wait between
+	// retries.
+	DefaultMaxBackoff = 5 * time.Second
+
+	// DefaultQueueSize is the default number of export requests buffered
+	// while a previous export is still in flight.
+	DefaultQueueSize = 16
+
+	// ScopeName is the instrumentation scope name attached to every
+	// export request.
+	ScopeName = "github.com/uber-go/tally/v4/otlp"
+)
+
+var (
+	// DefaultTimerBuckets is the default set of buckets timers are
+	// exported with.
+	DefaultTimerBuckets = tally.DurationBuckets{
+		5 * time.Millisecond,
+		10 * time.Millisecond,
+		25 * time.Millisecond,
+		50 * time.Millisecond,
+		100 * time.Millisecond,
+		250 * time.Millisecond,
+		500 * time.Millisecond,
+		time.Second,
+		2500 * time.Millisecond,
+		5 * time.Second,
+		10 * time.Second,
+	}
+
+	errAlreadyClosed = errors.New("reporter already closed")
+	errQueueFull     = errors.New("export queue full, dropping metrics")
+)
+
+// Reporter is a tally.CachedStatsReporter that exports metrics to an
+// OpenTelemetry collector over OTLP/HTTP.
+type Reporter interface {
+	tally.CachedStatsReporter
+	io.Closer
+}
+
+// Options is a set of options for the OTLP reporter.
+type Options struct {
+	// Endpoint is the full URL metrics are posted to, by default this
+	// will be set to DefaultEndpoint.
+	Endpoint string
+
+	// Headers are added to every export request, typically used for
+	// authentication.
+	Headers map[string]string
+
+	// ResourceAttributes describe the entity producing the metrics, for
+	// example service.name and deployment.environment.
+	ResourceAttributes map[string]string
+
+	// DisableCompression disables gzip compression of export requests.
+	DisableCompression bool
+
+	// Timeout is the timeout of a single export attempt, by default this
+	// will be set to DefaultTimeout.
+	Timeout time.Duration
+
+	// MaxRetries is the number of times a failed export is retried, by
+	// default this will be set to DefaultMaxRetries. Use a negative value
+	// to disable retries.
+	MaxRetries int
+
+	// InitialBackoff is the wait before the first retry, doubling for
+	// each subsequent retry. By default this will be set to
+	// DefaultInitialBackoff.
+	InitialBackoff time.Duration
+
+	// MaxBackoff is the upper limit of the wait between retries, also
+	// when the server asks to retry later with Retry-After, by default
+	// this will be set to DefaultMaxBackoff.
+	MaxBackoff time.Duration
+
+	// QueueSize is the number of export requests buffered while a
+	// previous export is in flight, by default this will be set to
+	// DefaultQueueSize.
+	QueueSize int
+
+	// TimerBuckets are the buckets timers are exported with, by default
+	// this will be set to DefaultTimerBuckets.
+	TimerBuckets tally.DurationBuckets
+
+	// HTTPClient is the client used to send export requests, by default
+	// a client with Timeout is used.
+	HTTPClient HTTPClient
+
+	// OnError is called with any error encountered exporting metrics,
+	// by default errors are ignored.
+	OnError func(err error)
+}
+
+type metricKind int
+
+const (
+	sumKind metricKind = iota
+	gaugeKind
+	histogramKind
+)
+
+type familyKey struct {
+	name string
+	kind metricKind
+}
+
+// family is the set of series sharing a metric name and kind, which
+// OTLP expects to be encoded as data points of a single Metric.
+type family struct {
+	unit   string
+	points []dataPoint
+}
+
+// dataPoint appends the encoded data point accumulated since the last
+// flush to b, returning false if there is nothing to export.
+type dataPoint interface {
+	appendPoint(b []byte, start, now uint64) ([]byte, bool)
+}
+
+type reporter struct {
+	exporter *exporter
+	resource []byte
+	scope    []byte
+
+	timerBounds []float64
+
+	sync.RWMutex
+	families  map[familyKey]*family
+	order     []familyKey
+	lastFlush time.Time
+	closed    bool
+}
+
+// NewReporter returns a new OTLP/HTTP reporter.
+func NewReporter(opts Options) (Reporter, error) {
+	if opts.Endpoint == "" {
+		opts.Endpoint = DefaultEndpoint
+	}
+	if opts.Timeout <= 0 {
+		opts.Timeout = DefaultTimeout
+	}
+	if opts.MaxRetries == 0 {
+		opts.MaxRetries = DefaultMaxRetries
+	}
+	if opts.InitialBackoff <= 0 {
+		opts.InitialBackoff = DefaultInitialBackoff
+	}
+	if opts.MaxBackoff <= 0 {
+		opts.MaxBackoff = DefaultMaxBackoff
+	}
+	if opts.QueueSize <= 0 {
+		opts.QueueSize = DefaultQueueSize
+	}
+	if opts.TimerBuckets == nil {
+		opts.TimerBuckets = DefaultTimerBuckets
+	}
+
+	exporter, err := newExporter(opts)
+	if err != nil {
+		return nil, err
+	}
+
+	resource := appendAttributes(nil, resourceAttributes,
+		encodeAttributes(opts.ResourceAttributes))
+	scope := appendString(nil, scopeName, ScopeName)
+	scope = appendString(scope, scopeVersion, tally.Version)
+
+	return &reporter{
+		exporter:    exporter,
+		resource:    resource,
+		scope:       scope,
+		timerBounds: durationBounds(opts.TimerBuckets),
+		families:    make(map[familyKey]*family),
+		lastFlush:   time.Now(),
+	}, nil
+}
+
+func (r *reporter) Capabilities() tally.Capabilities {
+	return r
+}
+
+func (r *reporter) Reporting() bool {
+	return true
+}
+
+func (r *reporter) Tagging() bool {
+	return true
+}
+
+func (r *reporter) AllocateCounter(
+	name string,
+	tags map[string]string,
+) tally.CachedCount {
+	c := &counter{attrs: encodeAttributes(tags)}
+	r.register(familyKey{name: name, kind: sumKind}, "", c)
+	return c
+}
+
+func (r *reporter) AllocateGauge(
+	name string,
+	tags map[string]string,
+) tally.CachedGauge {
+	g := &gauge{attrs: encodeAttributes(tags)}
+	r.register(familyKey{name: name, kind: gaugeKind}, "", g)
+	return g
+}
+
+func (r *reporter) AllocateTimer(
+	name string,
+	tags map[string]string,
+) tally.CachedTimer {
+	t := newTimer(encodeAttributes(tags), r.timerBounds)
+	r.register(familyKey{name: name, kind: histogramKind}, "s", t)
+	return t
+}
+
+func (r *reporter) AllocateHistogram(
+	name string,
+	tags map[string]string,
+	buckets tally.Buckets,
+) tally.CachedHistogram {
+	var (
+		bounds []float64
+		unit   string
+	)
+	if durations, ok := buckets.(tally.DurationBuckets); ok {
+		bounds = durationBounds(durations)
+		unit = "s"
+	} else {
+		bounds = valueBounds(buckets)
+	}
+
+	h := newHistogram(encodeAttributes(tags), bounds)
+	r.register(familyKey{name: name, kind: histogramKind}, unit, h)
+	return h
+}
+
+func (r *reporter) register(key familyKey, unit string, point dataPoint) {
+	r.Lock()
+	defer r.Unlock()
+
+	f, ok := r.families[key]
+	if !ok {
+		f = &family{unit: unit}
+		r.families[key] = f
+		r.order = append(r.order, key)
+	}
+	f.points = append(f.points, point)
+}
+
+// Flush encodes everything reported since the last flush and queues it
+// for export.
+func (r *reporter) Flush() {
+	r.Lock()
+	if r.closed {
+		r.Unlock()
+		return
+	}
+	req, ok := r.encodeWithLock()
+	r.Unlock()
+
+	if ok {
+		r.exporter.enqueue(req)
+	}
+}
+
+// Close exports any remaining metrics and waits for queued export
+// requests to be sent, without retrying them any further.
+func (r *reporter) Close() error {
+	r.Lock()
+	if r.closed {
+		r.Unlock()
+		return errAlreadyClosed
+	}
+	r.closed = true
+	req, ok := r.encodeWithLock()
+	r.Unlock()
+
+	if ok {
+		r.exporter.enqueue(req)
+	}
+	r.exporter.close()
+	return nil
+}
+
+func (r *reporter) encodeWithLock() ([]byte, bool) {
+	now := time.Now()
+	start := uint64(r.lastFlush.UnixNano())
+	end := uint64(now.UnixNano())
+	r.lastFlush = now
+
+	var (
+		scopeMetrics = appendMessage(nil, scopeMetricsScope, r.scope)
+		empty        = true
+		points       []byte
+	)
+	for _, key := range r.order {
+		f := r.families[key]
+
+		points = points[:0]
+		for _, p := range f.points {
+			var ok bool
+			if points, ok = p.appendPoint(points, start, end); ok {
+				empty = false
+			}
+		}
+		if len(points) == 0 {
+			continue
+		}
+
+		scopeMetrics = appendMessage(scopeMetrics, scopeMetricsMetrics,
+			encodeMetric(key, f.unit, points))
+	}
+	if empty {
+		return nil, false
+	}
+
+	resourceMetrics := appendMessage(nil, resourceMetricsResource, r.resource)
+	resourceMetrics = appendMessage(resourceMetrics, resourceMetricsScopeMetrics, scopeMetrics)
+	return appendMessage(nil, exportRequestResourceMetrics, resourceMetrics), true
+}
+
+func encodeMetric(key familyKey, unit string, points []byte) []byte {
+	metric := appendString(nil, metricName, key.name)
+	if unit != "" {
+		metric = appendString(metric, metricUnit, unit)
+	}
+
+	switch key.kind {
+	case sumKind:
+		data := append([]byte(nil), points...)
+		data = appendVarint(data, dataAggregationTemporality, aggregationTemporalityDelta)
+		data = appendVarint(data, sumIsMonotonic, 1)
+		metric = appendMessage(metric, metricSum, data)
+	case gaugeKind:
+		metric = appendMessage(metric, metricGauge, points)
+	case histogramKind:
+		data := append([]byte(nil), points...)
+		data = appendVarint(data, dataAggregationTemporality, aggregationTemporalityDelta)
+		metric = appendMessage(metric, metricHistogram, data)
+	}
+	return metric
+}
+
+// valueBounds returns the explicit bounds of a histogram, which are the
+// upper bounds of every bucket but the last one as that bucket is
+// implicitly unbounded in OTLP.
+func valueBounds(buckets tally.Buckets) []float64 {
+	pairs := tally.BucketPairs(buckets)
+	bounds := make([]float64, 0, len(pairs))
+	for _, pair := range pairs {
+		if upper := pair.UpperBoundValue(); upper != math.MaxFloat64 {
+			bounds = append(bounds, upper)
+		}
+	}
+	return bounds
+}
+
+// durationBounds is like valueBounds for durations, converted to
+// seconds as is the OpenTelemetry convention.
+func durationBounds(buckets tally.DurationBuckets) []float64 {
+	pairs := tally.BucketPairs(buckets)
+	bounds := make([]float64, 0, len(pairs))
+	for _, pair := range pairs {
+		if upper := pair.UpperBoundDuration(); upper != time.Duration(math.MaxInt64) {
+			bounds = append(bounds, upper.Seconds())
+		}
+	}
+	return bounds
+}
+
+// bucketIndex returns the index of the bucket whose upper bound is the
+// given value, or the unbounded last bucket.
+func bucketIndex(bounds []float64, upper float64) int {
+	return sort.SearchFloat64s(bounds, upper)
+}
+
+type counter struct {
+	attrs [][]byte
+	delta atomic.Int64
+}
+
+func (c *counter) ReportCount(value int64) {
+	c.delta.Add(value)
+}
+
+func (c *counter) appendPoint(b []byte, start, now uint64) ([]byte, bool) {
+	delta := c.delta.Swap(0)
+	if delta == 0 {
+		return b, false
+	}
+
+	point := appendFixed64(nil, numberStartTimeUnixNano, start)
+	point = appendFixed64(point, numberTimeUnixNano, now)
+	point = appendFixed64(point, numberAsInt, uint64(delta))
+	point = appendAttributes(point, numberAttributes, c.attrs)
+	return appendMessage(b, dataDataPoints, point), true
+}
+
+type gauge struct {
+	attrs   [][]byte
+	value   atomic.Float64
+	updated atomic.Bool
+}
+
+func (g *gauge) ReportGauge(value float64) {
+	g.value.Store(value)
+	g.updated.Store(true)
+}
+
+func (g *gauge) appendPoint(b []byte, start, now uint64) ([]byte, bool) {
+	if !g.updated.Swap(false) {
+		return b, false
+	}
+
+	point := appendFixed64(nil, numberTimeUnixNano, now)
+	point = appendDouble(point, numberAsDouble, g.value.Load())
+	point = appendAttributes(point, numberAttributes, g.attrs)
+	return appendMessage(b, dataDataPoints, point), true
+}
+
+type histogram struct {
+	attrs  [][]byte
+	bounds []float64
+	counts []atomic.Uint64
+}
+
+func newHistogram(attrs [][]byte, bounds []float64) *histogram {
+	return &histogram{
+		attrs:  attrs,
+		bounds: bounds,
+		counts: make([]atomic.Uint64, len(bounds)+1),
+	}
+}
+
+func (h *histogram) ValueBucket(
+	bucketLowerBound float64,
+	bucketUpperBound float64,
+) tally.CachedHistogramBucket {
+	return &histogramBucket{
+		count: &h.counts[bucketIndex(h.bounds, bucketUpperBound)],
+	}
+}
+
+func (h *histogram) DurationBucket(
+	bucketLowerBound time.Duration,
+	bucketUpperBound time.Duration,
+) tally.CachedHistogramBucket {
+	idx := len(h.bounds)
+	if bucketUpperBound != time.Duration(math.MaxInt64) {
+		idx = bucketIndex(h.bounds, bucketUpperBound.Seconds())
+	}
+	return &histogramBucket{count: &h.counts[idx]}
+}
+
+func (h *histogram) appendPoint(b []byte, start, now uint64) ([]byte, bool) {
+	var (
+		counts = make([]uint64, len(h.counts))
+		total  uint64
+	)
+	for i := range h.counts {
+		counts[i] = h.counts[i].Swap(0)
+		total += counts[i]
+	}
+	if total == 0 {
+		return b, false
+	}
+
+	point := appendFixed64(nil, histogramStartTimeUnixNano, start)
+	point = appendFixed64(point, histogramTimeUnixNano, now)
+	point = appendFixed64(point, histogramCount, total)
+	point = appendPackedFixed64(point, histogramBucketCounts, counts)
+	point = appendPackedDouble(point, histogramExplicitBounds, h.bounds)
+	point = appendAttributes(point, histogramAttributes, h.attrs)
+	return appendMessage(b, dataDataPoints, point), true
+}
+
+type histogramBucket struct {
+	count *atomic.Uint64
+}
+
+func (b *histogramBucket) ReportSamples(value int64) {
+	b.count.Add(uint64(value))
+}
+
+type timer struct {
+	attrs  [][]byte
+	bounds []float64
+
+	sync.Mutex
+	counts []uint64
+	count  uint64
+	sum    float64
+	min    float64
+	max    float64
+}
+
+func newTimer(attrs [][]byte, bounds []float64) *timer {
+	return &timer{
+		attrs:  attrs,
+		bounds: bounds,
+		counts: make([]uint64, len(bounds)+1),
+	}
+}
+
+func (t *timer) ReportTimer(interval time.Duration) {
+	seconds := interval.Seconds()
+
+	t.Lock()
+	t.counts[bucketIndex(t.bounds, seconds)]++
+	if t.count == 0 || seconds < t.min {
+		t.min = seconds
+	}
+	if t.count == 0 || seconds > t.max {
+		t.max = seconds
+	}
+	t.count++
+	t.sum += seconds
+	t.Unlock()
+}
+
+func (t *timer) appendPoint(b []byte, start, now uint64) ([]byte, bool) {
+	t.Lock()
+	if t.count == 0 {
+		t.Unlock()
+		return b, false
+	}
+
+	point := appendFixed64(nil, histogramStartTimeUnixNano, start)
+	point = appendFixed64(point, histogramTimeUnixNano, now)
+	point = appendFixed64(point, histogramCount, t.count)
+	point = appendDouble(point, histogramSum, t.sum)
+	point = appendPackedFixed64(point, histogramBucketCounts, t.counts)
+	point = appendPackedDouble(point, histogramExplicitBounds, t.bounds)
+	point = appendAttributes(point, histogramAttributes, t.attrs)
+	point = appendDouble(point, histogramMin, t.min)
+	point = appendDouble(point, histogramMax, t.max)
+
+	for i := range t.counts {
+		t.counts[i] = 0
+	}
+	t.count, t.sum, t.min, t.max = 0, 0, 0, 0
+	t.Unlock()
+
+	return appendMessage(b, dataDataPoints, point), true
+}
@@ -0,0 +1,457 @@
+// Copyright (c) 2022 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package otlp
+
+import (
+	"compress/gzip"
+	"io/ioutil"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	tally "github.com/uber-go/tally/v4"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// message is a decoded protobuf message, keeping the raw value of every
+// field as either a []byte or a uint64.
+type message map[protowire.Number][]interface{}
+
+func decode(t *testing.T, b []byte) message {
+	m := make(message)
+	for len(b) > 0 {
+		num, typ, n := protowire.ConsumeTag(b)
+		require.True(t, n > 0, "invalid tag")
+		b = b[n:]
+
+		var v interface{}
+		switch typ {
+		case protowire.VarintType:
+			v, n = protowire.ConsumeVarint(b)
+		case protowire.Fixed64Type:
+			v, n = protowire.ConsumeFixed64(b)
+		case protowire.BytesType:
+			v, n = protowire.ConsumeBytes(b)
+		default:
+			t.Fatalf("unexpected wire type %v", typ)
+		}
+		require.True(t, n > 0, "invalid field %v", num)
+		b = b[n:]
+		m[num] = append(m[num], v)
+	}
+	return m
+}
+
+func (m message) messages(t *testing.T, num protowire.Number) []message {
+	var result []message
+	for _, v := range m[num] {
+		result = append(result, decode(t, v.([]byte)))
+	}
+	return result
+}
+
+func (m message) message(t *testing.T, num protowire.Number) message {
+	msgs := m.messages(t, num)
+	require.Len(t, msgs, 1)
+	return msgs[0]
+}
+
+func (m message) string(num protowire.Number) string {
+	if len(m[num]) == 0 {
+		return ""
+	}
+	return string(m[num][0].([]byte))
+}
+
+func (m message) uint(num protowire.Number) uint64 {
+	if len(m[num]) == 0 {
+		return 0
+	}
+	return m[num][0].(uint64)
+}
+
+func (m message) double(num protowire.Number) float64 {
+	return math.Float64frombits(m.uint(num))
+}
+
+func (m message) packed(t *testing.T, num protowire.Number) []uint64 {
+	var result []uint64
+	for _, v := range m[num] {
+		b := v.([]byte)
+		for len(b) > 0 {
+			value, n := protowire.ConsumeFixed64(b)
+			require.True(t, n > 0)
+			result = append(result, value)
+			b = b[n:]
+		}
+	}
+	return result
+}
+
+func (m message) attributes(t *testing.T, num protowire.Number) map[string]string {
+	result := make(map[string]string)
+	for _, kv := range m.messages(t, num) {
+		result[kv.string(keyValueKey)] = kv.message(t, keyValueValue).string(anyValueString)
+	}
+	return result
+}
+
+// collector is a stand-in for an OpenTelemetry collector.
+type collector struct {
+	t *testing.T
+
+	sync.Mutex
+	requests   []message
+	headers    []http.Header
+	statuses   []int
+	retryAfter string
+}
+
+func newCollector(t *testing.T, statuses ...int) (*collector, *httptest.Server) {
+	c := &collector{t: t, statuses: statuses}
+	return c, httptest.NewServer(c)
+}
+
+func (c *collector) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	c.Lock()
+	defer c.Unlock()
+
+	c.headers = append(c.headers, r.Header)
+	if len(c.statuses) > 0 {
+		status := c.statuses[0]
+		c.statuses = c.statuses[1:]
+		if status != http.StatusOK {
+			if c.retryAfter != "" {
+				w.Header().Set("Retry-After", c.retryAfter)
+			}
+			w.WriteHeader(status)
+			return
+		}
+	}
+
+	body := r.Body
+	if r.Header.Get("Content-Encoding") == "gzip" {
+		gz, err := gzip.NewReader(r.Body)
+		require.NoError(c.t, err)
+		body = gz
+	}
+	b, err := ioutil.ReadAll(body)
+	require.NoError(c.t, err)
+	c.requests = append(c.requests, decode(c.t, b))
+}
+
+// metrics returns every metric received keyed by name.
+func (c *collector) metrics() map[string]message {
+	c.Lock()
+	defer c.Unlock()
+
+	result := make(map[string]message)
+	for _, req := range c.requests {
+		for _, rm := range req.messages(c.t, exportRequestResourceMetrics) {
+			for _, sm := range rm.messages(c.t, resourceMetricsScopeMetrics) {
+				for _, m := range sm.messages(c.t, scopeMetricsMetrics) {
+					result[m.string(metricName)] = m
+				}
+			}
+		}
+	}
+	return result
+}
+
+func TestReporterExportsMetrics(t *testing.T) {
+	c, server := newCollector(t)
+	defer server.Close()
+
+	r, err := NewReporter(Options{
+		Endpoint:           server.URL,
+		Headers:            map[string]string{"Authorization": "Bearer secret"},
+		ResourceAttributes: map[string]string{"service.name": "test"},
+		TimerBuckets:       tally.DurationBuckets{10 * time.Millisecond, time.Second},
+	})
+	require.NoError(t, err)
+
+	scope, closer := tally.NewRootScope(tally.ScopeOptions{
+		CachedReporter: r,
+		Tags:           map[string]string{"env": "test"},
+	}, 0)
+
+	scope.Counter("requests").Inc(3)
+	scope.Gauge("queue").Update(4.5)
+	scope.Timer("latency").Record(5 * time.Millisecond)
+	scope.Timer("latency").Record(100 * time.Millisecond)
+	scope.Histogram("sizes", tally.ValueBuckets{1, 10}).RecordValue(5)
+	scope.Histogram("waits", tally.DurationBuckets{time.Second}).RecordDuration(2 * time.Second)
+	require.NoError(t, closer.Close())
+
+	c.Lock()
+	require.Len(t, c.requests, 1)
+	assert.Equal(t, "Bearer secret", c.headers[0].Get("Authorization"))
+	assert.Equal(t, "application/x-protobuf", c.headers[0].Get("Content-Type"))
+	assert.Equal(t, "gzip", c.headers[0].Get("Content-Encoding"))
+
+	rm := c.requests[0].message(t, exportRequestResourceMetrics)
+	c.Unlock()
+	assert.Equal(t, map[string]string{"service.name": "test"},
+		rm.message(t, resourceMetricsResource).attributes(t, resourceAttributes))
+	instrumentation := rm.message(t, resourceMetricsScopeMetrics).message(t, scopeMetricsScope)
+	assert.Equal(t, ScopeName, instrumentation.string(scopeName))
+	assert.Equal(t, tally.Version, instrumentation.string(scopeVersion))
+
+	metrics := c.metrics()
+	for _, name := range []string{"requests", "queue", "latency", "sizes", "waits"} {
+		require.Contains(t, metrics, name)
+	}
+
+	sum := metrics["requests"].message(t, metricSum)
+	assert.Equal(t, uint64(aggregationTemporalityDelta), sum.uint(dataAggregationTemporality))
+	assert.Equal(t, uint64(1), sum.uint(sumIsMonotonic))
+	point := sum.message(t, dataDataPoints)
+	assert.Equal(t, uint64(3), point.uint(numberAsInt))
+	assert.Equal(t, map[string]string{"env": "test"}, point.attributes(t, numberAttributes))
+	assert.True(t, point.uint(numberStartTimeUnixNano) <= point.uint(numberTimeUnixNano))
+
+	point = metrics["queue"].message(t, metricGauge).message(t, dataDataPoints)
+	assert.Equal(t, 4.5, point.double(numberAsDouble))
+
+	latency := metrics["latency"]
+	assert.Equal(t, "s", latency.string(metricUnit))
+	point = latency.message(t, metricHistogram).message(t, dataDataPoints)
+	assert.Equal(t, uint64(2), point.uint(histogramCount))
+	assert.InDelta(t, 0.105, point.double(histogramSum), 1e-9)
+	assert.InDelta(t, 0.005, point.double(histogramMin), 1e-9)
+	assert.InDelta(t, 0.1, point.double(histogramMax), 1e-9)
+	assert.Equal(t, []uint64{1, 1, 0}, point.packed(t, histogramBucketCounts))
+	assert.Equal(t, []uint64{math.Float64bits(0.01), math.Float64bits(1)},
+		point.packed(t, histogramExplicitBounds))
+
+	point = metrics["sizes"].message(t, metricHistogram).message(t, dataDataPoints)
+	assert.Equal(t, uint64(1), point.uint(histogramCount))
+	assert.Equal(t, []uint64{0, 1, 0}, point.packed(t, histogramBucketCounts))
+	assert.Equal(t, []uint64{math.Float64bits(1), math.Float64bits(10)},
+		point.packed(t, histogramExplicitBounds))
+
+	waits := metrics["waits"]
+	assert.Equal(t, "s", waits.string(metricUnit))
+	point = waits.message(t, metricHistogram).message(t, dataDataPoints)
+	assert.Equal(t, []uint64{0, 1}, point.packed(t, histogramBucketCounts))
+	assert.Equal(t, []uint64{math.Float64bits(1)}, point.packed(t, histogramExplicitBounds))
+}
+
+func TestReporterGroupsSeriesByName(t *testing.T) {
+	c, server := newCollector(t)
+	defer server.Close()
+
+	r, err := NewReporter(Options{Endpoint: server.URL, DisableCompression: true})
+	require.NoError(t, err)
+
+	r.AllocateCounter("requests", map[string]string{"host": "a"}).ReportCount(1)
+	r.AllocateCounter("requests", map[string]string{"host": "b"}).ReportCount(2)
+	r.AllocateCounter("idle", nil)
+	require.NoError(t, r.Close())
+
+	c.Lock()
+	assert.Equal(t, "", c.headers[0].Get("Content-Encoding"))
+	c.Unlock()
+
+	metrics := c.metrics()
+	require.Len(t, metrics, 1)
+	points := metrics["requests"].message(t, metricSum).messages(t, dataDataPoints)
+	require.Len(t, points, 2)
+	assert.Equal(t, map[string]string{"host": "a"}, points[0].attributes(t, numberAttributes))
+	assert.Equal(t, uint64(1), points[0].uint(numberAsInt))
+	assert.Equal(t, map[string]string{"host": "b"}, points[1].attributes(t, numberAttributes))
+	assert.Equal(t, uint64(2), points[1].uint(numberAsInt))
+}
+
+func TestReporterReportsDeltas(t *testing.T) {
+	c, server := newCollector(t)
+	defer server.Close()
+
+	r, err := NewReporter(Options{Endpoint: server.URL})
+	require.NoError(t, err)
+
+	counter := r.AllocateCounter("requests", nil)
+	counter.ReportCount(2)
+	r.Flush()
+	r.Flush()
+	counter.ReportCount(5)
+	require.NoError(t, r.Close())
+
+	c.Lock()
+	defer c.Unlock()
+	require.Len(t, c.requests, 2)
+	var values []uint64
+	for _, req := range c.requests {
+		point := req.message(t, exportRequestResourceMetrics).
+			message(t, resourceMetricsScopeMetrics).
+			message(t, scopeMetricsMetrics).
+			message(t, metricSum).
+			message(t, dataDataPoints)
+		values = append(values, point.uint(numberAsInt))
+	}
+	assert.Equal(t, []uint64{2, 5}, values)
+}
+
+func TestReporterRetries(t *testing.T) {
+	c, server := newCollector(t, http.StatusServiceUnavailable, http.StatusTooManyRequests)
+	defer server.Close()
+
+	r, err := NewReporter(Options{
+		Endpoint:       server.URL,
+		InitialBackoff: time.Millisecond,
+	})
+	require.NoError(t, err)
+
+	r.AllocateGauge("queue", nil).ReportGauge(1)
+	r.Flush()
+	require.Eventually(t, func() bool {
+		c.Lock()
+		defer c.Unlock()
+		return len(c.requests) == 1
+	}, time.Second, time.Millisecond)
+	require.NoError(t, r.Close())
+
+	c.Lock()
+	defer c.Unlock()
+	assert.Len(t, c.headers, 3)
+}
+
+func TestReporterDoesNotRetryClientErrors(t *testing.T) {
+	c, server := newCollector(t, http.StatusBadRequest)
+	defer server.Close()
+
+	var errs []error
+	r, err := NewReporter(Options{
+		Endpoint:       server.URL,
+		InitialBackoff: time.Millisecond,
+		OnError: func(err error) {
+			errs = append(errs, err)
+		},
+	})
+	require.NoError(t, err)
+
+	r.AllocateGauge("queue", nil).ReportGauge(1)
+	require.NoError(t, r.Close())
+	assert.Error(t, r.Close())
+
+	c.Lock()
+	defer c.Unlock()
+	assert.Len(t, c.headers, 1)
+	assert.Len(t, c.requests, 0)
+	require.Len(t, errs, 1)
+	assert.Contains(t, errs[0].Error(), "400")
+}
+
+func TestReporterGivesUpAfterMaxRetries(t *testing.T) {
+	c, server := newCollector(t, http.StatusBadGateway, http.StatusBadGateway)
+	defer server.Close()
+
+	var errs []error
+	r, err := NewReporter(Options{
+		Endpoint:       server.URL,
+		MaxRetries:     1,
+		InitialBackoff: time.Millisecond,
+		OnError: func(err error) {
+			errs = append(errs, err)
+		},
+	})
+	require.NoError(t, err)
+
+	r.AllocateGauge("queue", nil).ReportGauge(1)
+	r.Flush()
+	require.Eventually(t, func() bool {
+		c.Lock()
+		defer c.Unlock()
+		return len(c.headers) == 2
+	}, time.Second, time.Millisecond)
+	require.NoError(t, r.Close())
+
+	c.Lock()
+	defer c.Unlock()
+	assert.Len(t, c.headers, 2)
+	require.Len(t, errs, 1)
+}
+
+func TestReporterCapsRetryAfter(t *testing.T) {
+	c, server := newCollector(t, http.StatusServiceUnavailable)
+	c.retryAfter = "3600"
+	defer server.Close()
+
+	r, err := NewReporter(Options{
+		Endpoint:   server.URL,
+		MaxBackoff: time.Millisecond,
+	})
+	require.NoError(t, err)
+	defer r.Close()
+
+	r.AllocateGauge("queue", nil).ReportGauge(1)
+	r.Flush()
+	require.Eventually(t, func() bool {
+		c.Lock()
+		defer c.Unlock()
+		return len(c.requests) == 1
+	}, time.Second, time.Millisecond)
+}
+
+func TestReporterCloseInterruptsRetries(t *testing.T) {
+	c, server := newCollector(t, http.StatusServiceUnavailable)
+	c.retryAfter = "3600"
+	defer server.Close()
+
+	r, err := NewReporter(Options{
+		Endpoint:   server.URL,
+		MaxBackoff: time.Hour,
+	})
+	require.NoError(t, err)
+
+	r.AllocateGauge("queue", nil).ReportGauge(1)
+	r.Flush()
+	require.Eventually(t, func() bool {
+		c.Lock()
+		defer c.Unlock()
+		return len(c.headers) == 1
+	}, time.Second, time.Millisecond)
+
+	closed := make(chan error)
+	go func() { closed <- r.Close() }()
+	select {
+	case err := <-closed:
+		require.NoError(t, err)
+	case <-time.After(time.Second):
+		t.Fatal("close waited for the retry after the server asked for")
+	}
+}
+
+func TestReporterInvalidEndpoint(t *testing.T) {
+	_, err := NewReporter(Options{Endpoint: "://"})
+	assert.Error(t, err)
+}
+
+func TestRetryAfter(t *testing.T) {
+	assert.Equal(t, 2*time.Second, retryAfter("2"))
+	assert.Equal(t, time.Duration(0), retryAfter(""))
+	assert.Equal(t, time.Duration(0), retryAfter("Wed, 21 Oct 2015 07:28:00 GMT"))
+}
@@ -21,13 +21,18 @@
 package prometheus
 
 import (
+	"crypto/tls"
 	"fmt"
 	"log"
 	"net"
 	"net/http"
 	"os"
+	"path"
+	"regexp"
 	"strings"
+	"time"
 
+	"github.com/pkg/errors"
 	prom "github.com/prometheus/client_golang/prometheus"
 )
 
@@ -41,10 +46,35 @@ type Configuration struct {
 	// Supported networks: tcp, tcp4, tcp6 and unix.
 	ListenNetwork string `yaml:"listenNetwork"`
 
-	// ListenAddress if specified will be used instead of just registering the
-	// handler on the default HTTP serve mux without listening.
+	// ListenAddress if specified is the address the reporter serves the
+	// metrics on, until it is closed. Otherwise the handlers are just
+	// registered on the default HTTP serve mux without listening.
 	ListenAddress string `yaml:"listenAddress"`
 
+	// TLS if specified serves the metrics over TLS.
+	TLS *TLSConfiguration `yaml:"tls"`
+
+	// BasicAuth if specified requires scrapes to authenticate with basic
+	// auth, it cannot be used with a bearer token.
+	BasicAuth *BasicAuthConfiguration `yaml:"basicAuth"`
+
+	// BearerToken if specified requires scrapes to authenticate with this
+	// bearer token.
+	BearerToken string `yaml:"bearerToken"`
+
+	// ReadTimeout and WriteTimeout if specified are the read and write
+	// timeouts of the server.
+	ReadTimeout  time.Duration `yaml:"readTimeout"`
+	WriteTimeout time.Duration `yaml:"writeTimeout"`
+
+	// DisableCompression if true does not gzip the responses of the
+	// server to scrapers that accept gzip, which are gzipped by default.
+	DisableCompression bool `yaml:"disableCompression"`
+
+	// HealthCheck if true serves an unauthenticated health check handler
+	// on the path "/healthz".
+	HealthCheck bool `yaml:"healthCheck"`
+
 	// TimerType is the default Prometheus type to use for Tally timers.
 	TimerType string `yaml:"timerType"`
 
@@ -60,8 +90,171 @@ type Configuration struct {
 	// on the specified listen address or registering a metric with the
 	// Prometheus. By default the registerer will panic.
 	OnError string `yaml:"onError"`
+
+	// OpenMetrics if true serves the OpenMetrics exposition format to
+	// scrapers that accept it.
+	OpenMetrics bool `yaml:"openMetrics"`
+
+	// NamingConventions if true suffixes counters with _total, and timers
+	// and duration histograms with _seconds.
+	NamingConventions bool `yaml:"namingConventions"`
+
+	// NativeHistogramBucketFactor if greater than one backs histogram
+	// timers with Prometheus native histograms with this bucket factor.
+	NativeHistogramBucketFactor float64 `yaml:"nativeHistogramBucketFactor"`
+
+	// NativeHistogramMaxBucketNumber if specified is the max number of
+	// buckets of native histograms.
+	NativeHistogramMaxBucketNumber uint32 `yaml:"nativeHistogramMaxBucketNumber"`
+
+	// NativeHistogramClassicBuckets if true keeps the classic buckets of
+	// native histograms.
+	NativeHistogramClassicBuckets bool `yaml:"nativeHistogramClassicBuckets"`
+
+	// UnionTagKeys if true exposes metrics of the same name with different
+	// sets of tag keys as a single metric with the union of the tag keys,
+	// rather than failing to register them.
+	UnionTagKeys bool `yaml:"unionTagKeys"`
+
+	// StaleTimeout if specified stops exposing series that were not
+	// reported for longer than the timeout.
+	StaleTimeout time.Duration `yaml:"staleTimeout"`
+
+	// Rules if specified override the timer type, buckets, objectives and
+	// help text of the timers and histograms matching them, the first
+	// matching rule applies.
+	Rules []RuleConfiguration `yaml:"rules"`
+
+	// Push if specified will push the metrics to a Pushgateway on flush
+	// and close, for batch jobs that cannot be scraped. If no listen
+	// address is specified the handler is then not registered.
+	Push *PushConfiguration `yaml:"push"`
+}
+
+// RuleConfiguration is a configuration of the timers and histograms
+// whose tally name matches, either with a glob or a regexp.
+type RuleConfiguration struct {
+	// Match is a glob pattern, with the syntax of path.Match, matching
+	// the tally metric names.
+	Match string `yaml:"match"`
+
+	// Regexp is a regular expression matching the whole tally metric
+	// names.
+	Regexp string `yaml:"regexp"`
+
+	// TimerType if specified is the Prometheus type of matching timers,
+	// by default the configured timer type.
+	TimerType string `yaml:"timerType"`
+
+	// HistogramBuckets if specified are the buckets of matching
+	// histogram timers.
+	HistogramBuckets []HistogramObjective `yaml:"histogramBuckets"`
+
+	// SummaryObjectives if specified are the objectives of matching
+	// summary timers.
+	SummaryObjectives []SummaryObjective `yaml:"summaryObjectives"`
+
+	// SummaryMaxAge if specified is the max age of the observations of
+	// matching summary timers.
+	SummaryMaxAge time.Duration `yaml:"summaryMaxAge"`
+
+	// Help if specified is the help text of matching timers and
+	// histograms.
+	Help string `yaml:"help"`
+}
+
+func (c RuleConfiguration) newRule(defaultTimerType TimerType) (Rule, error) {
+	rule := Rule{Help: c.Help}
+
+	switch {
+	case c.Match != "" && c.Regexp == "":
+		pattern := c.Match
+		if _, err := path.Match(pattern, ""); err != nil {
+			return Rule{}, errors.WithMessagef(err, "invalid rule match %q", pattern)
+		}
+		rule.Match = func(name string) bool {
+			matched, _ := path.Match(pattern, name)
+			return matched
+		}
+	case c.Regexp != "" && c.Match == "":
+		re, err := regexp.Compile("^(?:" + c.Regexp + ")$")
+		if err != nil {
+			return Rule{}, errors.WithMessagef(err, "invalid rule regexp %q", c.Regexp)
+		}
+		rule.Match = re.MatchString
+	default:
+		return Rule{}, errInvalidRuleConfiguration
+	}
+
+	if c.TimerType == "" && len(c.HistogramBuckets) == 0 &&
+		len(c.SummaryObjectives) == 0 && c.SummaryMaxAge == 0 {
+		return rule, nil
+	}
+
+	timerType := defaultTimerType
+	switch c.TimerType {
+	case "":
+	case "summary":
+		timerType = SummaryTimerType
+	case "histogram":
+		timerType = HistogramTimerType
+	default:
+		return Rule{}, errors.Errorf("unknown rule timer type %q", c.TimerType)
+	}
+	rule.TimerOptions = &RegisterTimerOptions{
+		TimerType:         timerType,
+		HistogramBuckets:  histogramBuckets(c.HistogramBuckets),
+		SummaryObjectives: summaryObjectives(c.SummaryObjectives),
+		SummaryMaxAge:     c.SummaryMaxAge,
+	}
+	return rule, nil
+}
+
+// TLSConfiguration is a configuration for serving metrics over TLS.
+type TLSConfiguration struct {
+	// CertFile and KeyFile are the paths of the PEM encoded certificate
+	// and key, reloaded when either is modified.
+	CertFile string `yaml:"certFile" validate:"nonzero"`
+	KeyFile  string `yaml:"keyFile" validate:"nonzero"`
+}
+
+// BasicAuthConfiguration is a configuration for basic auth.
+type BasicAuthConfiguration struct {
+	Username string `yaml:"username" validate:"nonzero"`
+	Password string `yaml:"password"`
+}
+
+// PushConfiguration is a configuration for pushing to a Pushgateway.
+type PushConfiguration struct {
+	// URL is the Pushgateway URL, without the /metrics/job/... part.
+	URL string `yaml:"url" validate:"nonzero"`
+
+	// Job is the job label of the pushed group.
+	Job string `yaml:"job" validate:"nonzero"`
+
+	// Grouping are the grouping key labels of the pushed group besides
+	// the job, these should be the root scope tags.
+	Grouping map[string]string `yaml:"grouping"`
+
+	// Interval is the minimum time between pushes on flush, by default
+	// every flush pushes.
+	Interval time.Duration `yaml:"interval"`
+
+	// Username and Password if specified are used for basic auth.
+	Username string `yaml:"username"`
+	Password string `yaml:"password"`
+
+	// DeleteOnClose deletes the pushed group on close instead of pushing.
+	DeleteOnClose bool `yaml:"deleteOnClose"`
 }
 
+var (
+	errInvalidPushConfiguration = errors.New("push requires a url and a job")
+	errInvalidTLSConfiguration  = errors.New("tls requires a cert file and a key file")
+	errInvalidAuthConfiguration = errors.New("basic auth and bearer token are mutually exclusive")
+	errInvalidRuleConfiguration = errors.New("rule requires either a match or a regexp")
+)
+
 // HistogramObjective is a Prometheus histogram bucket.
 // See: https://godoc.org/github.com/prometheus/client_golang/prometheus#HistogramOpts
 type HistogramObjective struct {
@@ -75,6 +268,28 @@ type SummaryObjective struct {
 	AllowedError float64 `yaml:"allowedError"`
 }
 
+func histogramBuckets(objectives []HistogramObjective) []float64 {
+	if len(objectives) == 0 {
+		return nil
+	}
+	values := make([]float64, 0, len(objectives))
+	for _, value := range objectives {
+		values = append(values, value.Upper)
+	}
+	return values
+}
+
+func summaryObjectives(objectives []SummaryObjective) map[float64]float64 {
+	if len(objectives) == 0 {
+		return nil
+	}
+	values := make(map[float64]float64, len(objectives))
+	for _, value := range objectives {
+		values[value.Percentile] = value.AllowedError
+	}
+	return values
+}
+
 // ConfigurationOptions allows some programatic options, such as using a
 // specific registry and what error callback to register.
 type ConfigurationOptions struct {
@@ -124,53 +339,115 @@ func (c Configuration) NewReporter(
 		opts.DefaultTimerType = HistogramTimerType
 	}
 
-	if len(c.DefaultHistogramBuckets) > 0 {
-		var values []float64
-		for _, value := range c.DefaultHistogramBuckets {
-			values = append(values, value.Upper)
+	opts.DefaultHistogramBuckets = histogramBuckets(c.DefaultHistogramBuckets)
+	opts.DefaultSummaryObjectives = summaryObjectives(c.DefaultSummaryObjectives)
+
+	for _, rule := range c.Rules {
+		r, err := rule.newRule(opts.DefaultTimerType)
+		if err != nil {
+			return nil, err
 		}
-		opts.DefaultHistogramBuckets = values
+		opts.Rules = append(opts.Rules, r)
 	}
 
-	if len(c.DefaultSummaryObjectives) > 0 {
-		values := make(map[float64]float64)
-		for _, value := range c.DefaultSummaryObjectives {
-			values[value.Percentile] = value.AllowedError
+	opts.EnableOpenMetrics = c.OpenMetrics
+	opts.DisableCompression = c.DisableCompression
+	opts.NamingConventions = c.NamingConventions
+	opts.NativeHistogramBucketFactor = c.NativeHistogramBucketFactor
+	opts.NativeHistogramMaxBucketNumber = c.NativeHistogramMaxBucketNumber
+	opts.NativeHistogramClassicBuckets = c.NativeHistogramClassicBuckets
+	opts.UnionTagKeys = c.UnionTagKeys
+	opts.StaleTimeout = c.StaleTimeout
+
+	if push := c.Push; push != nil {
+		if push.URL == "" || push.Job == "" {
+			return nil, errInvalidPushConfiguration
+		}
+		opts.Push = &PushOptions{
+			URL:           push.URL,
+			Job:           push.Job,
+			Grouping:      push.Grouping,
+			Interval:      push.Interval,
+			Username:      push.Username,
+			Password:      push.Password,
+			DeleteOnClose: push.DeleteOnClose,
+		}
+		// Only use an explicitly configured error handler for push errors,
+		// as by default registration errors panic.
+		if configOpts.OnError != nil || c.OnError != "" {
+			opts.Push.OnError = opts.OnRegisterError
 		}
-		opts.DefaultSummaryObjectives = values
 	}
 
-	reporter := NewReporter(opts)
+	if c.BasicAuth != nil && c.BearerToken != "" {
+		return nil, errInvalidAuthConfiguration
+	}
 
-	path := "/metrics"
-	if handlerPath := strings.TrimSpace(c.HandlerPath); handlerPath != "" {
-		path = handlerPath
+	addr := strings.TrimSpace(c.ListenAddress)
+	if addr == "" {
+		r := NewReporter(opts)
+		c.registerHandlers(http.DefaultServeMux, r)
+		return r, nil
 	}
 
-	if addr := strings.TrimSpace(c.ListenAddress); addr == "" {
-		http.Handle(path, reporter.HTTPHandler())
-	} else {
-		mux := http.NewServeMux()
-		mux.Handle(path, reporter.HTTPHandler())
-		go func() {
-			network := c.ListenNetwork
-			if network == "" {
-				network = "tcp"
-			}
+	var tlsConfig *tls.Config
+	if c.TLS != nil {
+		if c.TLS.CertFile == "" || c.TLS.KeyFile == "" {
+			return nil, errInvalidTLSConfiguration
+		}
+		reloader, err := newCertReloader(c.TLS.CertFile, c.TLS.KeyFile)
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig = reloader.tlsConfig()
+	}
 
-			listener, err := net.Listen(network, addr)
-			if err != nil {
-				opts.OnRegisterError(err)
-				return
-			}
+	listener, err := c.listen(addr)
+	if err != nil {
+		return nil, err
+	}
 
-			defer listener.Close()
+	r := NewReporter(opts)
 
-			if err = http.Serve(listener, mux); err != nil {
-				opts.OnRegisterError(err)
-			}
-		}()
+	server := newServer(c.handler(r), listener, c.ReadTimeout, c.WriteTimeout,
+		tlsConfig, opts.OnRegisterError)
+	r.(*reporter).server = server
+	go server.serve()
+
+	return r, nil
+}
+
+func (c Configuration) listen(addr string) (net.Listener, error) {
+	network := c.ListenNetwork
+	if network == "" {
+		network = "tcp"
 	}
+	return net.Listen(network, addr)
+}
+
+// handler returns the handler of the server, serving the metrics and
+// the health check.
+func (c Configuration) handler(r Reporter) http.Handler {
+	mux := http.NewServeMux()
+	c.registerHandlers(mux, r)
+	return mux
+}
 
-	return reporter, nil
+func (c Configuration) registerHandlers(mux *http.ServeMux, r Reporter) {
+	var handler http.Handler = r.HTTPHandler()
+	if c.BasicAuth != nil {
+		handler = basicAuthHandler(c.BasicAuth.Username, c.BasicAuth.Password, handler)
+	} else if c.BearerToken != "" {
+		handler = bearerTokenHandler(c.BearerToken, handler)
+	}
+
+	path := "/metrics"
+	if handlerPath := strings.TrimSpace(c.HandlerPath); handlerPath != "" {
+		path = handlerPath
+	}
+
+	mux.Handle(path, handler)
+	if c.HealthCheck {
+		mux.Handle(DefaultHealthCheckPath, healthCheckHandler())
+	}
 }
@@ -21,32 +21,33 @@
 package prometheus
 
 import (
+	"io"
 	"io/ioutil"
 	"net"
+	"net/http"
+	"net/http/httptest"
 	"os"
 	"path"
 	"testing"
 	"time"
 
+	prom "github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+	tally "github.com/uber-go/tally/v4"
 )
 
-func TestListenErrorCallsOnRegisterError(t *testing.T) {
-	// Ensure that Listen error calls default OnRegisterError to panic
+func TestListenErrorReturned(t *testing.T) {
 	listener, err := net.Listen("tcp", "127.0.0.1:0")
 	require.NoError(t, err)
 
 	defer func() { _ = listener.Close() }()
 
-	assert.NotPanics(t, func() {
-		cfg := Configuration{
-			ListenAddress: listener.Addr().String(),
-			OnError:       "log",
-		}
-		_, _ = cfg.NewReporter(ConfigurationOptions{})
-		time.Sleep(time.Second)
-	})
+	cfg := Configuration{ListenAddress: listener.Addr().String()}
+	r, err := cfg.NewReporter(ConfigurationOptions{Registry: prom.NewRegistry()})
+	assert.Error(t, err)
+	assert.Nil(t, r)
 }
 
 func TestUnixDomainSocketListener(t *testing.T) {
@@ -81,3 +82,145 @@ func TestTcpListener(t *testing.T) {
 		})
 	}
 }
+
+func TestDefaultServeMuxRegistration(t *testing.T) {
+	cfg := Configuration{HandlerPath: "/default-mux-metrics"}
+	r, err := cfg.NewReporter(ConfigurationOptions{Registry: prom.NewRegistry()})
+	require.NoError(t, err)
+	r.AllocateCounter("requests", nil).ReportCount(1)
+
+	rec := httptest.NewRecorder()
+	http.DefaultServeMux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/default-mux-metrics", nil))
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Contains(t, rec.Body.String(), "requests 1")
+}
+
+func TestPushConfiguration(t *testing.T) {
+	gateway := &pushgateway{}
+	server := httptest.NewServer(gateway)
+	defer server.Close()
+
+	cfg := Configuration{
+		HandlerPath: "/push-metrics",
+		Push: &PushConfiguration{
+			URL:      server.URL,
+			Job:      "backfill",
+			Grouping: map[string]string{"env": "test"},
+		},
+	}
+	r, err := cfg.NewReporter(ConfigurationOptions{Registry: prom.NewRegistry()})
+	require.NoError(t, err)
+
+	r.Flush()
+	require.NoError(t, r.(io.Closer).Close())
+	pushes := gateway.pushes()
+	require.Len(t, pushes, 2)
+	assert.Equal(t, "/metrics/job/backfill/env/test", pushes[0].path)
+
+	cfg.Push.Job = ""
+	_, err = cfg.NewReporter(ConfigurationOptions{Registry: prom.NewRegistry()})
+	assert.Equal(t, errInvalidPushConfiguration, err)
+}
+
+func TestRulesConfiguration(t *testing.T) {
+	registry := prom.NewRegistry()
+	cfg := Configuration{
+		HandlerPath: "/rules-metrics",
+		TimerType:   "histogram",
+		Rules: []RuleConfiguration{
+			{
+				Match:             "rpc_*_latency",
+				TimerType:         "summary",
+				SummaryObjectives: []SummaryObjective{{Percentile: 0.9, AllowedError: 0.01}},
+				SummaryMaxAge:     time.Minute,
+				Help:              "RPC latency",
+			},
+			{
+				Regexp:           "db_(read|write)",
+				HistogramBuckets: []HistogramObjective{{Upper: 0.1}, {Upper: 1}},
+			},
+			{
+				Match: "payload_*",
+				Help:  "Payload size",
+			},
+		},
+	}
+	r, err := cfg.NewReporter(ConfigurationOptions{Registry: registry})
+	require.NoError(t, err)
+
+	r.AllocateTimer("rpc_get_latency", nil).ReportTimer(time.Second)
+	r.AllocateTimer("db_read", nil).ReportTimer(time.Second)
+	r.AllocateTimer("db_reads", nil).ReportTimer(time.Second)
+	r.AllocateHistogram("payload_bytes", nil, tally.ValueBuckets{1}).
+		ValueBucket(0, 1).ReportSamples(1)
+
+	families := make(map[string]*dto.MetricFamily)
+	for _, family := range gather(t, registry) {
+		families[family.GetName()] = family
+	}
+
+	rpc := families["rpc_get_latency"]
+	require.NotNil(t, rpc)
+	assert.Equal(t, dto.MetricType_SUMMARY, rpc.GetType())
+	assert.Equal(t, "RPC latency", rpc.GetHelp())
+	require.Len(t, rpc.GetMetric()[0].GetSummary().GetQuantile(), 1)
+	assert.Equal(t, 0.9, rpc.GetMetric()[0].GetSummary().GetQuantile()[0].GetQuantile())
+
+	db := families["db_read"]
+	require.NotNil(t, db)
+	assert.Equal(t, dto.MetricType_HISTOGRAM, db.GetType())
+	assert.Len(t, db.GetMetric()[0].GetHistogram().GetBucket(), 2)
+
+	// The regexp matches whole names, the default buckets apply.
+	dbs := families["db_reads"]
+	require.NotNil(t, dbs)
+	assert.Len(t, dbs.GetMetric()[0].GetHistogram().GetBucket(),
+		len(DefaultHistogramBuckets()))
+
+	payload := families["payload_bytes"]
+	require.NotNil(t, payload)
+	assert.Equal(t, "Payload size", payload.GetHelp())
+}
+
+func TestRulesConfigurationErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		rule RuleConfiguration
+		err  string
+	}{
+		{
+			name: "no match",
+			rule: RuleConfiguration{Help: "help"},
+			err:  errInvalidRuleConfiguration.Error(),
+		},
+		{
+			name: "match and regexp",
+			rule: RuleConfiguration{Match: "a", Regexp: "a"},
+			err:  errInvalidRuleConfiguration.Error(),
+		},
+		{
+			name: "invalid match",
+			rule: RuleConfiguration{Match: "[a"},
+			err:  "invalid rule match",
+		},
+		{
+			name: "invalid regexp",
+			rule: RuleConfiguration{Regexp: "(a"},
+			err:  "invalid rule regexp",
+		},
+		{
+			name: "unknown timer type",
+			rule: RuleConfiguration{Match: "a", TimerType: "gauge"},
+			err:  "unknown rule timer type",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := Configuration{Rules: []RuleConfiguration{tt.rule}}
+			_, err := cfg.NewReporter(ConfigurationOptions{Registry: prom.NewRegistry()})
+			require.Error(t, err)
+			assert.Contains(t, err.Error(), tt.err)
+		})
+	}
+}
@@ -0,0 +1,242 @@
+// Copyright (c) 2022 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package prometheus
+
+import (
+	"math"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	prom "github.com/prometheus/client_golang/prometheus"
+	tally "github.com/uber-go/tally/v4"
+	"go.uber.org/atomic"
+)
+
+// bucketHistogramVec is a collector of tally histograms, which are bucketed by
+// tally before being reported. It keeps the count of samples of each
+// bucket and exposes them as const histograms, so that reporting the
+// samples of a bucket is a single addition rather than an observation
+// per sample.
+type bucketHistogramVec struct {
+	reporter    *reporter
+	desc        *prom.Desc
+	tagKeys     []string
+	upperBounds []float64
+
+	sync.RWMutex
+	series map[string]*histogramSeries
+}
+
+func newBucketHistogramVec(
+	reporter *reporter,
+	name string,
+	desc string,
+	tagKeys []string,
+	buckets tally.Buckets,
+) *bucketHistogramVec {
+	var upperBounds []float64
+	switch b := buckets.(type) {
+	case tally.DurationBuckets:
+		for _, bound := range b.AsDurations() {
+			upperBounds = append(upperBounds, float64(bound)/float64(time.Second))
+		}
+	default:
+		upperBounds = buckets.AsValues()
+	}
+	sort.Float64s(upperBounds)
+
+	return &bucketHistogramVec{
+		reporter:    reporter,
+		desc:        prom.NewDesc(name, desc, tagKeys, nil),
+		tagKeys:     tagKeys,
+		upperBounds: upperBounds,
+		series:      make(map[string]*histogramSeries),
+	}
+}
+
+// with returns the series of the tag values, creating it if needed.
+func (v *bucketHistogramVec) with(tags map[string]string) *histogramSeries {
+	labelValues := make([]string, len(v.tagKeys))
+	for i, key := range v.tagKeys {
+		labelValues[i] = tags[key]
+	}
+	key := strings.Join(labelValues, "\xff")
+
+	v.RLock()
+	series, ok := v.series[key]
+	v.RUnlock()
+	if ok {
+		return series
+	}
+
+	v.Lock()
+	defer v.Unlock()
+
+	if series, ok := v.series[key]; ok {
+		return series
+	}
+	series = &histogramSeries{
+		vec:         v,
+		labelValues: labelValues,
+		counts:      make([]atomic.Uint64, len(v.upperBounds)),
+		created:     time.Now(),
+	}
+	series.series = v.reporter.newSeries(
+		func() { v.delete(key) },
+		func() { v.set(key, series) },
+	)
+	v.series[key] = series
+	return series
+}
+
+func (v *bucketHistogramVec) set(key string, series *histogramSeries) {
+	v.Lock()
+	v.series[key] = series
+	v.Unlock()
+}
+
+func (v *bucketHistogramVec) delete(key string) {
+	v.Lock()
+	delete(v.series, key)
+	v.Unlock()
+}
+
+func (v *bucketHistogramVec) Describe(ch chan<- *prom.Desc) {
+	ch <- v.desc
+}
+
+func (v *bucketHistogramVec) Collect(ch chan<- prom.Metric) {
+	v.RLock()
+	series := make([]*histogramSeries, 0, len(v.series))
+	for _, s := range v.series {
+		series = append(series, s)
+	}
+	v.RUnlock()
+
+	for _, s := range series {
+		ch <- s.metric()
+	}
+}
+
+// histogramSeries is the series of a histogram for a set of tag values.
+type histogramSeries struct {
+	vec         *bucketHistogramVec
+	labelValues []string
+	counts      []atomic.Uint64
+	count       atomic.Uint64
+	sum         atomic.Float64
+	created     time.Time
+	series      *series
+}
+
+// Release implements tally.CachedMetricReleaser.
+func (s *histogramSeries) Release() {
+	s.series.Release()
+}
+
+// bucket returns the bucket with the upper bound, the samples of the
+// bucket above the last upper bound are only added to the count.
+func (s *histogramSeries) bucket(lowerBound, upperBound float64) cachedHistogramBucket {
+	idx := sort.SearchFloat64s(s.vec.upperBounds, upperBound)
+	if idx < len(s.vec.upperBounds) && s.vec.upperBounds[idx] != upperBound {
+		idx = len(s.vec.upperBounds)
+	}
+
+	// The sum is estimated with the upper bound of the bucket of the
+	// samples, or the lower bound for the bucket without one.
+	value := upperBound
+	if upperBound == math.MaxFloat64 {
+		value = lowerBound
+		if lowerBound == -math.MaxFloat64 {
+			value = 0
+		}
+	}
+	return cachedHistogramBucket{series: s, idx: idx, value: value}
+}
+
+func (s *histogramSeries) metric() prom.Metric {
+	buckets := make(map[float64]uint64, len(s.counts))
+	var cumulative uint64
+	for i := range s.counts {
+		cumulative += s.counts[i].Load()
+		buckets[s.vec.upperBounds[i]] = cumulative
+	}
+
+	// The count is loaded after the buckets, and added before them by
+	// ReportSamples, so that it is never less than the count of the last
+	// bucket while samples are being reported.
+	metric, err := prom.NewConstHistogramWithCreatedTimestamp(s.vec.desc,
+		s.count.Load(), s.sum.Load(), buckets, s.created, s.labelValues...)
+	if err != nil {
+		return prom.NewInvalidMetric(s.vec.desc, err)
+	}
+	return metric
+}
+
+// ValueBucket implements tally.CachedHistogram.
+func (s *histogramSeries) ValueBucket(
+	bucketLowerBound, bucketUpperBound float64,
+) tally.CachedHistogramBucket {
+	return s.bucket(bucketLowerBound, bucketUpperBound)
+}
+
+// DurationBucket implements tally.CachedHistogram.
+func (s *histogramSeries) DurationBucket(
+	bucketLowerBound, bucketUpperBound time.Duration,
+) tally.CachedHistogramBucket {
+	return s.bucket(durationBound(bucketLowerBound), durationBound(bucketUpperBound))
+}
+
+// durationBound returns a duration bucket bound in seconds, with the
+// unbounded durations as the unbounded values.
+func durationBound(bound time.Duration) float64 {
+	switch bound {
+	case time.Duration(math.MaxInt64):
+		return math.MaxFloat64
+	case time.Duration(math.MinInt64):
+		return -math.MaxFloat64
+	}
+	return float64(bound) / float64(time.Second)
+}
+
+type cachedHistogramBucket struct {
+	series *histogramSeries
+	idx    int
+	value  float64
+}
+
+func (b cachedHistogramBucket) ReportSamples(value int64) {
+	if value <= 0 {
+		return
+	}
+
+	b.series.series.report()
+
+	// The count is added before the bucket as metric loads it after the
+	// buckets, so that it is never less than the count of the last bucket.
+	b.series.count.Add(uint64(value))
+	b.series.sum.Add(float64(value) * b.value)
+	if b.idx < len(b.series.counts) {
+		b.series.counts[b.idx].Add(uint64(value))
+	}
+}
@@ -0,0 +1,143 @@
+// Copyright (c) 2022 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package prometheus
+
+import (
+	"math"
+	"sync"
+	"testing"
+	"time"
+
+	prom "github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	tally "github.com/uber-go/tally/v4"
+)
+
+func TestHistogramOverflowBucket(t *testing.T) {
+	registry := prom.NewRegistry()
+	r := NewReporter(Options{Registerer: registry})
+
+	histogram := r.AllocateHistogram("test_histogram", nil, tally.ValueBuckets{1, 10})
+	histogram.ValueBucket(-math.MaxFloat64, 1).ReportSamples(2)
+	histogram.ValueBucket(1, 10).ReportSamples(3)
+	histogram.ValueBucket(10, math.MaxFloat64).ReportSamples(4)
+
+	metrics := gather(t, registry)
+	require.Len(t, metrics, 1)
+	h := metrics[0].GetMetric()[0].GetHistogram()
+
+	// The samples above the last bucket are only counted in the count,
+	// and estimated with the last bucket bound in the sum.
+	assert.Equal(t, uint64(9), h.GetSampleCount())
+	assert.Equal(t, float64(2*1+3*10+4*10), h.GetSampleSum())
+	require.Len(t, h.GetBucket(), 2)
+	assert.Equal(t, uint64(2), h.GetBucket()[0].GetCumulativeCount())
+	assert.Equal(t, uint64(5), h.GetBucket()[1].GetCumulativeCount())
+	assert.NotNil(t, h.GetCreatedTimestamp())
+}
+
+func TestHistogramConcurrentReportSamples(t *testing.T) {
+	registry := prom.NewRegistry()
+	r := NewReporter(Options{Registerer: registry})
+
+	buckets := tally.DurationBuckets{time.Millisecond, time.Second}
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			// Allocating the same histogram concurrently must return
+			// the same series.
+			histogram := r.AllocateHistogram("test_histogram", map[string]string{"a": "b"}, buckets)
+			bucket := histogram.DurationBucket(time.Millisecond, time.Second)
+			for j := 0; j < 100; j++ {
+				bucket.ReportSamples(10)
+			}
+		}()
+	}
+	wg.Wait()
+
+	h := gather(t, registry)[0].GetMetric()[0].GetHistogram()
+	assert.Equal(t, uint64(8000), h.GetSampleCount())
+	assert.Equal(t, uint64(0), h.GetBucket()[0].GetCumulativeCount())
+	assert.Equal(t, uint64(8000), h.GetBucket()[1].GetCumulativeCount())
+}
+
+func TestHistogramCountWhileReportingSamples(t *testing.T) {
+	registry := prom.NewRegistry()
+	r := NewReporter(Options{Registerer: registry})
+	histogram := r.AllocateHistogram("test_histogram", nil, tally.ValueBuckets{1, 10})
+	bucket := histogram.ValueBucket(1, 10)
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 10000; i++ {
+			bucket.ReportSamples(1)
+		}
+	}()
+
+	for {
+		h := gather(t, registry)[0].GetMetric()[0].GetHistogram()
+		buckets := h.GetBucket()
+		require.True(t, h.GetSampleCount() >= buckets[len(buckets)-1].GetCumulativeCount(),
+			"the count must not be less than the count of the last bucket")
+		select {
+		case <-done:
+			return
+		default:
+		}
+	}
+}
+
+func BenchmarkHistogramReportSamples(b *testing.B) {
+	r := NewReporter(Options{Registerer: prom.NewRegistry()})
+	histogram := r.AllocateHistogram("test_histogram", map[string]string{"a": "b"},
+		tally.MustMakeExponentialDurationBuckets(time.Millisecond, 2, 16))
+	bucket := histogram.DurationBucket(8*time.Millisecond, 16*time.Millisecond)
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		bucket.ReportSamples(1000)
+	}
+}
+
+func BenchmarkHistogramGather(b *testing.B) {
+	registry := prom.NewRegistry()
+	r := NewReporter(Options{Registerer: registry})
+	buckets := tally.MustMakeExponentialDurationBuckets(time.Millisecond, 2, 16)
+	for i := 0; i < 100; i++ {
+		histogram := r.AllocateHistogram("test_histogram",
+			map[string]string{"shard": string(rune('a' + i%26)), "id": string(rune('A' + i/26))},
+			buckets)
+		histogram.DurationBucket(8*time.Millisecond, 16*time.Millisecond).ReportSamples(1)
+	}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := registry.Gather(); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
@@ -0,0 +1,138 @@
+// Copyright (c) 2022 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package prometheus
+
+import (
+	"compress/gzip"
+	"net/http"
+	"strings"
+
+	prom "github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/common/expfmt"
+)
+
+// unitGatherer sets the unit of the gathered metric families the reporter
+// knows the unit of, so that it is written as a # UNIT line.
+type unitGatherer struct {
+	reporter *reporter
+}
+
+func (g unitGatherer) Gather() ([]*dto.MetricFamily, error) {
+	families, err := g.reporter.gatherer.Gather()
+	for _, family := range families {
+		if unit, ok := g.reporter.unit(family.GetName()); ok {
+			family.Unit = &unit
+		}
+	}
+	return families, err
+}
+
+// openMetricsHandler serves the gathered metrics in the format negotiated
+// with the scraper, including OpenMetrics. Unlike the promhttp handler it
+// writes the # UNIT lines and _created samples of OpenMetrics, and
+// suffixes counters with _total as OpenMetrics requires.
+type openMetricsHandler struct {
+	gatherer prom.Gatherer
+}
+
+func newOpenMetricsHandler(gatherer prom.Gatherer) http.Handler {
+	return openMetricsHandler{gatherer: gatherer}
+}
+
+func (h openMetricsHandler) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	families, err := h.gatherer.Gather()
+	if err != nil {
+		http.Error(w, "error gathering metrics: "+err.Error(),
+			http.StatusInternalServerError)
+		return
+	}
+
+	format := expfmt.NegotiateIncludingOpenMetrics(req.Header)
+	w.Header().Set("Content-Type", string(format))
+
+	if format.FormatType() == expfmt.TypeOpenMetrics {
+		// OpenMetrics counters must have the _total suffix, without it
+		// they are exposed as unknown metrics.
+		for _, family := range families {
+			if family.GetType() == dto.MetricType_COUNTER &&
+				!strings.HasSuffix(family.GetName(), CounterSuffix) {
+				name := family.GetName() + CounterSuffix
+				family.Name = &name
+			}
+		}
+	}
+
+	enc := expfmt.NewEncoder(w, format, expfmt.WithUnit(), expfmt.WithCreatedLines())
+	for _, family := range families {
+		if err := enc.Encode(family); err != nil {
+			// The response has already been written to, stop sending.
+			return
+		}
+	}
+	if closer, ok := enc.(expfmt.Closer); ok {
+		closer.Close()
+	}
+}
+
+// gzipHandler compresses the responses for clients that accept gzip.
+// The request passed on does not accept gzip so that the handler does
+// not compress again.
+func gzipHandler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if !acceptsGzip(req) {
+			next.ServeHTTP(w, req)
+			return
+		}
+
+		req = req.Clone(req.Context())
+		req.Header.Del("Accept-Encoding")
+
+		w.Header().Set("Content-Encoding", "gzip")
+		w.Header().Add("Vary", "Accept-Encoding")
+		gz := gzip.NewWriter(w)
+		defer gz.Close()
+		next.ServeHTTP(gzipResponseWriter{ResponseWriter: w, writer: gz}, req)
+	})
+}
+
+func acceptsGzip(req *http.Request) bool {
+	for _, encoding := range strings.Split(req.Header.Get("Accept-Encoding"), ",") {
+		if strings.TrimSpace(strings.Split(encoding, ";")[0]) == "gzip" {
+			return true
+		}
+	}
+	return false
+}
+
+type gzipResponseWriter struct {
+	http.ResponseWriter
+	writer *gzip.Writer
+}
+
+func (w gzipResponseWriter) WriteHeader(code int) {
+	w.Header().Del("Content-Length")
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w gzipResponseWriter) Write(b []byte) (int, error) {
+	return w.writer.Write(b)
+}
@@ -0,0 +1,147 @@
+// Copyright (c) 2022 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package prometheus
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	prom "github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	tally "github.com/uber-go/tally/v4"
+)
+
+const openMetricsAccept = "application/openmetrics-text;version=1.0.0,text/plain;version=0.0.4;q=0.5"
+
+func scrape(t *testing.T, handler http.Handler, accept string) (string, string) {
+	req := httptest.NewRequest(http.MethodGet, "/metrics", nil)
+	if accept != "" {
+		req.Header.Set("Accept", accept)
+	}
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	require.Equal(t, http.StatusOK, rec.Code)
+
+	body, err := ioutil.ReadAll(rec.Body)
+	require.NoError(t, err)
+	return rec.Header().Get("Content-Type"), string(body)
+}
+
+func TestNamingConventions(t *testing.T) {
+	registry := prom.NewRegistry()
+	r := NewReporter(Options{
+		Registerer:        registry,
+		DefaultTimerType:  HistogramTimerType,
+		NamingConventions: true,
+	})
+
+	r.AllocateCounter("requests", nil).ReportCount(1)
+	r.AllocateCounter("errors_total", nil).ReportCount(1)
+	r.AllocateTimer("latency", nil).ReportTimer(time.Millisecond)
+	r.AllocateHistogram("wait", nil, tally.DurationBuckets{time.Second}).
+		DurationBucket(0, time.Second).ReportSamples(1)
+	r.AllocateHistogram("size", nil, tally.ValueBuckets{10}).
+		ValueBucket(0, 10).ReportSamples(1)
+
+	_, err := r.RegisterCounter("requests", nil, "requests counter")
+	require.NoError(t, err, "registering must resolve to the same counter")
+
+	var names []string
+	for _, family := range gather(t, registry) {
+		names = append(names, family.GetName())
+	}
+	assert.ElementsMatch(t, []string{
+		"requests_total",
+		"errors_total",
+		"latency_seconds",
+		"wait_seconds",
+		"size",
+	}, names)
+}
+
+func TestOpenMetricsNegotiation(t *testing.T) {
+	registry := prom.NewRegistry()
+	r := NewReporter(Options{
+		Registerer:        registry,
+		DefaultTimerType:  HistogramTimerType,
+		EnableOpenMetrics: true,
+		NamingConventions: true,
+	})
+
+	r.AllocateCounter("requests", nil).ReportCount(2)
+	r.AllocateTimer("latency", nil).ReportTimer(time.Millisecond)
+
+	contentType, body := scrape(t, r.HTTPHandler(), openMetricsAccept)
+	assert.True(t, strings.HasPrefix(contentType, "application/openmetrics-text"))
+	assert.Contains(t, body, "# TYPE requests counter\n")
+	assert.Contains(t, body, "requests_total 2.0\n")
+	assert.Contains(t, body, "requests_created ")
+	assert.Contains(t, body, "# UNIT latency_seconds seconds\n")
+	assert.Contains(t, body, "latency_seconds_count 1\n")
+	assert.True(t, strings.HasSuffix(body, "# EOF\n"))
+
+	contentType, body = scrape(t, r.HTTPHandler(), "")
+	assert.True(t, strings.HasPrefix(contentType, "text/plain"))
+	assert.Contains(t, body, "requests_total 2\n")
+	assert.NotContains(t, body, "# UNIT")
+	assert.NotContains(t, body, "# EOF")
+}
+
+func TestOpenMetricsExemplars(t *testing.T) {
+	registry := prom.NewRegistry()
+	r := NewReporter(Options{
+		Registerer:        registry,
+		DefaultTimerType:  HistogramTimerType,
+		EnableOpenMetrics: true,
+		ExemplarLabels: func(name string, tags map[string]string) prom.Labels {
+			if name == "requests" {
+				return prom.Labels{"trace_id": tags["trace"]}
+			}
+			return nil
+		},
+	})
+
+	r.AllocateCounter("requests", map[string]string{"trace": "abc"}).ReportCount(1)
+	r.AllocateTimer("latency", nil).ReportTimer(time.Millisecond)
+
+	_, body := scrape(t, r.HTTPHandler(), openMetricsAccept)
+	assert.Contains(t, body, `requests_total{trace="abc"} 1.0 # {trace_id="abc"} 1.0`)
+	assert.NotContains(t, body, "latency_bucket{le=\"0.001\"} 1 #")
+}
+
+func TestOpenMetricsConfiguration(t *testing.T) {
+	registry := prom.NewRegistry()
+	r, err := Configuration{
+		ListenAddress:     "127.0.0.1:0",
+		OpenMetrics:       true,
+		NamingConventions: true,
+	}.NewReporter(ConfigurationOptions{Registry: registry})
+	require.NoError(t, err)
+
+	r.AllocateCounter("requests", nil).ReportCount(1)
+	_, body := scrape(t, r.HTTPHandler(), openMetricsAccept)
+	assert.Contains(t, body, "requests_total 1.0\n")
+}
@@ -0,0 +1,169 @@
+// Copyright (c) 2022 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package prometheus
+
+import (
+	"log"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	prom "github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/push"
+	dto "github.com/prometheus/client_model/go"
+)
+
+// PushOptions is a set of options for pushing the gathered metrics to a
+// Prometheus Pushgateway, for batch jobs that live too briefly to be
+// scraped.
+type PushOptions struct {
+	// URL is the Pushgateway URL, without the /metrics/job/... part.
+	URL string
+
+	// Job is the job label of the pushed group.
+	Job string
+
+	// Grouping are the grouping key labels of the pushed group besides
+	// the job, these should be the root scope tags (ScopeOptions.Tags)
+	// so that the group is keyed by the labels common to all its series.
+	Grouping map[string]string
+
+	// Interval is the minimum time between pushes on Flush, use zero to
+	// push on every Flush. A push always happens on Close unless
+	// DeleteOnClose is set.
+	Interval time.Duration
+
+	// Username and Password if specified are used for basic auth.
+	Username string
+	Password string
+
+	// DeleteOnClose deletes the pushed group from the Pushgateway on
+	// Close instead of pushing, so that series of a finished job do
+	// not linger.
+	DeleteOnClose bool
+
+	// HTTPClient is the client used to push, use nil to specify
+	// the default HTTP client.
+	HTTPClient push.HTTPDoer
+
+	// OnError is called when pushing or deleting fails. Use nil to
+	// specify to log errors by default.
+	OnError func(err error)
+}
+
+type pusher struct {
+	sync.Mutex
+	opts     PushOptions
+	pusher   *push.Pusher
+	lastPush time.Time
+	closed   bool
+}
+
+func newPusher(opts PushOptions, gatherer prom.Gatherer) *pusher {
+	if opts.OnError == nil {
+		opts.OnError = func(err error) {
+			log.Printf("tally prometheus reporter push error: %v\n", err)
+		}
+	}
+
+	p := push.New(opts.URL, opts.Job).Gatherer(&groupingGatherer{
+		gatherer: gatherer,
+		grouping: opts.Grouping,
+	})
+	for k, v := range opts.Grouping {
+		p = p.Grouping(k, v)
+	}
+	if opts.Username != "" || opts.Password != "" {
+		p = p.BasicAuth(opts.Username, opts.Password)
+	}
+	if opts.HTTPClient != nil {
+		p = p.Client(opts.HTTPClient)
+	}
+	return &pusher{opts: opts, pusher: p}
+}
+
+func (p *pusher) flush() {
+	p.Lock()
+	defer p.Unlock()
+
+	if p.closed {
+		return
+	}
+	if now := time.Now(); p.lastPush.IsZero() || now.Sub(p.lastPush) >= p.opts.Interval {
+		p.lastPush = now
+		p.pushWithLock()
+	}
+}
+
+func (p *pusher) pushWithLock() {
+	if err := p.pusher.Push(); err != nil {
+		p.opts.OnError(errors.WithMessage(err, "failed to push to pushgateway"))
+	}
+}
+
+func (p *pusher) close() {
+	p.Lock()
+	defer p.Unlock()
+
+	if p.closed {
+		return
+	}
+	p.closed = true
+
+	if !p.opts.DeleteOnClose {
+		p.pushWithLock()
+		return
+	}
+	if err := p.pusher.Delete(); err != nil {
+		p.opts.OnError(errors.WithMessage(err, "failed to delete pushgateway group"))
+	}
+}
+
+// groupingGatherer removes the grouping labels from gathered metrics, as
+// the Pushgateway rejects metrics that contain them and instead adds them
+// to every metric of the group itself. Labels with a different value than
+// the grouping label are kept so the push fails rather than being
+// silently relabeled.
+type groupingGatherer struct {
+	gatherer prom.Gatherer
+	grouping map[string]string
+}
+
+func (g *groupingGatherer) Gather() ([]*dto.MetricFamily, error) {
+	families, err := g.gatherer.Gather()
+	if len(g.grouping) == 0 {
+		return families, err
+	}
+
+	for _, family := range families {
+		for _, metric := range family.Metric {
+			labels := metric.Label[:0]
+			for _, label := range metric.Label {
+				if value, ok := g.grouping[label.GetName()]; ok && value == label.GetValue() {
+					continue
+				}
+				labels = append(labels, label)
+			}
+			metric.Label = labels
+		}
+	}
+	return families, err
+}
@@ -0,0 +1,261 @@
+// Copyright (c) 2022 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package prometheus
+
+import (
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	prom "github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	tally "github.com/uber-go/tally/v4"
+)
+
+type pushRequest struct {
+	method string
+	path   string
+	user   string
+	pass   string
+	body   []byte
+}
+
+// pushgateway is a stand-in for a Prometheus Pushgateway.
+type pushgateway struct {
+	sync.Mutex
+	requests []pushRequest
+	status   int
+}
+
+func (g *pushgateway) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	g.Lock()
+	defer g.Unlock()
+
+	body, _ := ioutil.ReadAll(r.Body)
+	user, pass, _ := r.BasicAuth()
+	g.requests = append(g.requests, pushRequest{
+		method: r.Method,
+		path:   r.URL.Path,
+		user:   user,
+		pass:   pass,
+		body:   body,
+	})
+	if g.status != 0 {
+		w.WriteHeader(g.status)
+		return
+	}
+	w.WriteHeader(http.StatusAccepted)
+}
+
+func (g *pushgateway) pushes() []pushRequest {
+	g.Lock()
+	defer g.Unlock()
+	return append([]pushRequest(nil), g.requests...)
+}
+
+func TestPushOnFlushAndClose(t *testing.T) {
+	gateway := &pushgateway{}
+	server := httptest.NewServer(gateway)
+	defer server.Close()
+
+	registry := prom.NewRegistry()
+	r := NewReporter(Options{
+		Registerer: registry,
+		Push: &PushOptions{
+			URL:      server.URL,
+			Job:      "backfill",
+			Grouping: map[string]string{"env": "test"},
+			Username: "user",
+			Password: "secret",
+		},
+	})
+
+	r.AllocateCounter("rows", map[string]string{"env": "test"}).ReportCount(10)
+	r.Flush()
+	require.NoError(t, r.(io.Closer).Close())
+
+	pushes := gateway.pushes()
+	require.Len(t, pushes, 2)
+	for _, p := range pushes {
+		assert.Equal(t, http.MethodPut, p.method)
+		assert.Equal(t, "/metrics/job/backfill/env/test", p.path)
+		assert.Equal(t, "user", p.user)
+		assert.Equal(t, "secret", p.pass)
+		assert.Contains(t, string(p.body), "rows")
+	}
+
+	// Closing again does not push again.
+	require.NoError(t, r.(io.Closer).Close())
+	r.Flush()
+	assert.Len(t, gateway.pushes(), 2)
+}
+
+func TestPushInterval(t *testing.T) {
+	gateway := &pushgateway{}
+	server := httptest.NewServer(gateway)
+	defer server.Close()
+
+	r := NewReporter(Options{
+		Registerer: prom.NewRegistry(),
+		Push: &PushOptions{
+			URL:      server.URL,
+			Job:      "backfill",
+			Interval: time.Hour,
+		},
+	})
+
+	r.AllocateCounter("rows", nil).ReportCount(1)
+	r.Flush()
+	r.Flush()
+	r.Flush()
+	assert.Len(t, gateway.pushes(), 1)
+
+	require.NoError(t, r.(io.Closer).Close())
+	assert.Len(t, gateway.pushes(), 2)
+}
+
+func TestPushDeleteOnClose(t *testing.T) {
+	gateway := &pushgateway{}
+	server := httptest.NewServer(gateway)
+	defer server.Close()
+
+	r := NewReporter(Options{
+		Registerer: prom.NewRegistry(),
+		Push: &PushOptions{
+			URL:           server.URL,
+			Job:           "backfill",
+			Grouping:      map[string]string{"shard": "1"},
+			DeleteOnClose: true,
+		},
+	})
+
+	r.Flush()
+	require.NoError(t, r.(io.Closer).Close())
+
+	pushes := gateway.pushes()
+	require.Len(t, pushes, 2)
+	assert.Equal(t, http.MethodPut, pushes[0].method)
+	assert.Equal(t, http.MethodDelete, pushes[1].method)
+	assert.Equal(t, "/metrics/job/backfill/shard/1", pushes[1].path)
+}
+
+func TestPushErrorCallsOnError(t *testing.T) {
+	gateway := &pushgateway{status: http.StatusBadRequest}
+	server := httptest.NewServer(gateway)
+	defer server.Close()
+
+	var errs []error
+	r := NewReporter(Options{
+		Registerer: prom.NewRegistry(),
+		Push: &PushOptions{
+			URL: server.URL,
+			Job: "backfill",
+			OnError: func(err error) {
+				errs = append(errs, err)
+			},
+		},
+	})
+
+	r.AllocateCounter("rows", nil).ReportCount(1)
+	r.Flush()
+	require.Len(t, errs, 1)
+	assert.Contains(t, errs[0].Error(), "failed to push to pushgateway")
+}
+
+func TestPushGroupingRootScopeTags(t *testing.T) {
+	gateway := &pushgateway{}
+	server := httptest.NewServer(gateway)
+	defer server.Close()
+
+	tags := map[string]string{"env": "test", "shard": "1"}
+	r := NewReporter(Options{
+		Registerer: prom.NewRegistry(),
+		Push: &PushOptions{
+			URL:           server.URL,
+			Job:           "backfill",
+			Grouping:      tags,
+			DeleteOnClose: true,
+		},
+		OnRegisterError: func(err error) {},
+	})
+	scope, closer := tally.NewRootScope(tally.ScopeOptions{
+		CachedReporter: r,
+		Separator:      DefaultSeparator,
+		Tags:           tags,
+	}, 0)
+
+	scope.Tagged(map[string]string{"table": "a"}).Counter("rows").Inc(1)
+	scope.Tagged(map[string]string{"table": "b"}).Counter("rows").Inc(1)
+	require.NoError(t, closer.Close())
+
+	pushes := gateway.pushes()
+	require.Len(t, pushes, 2)
+	grouping := map[string]string{"job": "backfill", "env": "test", "shard": "1"}
+	assert.Equal(t, http.MethodPut, pushes[0].method)
+	assert.Equal(t, grouping, pathGrouping(t, pushes[0].path))
+	assert.Contains(t, string(pushes[0].body), "table")
+	assert.NotContains(t, string(pushes[0].body), "shard")
+	assert.Equal(t, http.MethodDelete, pushes[1].method)
+	assert.Equal(t, grouping, pathGrouping(t, pushes[1].path))
+}
+
+// pathGrouping returns the grouping labels of a push path, which are in
+// no particular order.
+func pathGrouping(t *testing.T, path string) map[string]string {
+	parts := strings.Split(strings.TrimPrefix(path, "/metrics/"), "/")
+	require.True(t, len(parts)%2 == 0, path)
+	grouping := make(map[string]string)
+	for i := 0; i < len(parts); i += 2 {
+		grouping[parts[i]] = parts[i+1]
+	}
+	return grouping
+}
+
+func TestGroupingGathererRemovesGroupingLabels(t *testing.T) {
+	registry := prom.NewRegistry()
+	r := NewReporter(Options{Registerer: registry})
+	r.AllocateCounter("rows", map[string]string{"env": "test", "shard": "1"}).ReportCount(1)
+	r.AllocateCounter("rows", map[string]string{"env": "prod", "shard": "1"}).ReportCount(1)
+
+	g := &groupingGatherer{
+		gatherer: registry,
+		grouping: map[string]string{"env": "test"},
+	}
+	families, err := g.Gather()
+	require.NoError(t, err)
+	require.Len(t, families, 1)
+
+	var labels []string
+	for _, metric := range families[0].Metric {
+		var pairs []string
+		for _, label := range metric.Label {
+			pairs = append(pairs, label.GetName()+"="+label.GetValue())
+		}
+		labels = append(labels, strings.Join(pairs, ","))
+	}
+	assert.Equal(t, []string{"env=prod,shard=1", "shard=1"}, labels)
+}
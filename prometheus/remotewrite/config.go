@@ -0,0 +1,81 @@
+// Copyright (c) 2022 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package remotewrite
+
+import (
+	"time"
+)
+
+// Configuration is a configuration for a remote write reporter.
+type Configuration struct {
+	// URL is the remote write endpoint.
+	URL string `yaml:"url" validate:"nonzero"`
+
+	// Headers are added to every write request.
+	Headers map[string]string `yaml:"headers"`
+
+	// ExternalLabels are added to every series.
+	ExternalLabels map[string]string `yaml:"externalLabels"`
+
+	// Shards is the number of shards series are spread across.
+	Shards int `yaml:"shards"`
+
+	// MaxSamplesPerSend is the maximum number of samples in a single
+	// write request.
+	MaxSamplesPerSend int `yaml:"maxSamplesPerSend"`
+
+	// QueueSize is the number of write requests each shard buffers.
+	QueueSize int `yaml:"queueSize"`
+
+	// Timeout is the timeout of a single write request.
+	Timeout time.Duration `yaml:"timeout"`
+
+	// MaxRetries is the number of times a failed write request is
+	// retried, use a negative value to disable retries.
+	MaxRetries int `yaml:"maxRetries"`
+
+	// MinBackoff is the wait before the first retry.
+	MinBackoff time.Duration `yaml:"minBackoff"`
+
+	// MaxBackoff is the upper limit of the wait between retries.
+	MaxBackoff time.Duration `yaml:"maxBackoff"`
+
+	// TimerBuckets if specified will set the buckets, in seconds, of the
+	// histograms timers are pushed as.
+	TimerBuckets []float64 `yaml:"timerBuckets"`
+}
+
+// NewReporter creates a new remote write reporter from this configuration.
+func (c Configuration) NewReporter() (Reporter, error) {
+	return NewReporter(Options{
+		URL:               c.URL,
+		Headers:           c.Headers,
+		ExternalLabels:    c.ExternalLabels,
+		Shards:            c.Shards,
+		MaxSamplesPerSend: c.MaxSamplesPerSend,
+		QueueSize:         c.QueueSize,
+		Timeout:           c.Timeout,
+		MaxRetries:        c.MaxRetries,
+		MinBackoff:        c.MinBackoff,
+		MaxBackoff:        c.MaxBackoff,
+		TimerBuckets:      c.TimerBuckets,
+	})
+}
@@ -0,0 +1,49 @@
+// Copyright (c) 2022 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package remotewrite
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/uber-go/tally/v4/prometheus"
+)
+
+func TestConfigSimple(t *testing.T) {
+	c := Configuration{
+		URL:            "http://localhost:9090/api/v1/write",
+		ExternalLabels: map[string]string{"cluster-name": "east"},
+		Shards:         2,
+		Timeout:        time.Second,
+	}
+	r, err := c.NewReporter()
+	require.NoError(t, err)
+	defer r.Close()
+
+	reporter := r.(*reporter)
+	assert.Len(t, reporter.shards, 2)
+	assert.Equal(t, time.Second, reporter.opts.Timeout)
+	assert.Equal(t, DefaultMaxSamplesPerSend, reporter.opts.MaxSamplesPerSend)
+	assert.Equal(t, prometheus.DefaultHistogramBuckets(), reporter.timerBounds)
+	assert.Equal(t, map[string]string{"cluster_name": "east"}, reporter.externalLabels)
+}
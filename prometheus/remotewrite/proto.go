@@ -0,0 +1,84 @@
+// Copyright (c) 2022 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package remotewrite
+
+import (
+	"math"
+
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// Field numbers of the remote write protobuf messages, see
+// https://github.com/prometheus/prometheus/blob/main/prompb/types.proto
+const (
+	// WriteRequest.
+	writeRequestTimeseries protowire.Number = 1
+
+	// TimeSeries.
+	timeSeriesLabels  protowire.Number = 1
+	timeSeriesSamples protowire.Number = 2
+
+	// Label.
+	labelName  protowire.Number = 1
+	labelValue protowire.Number = 2
+
+	// Sample.
+	sampleValue     protowire.Number = 1
+	sampleTimestamp protowire.Number = 2
+)
+
+func appendMessage(b []byte, num protowire.Number, msg []byte) []byte {
+	b = protowire.AppendTag(b, num, protowire.BytesType)
+	return protowire.AppendBytes(b, msg)
+}
+
+func appendLabel(b []byte, name, value string) []byte {
+	var label []byte
+	label = protowire.AppendTag(label, labelName, protowire.BytesType)
+	label = protowire.AppendString(label, name)
+	label = protowire.AppendTag(label, labelValue, protowire.BytesType)
+	label = protowire.AppendString(label, value)
+	return appendMessage(b, timeSeriesLabels, label)
+}
+
+func appendSample(b []byte, value float64, timestamp int64) []byte {
+	var s []byte
+	s = protowire.AppendTag(s, sampleValue, protowire.Fixed64Type)
+	s = protowire.AppendFixed64(s, math.Float64bits(value))
+	s = protowire.AppendTag(s, sampleTimestamp, protowire.VarintType)
+	s = protowire.AppendVarint(s, uint64(timestamp))
+	return appendMessage(b, timeSeriesSamples, s)
+}
+
+// encodeWriteRequest encodes a WriteRequest with a time series per
+// sample, all sharing the same timestamp.
+func encodeWriteRequest(samples []sample, timestamp int64) []byte {
+	var (
+		req []byte
+		ts  []byte
+	)
+	for _, s := range samples {
+		ts = append(ts[:0], s.series.labels...)
+		ts = appendSample(ts, s.value, timestamp)
+		req = appendMessage(req, writeRequestTimeseries, ts)
+	}
+	return req
+}
@@ -0,0 +1,587 @@
+// Copyright (c) 2022 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package remotewrite
+
+import (
+	"hash/fnv"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	tally "github.com/uber-go/tally/v4"
+	"github.com/uber-go/tally/v4/prometheus"
+	"go.uber.org/atomic"
+)
+
+const (
+	// DefaultShards is the default number of shards series are spread
+	// across, each sending its own requests concurrently.
+	DefaultShards = 4
+
+	// DefaultMaxSamplesPerSend is the default maximum number of samples
+	// in a single write request.
+	DefaultMaxSamplesPerSend = 2000
+
+	// DefaultQueueSize is the default number of write requests each shard
+	// buffers while a previous request is in flight.
+	DefaultQueueSize = 64
+
+	// DefaultTimeout is the default timeout of a single write request.
+	DefaultTimeout = 30 * time.Second
+
+	// DefaultMaxRetries is the default number of times a failed write
+	// request is retried before it is dropped.
+	DefaultMaxRetries = 10
+
+	// DefaultMinBackoff is the default wait before the first retry.
+	DefaultMinBackoff = 30 * time.Millisecond
+
+	// DefaultMaxBackoff is the default upper limit of the wait between
+	// retries.
+	DefaultMaxBackoff = 5 * time.Second
+
+	metricNameLabel = "__name__"
+	bucketLabel     = "le"
+)
+
+var (
+	errNoURL         = errors.New("no remote write URL specified")
+	errAlreadyClosed = errors.New("reporter already closed")
+	errQueueFull     = errors.New("remote write queue full, dropping samples")
+
+	sanitizer = tally.NewSanitizer(prometheus.DefaultSanitizerOpts)
+)
+
+// Reporter is a tally.CachedStatsReporter that pushes metrics using the
+// Prometheus remote write protocol.
+type Reporter interface {
+	tally.CachedStatsReporter
+	io.Closer
+}
+
+// HTTPClient is the subset of *http.Client used to send write requests.
+type HTTPClient interface {
+	Do(req *http.Request) (*http.Response, error)
+}
+
+// Options is a set of options for the remote write reporter.
+type Options struct {
+	// URL is the remote write endpoint, this must be specified.
+	URL string
+
+	// Headers are added to every write request, typically used for
+	// authentication.
+	Headers map[string]string
+
+	// ExternalLabels are added to every series unless a series already
+	// has a label with the same name.
+	ExternalLabels map[string]string
+
+	// Shards is the number of shards series are spread across by the
+	// hash of their labels, by default this will be set to DefaultShards.
+	// Samples of a series are always sent in order by the same shard.
+	Shards int
+
+	// MaxSamplesPerSend is the maximum number of samples in a single write
+	// request, by default this will be set to DefaultMaxSamplesPerSend.
+	MaxSamplesPerSend int
+
+	// QueueSize is the number of write requests each shard buffers, by
+	// default this will be set to DefaultQueueSize.
+	QueueSize int
+
+	// Timeout is the timeout of a single write request, by default this
+	// will be set to DefaultTimeout.
+	Timeout time.Duration
+
+	// MaxRetries is the number of times a failed write request is
+	// retried, by default this will be set to DefaultMaxRetries. Use a
+	// negative value to disable retries.
+	MaxRetries int
+
+	// MinBackoff is the wait before the first retry, doubling for each
+	// subsequent retry. By default this will be set to DefaultMinBackoff.
+	MinBackoff time.Duration
+
+	// MaxBackoff is the upper limit of the wait between retries, by
+	// default this will be set to DefaultMaxBackoff.
+	MaxBackoff time.Duration
+
+	// TimerBuckets are the buckets, in seconds, of the histograms timers
+	// are pushed as. By default this will be set to
+	// prometheus.DefaultHistogramBuckets().
+	TimerBuckets []float64
+
+	// HTTPClient is the client used to send write requests, by default a
+	// client with Timeout is used.
+	HTTPClient HTTPClient
+
+	// OnError is called with any error encountered pushing metrics, by
+	// default errors are ignored.
+	OnError func(err error)
+}
+
+// series is a set of sanitized and sorted labels, pre-encoded as the
+// labels of a TimeSeries.
+type series struct {
+	labels []byte
+	hash   uint64
+}
+
+type label struct {
+	name  string
+	value string
+}
+
+type sample struct {
+	series *series
+	value  float64
+}
+
+// metric appends its current samples to dst.
+type metric interface {
+	appendSamples(dst []sample) []sample
+}
+
+type reporter struct {
+	opts           Options
+	externalLabels map[string]string
+	timerBounds    []float64
+	shards         []*shard
+
+	sync.RWMutex
+	metrics []metric
+	batch   []sample
+	closed  bool
+}
+
+// NewReporter returns a new remote write reporter.
+func NewReporter(opts Options) (Reporter, error) {
+	if opts.URL == "" {
+		return nil, errNoURL
+	}
+	if _, err := http.NewRequest(http.MethodPost, opts.URL, nil); err != nil {
+		return nil, errors.Wrap(err, "invalid remote write URL")
+	}
+	if opts.Shards <= 0 {
+		opts.Shards = DefaultShards
+	}
+	if opts.MaxSamplesPerSend <= 0 {
+		opts.MaxSamplesPerSend = DefaultMaxSamplesPerSend
+	}
+	if opts.QueueSize <= 0 {
+		opts.QueueSize = DefaultQueueSize
+	}
+	if opts.Timeout <= 0 {
+		opts.Timeout = DefaultTimeout
+	}
+	if opts.MaxRetries == 0 {
+		opts.MaxRetries = DefaultMaxRetries
+	}
+	if opts.MinBackoff <= 0 {
+		opts.MinBackoff = DefaultMinBackoff
+	}
+	if opts.MaxBackoff <= 0 {
+		opts.MaxBackoff = DefaultMaxBackoff
+	}
+	if opts.TimerBuckets == nil {
+		opts.TimerBuckets = prometheus.DefaultHistogramBuckets()
+	}
+	if opts.HTTPClient == nil {
+		opts.HTTPClient = &http.Client{Timeout: opts.Timeout}
+	}
+
+	externalLabels := make(map[string]string, len(opts.ExternalLabels))
+	for k, v := range opts.ExternalLabels {
+		externalLabels[sanitizer.Key(k)] = sanitizer.Value(v)
+	}
+
+	timerBounds := append([]float64(nil), opts.TimerBuckets...)
+	sort.Float64s(timerBounds)
+
+	r := &reporter{
+		opts:           opts,
+		externalLabels: externalLabels,
+		timerBounds:    timerBounds,
+		shards:         make([]*shard, opts.Shards),
+	}
+	for i := range r.shards {
+		r.shards[i] = newShard(opts)
+	}
+	return r, nil
+}
+
+// newSeries builds a series the same way the prometheus reporter would
+// expose it, with an optional extra label such as the bucket label.
+func (r *reporter) newSeries(
+	name string,
+	tags map[string]string,
+	extra ...label,
+) *series {
+	labels := make([]label, 0, len(tags)+len(r.externalLabels)+len(extra)+1)
+	seen := make(map[string]struct{}, cap(labels))
+	add := func(name, value string) {
+		if _, ok := seen[name]; ok {
+			return
+		}
+		seen[name] = struct{}{}
+		labels = append(labels, label{name: name, value: value})
+	}
+
+	add(metricNameLabel, sanitizer.Name(name))
+	for _, l := range extra {
+		add(l.name, l.value)
+	}
+	for k, v := range tags {
+		add(sanitizer.Key(k), sanitizer.Value(v))
+	}
+	for k, v := range r.externalLabels {
+		add(k, v)
+	}
+	sort.Slice(labels, func(i, j int) bool {
+		return labels[i].name < labels[j].name
+	})
+
+	var (
+		s = &series{}
+		h = fnv.New64a()
+	)
+	for _, l := range labels {
+		s.labels = appendLabel(s.labels, l.name, l.value)
+		h.Write([]byte(l.name))
+		h.Write([]byte{0xff})
+		h.Write([]byte(l.value))
+		h.Write([]byte{0xff})
+	}
+	s.hash = h.Sum64()
+	return s
+}
+
+// newBucketSeries returns a series per bucket bound plus the +Inf bucket.
+func (r *reporter) newBucketSeries(
+	name string,
+	tags map[string]string,
+	bounds []float64,
+) []*series {
+	result := make([]*series, 0, len(bounds)+1)
+	for _, bound := range bounds {
+		result = append(result, r.newSeries(name+"_bucket", tags,
+			label{name: bucketLabel, value: formatBound(bound)}))
+	}
+	return append(result, r.newSeries(name+"_bucket", tags,
+		label{name: bucketLabel, value: "+Inf"}))
+}
+
+func formatBound(bound float64) string {
+	return strconv.FormatFloat(bound, 'f', -1, 64)
+}
+
+func (r *reporter) register(m metric) {
+	r.Lock()
+	r.metrics = append(r.metrics, m)
+	r.Unlock()
+}
+
+func (r *reporter) Capabilities() tally.Capabilities {
+	return r
+}
+
+func (r *reporter) Reporting() bool {
+	return true
+}
+
+func (r *reporter) Tagging() bool {
+	return true
+}
+
+func (r *reporter) AllocateCounter(
+	name string,
+	tags map[string]string,
+) tally.CachedCount {
+	c := &counter{series: r.newSeries(name, tags)}
+	r.register(c)
+	return c
+}
+
+func (r *reporter) AllocateGauge(
+	name string,
+	tags map[string]string,
+) tally.CachedGauge {
+	g := &gauge{series: r.newSeries(name, tags)}
+	r.register(g)
+	return g
+}
+
+func (r *reporter) AllocateTimer(
+	name string,
+	tags map[string]string,
+) tally.CachedTimer {
+	t := &timer{
+		bounds:       r.timerBounds,
+		bucketSeries: r.newBucketSeries(name, tags, r.timerBounds),
+		sumSeries:    r.newSeries(name+"_sum", tags),
+		countSeries:  r.newSeries(name+"_count", tags),
+		counts:       make([]uint64, len(r.timerBounds)+1),
+	}
+	r.register(t)
+	return t
+}
+
+func (r *reporter) AllocateHistogram(
+	name string,
+	tags map[string]string,
+	buckets tally.Buckets,
+) tally.CachedHistogram {
+	var bounds []float64
+	for _, pair := range tally.BucketPairs(buckets) {
+		if _, ok := buckets.(tally.DurationBuckets); ok {
+			if upper := pair.UpperBoundDuration(); upper != time.Duration(math.MaxInt64) {
+				bounds = append(bounds, upper.Seconds())
+			}
+		} else if upper := pair.UpperBoundValue(); upper != math.MaxFloat64 {
+			bounds = append(bounds, upper)
+		}
+	}
+
+	h := &histogram{
+		bounds:       bounds,
+		bucketSeries: r.newBucketSeries(name, tags, bounds),
+		sumSeries:    r.newSeries(name+"_sum", tags),
+		countSeries:  r.newSeries(name+"_count", tags),
+		counts:       make([]atomic.Uint64, len(bounds)+1),
+	}
+	r.register(h)
+	return h
+}
+
+// Flush queues the current value of every series to be pushed by the
+// shard owning the series.
+func (r *reporter) Flush() {
+	r.Lock()
+	defer r.Unlock()
+
+	if r.closed {
+		return
+	}
+	r.flushWithLock()
+}
+
+func (r *reporter) flushWithLock() {
+	r.batch = r.batch[:0]
+	for _, m := range r.metrics {
+		r.batch = m.appendSamples(r.batch)
+	}
+	if len(r.batch) == 0 {
+		return
+	}
+
+	var (
+		timestamp = time.Now().UnixNano() / int64(time.Millisecond)
+		sharded   = make([][]sample, len(r.shards))
+	)
+	for _, s := range r.batch {
+		idx := s.series.hash % uint64(len(r.shards))
+		sharded[idx] = append(sharded[idx], s)
+	}
+	for i, samples := range sharded {
+		for len(samples) > 0 {
+			n := len(samples)
+			if n > r.opts.MaxSamplesPerSend {
+				n = r.opts.MaxSamplesPerSend
+			}
+			if !r.shards[i].enqueue(batch{samples: samples[:n], timestamp: timestamp}) {
+				r.onError(errQueueFull)
+			}
+			samples = samples[n:]
+		}
+	}
+}
+
+// Close pushes the current value of every series and waits for queued
+// write requests to complete, including their retries.
+func (r *reporter) Close() error {
+	r.Lock()
+	if r.closed {
+		r.Unlock()
+		return errAlreadyClosed
+	}
+	r.closed = true
+	r.flushWithLock()
+	r.Unlock()
+
+	for _, s := range r.shards {
+		s.close()
+	}
+	return nil
+}
+
+func (r *reporter) onError(err error) {
+	if r.opts.OnError != nil {
+		r.opts.OnError(err)
+	}
+}
+
+// bucketIndex returns the index of the bucket whose upper bound is the
+// given value, or the +Inf bucket.
+func bucketIndex(bounds []float64, upper float64) int {
+	return sort.SearchFloat64s(bounds, upper)
+}
+
+type counter struct {
+	series *series
+	value  atomic.Int64
+}
+
+func (c *counter) ReportCount(value int64) {
+	c.value.Add(value)
+}
+
+func (c *counter) appendSamples(dst []sample) []sample {
+	return append(dst, sample{series: c.series, value: float64(c.value.Load())})
+}
+
+type gauge struct {
+	series  *series
+	value   atomic.Float64
+	updated atomic.Bool
+}
+
+func (g *gauge) ReportGauge(value float64) {
+	g.value.Store(value)
+	g.updated.Store(true)
+}
+
+func (g *gauge) appendSamples(dst []sample) []sample {
+	if !g.updated.Load() {
+		return dst
+	}
+	return append(dst, sample{series: g.series, value: g.value.Load()})
+}
+
+type timer struct {
+	bounds       []float64
+	bucketSeries []*series
+	sumSeries    *series
+	countSeries  *series
+
+	sync.Mutex
+	counts []uint64
+	sum    float64
+}
+
+func (t *timer) ReportTimer(interval time.Duration) {
+	seconds := interval.Seconds()
+
+	t.Lock()
+	t.counts[bucketIndex(t.bounds, seconds)]++
+	t.sum += seconds
+	t.Unlock()
+}
+
+func (t *timer) appendSamples(dst []sample) []sample {
+	t.Lock()
+	defer t.Unlock()
+
+	var cumulative uint64
+	for i, count := range t.counts {
+		cumulative += count
+		dst = append(dst, sample{series: t.bucketSeries[i], value: float64(cumulative)})
+	}
+	dst = append(dst, sample{series: t.sumSeries, value: t.sum})
+	return append(dst, sample{series: t.countSeries, value: float64(cumulative)})
+}
+
+// histogram is a tally histogram, its sum is estimated from the bounds of
+// the buckets of the samples as tally histograms do not report values.
+type histogram struct {
+	bounds       []float64
+	bucketSeries []*series
+	sumSeries    *series
+	countSeries  *series
+	counts       []atomic.Uint64
+	sum          atomic.Float64
+}
+
+func (h *histogram) ValueBucket(
+	bucketLowerBound float64,
+	bucketUpperBound float64,
+) tally.CachedHistogramBucket {
+	return h.bucket(bucketIndex(h.bounds, bucketUpperBound),
+		bucketLowerBound, bucketUpperBound)
+}
+
+func (h *histogram) DurationBucket(
+	bucketLowerBound time.Duration,
+	bucketUpperBound time.Duration,
+) tally.CachedHistogramBucket {
+	var (
+		idx   = len(h.bounds)
+		lower = -math.MaxFloat64
+		upper = math.MaxFloat64
+	)
+	if bucketUpperBound != time.Duration(math.MaxInt64) {
+		upper = bucketUpperBound.Seconds()
+		idx = bucketIndex(h.bounds, upper)
+	}
+	if bucketLowerBound != time.Duration(math.MinInt64) {
+		lower = bucketLowerBound.Seconds()
+	}
+	return h.bucket(idx, lower, upper)
+}
+
+// bucket returns the bucket at idx, estimating the value of its samples
+// with its upper bound, or its lower bound for the bucket without one.
+func (h *histogram) bucket(idx int, lower, upper float64) *histogramBucket {
+	value := upper
+	if upper == math.MaxFloat64 {
+		value = lower
+		if lower == -math.MaxFloat64 {
+			value = 0
+		}
+	}
+	return &histogramBucket{count: &h.counts[idx], sum: &h.sum, value: value}
+}
+
+func (h *histogram) appendSamples(dst []sample) []sample {
+	var cumulative uint64
+	for i := range h.counts {
+		cumulative += h.counts[i].Load()
+		dst = append(dst, sample{series: h.bucketSeries[i], value: float64(cumulative)})
+	}
+	dst = append(dst, sample{series: h.sumSeries, value: h.sum.Load()})
+	return append(dst, sample{series: h.countSeries, value: float64(cumulative)})
+}
+
+type histogramBucket struct {
+	count *atomic.Uint64
+	sum   *atomic.Float64
+	value float64
+}
+
+func (b *histogramBucket) ReportSamples(value int64) {
+	b.sum.Add(float64(value) * b.value)
+	b.count.Add(uint64(value))
+}
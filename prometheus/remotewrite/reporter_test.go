@@ -0,0 +1,329 @@
+// Copyright (c) 2022 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package remotewrite
+
+import (
+	"io/ioutil"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/golang/snappy"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	tally "github.com/uber-go/tally/v4"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+type receivedSample struct {
+	labels    map[string]string
+	value     float64
+	timestamp int64
+}
+
+// receiver is a stand-in for a remote write endpoint.
+type receiver struct {
+	t *testing.T
+
+	sync.Mutex
+	requests int
+	headers  []http.Header
+	samples  []receivedSample
+	statuses []int
+}
+
+func newReceiver(t *testing.T, statuses ...int) (*receiver, *httptest.Server) {
+	r := &receiver{t: t, statuses: statuses}
+	return r, httptest.NewServer(r)
+}
+
+func (r *receiver) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	r.Lock()
+	defer r.Unlock()
+
+	r.headers = append(r.headers, req.Header)
+	if len(r.statuses) > 0 {
+		status := r.statuses[0]
+		r.statuses = r.statuses[1:]
+		if status != http.StatusOK {
+			w.WriteHeader(status)
+			return
+		}
+	}
+
+	compressed, err := ioutil.ReadAll(req.Body)
+	require.NoError(r.t, err)
+	b, err := snappy.Decode(nil, compressed)
+	require.NoError(r.t, err)
+
+	r.requests++
+	for _, ts := range fields(r.t, b, writeRequestTimeseries) {
+		s := receivedSample{labels: make(map[string]string)}
+		for _, l := range fields(r.t, ts, timeSeriesLabels) {
+			name := fields(r.t, l, labelName)[0]
+			value := fields(r.t, l, labelValue)[0]
+			s.labels[string(name)] = string(value)
+		}
+		samples := fields(r.t, ts, timeSeriesSamples)
+		require.Len(r.t, samples, 1)
+		decodeSample(r.t, samples[0], &s)
+		r.samples = append(r.samples, s)
+	}
+}
+
+// fields returns the raw values of every length delimited field num.
+func fields(t *testing.T, b []byte, num protowire.Number) [][]byte {
+	var result [][]byte
+	for len(b) > 0 {
+		n, typ, l := protowire.ConsumeTag(b)
+		require.True(t, l > 0)
+		b = b[l:]
+		if n == num && typ == protowire.BytesType {
+			v, l := protowire.ConsumeBytes(b)
+			require.True(t, l > 0)
+			result = append(result, v)
+		}
+		l = protowire.ConsumeFieldValue(n, typ, b)
+		require.True(t, l > 0)
+		b = b[l:]
+	}
+	return result
+}
+
+func decodeSample(t *testing.T, b []byte, s *receivedSample) {
+	for len(b) > 0 {
+		n, typ, l := protowire.ConsumeTag(b)
+		require.True(t, l > 0)
+		b = b[l:]
+		switch {
+		case n == sampleValue && typ == protowire.Fixed64Type:
+			v, l := protowire.ConsumeFixed64(b)
+			require.True(t, l > 0)
+			s.value = math.Float64frombits(v)
+		case n == sampleTimestamp && typ == protowire.VarintType:
+			v, l := protowire.ConsumeVarint(b)
+			require.True(t, l > 0)
+			s.timestamp = int64(v)
+		}
+		l = protowire.ConsumeFieldValue(n, typ, b)
+		require.True(t, l > 0)
+		b = b[l:]
+	}
+}
+
+// values returns the last value of each series keyed by its labels.
+func (r *receiver) values() map[string]float64 {
+	r.Lock()
+	defer r.Unlock()
+
+	result := make(map[string]float64)
+	for _, s := range r.samples {
+		result[seriesKey(s.labels)] = s.value
+	}
+	return result
+}
+
+func seriesKey(labels map[string]string) string {
+	var pairs []string
+	for k, v := range labels {
+		if k != metricNameLabel {
+			pairs = append(pairs, k+"="+v)
+		}
+	}
+	sort.Strings(pairs)
+	return labels[metricNameLabel] + "{" + strings.Join(pairs, ",") + "}"
+}
+
+func TestReporterPushesSeries(t *testing.T) {
+	recv, server := newReceiver(t)
+	defer server.Close()
+
+	r, err := NewReporter(Options{
+		URL:            server.URL,
+		Headers:        map[string]string{"Authorization": "Bearer secret"},
+		ExternalLabels: map[string]string{"cluster": "east-1", "env": "ignored"},
+		TimerBuckets:   []float64{0.01, 1},
+	})
+	require.NoError(t, err)
+
+	scope, closer := tally.NewRootScope(tally.ScopeOptions{
+		CachedReporter: r,
+		Separator:      "_",
+		Tags:           map[string]string{"env": "test"},
+	}, 0)
+
+	sub := scope.SubScope("http")
+	sub.Counter("requests").Inc(3)
+	sub.Counter("requests").Inc(2)
+	sub.Gauge("queue-depth").Update(4.5)
+	sub.Timer("latency").Record(5 * time.Millisecond)
+	sub.Timer("latency").Record(100 * time.Millisecond)
+	sub.Histogram("sizes", tally.ValueBuckets{1, 10}).RecordValue(5)
+	sub.Histogram("waits", tally.DurationBuckets{time.Second}).RecordDuration(2 * time.Second)
+	require.NoError(t, closer.Close())
+
+	recv.Lock()
+	headers := recv.headers[0]
+	recv.Unlock()
+	assert.Equal(t, "snappy", headers.Get("Content-Encoding"))
+	assert.Equal(t, "application/x-protobuf", headers.Get("Content-Type"))
+	assert.Equal(t, "0.1.0", headers.Get("X-Prometheus-Remote-Write-Version"))
+	assert.Equal(t, "Bearer secret", headers.Get("Authorization"))
+
+	values := recv.values()
+	expected := map[string]float64{
+		"http_requests{cluster=east_1,env=test}":               5,
+		"http_queue_depth{cluster=east_1,env=test}":            4.5,
+		"http_latency_bucket{cluster=east_1,env=test,le=0.01}": 1,
+		"http_latency_bucket{cluster=east_1,env=test,le=1}":    2,
+		"http_latency_bucket{cluster=east_1,env=test,le=+Inf}": 2,
+		"http_latency_sum{cluster=east_1,env=test}":            0.105,
+		"http_latency_count{cluster=east_1,env=test}":          2,
+		"http_sizes_bucket{cluster=east_1,env=test,le=1}":      0,
+		"http_sizes_bucket{cluster=east_1,env=test,le=10}":     1,
+		"http_sizes_bucket{cluster=east_1,env=test,le=+Inf}":   1,
+		"http_sizes_sum{cluster=east_1,env=test}":              10,
+		"http_sizes_count{cluster=east_1,env=test}":            1,
+		"http_waits_bucket{cluster=east_1,env=test,le=1}":      0,
+		"http_waits_bucket{cluster=east_1,env=test,le=+Inf}":   1,
+		"http_waits_sum{cluster=east_1,env=test}":              1,
+		"http_waits_count{cluster=east_1,env=test}":            1,
+	}
+	for key, value := range expected {
+		require.Contains(t, values, key)
+		assert.InDelta(t, value, values[key], 1e-9, key)
+	}
+}
+
+func TestReporterCountersAreCumulative(t *testing.T) {
+	recv, server := newReceiver(t)
+	defer server.Close()
+
+	r, err := NewReporter(Options{URL: server.URL, Shards: 1})
+	require.NoError(t, err)
+
+	counter := r.AllocateCounter("requests", nil)
+	counter.ReportCount(2)
+	r.Flush()
+	counter.ReportCount(3)
+	require.NoError(t, r.Close())
+	assert.Error(t, r.Close())
+
+	recv.Lock()
+	defer recv.Unlock()
+	require.Len(t, recv.samples, 2)
+	assert.Equal(t, 2.0, recv.samples[0].value)
+	assert.Equal(t, 5.0, recv.samples[1].value)
+	assert.True(t, recv.samples[0].timestamp <= recv.samples[1].timestamp)
+	assert.True(t, recv.samples[0].timestamp > 0)
+}
+
+func TestReporterBatchesAndShards(t *testing.T) {
+	recv, server := newReceiver(t)
+	defer server.Close()
+
+	r, err := NewReporter(Options{
+		URL:               server.URL,
+		Shards:            2,
+		MaxSamplesPerSend: 3,
+	})
+	require.NoError(t, err)
+
+	rep := r.(*reporter)
+	var perShard [2]int
+	for i := 0; i < 20; i++ {
+		tags := map[string]string{"id": string(rune('a' + i))}
+		r.AllocateGauge("gauge", tags).ReportGauge(float64(i))
+		perShard[rep.newSeries("gauge", tags).hash%2]++
+	}
+	require.NoError(t, r.Close())
+
+	recv.Lock()
+	defer recv.Unlock()
+	assert.Len(t, recv.samples, 20)
+	expectedRequests := (perShard[0]+2)/3 + (perShard[1]+2)/3
+	assert.Equal(t, expectedRequests, recv.requests)
+}
+
+func TestReporterSeriesHashIsStable(t *testing.T) {
+	r, err := NewReporter(Options{URL: "http://localhost:9090/api/v1/write"})
+	require.NoError(t, err)
+	defer r.Close()
+
+	rep := r.(*reporter)
+	a := rep.newSeries("requests", map[string]string{"a": "1", "b": "2"})
+	b := rep.newSeries("requests", map[string]string{"b": "2", "a": "1"})
+	c := rep.newSeries("requests", map[string]string{"a": "1", "b": "3"})
+	assert.Equal(t, a.hash, b.hash)
+	assert.Equal(t, a.labels, b.labels)
+	assert.NotEqual(t, a.hash, c.hash)
+}
+
+func TestReporterRetries(t *testing.T) {
+	recv, server := newReceiver(t,
+		http.StatusInternalServerError, http.StatusTooManyRequests)
+	defer server.Close()
+
+	r, err := NewReporter(Options{URL: server.URL, MinBackoff: time.Millisecond})
+	require.NoError(t, err)
+
+	r.AllocateGauge("queue", nil).ReportGauge(1)
+	require.NoError(t, r.Close())
+
+	recv.Lock()
+	defer recv.Unlock()
+	assert.Len(t, recv.headers, 3)
+	assert.Len(t, recv.samples, 1)
+}
+
+func TestReporterDoesNotRetryClientErrors(t *testing.T) {
+	recv, server := newReceiver(t, http.StatusBadRequest)
+	defer server.Close()
+
+	var errs []error
+	r, err := NewReporter(Options{
+		URL:        server.URL,
+		MinBackoff: time.Millisecond,
+		OnError: func(err error) {
+			errs = append(errs, err)
+		},
+	})
+	require.NoError(t, err)
+
+	r.AllocateGauge("queue", nil).ReportGauge(1)
+	require.NoError(t, r.Close())
+
+	recv.Lock()
+	defer recv.Unlock()
+	assert.Len(t, recv.headers, 1)
+	require.Len(t, errs, 1)
+	assert.Contains(t, errs[0].Error(), "400")
+}
+
+func TestReporterRequiresURL(t *testing.T) {
+	_, err := NewReporter(Options{})
+	assert.Equal(t, errNoURL, err)
+}
@@ -0,0 +1,133 @@
+// Copyright (c) 2022 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package remotewrite
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/golang/snappy"
+	"github.com/pkg/errors"
+)
+
+type batch struct {
+	samples   []sample
+	timestamp int64
+}
+
+// shard sends the write requests of a subset of series from a background
+// goroutine, one at a time so that samples of a series stay in order.
+type shard struct {
+	opts Options
+
+	batchCh chan batch
+	wg      sync.WaitGroup
+	once    sync.Once
+}
+
+func newShard(opts Options) *shard {
+	s := &shard{
+		opts:    opts,
+		batchCh: make(chan batch, opts.QueueSize),
+	}
+	s.wg.Add(1)
+	go s.process()
+	return s
+}
+
+func (s *shard) enqueue(b batch) bool {
+	select {
+	case s.batchCh <- b:
+		return true
+	default:
+		return false
+	}
+}
+
+func (s *shard) close() {
+	s.once.Do(func() {
+		close(s.batchCh)
+	})
+	s.wg.Wait()
+}
+
+func (s *shard) process() {
+	defer s.wg.Done()
+
+	for b := range s.batchCh {
+		req := encodeWriteRequest(b.samples, b.timestamp)
+		if err := s.write(snappy.Encode(nil, req)); err != nil && s.opts.OnError != nil {
+			s.opts.OnError(err)
+		}
+	}
+}
+
+// write sends a write request, retrying on network errors, 5xx and 429
+// responses as a Prometheus server would.
+func (s *shard) write(body []byte) error {
+	backoff := s.opts.MinBackoff
+	for attempt := 0; ; attempt++ {
+		retryable, err := s.send(body)
+		if err == nil {
+			return nil
+		}
+		if !retryable || attempt >= s.opts.MaxRetries {
+			return err
+		}
+
+		time.Sleep(backoff)
+		if backoff *= 2; backoff > s.opts.MaxBackoff {
+			backoff = s.opts.MaxBackoff
+		}
+	}
+}
+
+func (s *shard) send(body []byte) (bool, error) {
+	req, err := http.NewRequest(http.MethodPost, s.opts.URL, bytes.NewReader(body))
+	if err != nil {
+		return false, errors.Wrap(err, "failed to create write request")
+	}
+	req.Header.Set("Content-Encoding", "snappy")
+	req.Header.Set("Content-Type", "application/x-protobuf")
+	req.Header.Set("X-Prometheus-Remote-Write-Version", "0.1.0")
+	for k, v := range s.opts.Headers {
+		req.Header.Set(k, v)
+	}
+
+	resp, err := s.opts.HTTPClient.Do(req)
+	if err != nil {
+		return true, errors.Wrap(err, "failed to remote write")
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(ioutil.Discard, resp.Body)
+
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return false, nil
+	}
+
+	err = fmt.Errorf("failed to remote write: %s", resp.Status)
+	return resp.StatusCode >= 500 || resp.StatusCode == http.StatusTooManyRequests, err
+}
@@ -30,12 +30,29 @@ import (
 	prom "github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 	tally "github.com/uber-go/tally/v4"
+	"go.uber.org/atomic"
 )
 
 const (
 	// DefaultSeparator is the default separator that should be used with
 	// a tally scope for a prometheus reporter.
 	DefaultSeparator = "_"
+
+	// CounterSuffix is the suffix of counter names with naming conventions.
+	CounterSuffix = "_total"
+
+	// DefaultNativeHistogramMaxBucketNumber is the default max number of
+	// buckets of native histograms.
+	DefaultNativeHistogramMaxBucketNumber = uint32(160)
+
+	// DefaultNativeHistogramMinResetDuration is the default min duration
+	// between resets of native histograms exceeding their max number of
+	// buckets.
+	DefaultNativeHistogramMinResetDuration = time.Hour
+
+	// SecondsUnit is the unit of timers and duration histograms with naming
+	// conventions, also added as a suffix of their names.
+	SecondsUnit = "seconds"
 )
 
 var (
@@ -77,7 +94,10 @@ func DefaultSummaryObjectives() map[float64]float64 {
 	}
 }
 
-// Reporter is a Prometheus backed tally reporter.
+// Reporter is a Prometheus backed tally reporter. The reporters returned
+// by NewReporter and Configuration.NewReporter also implement io.Closer,
+// to stop serving and pushing metrics, and are closed by the root scope
+// they are used by when it is closed.
 type Reporter interface {
 	tally.CachedStatsReporter
 
@@ -128,6 +148,22 @@ type RegisterTimerOptions struct {
 	TimerType         TimerType
 	HistogramBuckets  []float64
 	SummaryObjectives map[float64]float64
+	SummaryMaxAge     time.Duration
+}
+
+// Rule overrides the reporter defaults for the timers and histograms
+// allocated with a matching name, the first matching rule applies.
+type Rule struct {
+	// Match returns whether the rule applies to a tally metric name.
+	Match func(name string) bool
+
+	// TimerOptions if not nil are the options of matching timers, as if
+	// registered with RegisterTimer.
+	TimerOptions *RegisterTimerOptions
+
+	// Help if not empty is the help text of matching timers and
+	// histograms.
+	Help string
 }
 
 // TimerUnion is a representation of either a summary or a histogram
@@ -142,15 +178,42 @@ type metricID string
 
 type reporter struct {
 	sync.RWMutex
-	registerer      prom.Registerer
-	gatherer        prom.Gatherer
-	timerType       TimerType
-	objectives      map[float64]float64
-	buckets         []float64
-	onRegisterError func(e error)
-	counters        map[metricID]*prom.CounterVec
-	gauges          map[metricID]*prom.GaugeVec
-	timers          map[metricID]*promTimerVec
+	registerer         prom.Registerer
+	gatherer           prom.Gatherer
+	timerType          TimerType
+	objectives         map[float64]float64
+	buckets            []float64
+	onRegisterError    func(e error)
+	openMetrics        bool
+	disableCompression bool
+	conventions        bool
+	exemplarLabels     func(name string, tags map[string]string) prom.Labels
+	native             nativeHistogramOptions
+	unionTagKeys       bool
+	unions             map[string]*unionCollector
+	staleTimeout       time.Duration
+	rules              []Rule
+	staleLock          sync.Mutex
+	stale              map[*series]struct{}
+	units              map[string]string
+	counters           map[metricID]*prom.CounterVec
+	gauges             map[metricID]*prom.GaugeVec
+	timers             map[metricID]*promTimerVec
+	histograms         map[metricID]*bucketHistogramVec
+	pusher             *pusher
+	server             *server
+}
+
+type nativeHistogramOptions struct {
+	bucketFactor     float64
+	maxBucketNumber  uint32
+	minResetDuration time.Duration
+	classicBuckets   bool
+	defaultBuckets   tally.Buckets
+}
+
+func (o nativeHistogramOptions) enabled() bool {
+	return o.bucketFactor > 1
 }
 
 type promTimerVec struct {
@@ -158,56 +221,101 @@ type promTimerVec struct {
 	histogram *prom.HistogramVec
 }
 
+// cachedMetric holds the child of its vector atomically, as the child is
+// replaced when a series removed as stale is restored concurrently with
+// reports.
 type cachedMetric struct {
-	counter     prom.Counter
-	gauge       prom.Gauge
+	counter     atomic.Value // prom.Counter
+	gauge       atomic.Value // prom.Gauge
 	reportTimer func(d time.Duration)
-	histogram   prom.Observer
-	summary     prom.Observer
+	histogram   atomic.Value // prom.Observer
+	summary     atomic.Value // prom.Observer
+	exemplar    func() prom.Labels
+	series      *series
 }
 
 func (m *cachedMetric) ReportCount(value int64) {
-	m.counter.Add(float64(value))
+	m.series.report()
+	m.addCount(value)
+}
+
+func (m *cachedMetric) addCount(value int64) {
+	counter := m.counter.Load().(prom.Counter)
+	if m.exemplar != nil {
+		if adder, ok := counter.(prom.ExemplarAdder); ok {
+			if labels := m.exemplar(); len(labels) > 0 {
+				adder.AddWithExemplar(float64(value), labels)
+				return
+			}
+		}
+	}
+	counter.Add(float64(value))
 }
 
 func (m *cachedMetric) ReportGauge(value float64) {
-	m.gauge.Set(value)
+	m.series.report()
+	m.gauge.Load().(prom.Gauge).Set(value)
 }
 
 func (m *cachedMetric) ReportTimer(interval time.Duration) {
+	m.series.report()
 	m.reportTimer(interval)
 }
 
+// Release implements tally.CachedMetricReleaser.
+func (m *cachedMetric) Release() {
+	m.series.Release()
+}
+
 func (m *cachedMetric) reportTimerHistogram(interval time.Duration) {
-	m.histogram.Observe(float64(interval) / float64(time.Second))
+	m.observeHistogram(float64(interval) / float64(time.Second))
+}
+
+func (m *cachedMetric) observeHistogram(value float64) {
+	histogram := m.histogram.Load().(prom.Observer)
+	if m.exemplar != nil {
+		if observer, ok := histogram.(prom.ExemplarObserver); ok {
+			if labels := m.exemplar(); len(labels) > 0 {
+				observer.ObserveWithExemplar(value, labels)
+				return
+			}
+		}
+	}
+	histogram.Observe(value)
 }
 
 func (m *cachedMetric) reportTimerSummary(interval time.Duration) {
-	m.summary.Observe(float64(interval) / float64(time.Second))
+	m.summary.Load().(prom.Observer).Observe(float64(interval) / float64(time.Second))
 }
 
-func (m *cachedMetric) ValueBucket(
+// observedHistogram is a cached histogram backed by a Prometheus histogram
+// with its own buckets, a native histogram or one registered with
+// RegisterTimer, the values recorded are observed directly rather than
+// bucketed by tally.
+type observedHistogram struct {
+	*cachedMetric
+}
+
+func (h observedHistogram) ValueBucket(
 	bucketLowerBound, bucketUpperBound float64,
 ) tally.CachedHistogramBucket {
-	return cachedHistogramBucket{m, bucketUpperBound}
+	return noopMetric{}
 }
 
-func (m *cachedMetric) DurationBucket(
+func (h observedHistogram) DurationBucket(
 	bucketLowerBound, bucketUpperBound time.Duration,
 ) tally.CachedHistogramBucket {
-	upperBound := float64(bucketUpperBound) / float64(time.Second)
-	return cachedHistogramBucket{m, upperBound}
+	return noopMetric{}
 }
 
-type cachedHistogramBucket struct {
-	metric     *cachedMetric
-	upperBound float64
+func (h observedHistogram) RecordValue(value float64) {
+	h.series.report()
+	h.observeHistogram(value)
 }
 
-func (b cachedHistogramBucket) ReportSamples(value int64) {
-	for i := int64(0); i < value; i++ {
-		b.metric.histogram.Observe(b.upperBound)
-	}
+func (h observedHistogram) RecordDuration(value time.Duration) {
+	h.series.report()
+	h.observeHistogram(float64(value) / float64(time.Second))
 }
 
 type noopMetric struct{}
@@ -225,7 +333,16 @@ func (m noopMetric) DurationBucket(lower, upper time.Duration) tally.CachedHisto
 }
 
 func (r *reporter) HTTPHandler() http.Handler {
-	return promhttp.HandlerFor(r.gatherer, promhttp.HandlerOpts{})
+	if r.openMetrics {
+		handler := newOpenMetricsHandler(unitGatherer{reporter: r})
+		if r.disableCompression {
+			return handler
+		}
+		return gzipHandler(handler)
+	}
+	return promhttp.HandlerFor(r.gatherer, promhttp.HandlerOpts{
+		DisableCompression: r.disableCompression,
+	})
 }
 
 // TimerType describes a type of timer
@@ -265,6 +382,79 @@ type Options struct {
 	// a metric with the registerer fails. Use nil to specify
 	// to panic by default when registering fails.
 	OnRegisterError func(err error)
+
+	// Push if not nil will push the gathered metrics to a Pushgateway
+	// on Flush and Close.
+	Push *PushOptions
+
+	// EnableOpenMetrics if true serves the OpenMetrics exposition format
+	// to scrapers that accept it, with the # UNIT lines, _created samples
+	// and exemplars only that format supports.
+	EnableOpenMetrics bool
+
+	// DisableCompression if true does not gzip the responses of the HTTP
+	// handler to scrapers that accept gzip, which are gzipped by default.
+	DisableCompression bool
+
+	// NamingConventions if true applies the Prometheus naming conventions
+	// to the names of tally metrics: counters are suffixed with _total,
+	// timers and duration histograms with their base unit _seconds.
+	NamingConventions bool
+
+	// ExemplarLabels if not nil is called when a counter is incremented or
+	// a histogram is observed, and non empty labels returned are attached
+	// as an exemplar, typically the trace ID of the operation. Exemplar
+	// labels must not exceed 128 runes in total.
+	ExemplarLabels func(name string, tags map[string]string) prom.Labels
+
+	// NativeHistogramBucketFactor if greater than one backs histogram
+	// timers with Prometheus native histograms, with the growth factor
+	// of their exponential buckets bounding the relative error of the
+	// quantiles estimated from them, e.g. 1.1 for 10%. Native histograms
+	// are only exposed with the protobuf exposition format.
+	NativeHistogramBucketFactor float64
+
+	// NativeHistogramMaxBucketNumber is the max number of buckets of
+	// native histograms, by default DefaultNativeHistogramMaxBucketNumber.
+	NativeHistogramMaxBucketNumber uint32
+
+	// NativeHistogramMinResetDuration is the min duration between resets of
+	// native histograms exceeding their max number of buckets, before which
+	// their resolution is reduced instead, by default
+	// DefaultNativeHistogramMinResetDuration.
+	NativeHistogramMinResetDuration time.Duration
+
+	// NativeHistogramClassicBuckets if true keeps the classic buckets of
+	// native histograms for scrapers that do not support them.
+	NativeHistogramClassicBuckets bool
+
+	// NativeHistogramDefaultBuckets if not nil backs tally histograms
+	// allocated with these buckets with native histograms, recording
+	// values directly rather than as samples of the buckets. Set it to
+	// the DefaultBuckets of the scope options to have histograms created
+	// with tally.DefaultBuckets be native histograms. The _sum of other
+	// tally histograms is estimated from the bounds of the buckets of
+	// their values, as tally only reports the buckets.
+	NativeHistogramDefaultBuckets tally.Buckets
+
+	// UnionTagKeys if true exposes the metrics of the same name with
+	// different sets of tag keys as a single metric, labeled with the
+	// union of the tag keys seen and with empty values for the keys a
+	// metric does not have. Series with an empty tag value fail to be
+	// allocated when a metric of the name does not have the tag key, as
+	// they could not be told apart. By default registering a metric with
+	// the same name but different tag keys fails.
+	UnionTagKeys bool
+
+	// StaleTimeout if greater than zero removes the series not reported
+	// for longer than the timeout, they are exported again when reported
+	// again. Series are removed regardless when the tally scope of their
+	// metric is closed.
+	StaleTimeout time.Duration
+
+	// Rules if not empty override the defaults for the timers and
+	// histograms matching them when they are first allocated.
+	Rules []Rule
 }
 
 // NewReporter returns a new Reporter for Prometheus client backed metrics
@@ -289,6 +479,12 @@ func NewReporter(opts Options) Reporter {
 	if opts.DefaultSummaryObjectives == nil {
 		opts.DefaultSummaryObjectives = DefaultSummaryObjectives()
 	}
+	if opts.NativeHistogramMaxBucketNumber == 0 {
+		opts.NativeHistogramMaxBucketNumber = DefaultNativeHistogramMaxBucketNumber
+	}
+	if opts.NativeHistogramMinResetDuration == 0 {
+		opts.NativeHistogramMinResetDuration = DefaultNativeHistogramMinResetDuration
+	}
 	if opts.OnRegisterError == nil {
 		opts.OnRegisterError = func(err error) {
 			// n.b. Because our forked Prometheus client does not actually emit
@@ -307,17 +503,39 @@ func NewReporter(opts Options) Reporter {
 		}
 	}
 
-	return &reporter{
-		registerer:      opts.Registerer,
-		gatherer:        opts.Gatherer,
-		timerType:       opts.DefaultTimerType,
-		buckets:         opts.DefaultHistogramBuckets,
-		objectives:      opts.DefaultSummaryObjectives,
-		onRegisterError: opts.OnRegisterError,
-		counters:        make(map[metricID]*prom.CounterVec),
-		gauges:          make(map[metricID]*prom.GaugeVec),
-		timers:          make(map[metricID]*promTimerVec),
+	r := &reporter{
+		registerer:         opts.Registerer,
+		gatherer:           opts.Gatherer,
+		timerType:          opts.DefaultTimerType,
+		buckets:            opts.DefaultHistogramBuckets,
+		objectives:         opts.DefaultSummaryObjectives,
+		onRegisterError:    opts.OnRegisterError,
+		openMetrics:        opts.EnableOpenMetrics,
+		disableCompression: opts.DisableCompression,
+		conventions:        opts.NamingConventions,
+		exemplarLabels:     opts.ExemplarLabels,
+		unionTagKeys:       opts.UnionTagKeys,
+		unions:             make(map[string]*unionCollector),
+		staleTimeout:       opts.StaleTimeout,
+		rules:              opts.Rules,
+		stale:              make(map[*series]struct{}),
+		units:              make(map[string]string),
+		counters:           make(map[metricID]*prom.CounterVec),
+		gauges:             make(map[metricID]*prom.GaugeVec),
+		timers:             make(map[metricID]*promTimerVec),
+		histograms:         make(map[metricID]*bucketHistogramVec),
+		native: nativeHistogramOptions{
+			bucketFactor:     opts.NativeHistogramBucketFactor,
+			maxBucketNumber:  opts.NativeHistogramMaxBucketNumber,
+			minResetDuration: opts.NativeHistogramMinResetDuration,
+			classicBuckets:   opts.NativeHistogramClassicBuckets,
+			defaultBuckets:   opts.NativeHistogramDefaultBuckets,
+		},
 	}
+	if opts.Push != nil {
+		r.pusher = newPusher(*opts.Push, opts.Gatherer)
+	}
+	return r
 }
 
 func (r *reporter) RegisterCounter(
@@ -325,7 +543,7 @@ func (r *reporter) RegisterCounter(
 	tagKeys []string,
 	desc string,
 ) (*prom.CounterVec, error) {
-	return r.counterVec(name, tagKeys, desc)
+	return r.counterVec(r.counterName(name), tagKeys, desc)
 }
 
 func (r *reporter) counterVec(
@@ -350,7 +568,7 @@ func (r *reporter) counterVec(
 		tagKeys,
 	)
 
-	if err := r.registerer.Register(ctr); err != nil {
+	if err := r.register(name, "counter", desc, tagKeys, ctr); err != nil {
 		return nil, err
 	}
 
@@ -361,12 +579,22 @@ func (r *reporter) counterVec(
 // AllocateCounter implements tally.CachedStatsReporter.
 func (r *reporter) AllocateCounter(name string, tags map[string]string) tally.CachedCount {
 	tagKeys := keysFromMap(tags)
-	counterVec, err := r.counterVec(name, tagKeys, name+" counter")
+	promName := r.counterName(name)
+	counterVec, err := r.counterVec(promName, tagKeys, name+" counter")
+	if err == nil {
+		err = r.unionTags(promName, tags)
+	}
 	if err != nil {
 		r.onRegisterError(err)
 		return noopMetric{}
 	}
-	return &cachedMetric{counter: counterVec.With(tags)}
+	m := &cachedMetric{exemplar: r.exemplar(name, tags)}
+	m.counter.Store(counterVec.With(tags))
+	m.series = r.newSeries(
+		func() { counterVec.Delete(tags) },
+		func() { m.counter.Store(counterVec.With(tags)) },
+	)
+	return m
 }
 
 func (r *reporter) RegisterGauge(
@@ -399,7 +627,7 @@ func (r *reporter) gaugeVec(
 		tagKeys,
 	)
 
-	if err := r.registerer.Register(g); err != nil {
+	if err := r.register(name, "gauge", desc, tagKeys, g); err != nil {
 		return nil, err
 	}
 
@@ -411,11 +639,20 @@ func (r *reporter) gaugeVec(
 func (r *reporter) AllocateGauge(name string, tags map[string]string) tally.CachedGauge {
 	tagKeys := keysFromMap(tags)
 	gaugeVec, err := r.gaugeVec(name, tagKeys, name+" gauge")
+	if err == nil {
+		err = r.unionTags(name, tags)
+	}
 	if err != nil {
 		r.onRegisterError(err)
 		return noopMetric{}
 	}
-	return &cachedMetric{gauge: gaugeVec.With(tags)}
+	m := &cachedMetric{}
+	m.gauge.Store(gaugeVec.With(tags))
+	m.series = r.newSeries(
+		func() { gaugeVec.Delete(tags) },
+		func() { m.gauge.Store(gaugeVec.With(tags)) },
+	)
+	return m
 }
 
 func (r *reporter) RegisterTimer(
@@ -424,13 +661,14 @@ func (r *reporter) RegisterTimer(
 	desc string,
 	opts *RegisterTimerOptions,
 ) (TimerUnion, error) {
-	timerType, buckets, objectives := r.timerConfig(opts)
+	name = r.timerName(name)
+	timerType, buckets, objectives, maxAge := r.timerConfig(opts)
 	switch timerType {
 	case HistogramTimerType:
-		h, err := r.histogramVec(name, tagKeys, desc, buckets)
+		h, err := r.histogramVec(name, tagKeys, desc, buckets, r.native.enabled())
 		return TimerUnion{TimerType: timerType, Histogram: h}, err
 	case SummaryTimerType:
-		s, err := r.summaryVec(name, tagKeys, desc, objectives)
+		s, err := r.summaryVec(name, tagKeys, desc, objectives, maxAge)
 		return TimerUnion{TimerType: timerType, Summary: s}, err
 	}
 	return TimerUnion{}, errUnknownTimerType
@@ -442,6 +680,7 @@ func (r *reporter) timerConfig(
 	timerType TimerType,
 	buckets []float64,
 	objectives map[float64]float64,
+	maxAge time.Duration,
 ) {
 	timerType = r.timerType
 	objectives = r.objectives
@@ -454,15 +693,36 @@ func (r *reporter) timerConfig(
 		if opts.HistogramBuckets != nil {
 			buckets = opts.HistogramBuckets
 		}
+		maxAge = opts.SummaryMaxAge
 	}
 	return
 }
 
+// rule returns the first rule matching the tally metric name, or nil.
+func (r *reporter) rule(name string) *Rule {
+	for i := range r.rules {
+		if r.rules[i].Match(name) {
+			return &r.rules[i]
+		}
+	}
+	return nil
+}
+
+// help returns the help text of the metric from the rule if any, or the
+// default help text.
+func (r *reporter) help(rule *Rule, defaultHelp string) string {
+	if rule != nil && rule.Help != "" {
+		return rule.Help
+	}
+	return defaultHelp
+}
+
 func (r *reporter) summaryVec(
 	name string,
 	tagKeys []string,
 	desc string,
 	objectives map[float64]float64,
+	maxAge time.Duration,
 ) (*prom.SummaryVec, error) {
 	id := canonicalMetricID(name, tagKeys)
 
@@ -478,11 +738,12 @@ func (r *reporter) summaryVec(
 			Name:       name,
 			Help:       desc,
 			Objectives: objectives,
+			MaxAge:     maxAge,
 		},
 		tagKeys,
 	)
 
-	if err := r.registerer.Register(s); err != nil {
+	if err := r.register(name, "summary", desc, tagKeys, s); err != nil {
 		return nil, err
 	}
 
@@ -495,6 +756,7 @@ func (r *reporter) histogramVec(
 	tagKeys []string,
 	desc string,
 	buckets []float64,
+	native bool,
 ) (*prom.HistogramVec, error) {
 	id := canonicalMetricID(name, tagKeys)
 
@@ -505,16 +767,23 @@ func (r *reporter) histogramVec(
 		return h.histogram, nil
 	}
 
-	h := prom.NewHistogramVec(
-		prom.HistogramOpts{
-			Name:    name,
-			Help:    desc,
-			Buckets: buckets,
-		},
-		tagKeys,
-	)
+	opts := prom.HistogramOpts{
+		Name:    name,
+		Help:    desc,
+		Buckets: buckets,
+	}
+	if native {
+		opts.NativeHistogramBucketFactor = r.native.bucketFactor
+		opts.NativeHistogramMaxBucketNumber = r.native.maxBucketNumber
+		opts.NativeHistogramMinResetDuration = r.native.minResetDuration
+		if !r.native.classicBuckets {
+			opts.Buckets = []float64{}
+		}
+	}
 
-	if err := r.registerer.Register(h); err != nil {
+	h := prom.NewHistogramVec(opts, tagKeys)
+
+	if err := r.register(name, "histogram", desc, tagKeys, h); err != nil {
 		return nil, err
 	}
 
@@ -529,22 +798,46 @@ func (r *reporter) AllocateTimer(name string, tags map[string]string) tally.Cach
 		err   error
 	)
 	tagKeys := keysFromMap(tags)
-	timerType, buckets, objectives := r.timerConfig(nil)
+	promName := r.timerName(name)
+	rule := r.rule(name)
+	var opts *RegisterTimerOptions
+	if rule != nil {
+		opts = rule.TimerOptions
+	}
+	timerType, buckets, objectives, maxAge := r.timerConfig(opts)
 	switch timerType {
 	case HistogramTimerType:
 		var histogramVec *prom.HistogramVec
-		histogramVec, err = r.histogramVec(name, tagKeys, name+" histogram", buckets)
+		histogramVec, err = r.histogramVec(promName, tagKeys,
+			r.help(rule, name+" histogram"), buckets, r.native.enabled())
 		if err == nil {
-			t := &cachedMetric{histogram: histogramVec.With(tags)}
+			err = r.unionTags(promName, tags)
+		}
+		if err == nil {
+			t := &cachedMetric{exemplar: r.exemplar(name, tags)}
+			t.histogram.Store(histogramVec.With(tags))
 			t.reportTimer = t.reportTimerHistogram
+			t.series = r.newSeries(
+				func() { histogramVec.Delete(tags) },
+				func() { t.histogram.Store(histogramVec.With(tags)) },
+			)
 			timer = t
 		}
 	case SummaryTimerType:
 		var summaryVec *prom.SummaryVec
-		summaryVec, err = r.summaryVec(name, tagKeys, name+" summary", objectives)
+		summaryVec, err = r.summaryVec(promName, tagKeys,
+			r.help(rule, name+" summary"), objectives, maxAge)
 		if err == nil {
-			t := &cachedMetric{summary: summaryVec.With(tags)}
+			err = r.unionTags(promName, tags)
+		}
+		if err == nil {
+			t := &cachedMetric{}
+			t.summary.Store(summaryVec.With(tags))
 			t.reportTimer = t.reportTimerSummary
+			t.series = r.newSeries(
+				func() { summaryVec.Delete(tags) },
+				func() { t.summary.Store(summaryVec.With(tags)) },
+			)
 			timer = t
 		}
 	default:
@@ -563,12 +856,206 @@ func (r *reporter) AllocateHistogram(
 	buckets tally.Buckets,
 ) tally.CachedHistogram {
 	tagKeys := keysFromMap(tags)
-	histogramVec, err := r.histogramVec(name, tagKeys, name+" histogram", buckets.AsValues())
+	promName := name
+	if _, ok := buckets.(tally.DurationBuckets); ok {
+		promName = r.timerName(name)
+	}
+	// Histograms of a timer registered with RegisterTimer observe into it.
+	if histogramVec, ok := r.registeredTimerHistogram(promName, tagKeys); ok {
+		if err := r.unionTags(promName, tags); err != nil {
+			r.onRegisterError(err)
+			return noopMetric{}
+		}
+		return r.observedHistogram(name, tags, histogramVec)
+	}
+
+	help := r.help(r.rule(name), name+" histogram")
+	if r.nativeDefaultBuckets(buckets) {
+		histogramVec, err := r.histogramVec(promName, tagKeys, help,
+			buckets.AsValues(), true)
+		if err == nil {
+			err = r.unionTags(promName, tags)
+		}
+		if err != nil {
+			r.onRegisterError(err)
+			return noopMetric{}
+		}
+		return r.observedHistogram(name, tags, histogramVec)
+	}
+
+	histogramVec, err := r.bucketHistogramVec(promName, tagKeys, help, buckets)
+	if err == nil {
+		err = r.unionTags(promName, tags)
+	}
 	if err != nil {
 		r.onRegisterError(err)
 		return noopMetric{}
 	}
-	return &cachedMetric{histogram: histogramVec.With(tags)}
+	return histogramVec.with(tags)
+}
+
+// registeredTimerHistogram returns the histogram vector of a histogram
+// timer registered under the name and tag keys, if any.
+func (r *reporter) registeredTimerHistogram(
+	name string,
+	tagKeys []string,
+) (*prom.HistogramVec, bool) {
+	id := canonicalMetricID(name, tagKeys)
+
+	r.Lock()
+	defer r.Unlock()
+
+	if t, ok := r.timers[id]; ok && t.histogram != nil {
+		return t.histogram, true
+	}
+	return nil, false
+}
+
+func (r *reporter) observedHistogram(
+	name string,
+	tags map[string]string,
+	histogramVec *prom.HistogramVec,
+) observedHistogram {
+	h := &cachedMetric{exemplar: r.exemplar(name, tags)}
+	h.histogram.Store(histogramVec.With(tags))
+	h.series = r.newSeries(
+		func() { histogramVec.Delete(tags) },
+		func() { h.histogram.Store(histogramVec.With(tags)) },
+	)
+	return observedHistogram{h}
+}
+
+func (r *reporter) bucketHistogramVec(
+	name string,
+	tagKeys []string,
+	desc string,
+	buckets tally.Buckets,
+) (*bucketHistogramVec, error) {
+	id := canonicalMetricID(name, tagKeys)
+
+	r.Lock()
+	defer r.Unlock()
+
+	if h, ok := r.histograms[id]; ok {
+		return h, nil
+	}
+
+	h := newBucketHistogramVec(r, name, desc, tagKeys, buckets)
+	if err := r.register(name, "histogram", desc, tagKeys, h); err != nil {
+		return nil, err
+	}
+
+	r.histograms[id] = h
+	return h, nil
+}
+
+// nativeDefaultBuckets returns whether histograms with the buckets are
+// backed by native histograms.
+func (r *reporter) nativeDefaultBuckets(buckets tally.Buckets) bool {
+	defaults := r.native.defaultBuckets
+	if !r.native.enabled() || defaults == nil || buckets == nil {
+		return false
+	}
+	_, durations := buckets.(tally.DurationBuckets)
+	_, defaultDurations := defaults.(tally.DurationBuckets)
+	if durations != defaultDurations {
+		return false
+	}
+
+	values, defaultValues := buckets.AsValues(), defaults.AsValues()
+	if len(values) != len(defaultValues) {
+		return false
+	}
+	for i := range values {
+		if values[i] != defaultValues[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// register registers the vector of a metric, or adds it to the union
+// collector of the metric name with UnionTagKeys. Must be called with
+// the lock held.
+func (r *reporter) register(
+	name string,
+	kind string,
+	desc string,
+	tagKeys []string,
+	vector prom.Collector,
+) error {
+	if !r.unionTagKeys {
+		return r.registerer.Register(vector)
+	}
+
+	union, ok := r.unions[name]
+	if !ok {
+		union = newUnionCollector(name, kind, desc)
+		if err := r.registerer.Register(union); err != nil {
+			return err
+		}
+		r.unions[name] = union
+	}
+	return union.add(kind, tagKeys, vector)
+}
+
+// unionTags adds the tags of a series to the union collector of the
+// metric name with UnionTagKeys.
+func (r *reporter) unionTags(name string, tags map[string]string) error {
+	if !r.unionTagKeys {
+		return nil
+	}
+
+	r.Lock()
+	union, ok := r.unions[name]
+	r.Unlock()
+	if !ok {
+		return nil
+	}
+	return union.addTags(tags)
+}
+
+// counterName returns the name of a counter, suffixed with _total when
+// applying naming conventions.
+func (r *reporter) counterName(name string) string {
+	if !r.conventions || strings.HasSuffix(name, CounterSuffix) {
+		return name
+	}
+	return name + CounterSuffix
+}
+
+// timerName returns the name of a timer or duration histogram, suffixed
+// with its unit when applying naming conventions.
+func (r *reporter) timerName(name string) string {
+	if !r.conventions {
+		return name
+	}
+	if suffix := DefaultSeparator + SecondsUnit; !strings.HasSuffix(name, suffix) {
+		name += suffix
+	}
+
+	r.Lock()
+	r.units[name] = SecondsUnit
+	r.Unlock()
+	return name
+}
+
+// unit returns the unit of a metric family, if any.
+func (r *reporter) unit(name string) (string, bool) {
+	r.RLock()
+	unit, ok := r.units[name]
+	r.RUnlock()
+	return unit, ok
+}
+
+// exemplar returns the exemplar labels func of a metric, if any.
+func (r *reporter) exemplar(name string, tags map[string]string) func() prom.Labels {
+	if r.exemplarLabels == nil {
+		return nil
+	}
+	return func() prom.Labels {
+		return r.exemplarLabels(name, tags)
+	}
 }
 
 func (r *reporter) Capabilities() tally.Capabilities {
@@ -583,8 +1070,30 @@ func (r *reporter) Tagging() bool {
 	return true
 }
 
-// Flush does nothing for prometheus
-func (r *reporter) Flush() {}
+// Flush removes the stale series with a stale timeout, and pushes to the
+// Pushgateway when push is enabled
+func (r *reporter) Flush() {
+	if r.staleTimeout > 0 {
+		r.expireStale()
+	}
+	if r.pusher != nil {
+		r.pusher.flush()
+	}
+}
+
+// Close stops the HTTP server when created from a configuration with a
+// listen address, then pushes to or deletes the group from the
+// Pushgateway when push is enabled.
+func (r *reporter) Close() error {
+	var err error
+	if r.server != nil {
+		err = r.server.close()
+	}
+	if r.pusher != nil {
+		r.pusher.close()
+	}
+	return err
+}
 
 var metricIDKeyValue = "1"
 
@@ -588,3 +588,111 @@ func assertMetric(
 	}
 	require.Fail(t, msgFmt("metric not found"))
 }
+
+func TestTimerNativeHistogram(t *testing.T) {
+	registry := prom.NewRegistry()
+	r := NewReporter(Options{
+		Registerer:                  registry,
+		DefaultTimerType:            HistogramTimerType,
+		NativeHistogramBucketFactor: 1.1,
+	})
+
+	timer := r.AllocateTimer("test_timer", nil)
+	timer.ReportTimer(3 * time.Millisecond)
+	timer.ReportTimer(30 * time.Millisecond)
+	timer.ReportTimer(300 * time.Millisecond)
+
+	metrics := gather(t, registry)
+	require.Len(t, metrics, 1)
+	h := metrics[0].GetMetric()[0].GetHistogram()
+	assert.Equal(t, uint64(3), h.GetSampleCount())
+	assert.InDelta(t, 0.333, h.GetSampleSum(), 1e-9)
+	assert.Equal(t, int32(3), h.GetSchema())
+	assert.NotEmpty(t, h.GetPositiveSpan())
+	assert.Empty(t, h.GetBucket(), "classic buckets must be dropped")
+
+	registry = prom.NewRegistry()
+	r = NewReporter(Options{
+		Registerer:                    registry,
+		DefaultTimerType:              HistogramTimerType,
+		NativeHistogramBucketFactor:   1.1,
+		NativeHistogramClassicBuckets: true,
+	})
+	r.AllocateTimer("test_timer", nil).ReportTimer(3 * time.Millisecond)
+
+	h = gather(t, registry)[0].GetMetric()[0].GetHistogram()
+	assert.NotEmpty(t, h.GetPositiveSpan())
+	assert.Len(t, h.GetBucket(), len(DefaultHistogramBuckets()))
+}
+
+func TestHistogramUsesRegisteredTimer(t *testing.T) {
+	registry := prom.NewRegistry()
+	r := NewReporter(Options{Registerer: registry})
+	_, err := r.RegisterTimer("latency", []string{"route"}, "latency of the routes",
+		&RegisterTimerOptions{
+			TimerType:        HistogramTimerType,
+			HistogramBuckets: []float64{0.01, 1},
+		})
+	require.NoError(t, err)
+
+	// Allocating the histogram must not register another collector, which
+	// would panic with the default OnRegisterError.
+	histogram := r.AllocateHistogram("latency", map[string]string{"route": "/a"},
+		tally.DurationBuckets{time.Second})
+	recorder, ok := histogram.(tally.CachedHistogramRecorder)
+	require.True(t, ok)
+	recorder.RecordDuration(5 * time.Millisecond)
+	recorder.RecordDuration(100 * time.Millisecond)
+
+	var family *dto.MetricFamily
+	for _, f := range gather(t, registry) {
+		if f.GetName() == "latency" {
+			family = f
+		}
+	}
+	require.NotNil(t, family)
+	assert.Equal(t, "latency of the routes", family.GetHelp())
+	h := family.GetMetric()[0].GetHistogram()
+	assert.Equal(t, uint64(2), h.GetSampleCount())
+	assert.InDelta(t, 0.105, h.GetSampleSum(), 1e-9)
+	require.Len(t, h.GetBucket(), 2)
+	assert.Equal(t, uint64(1), h.GetBucket()[0].GetCumulativeCount())
+}
+
+func TestHistogramNativeDefaultBuckets(t *testing.T) {
+	registry := prom.NewRegistry()
+	defaultBuckets := tally.MustMakeExponentialValueBuckets(1, 2, 10)
+	r := NewReporter(Options{
+		Registerer:                    registry,
+		NativeHistogramBucketFactor:   1.1,
+		NativeHistogramDefaultBuckets: defaultBuckets,
+		OnRegisterError:               func(err error) {},
+	})
+	scope, closer := tally.NewRootScope(tally.ScopeOptions{
+		CachedReporter: r,
+		Separator:      DefaultSeparator,
+		DefaultBuckets: defaultBuckets,
+	}, 0)
+
+	native := scope.Histogram("native", tally.DefaultBuckets)
+	native.RecordValue(3.7)
+	native.RecordValue(100.25)
+	classic := scope.Histogram("classic", tally.ValueBuckets{1, 10})
+	classic.RecordValue(3.7)
+	require.NoError(t, closer.Close())
+
+	histograms := make(map[string]*dto.Histogram)
+	for _, family := range gather(t, registry) {
+		histograms[family.GetName()] = family.GetMetric()[0].GetHistogram()
+	}
+
+	require.Contains(t, histograms, "native")
+	assert.Equal(t, uint64(2), histograms["native"].GetSampleCount())
+	assert.Equal(t, 103.95, histograms["native"].GetSampleSum(),
+		"values must be observed exactly rather than as bucket bounds")
+	assert.NotEmpty(t, histograms["native"].GetPositiveSpan())
+
+	require.Contains(t, histograms, "classic")
+	assert.Equal(t, 10.0, histograms["classic"].GetSampleSum())
+	assert.Empty(t, histograms["classic"].GetPositiveSpan())
+}
@@ -0,0 +1,141 @@
+// Copyright (c) 2022 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package prometheus
+
+import (
+	"sync"
+	"time"
+
+	"go.uber.org/atomic"
+)
+
+// series is the lifecycle of a series the reporter exports. It is removed
+// from its vector when the scope of its metric is closed, or with a stale
+// timeout when it has not been reported for longer than the timeout, in
+// which case it is restored when reported again.
+//
+// Reports only count themselves and check whether the series is removed,
+// the mutex is only taken to remove or restore the series.
+type series struct {
+	reporter *reporter
+	remove   func()
+	restore  func()
+	reports  atomic.Uint64
+	removed  atomic.Bool
+
+	sync.Mutex
+	seenReports uint64
+	lastReport  int64
+	released    bool
+}
+
+func (r *reporter) newSeries(remove, restore func()) *series {
+	s := &series{
+		reporter:   r,
+		remove:     remove,
+		restore:    restore,
+		lastReport: time.Now().UnixNano(),
+	}
+	if r.staleTimeout > 0 {
+		r.staleLock.Lock()
+		r.stale[s] = struct{}{}
+		r.staleLock.Unlock()
+	}
+	return s
+}
+
+// report must be called before reporting to the series, it restores the
+// series if it was removed as stale.
+func (s *series) report() {
+	if s.reporter.staleTimeout <= 0 {
+		return
+	}
+
+	s.reports.Inc()
+	if !s.removed.Load() {
+		return
+	}
+
+	s.Lock()
+	if s.removed.Load() && !s.released {
+		s.restore()
+		s.removed.Store(false)
+	}
+	s.Unlock()
+}
+
+// Release implements tally.CachedMetricReleaser.
+func (s *series) Release() {
+	s.Lock()
+	s.released = true
+	if !s.removed.Load() {
+		s.remove()
+		s.removed.Store(true)
+	}
+	s.Unlock()
+
+	if s.reporter.staleTimeout > 0 {
+		s.reporter.staleLock.Lock()
+		delete(s.reporter.stale, s)
+		s.reporter.staleLock.Unlock()
+	}
+}
+
+func (s *series) expire(now int64, timeout time.Duration) {
+	s.Lock()
+	defer s.Unlock()
+
+	if s.removed.Load() {
+		return
+	}
+	if reports := s.reports.Load(); reports != s.seenReports {
+		s.seenReports = reports
+		s.lastReport = now
+		return
+	}
+	if now-s.lastReport <= int64(timeout) {
+		return
+	}
+
+	// The series is marked removed before checking for reports again, so
+	// that a concurrent report either is seen here or sees the series
+	// removed and restores it once removed.
+	s.removed.Store(true)
+	if s.reports.Load() != s.seenReports {
+		s.removed.Store(false)
+		return
+	}
+	s.remove()
+}
+
+// expireStale removes the series not reported for longer than the stale
+// timeout. The time of the reports is the time of the first flush they
+// are seen by, so that reporting does not need to read the clock.
+func (r *reporter) expireStale() {
+	now := time.Now().UnixNano()
+
+	r.staleLock.Lock()
+	defer r.staleLock.Unlock()
+
+	for s := range r.stale {
+		s.expire(now, r.staleTimeout)
+	}
+}
@@ -0,0 +1,196 @@
+// Copyright (c) 2022 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package prometheus
+
+import (
+	"io"
+	"sync"
+	"testing"
+	"time"
+
+	prom "github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	tally "github.com/uber-go/tally/v4"
+)
+
+func gatherNames(t *testing.T, registry *prom.Registry) map[string]int {
+	names := make(map[string]int)
+	for _, family := range gather(t, registry) {
+		names[family.GetName()] = len(family.GetMetric())
+	}
+	return names
+}
+
+func TestSeriesReleasedOnScopeClose(t *testing.T) {
+	registry := prom.NewRegistry()
+	r := NewReporter(Options{
+		Registerer:       registry,
+		DefaultTimerType: HistogramTimerType,
+		OnRegisterError:  func(err error) {},
+	})
+	root, closer := tally.NewRootScope(tally.ScopeOptions{
+		CachedReporter: r,
+		Separator:      DefaultSeparator,
+	}, 10*time.Millisecond)
+	defer closer.Close()
+
+	for _, id := range []string{"a", "b"} {
+		scope := root.Tagged(map[string]string{"id": id})
+		scope.Counter("requests").Inc(1)
+		scope.Gauge("load").Update(1)
+		scope.Timer("latency").Record(time.Millisecond)
+		scope.Histogram("size", tally.ValueBuckets{1}).RecordValue(1)
+	}
+
+	seriesCount := func(n int) func() bool {
+		return func() bool {
+			names := gatherNames(t, registry)
+			for _, name := range []string{"requests", "load", "latency", "size"} {
+				if names[name] != n {
+					return false
+				}
+			}
+			return true
+		}
+	}
+	require.Eventually(t, seriesCount(2), time.Second, time.Millisecond)
+
+	// The next report after closing a subscope removes its series.
+	require.NoError(t, root.Tagged(map[string]string{"id": "a"}).(io.Closer).Close())
+	require.Eventually(t, seriesCount(1), time.Second, time.Millisecond)
+}
+
+func TestSeriesStaleTimeout(t *testing.T) {
+	registry := prom.NewRegistry()
+	r := NewReporter(Options{
+		Registerer:   registry,
+		StaleTimeout: time.Millisecond,
+	})
+
+	counter := r.AllocateCounter("requests", map[string]string{"id": "a"})
+	counter.ReportCount(2)
+	histogram := r.AllocateHistogram("size", nil, tally.ValueBuckets{1})
+	histogram.ValueBucket(0, 1).ReportSamples(1)
+	r.Flush()
+	assert.Equal(t, map[string]int{"requests": 1, "size": 1}, gatherNames(t, registry))
+
+	time.Sleep(2 * time.Millisecond)
+	r.Flush()
+	assert.Empty(t, gatherNames(t, registry))
+
+	// Reported again, the series are exported again.
+	counter.ReportCount(3)
+	histogram.ValueBucket(0, 1).ReportSamples(1)
+	metrics := gather(t, registry)
+	require.Len(t, metrics, 2)
+	for _, family := range metrics {
+		switch family.GetName() {
+		case "requests":
+			assert.Equal(t, 3.0, family.GetMetric()[0].GetCounter().GetValue())
+		case "size":
+			assert.Equal(t, uint64(2), family.GetMetric()[0].GetHistogram().GetSampleCount())
+		}
+	}
+}
+
+func TestSeriesStaleTimeoutWhileReporting(t *testing.T) {
+	registry := prom.NewRegistry()
+	r := NewReporter(Options{
+		Registerer:   registry,
+		StaleTimeout: time.Nanosecond,
+	})
+
+	counter := r.AllocateCounter("requests", nil)
+	histogram := r.AllocateHistogram("size", nil, tally.ValueBuckets{1})
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-done:
+					return
+				default:
+				}
+				counter.ReportCount(1)
+				histogram.ValueBucket(0, 1).ReportSamples(1)
+			}
+		}()
+	}
+	for i := 0; i < 100; i++ {
+		r.Flush()
+	}
+	close(done)
+	wg.Wait()
+
+	// A report after the series were removed as stale exports them again.
+	time.Sleep(time.Millisecond)
+	r.Flush()
+	r.Flush()
+	counter.ReportCount(1)
+	histogram.ValueBucket(0, 1).ReportSamples(1)
+	assert.Equal(t, map[string]int{"requests": 1, "size": 1}, gatherNames(t, registry))
+}
+
+func TestSeriesRestoredWhileReporting(t *testing.T) {
+	r := NewReporter(Options{
+		Registerer:   prom.NewRegistry(),
+		StaleTimeout: time.Nanosecond,
+	})
+	counter := r.AllocateCounter("requests", nil).(*cachedMetric)
+	timer := r.AllocateTimer("latency", nil).(*cachedMetric)
+
+	// Reports that checked the series before it was removed as stale
+	// report to its child while another report restores it.
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		counter.addCount(1)
+		timer.reportTimer(time.Millisecond)
+	}()
+
+	now := time.Now().Add(time.Hour).UnixNano()
+	for _, m := range []*cachedMetric{counter, timer} {
+		m.series.expire(now, time.Nanosecond)
+		require.True(t, m.series.removed.Load())
+		m.series.report()
+		require.False(t, m.series.removed.Load())
+	}
+	wg.Wait()
+}
+
+func TestSeriesReleasedNotRestored(t *testing.T) {
+	registry := prom.NewRegistry()
+	r := NewReporter(Options{
+		Registerer:   registry,
+		StaleTimeout: time.Hour,
+	})
+
+	gauge := r.AllocateGauge("load", nil)
+	gauge.ReportGauge(1)
+	gauge.(tally.CachedMetricReleaser).Release()
+	gauge.ReportGauge(2)
+	assert.Empty(t, gatherNames(t, registry))
+}
@@ -0,0 +1,201 @@
+// Copyright (c) 2022 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package prometheus
+
+import (
+	"context"
+	"crypto/subtle"
+	"crypto/tls"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultHealthCheckPath is the path of the health check handler.
+	DefaultHealthCheckPath = "/healthz"
+
+	// serverShutdownTimeout is how long Close waits for in flight
+	// scrapes to complete before closing their connections.
+	serverShutdownTimeout = 5 * time.Second
+)
+
+// server is the HTTP server serving the metrics of a reporter configured
+// with a listen address.
+type server struct {
+	server   *http.Server
+	listener net.Listener
+	onError  func(err error)
+	done     chan struct{}
+}
+
+func newServer(
+	handler http.Handler,
+	listener net.Listener,
+	readTimeout time.Duration,
+	writeTimeout time.Duration,
+	tlsConfig *tls.Config,
+	onError func(err error),
+) *server {
+	if tlsConfig != nil {
+		listener = tls.NewListener(listener, tlsConfig)
+	}
+	return &server{
+		server: &http.Server{
+			Handler:      handler,
+			ReadTimeout:  readTimeout,
+			WriteTimeout: writeTimeout,
+			TLSConfig:    tlsConfig,
+		},
+		listener: listener,
+		onError:  onError,
+		done:     make(chan struct{}),
+	}
+}
+
+func (s *server) serve() {
+	defer close(s.done)
+	if err := s.server.Serve(s.listener); err != nil && err != http.ErrServerClosed {
+		s.onError(err)
+	}
+}
+
+// close gracefully shuts down the server, waiting for in flight scrapes
+// up to the shutdown timeout.
+func (s *server) close() error {
+	ctx, cancel := context.WithTimeout(context.Background(), serverShutdownTimeout)
+	defer cancel()
+
+	err := s.server.Shutdown(ctx)
+	if err != nil {
+		err = s.server.Close()
+	}
+	<-s.done
+	return err
+}
+
+// certReloader loads a TLS certificate and key pair, reloading them when
+// either file is modified so that rotated certificates are picked up
+// without a restart.
+type certReloader struct {
+	sync.Mutex
+	certFile    string
+	keyFile     string
+	cert        *tls.Certificate
+	certModTime time.Time
+	keyModTime  time.Time
+}
+
+func newCertReloader(certFile, keyFile string) (*certReloader, error) {
+	r := &certReloader{certFile: certFile, keyFile: keyFile}
+	if _, err := r.certificate(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+func (r *certReloader) certificate() (*tls.Certificate, error) {
+	certInfo, err := os.Stat(r.certFile)
+	if err != nil {
+		return nil, err
+	}
+	keyInfo, err := os.Stat(r.keyFile)
+	if err != nil {
+		return nil, err
+	}
+
+	r.Lock()
+	defer r.Unlock()
+
+	if r.cert != nil &&
+		certInfo.ModTime().Equal(r.certModTime) &&
+		keyInfo.ModTime().Equal(r.keyModTime) {
+		return r.cert, nil
+	}
+
+	cert, err := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+	if err != nil {
+		if r.cert != nil {
+			// Keep serving the previous certificate while the files are
+			// being rotated, the pair may be momentarily mismatched.
+			return r.cert, nil
+		}
+		return nil, err
+	}
+
+	r.cert = &cert
+	r.certModTime = certInfo.ModTime()
+	r.keyModTime = keyInfo.ModTime()
+	return r.cert, nil
+}
+
+func (r *certReloader) tlsConfig() *tls.Config {
+	return &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		GetCertificate: func(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+			return r.certificate()
+		},
+	}
+}
+
+// basicAuthHandler requires the requests to be authenticated with
+// basic auth.
+func basicAuthHandler(username, password string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		user, pass, ok := req.BasicAuth()
+		if !ok || !secureEqual(user, username) || !secureEqual(pass, password) {
+			w.Header().Set("WWW-Authenticate", `Basic realm="metrics"`)
+			http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+			return
+		}
+		next.ServeHTTP(w, req)
+	})
+}
+
+// bearerTokenHandler requires the requests to be authenticated with a
+// bearer token.
+func bearerTokenHandler(token string, next http.Handler) http.Handler {
+	const prefix = "Bearer "
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		auth := req.Header.Get("Authorization")
+		if !strings.HasPrefix(auth, prefix) || !secureEqual(auth[len(prefix):], token) {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+			return
+		}
+		next.ServeHTTP(w, req)
+	})
+}
+
+func secureEqual(a, b string) bool {
+	return subtle.ConstantTimeCompare([]byte(a), []byte(b)) == 1
+}
+
+// healthCheckHandler always responds OK, for liveness probes.
+func healthCheckHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		_, _ = w.Write([]byte("ok\n"))
+	})
+}
@@ -0,0 +1,236 @@
+// Copyright (c) 2022 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package prometheus
+
+import (
+	"compress/gzip"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"os"
+	"path"
+	"testing"
+	"time"
+
+	prom "github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newServingReporter(t *testing.T, cfg Configuration) (*reporter, string) {
+	cfg.ListenAddress = "127.0.0.1:0"
+	r, err := cfg.NewReporter(ConfigurationOptions{Registry: prom.NewRegistry()})
+	require.NoError(t, err)
+	rep := r.(*reporter)
+	require.NotNil(t, rep.server)
+	rep.AllocateCounter("requests", nil).ReportCount(1)
+	return rep, rep.server.listener.Addr().String()
+}
+
+func get(t *testing.T, client *http.Client, url string, header http.Header) *http.Response {
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	require.NoError(t, err)
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	res, err := client.Do(req)
+	require.NoError(t, err)
+	return res
+}
+
+func TestServerClose(t *testing.T) {
+	r, addr := newServingReporter(t, Configuration{})
+
+	res := get(t, http.DefaultClient, "http://"+addr+"/metrics", nil)
+	res.Body.Close()
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+
+	require.NoError(t, r.Close())
+	_, err := http.Get("http://" + addr + "/metrics")
+	assert.Error(t, err)
+}
+
+func TestServerAuth(t *testing.T) {
+	tests := []struct {
+		name   string
+		cfg    Configuration
+		header http.Header
+	}{
+		{
+			name: "basic auth",
+			cfg: Configuration{
+				BasicAuth: &BasicAuthConfiguration{Username: "user", Password: "secret"},
+			},
+			header: http.Header{"Authorization": {"Basic dXNlcjpzZWNyZXQ="}},
+		},
+		{
+			name:   "bearer token",
+			cfg:    Configuration{BearerToken: "token"},
+			header: http.Header{"Authorization": {"Bearer token"}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.cfg.HealthCheck = true
+			r, addr := newServingReporter(t, tt.cfg)
+			defer r.Close()
+
+			res := get(t, http.DefaultClient, "http://"+addr+"/metrics", nil)
+			res.Body.Close()
+			assert.Equal(t, http.StatusUnauthorized, res.StatusCode)
+
+			res = get(t, http.DefaultClient, "http://"+addr+"/metrics",
+				http.Header{"Authorization": {"Bearer wrong"}})
+			res.Body.Close()
+			assert.Equal(t, http.StatusUnauthorized, res.StatusCode)
+
+			res = get(t, http.DefaultClient, "http://"+addr+"/metrics", tt.header)
+			res.Body.Close()
+			assert.Equal(t, http.StatusOK, res.StatusCode)
+
+			// The health check is not authenticated.
+			res = get(t, http.DefaultClient, "http://"+addr+DefaultHealthCheckPath, nil)
+			res.Body.Close()
+			assert.Equal(t, http.StatusOK, res.StatusCode)
+		})
+	}
+
+	_, err := Configuration{
+		ListenAddress: "127.0.0.1:0",
+		BasicAuth:     &BasicAuthConfiguration{Username: "user"},
+		BearerToken:   "token",
+	}.NewReporter(ConfigurationOptions{Registry: prom.NewRegistry()})
+	assert.Equal(t, errInvalidAuthConfiguration, err)
+}
+
+func TestServerCompression(t *testing.T) {
+	for _, openMetrics := range []bool{false, true} {
+		r, addr := newServingReporter(t, Configuration{OpenMetrics: openMetrics})
+
+		// Disable the transparent decompression of the client.
+		client := &http.Client{Transport: &http.Transport{DisableCompression: true}}
+		res := get(t, client, "http://"+addr+"/metrics",
+			http.Header{"Accept-Encoding": {"gzip"}})
+		assert.Equal(t, "gzip", res.Header.Get("Content-Encoding"))
+		gz, err := gzip.NewReader(res.Body)
+		require.NoError(t, err)
+		body, err := ioutil.ReadAll(gz)
+		require.NoError(t, err)
+		res.Body.Close()
+		assert.Contains(t, string(body), "requests 1")
+
+		res = get(t, client, "http://"+addr+"/metrics", nil)
+		body, err = ioutil.ReadAll(res.Body)
+		require.NoError(t, err)
+		res.Body.Close()
+		assert.Empty(t, res.Header.Get("Content-Encoding"))
+		assert.Contains(t, string(body), "requests 1")
+
+		require.NoError(t, r.Close())
+	}
+}
+
+func TestServerDisableCompression(t *testing.T) {
+	for _, openMetrics := range []bool{false, true} {
+		r, addr := newServingReporter(t, Configuration{
+			DisableCompression: true,
+			OpenMetrics:        openMetrics,
+		})
+
+		client := &http.Client{Transport: &http.Transport{DisableCompression: true}}
+		res := get(t, client, "http://"+addr+"/metrics",
+			http.Header{"Accept-Encoding": {"gzip"}})
+		body, err := ioutil.ReadAll(res.Body)
+		require.NoError(t, err)
+		res.Body.Close()
+		assert.Empty(t, res.Header.Get("Content-Encoding"))
+		assert.Contains(t, string(body), "requests 1")
+
+		require.NoError(t, r.Close())
+	}
+}
+
+func TestServerTLSReload(t *testing.T) {
+	dir, err := ioutil.TempDir("", "tally-test-prometheus")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	certFile, keyFile := path.Join(dir, "cert.pem"), path.Join(dir, "key.pem")
+	writeCertificate(t, certFile, keyFile, "first")
+
+	r, addr := newServingReporter(t, Configuration{
+		TLS: &TLSConfiguration{CertFile: certFile, KeyFile: keyFile},
+	})
+	defer r.Close()
+
+	client := &http.Client{Transport: &http.Transport{
+		TLSClientConfig:   &tls.Config{InsecureSkipVerify: true},
+		DisableKeepAlives: true,
+	}}
+	serverName := func() string {
+		res := get(t, client, "https://"+addr+"/metrics", nil)
+		res.Body.Close()
+		assert.Equal(t, http.StatusOK, res.StatusCode)
+		return res.TLS.PeerCertificates[0].Subject.CommonName
+	}
+	assert.Equal(t, "first", serverName())
+
+	writeCertificate(t, certFile, keyFile, "second")
+	future := time.Now().Add(time.Minute)
+	require.NoError(t, os.Chtimes(certFile, future, future))
+	require.NoError(t, os.Chtimes(keyFile, future, future))
+	assert.Equal(t, "second", serverName())
+
+	_, err = Configuration{
+		ListenAddress: "127.0.0.1:0",
+		TLS:           &TLSConfiguration{CertFile: certFile},
+	}.NewReporter(ConfigurationOptions{Registry: prom.NewRegistry()})
+	assert.Equal(t, errInvalidTLSConfiguration, err)
+}
+
+func writeCertificate(t *testing.T, certFile, keyFile, commonName string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: commonName},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	cert, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	require.NoError(t, err)
+	keyBytes, err := x509.MarshalECPrivateKey(key)
+	require.NoError(t, err)
+
+	require.NoError(t, ioutil.WriteFile(certFile,
+		pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert}), 0600))
+	require.NoError(t, ioutil.WriteFile(keyFile,
+		pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyBytes}), 0600))
+}
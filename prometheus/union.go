@@ -0,0 +1,197 @@
+// Copyright (c) 2022 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package prometheus
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+
+	prom "github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+// unionCollector collects the vectors of a metric name created for
+// different sets of tag keys as a single metric, labeled with the union
+// of the tag keys seen. Labels a vector does not have are empty, which
+// Prometheus treats the same as absent. As they could not be told apart,
+// series with an empty tag value are rejected when a vector does not
+// have the tag key, and so are vectors without the key of such a series.
+//
+// It is an unchecked collector as its labels change as vectors are added,
+// so it is registered once per metric name.
+type unionCollector struct {
+	sync.RWMutex
+	name    string
+	kind    string
+	help    string
+	keys    []string
+	vectors []prom.Collector
+
+	// vectorKeys are the tag keys of each vector and emptyKeys the keys
+	// of which a series has an empty value.
+	vectorKeys [][]string
+	emptyKeys  map[string]struct{}
+}
+
+func newUnionCollector(name, kind, help string) *unionCollector {
+	return &unionCollector{name: name, kind: kind, help: help}
+}
+
+// add adds the vector of a set of tag keys, extending the union of the
+// label keys with any new keys. The keys and vectors are copied on write
+// as Collect and the label pairs it creates keep referencing them.
+func (c *unionCollector) add(kind string, tagKeys []string, vector prom.Collector) error {
+	if kind != c.kind {
+		return fmt.Errorf("metric %s is already registered as a %s, cannot add a %s",
+			c.name, c.kind, kind)
+	}
+
+	c.Lock()
+	defer c.Unlock()
+
+	for key := range c.emptyKeys {
+		if !hasKey(tagKeys, key) {
+			return fmt.Errorf("metric %s has series with an empty %s tag, cannot add tag keys %v without it",
+				c.name, key, tagKeys)
+		}
+	}
+	c.vectorKeys = append(c.vectorKeys, tagKeys)
+
+	vectors := make([]prom.Collector, 0, len(c.vectors)+1)
+	vectors = append(vectors, c.vectors...)
+	c.vectors = append(vectors, vector)
+
+	keys := c.keys
+	for _, key := range tagKeys {
+		idx := sort.SearchStrings(keys, key)
+		if idx < len(keys) && keys[idx] == key {
+			continue
+		}
+		added := make([]string, 0, len(keys)+1)
+		added = append(added, keys[:idx]...)
+		added = append(added, key)
+		keys = append(added, keys[idx:]...)
+	}
+	c.keys = keys
+	return nil
+}
+
+// addTags adds the tags of a series of a vector, failing if a tag value
+// is empty and a vector does not have its key.
+func (c *unionCollector) addTags(tags map[string]string) error {
+	c.Lock()
+	defer c.Unlock()
+
+	for key, value := range tags {
+		if value != "" {
+			continue
+		}
+		for _, tagKeys := range c.vectorKeys {
+			if !hasKey(tagKeys, key) {
+				return fmt.Errorf("metric %s has tag keys %v without %s, cannot add a series with an empty %s tag",
+					c.name, tagKeys, key, key)
+			}
+		}
+		if c.emptyKeys == nil {
+			c.emptyKeys = make(map[string]struct{})
+		}
+		c.emptyKeys[key] = struct{}{}
+	}
+	return nil
+}
+
+func (c *unionCollector) Describe(ch chan<- *prom.Desc) {
+	// Unchecked, the labels change as vectors are added.
+}
+
+func (c *unionCollector) Collect(ch chan<- prom.Metric) {
+	c.RLock()
+	keys := c.keys
+	vectors := c.vectors
+	c.RUnlock()
+
+	desc := prom.NewDesc(c.name, c.help, keys, nil)
+	metrics := make(chan prom.Metric)
+	go func() {
+		for _, vector := range vectors {
+			vector.Collect(metrics)
+		}
+		close(metrics)
+	}()
+
+	for metric := range metrics {
+		var m dto.Metric
+		if err := metric.Write(&m); err != nil {
+			ch <- prom.NewInvalidMetric(desc, err)
+			continue
+		}
+		ch <- unionMetric{desc: desc, metric: &m, labels: unionLabels(keys, m.GetLabel())}
+	}
+}
+
+// unionLabels returns the label pairs of all keys, sorted as the keys,
+// with an empty value for the keys missing from the labels.
+func unionLabels(keys []string, labels []*dto.LabelPair) []*dto.LabelPair {
+	values := make(map[string]string, len(labels))
+	for _, label := range labels {
+		values[label.GetName()] = label.GetValue()
+	}
+
+	result := make([]*dto.LabelPair, 0, len(keys))
+	for i := range keys {
+		value := values[keys[i]]
+		result = append(result, &dto.LabelPair{Name: &keys[i], Value: &value})
+	}
+	return result
+}
+
+// unionMetric is a metric written by a vector relabeled with the union
+// of the label keys.
+type unionMetric struct {
+	desc   *prom.Desc
+	metric *dto.Metric
+	labels []*dto.LabelPair
+}
+
+func (m unionMetric) Desc() *prom.Desc {
+	return m.desc
+}
+
+func (m unionMetric) Write(out *dto.Metric) error {
+	out.Label = m.labels
+	out.Counter = m.metric.Counter
+	out.Gauge = m.metric.Gauge
+	out.Summary = m.metric.Summary
+	out.Histogram = m.metric.Histogram
+	out.Untyped = m.metric.Untyped
+	out.TimestampMs = m.metric.TimestampMs
+	return nil
+}
+
+func hasKey(keys []string, key string) bool {
+	for _, k := range keys {
+		if k == key {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,217 @@
+// Copyright (c) 2022 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package prometheus
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	prom "github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestUnionTagKeys(t *testing.T) {
+	registry := prom.NewRegistry()
+	r := NewReporter(Options{
+		Registerer:   registry,
+		UnionTagKeys: true,
+	})
+
+	r.AllocateCounter("requests", map[string]string{"region": "east"}).ReportCount(1)
+	r.AllocateCounter("requests", map[string]string{"host": "a"}).ReportCount(2)
+	r.AllocateCounter("requests", map[string]string{"host": "b", "region": "west"}).ReportCount(3)
+	r.AllocateGauge("load", map[string]string{"host": "a"}).ReportGauge(0.5)
+
+	assertMetric(t, gather(t, registry), metric{
+		name:  "requests",
+		mtype: dto.MetricType_COUNTER,
+		instances: []instance{
+			{
+				labels:  map[string]string{"host": "", "region": "east"},
+				counter: counterValue(1),
+			},
+			{
+				labels:  map[string]string{"host": "a", "region": ""},
+				counter: counterValue(2),
+			},
+			{
+				labels:  map[string]string{"host": "b", "region": "west"},
+				counter: counterValue(3),
+			},
+		},
+	})
+	assertMetric(t, gather(t, registry), metric{
+		name:  "load",
+		mtype: dto.MetricType_GAUGE,
+		instances: []instance{
+			{
+				labels: map[string]string{"host": "a"},
+				gauge:  gaugeValue(0.5),
+			},
+		},
+	})
+}
+
+func TestUnionTagKeysTimers(t *testing.T) {
+	registry := prom.NewRegistry()
+	r := NewReporter(Options{
+		Registerer:       registry,
+		DefaultTimerType: HistogramTimerType,
+		UnionTagKeys:     true,
+	})
+
+	r.AllocateTimer("latency", map[string]string{"route": "/a"}).ReportTimer(time.Millisecond)
+	r.AllocateTimer("latency", nil).ReportTimer(time.Millisecond)
+
+	metrics := gather(t, registry)
+	require.Len(t, metrics, 1)
+	require.Len(t, metrics[0].GetMetric(), 2)
+	for _, m := range metrics[0].GetMetric() {
+		require.Len(t, m.GetLabel(), 1)
+		assert.Equal(t, "route", m.GetLabel()[0].GetName())
+		assert.Equal(t, uint64(1), m.GetHistogram().GetSampleCount())
+	}
+}
+
+func TestUnionTagKeysAddedWhileGathering(t *testing.T) {
+	registry := prom.NewRegistry()
+	r := NewReporter(Options{
+		Registerer:   registry,
+		UnionTagKeys: true,
+	})
+	for _, key := range []string{"region", "shard", "zone"} {
+		r.AllocateCounter("requests", map[string]string{key: "1"}).ReportCount(1)
+	}
+
+	gathered := gather(t, registry)
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 100; i++ {
+			key := fmt.Sprintf("a%03d", i)
+			r.AllocateCounter("requests", map[string]string{key: "1"}).ReportCount(1)
+		}
+	}()
+	for i := 0; i < 100; i++ {
+		for _, m := range gather(t, registry)[0].GetMetric() {
+			labels := m.GetLabel()
+			for j := 1; j < len(labels); j++ {
+				assert.True(t, labels[j-1].GetName() < labels[j].GetName())
+			}
+		}
+	}
+	wg.Wait()
+
+	// The labels gathered before the keys were added are unchanged.
+	var names []string
+	for _, label := range gathered[0].GetMetric()[0].GetLabel() {
+		names = append(names, label.GetName())
+	}
+	assert.Equal(t, []string{"region", "shard", "zone"}, names)
+
+	metrics := gather(t, registry)[0].GetMetric()
+	require.Len(t, metrics, 103)
+	assert.Len(t, metrics[0].GetLabel(), 103)
+}
+
+func TestUnionTagKeysKindMismatch(t *testing.T) {
+	var registerErr error
+	r := NewReporter(Options{
+		Registerer:      prom.NewRegistry(),
+		UnionTagKeys:    true,
+		OnRegisterError: func(err error) { registerErr = err },
+	})
+
+	r.AllocateCounter("requests", map[string]string{"a": "1"})
+	r.AllocateGauge("requests", map[string]string{"b": "1"})
+	require.Error(t, registerErr)
+	assert.Contains(t, registerErr.Error(), "already registered as a counter")
+}
+
+func TestUnionTagKeysEmptyValue(t *testing.T) {
+	var registerErrs []error
+	registry := prom.NewRegistry()
+	r := NewReporter(Options{
+		Registerer:      registry,
+		UnionTagKeys:    true,
+		OnRegisterError: func(err error) { registerErrs = append(registerErrs, err) },
+	})
+
+	// An empty value of a key the other vector does not have would be
+	// exported with the same labels as the series of that vector.
+	r.AllocateCounter("requests", map[string]string{"a": "1"}).ReportCount(1)
+	r.AllocateCounter("requests", map[string]string{"a": "1", "b": ""}).ReportCount(2)
+	require.Len(t, registerErrs, 1)
+	assert.Contains(t, registerErrs[0].Error(), "cannot add a series with an empty b tag")
+
+	// So is a vector without the key of a series with an empty value.
+	r.AllocateCounter("responses", map[string]string{"a": "1", "b": ""}).ReportCount(2)
+	r.AllocateCounter("responses", map[string]string{"a": "1"}).ReportCount(1)
+	require.Len(t, registerErrs, 2)
+	assert.Contains(t, registerErrs[1].Error(), "cannot add tag keys [a] without it")
+
+	// Empty values of the keys all vectors have are fine.
+	r.AllocateCounter("requests", map[string]string{"a": ""}).ReportCount(3)
+	require.Len(t, registerErrs, 2)
+
+	metrics := gather(t, registry)
+	assertMetric(t, metrics, metric{
+		name:  "requests",
+		mtype: dto.MetricType_COUNTER,
+		instances: []instance{
+			{
+				labels:  map[string]string{"a": "", "b": ""},
+				counter: counterValue(3),
+			},
+			{
+				labels:  map[string]string{"a": "1", "b": ""},
+				counter: counterValue(1),
+			},
+		},
+	})
+	assertMetric(t, metrics, metric{
+		name:  "responses",
+		mtype: dto.MetricType_COUNTER,
+		instances: []instance{
+			{
+				labels:  map[string]string{"a": "1", "b": ""},
+				counter: counterValue(2),
+			},
+		},
+	})
+}
+
+func TestDifferentTagKeysWithoutUnion(t *testing.T) {
+	var registerErr error
+	r := NewReporter(Options{
+		Registerer:      prom.NewRegistry(),
+		OnRegisterError: func(err error) { registerErr = err },
+	})
+
+	r.AllocateCounter("requests", map[string]string{"region": "east"})
+	r.AllocateCounter("requests", map[string]string{"host": "a"})
+	assert.Error(t, registerErr)
+}
@@ -138,3 +138,22 @@ type CachedHistogram interface {
 type CachedHistogramBucket interface {
 	ReportSamples(value int64)
 }
+
+// CachedMetricReleaser is implemented by cached counters, gauges, timers
+// and histograms that hold resources in the backend, such as the series
+// they are exported as. Release is called when a closed scope clears its
+// metrics, after their last report.
+type CachedMetricReleaser interface {
+	Release()
+}
+
+// CachedHistogramRecorder is implemented by cached histograms that record
+// values as they are recorded rather than reporting samples of buckets,
+// for backends that choose the buckets themselves. Values of histograms
+// allocated as a CachedHistogramRecorder are not bucketed by the scope.
+type CachedHistogramRecorder interface {
+	CachedHistogram
+
+	RecordValue(value float64)
+	RecordDuration(value time.Duration)
+}
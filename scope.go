@@ -22,6 +22,7 @@ package tally
 
 import (
 	"io"
+	"sort"
 	"sync"
 	"time"
 
@@ -200,7 +201,13 @@ func (s *scope) report(r StatsReporter) {
 	}
 	s.gm.RUnlock()
 
-	// we do nothing for timers here because timers report directly to ths StatsReporter without buffering
+	// timers report directly to the StatsReporter without buffering, we only
+	// roll over their aggregates for snapshots here
+	s.tm.RLock()
+	for _, timer := range s.timers {
+		timer.report()
+	}
+	s.tm.RUnlock()
 
 	s.hm.RLock()
 	for name, histogram := range s.histograms {
@@ -222,7 +229,13 @@ func (s *scope) cachedReport() {
 	}
 	s.gm.RUnlock()
 
-	// we do nothing for timers here because timers report directly to ths StatsReporter without buffering
+	// timers report directly to the StatsReporter without buffering, we only
+	// roll over their aggregates for snapshots here
+	s.tm.RLock()
+	for _, timer := range s.timers {
+		timer.report()
+	}
+	s.tm.RUnlock()
 
 	s.hm.RLock()
 	for _, histogram := range s.histogramsSlice {
@@ -358,6 +371,9 @@ func (s *scope) Timer(name string) Timer {
 	t := newTimer(
 		s.fullyQualifiedName(name), s.tags, s.reporter, cachedTimer,
 	)
+	if s.registry.snapshotted.Load() {
+		t.aggregating = 1
+	}
 	s.timers[name] = t
 
 	return t
@@ -444,10 +460,11 @@ func (s *scope) Capabilities() Capabilities {
 
 func (s *scope) Snapshot() Snapshot {
 	snap := newSnapshot()
+	s.registry.snapshotted.Store(true)
 
 	s.registry.ForEachScope(func(ss *scope) {
 		// NB(r): tags are immutable, no lock required to read.
-		tags := make(map[string]string, len(s.tags))
+		tags := make(map[string]string, len(ss.tags))
 		for k, v := range ss.tags {
 			tags[k] = v
 		}
@@ -479,9 +496,10 @@ func (s *scope) Snapshot() Snapshot {
 			name := ss.fullyQualifiedName(key)
 			id := KeyForPrefixedStringMap(name, tags)
 			snap.timers[id] = &timerSnapshot{
-				name:   name,
-				tags:   tags,
-				values: t.snapshot(),
+				name:         name,
+				tags:         tags,
+				values:       t.snapshot(),
+				lastReported: t.snapshotAggregates(),
 			}
 		}
 		ss.tm.RUnlock()
@@ -502,6 +520,51 @@ func (s *scope) Snapshot() Snapshot {
 	return snap
 }
 
+func (s *scope) Scopes() []ScopeInfo {
+	var (
+		seen   = make(map[*scope]struct{})
+		scopes []ScopeInfo
+	)
+
+	s.registry.ForEachScope(func(ss *scope) {
+		// NB: the root scope is registered in every bucket and scopes may
+		// be registered under both their sanitized and unsanitized keys.
+		if _, ok := seen[ss]; ok {
+			return
+		}
+		seen[ss] = struct{}{}
+
+		info := ScopeInfo{
+			Prefix: ss.prefix,
+			Tags:   make(map[string]string, len(ss.tags)),
+		}
+		for k, v := range ss.tags {
+			info.Tags[k] = v
+		}
+
+		ss.cm.RLock()
+		info.Counters = len(ss.counters)
+		ss.cm.RUnlock()
+		ss.gm.RLock()
+		info.Gauges = len(ss.gauges)
+		ss.gm.RUnlock()
+		ss.tm.RLock()
+		info.Timers = len(ss.timers)
+		ss.tm.RUnlock()
+		ss.hm.RLock()
+		info.Histograms = len(ss.histograms)
+		ss.hm.RUnlock()
+
+		scopes = append(scopes, info)
+	})
+
+	sort.Slice(scopes, func(i, j int) bool {
+		return KeyForPrefixedStringMap(scopes[i].Prefix, scopes[i].Tags) <
+			KeyForPrefixedStringMap(scopes[j].Prefix, scopes[j].Tags)
+	})
+	return scopes
+}
+
 func (s *scope) Close() error {
 	// n.b. Once this flag is set, the next scope report will remove it from
 	//      the registry and clear its metrics.
@@ -521,6 +584,34 @@ func (s *scope) Close() error {
 	return nil
 }
 
+// releaseMetrics releases the cached metrics of a closed scope, so that
+// the reporter stops exporting them.
+func (s *scope) releaseMetrics() {
+	s.cm.RLock()
+	for _, c := range s.countersSlice {
+		releaseCached(c.cachedCount)
+	}
+	s.cm.RUnlock()
+
+	s.gm.RLock()
+	for _, g := range s.gaugesSlice {
+		releaseCached(g.cachedGauge)
+	}
+	s.gm.RUnlock()
+
+	s.tm.RLock()
+	for _, t := range s.timers {
+		releaseCached(t.cachedTimer)
+	}
+	s.tm.RUnlock()
+
+	s.hm.RLock()
+	for _, h := range s.histogramsSlice {
+		releaseCached(h.cached)
+	}
+	s.hm.RUnlock()
+}
+
 func (s *scope) clearMetrics() {
 	s.cm.Lock()
 	s.gm.Lock()
@@ -580,12 +671,41 @@ func (s *scope) copyAndSanitizeMap(tags map[string]string) map[string]string {
 // all emitted values have a given prefix or set of tags
 type TestScope interface {
 	Scope
+	Snapshotter
+}
 
+// Snapshotter takes read-only snapshots of the metrics of a scope and all
+// of its subscopes. The scopes returned by NewRootScope and NewTestScope,
+// and their subscopes, implement Snapshotter.
+//
+// Taking a snapshot does not reset counters or consume the values pending
+// to be reported, so it is safe to use alongside periodic reporting, e.g.
+// from a debug endpoint.
+type Snapshotter interface {
 	// Snapshot returns a copy of all values since the last report execution,
-	// this is an expensive operation and should only be use for testing purposes
+	// this is an expensive operation and should not be called on hot paths
 	Snapshot() Snapshot
 }
 
+// ScopeLister lists the scopes registered with a root scope. The scopes
+// returned by NewRootScope and NewTestScope, and their subscopes, implement
+// ScopeLister.
+type ScopeLister interface {
+	// Scopes returns the root scope and all of its subscopes ordered by
+	// prefix and tags.
+	Scopes() []ScopeInfo
+}
+
+// ScopeInfo describes a scope and the number of metrics created on it.
+type ScopeInfo struct {
+	Prefix     string            `json:"prefix"`
+	Tags       map[string]string `json:"tags"`
+	Counters   int               `json:"counters"`
+	Gauges     int               `json:"gauges"`
+	Timers     int               `json:"timers"`
+	Histograms int               `json:"histograms"`
+}
+
 // Snapshot is a snapshot of values since last report execution
 type Snapshot interface {
 	// Counters returns a snapshot of all counter summations since last report execution
@@ -633,10 +753,32 @@ type TimerSnapshot interface {
 	// Tags returns the tags
 	Tags() map[string]string
 
-	// Values returns the values
+	// Values returns the values, these are only retained by scopes
+	// without a reporter
 	Values() []time.Duration
 }
 
+// TimerAggregatesSnapshot is implemented by the timer snapshots taken by
+// the scopes returned by NewRootScope and NewTestScope. Timers allocated
+// after the scopes were first snapshotted compute aggregates from their
+// allocation, while the timers allocated before only compute aggregates
+// once included in a snapshot, so their first snapshot holds no
+// aggregates. Take a snapshot before allocating timers to aggregate all
+// their values.
+type TimerAggregatesSnapshot interface {
+	// LastReported returns the aggregates of the values recorded during
+	// the last report interval
+	LastReported() TimerAggregates
+}
+
+// TimerAggregates are aggregates of the values recorded by a timer.
+type TimerAggregates struct {
+	Count int64         `json:"count"`
+	Sum   time.Duration `json:"sum"`
+	Min   time.Duration `json:"min"`
+	Max   time.Duration `json:"max"`
+}
+
 // HistogramSnapshot is a snapshot of a histogram
 type HistogramSnapshot interface {
 	// Name returns the name
@@ -743,9 +885,10 @@ func (s *gaugeSnapshot) Value() float64 {
 }
 
 type timerSnapshot struct {
-	name   string
-	tags   map[string]string
-	values []time.Duration
+	name         string
+	tags         map[string]string
+	values       []time.Duration
+	lastReported TimerAggregates
 }
 
 func (s *timerSnapshot) Name() string {
@@ -760,6 +903,10 @@ func (s *timerSnapshot) Values() []time.Duration {
 	return s.values
 }
 
+func (s *timerSnapshot) LastReported() TimerAggregates {
+	return s.lastReported
+}
+
 type histogramSnapshot struct {
 	name      string
 	tags      map[string]string
@@ -47,6 +47,9 @@ type scopeRegistry struct {
 	subscopes []*scopeBucket
 	// Toggles internal metrics reporting.
 	skipInternalMetrics bool
+	// Set once the scopes are snapshotted, after which timers compute
+	// aggregates from their allocation.
+	snapshotted atomic.Bool
 }
 
 type scopeBucket struct {
@@ -86,6 +89,7 @@ func (r *scopeRegistry) Report(reporter StatsReporter) {
 
 			if s.closed.Load() {
 				r.removeWithRLock(subscopeBucket, name)
+				r.release(s)
 				s.clearMetrics()
 			}
 		}
@@ -106,6 +110,7 @@ func (r *scopeRegistry) CachedReport() {
 
 			if s.closed.Load() {
 				r.removeWithRLock(subscopeBucket, name)
+				r.release(s)
 				s.clearMetrics()
 			}
 		}
@@ -116,11 +121,11 @@ func (r *scopeRegistry) CachedReport() {
 
 func (r *scopeRegistry) ForEachScope(f func(*scope)) {
 	for _, subscopeBucket := range r.subscopes {
+		subscopeBucket.mu.RLock()
 		for _, s := range subscopeBucket.s {
-			subscopeBucket.mu.RLock()
 			f(s)
-			subscopeBucket.mu.RUnlock()
 		}
+		subscopeBucket.mu.RUnlock()
 	}
 }
 
@@ -216,6 +221,15 @@ func (r *scopeRegistry) purgeIfRootClosed() {
 	}
 }
 
+// release releases the cached metrics of a closed subscope. The metrics
+// are not released when the root scope is closed, as the reporter is
+// then closed after a last flush of all metrics.
+func (r *scopeRegistry) release(s *scope) {
+	if !r.root.closed.Load() {
+		s.releaseMetrics()
+	}
+}
+
 func (r *scopeRegistry) removeWithRLock(subscopeBucket *scopeBucket, key string) {
 	// n.b. This function must lock the registry for writing and return it to an
 	//      RLocked state prior to exiting. Defer order is important (LIFO).
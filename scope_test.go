@@ -1128,6 +1128,154 @@ func TestSnapshot(t *testing.T) {
 	}
 }
 
+func TestScopes(t *testing.T) {
+	s := NewTestScope("foo", map[string]string{"env": "test"})
+	s.Counter("beep").Inc(1)
+	s.Tagged(map[string]string{"service": "test"}).Gauge("boop").Update(1)
+	s.SubScope("bar").Timer("brrr").Record(time.Second)
+	s.SubScope("bar").Histogram("fizz", nil).RecordValue(1)
+
+	lister, ok := s.(ScopeLister)
+	require.True(t, ok)
+	assert.Equal(t, []ScopeInfo{
+		{
+			Prefix:   "foo",
+			Tags:     map[string]string{"env": "test"},
+			Counters: 1,
+		},
+		{
+			Prefix: "foo",
+			Tags:   map[string]string{"env": "test", "service": "test"},
+			Gauges: 1,
+		},
+		{
+			Prefix:     "foo.bar",
+			Tags:       map[string]string{"env": "test"},
+			Timers:     1,
+			Histograms: 1,
+		},
+	}, lister.Scopes())
+}
+
+func TestSnapshotWithReporter(t *testing.T) {
+	r := newTestStatsReporter()
+	s := newRootScope(ScopeOptions{Reporter: r, skipInternalMetrics: true}, 0)
+	defer s.Close()
+
+	var root Scope = s
+	snapshotter, ok := root.(Snapshotter)
+	require.True(t, ok)
+
+	r.cg.Add(1)
+	s.Counter("beep").Inc(3)
+	timer := s.Timer("brrr")
+
+	// Taking a snapshot must not consume the values pending to be reported.
+	// Timers only compute aggregates once snapshotted.
+	snap := snapshotter.Snapshot()
+	assert.EqualValues(t, 3, snap.Counters()["beep+"].Value())
+	assert.Empty(t, snap.Timers()["brrr+"].Values())
+	assert.Equal(t, TimerAggregates{}, timerLastReported(snap.Timers()["brrr+"]))
+
+	r.tg.Add(2)
+	timer.Record(time.Second)
+	timer.Record(3 * time.Second)
+
+	s.reportLoopRun()
+	r.WaitAll()
+	assert.EqualValues(t, 3, r.getCounters()["beep"].val)
+
+	snap = snapshotter.Snapshot()
+	assert.EqualValues(t, 0, snap.Counters()["beep+"].Value())
+	assert.Equal(t, TimerAggregates{
+		Count: 2,
+		Sum:   4 * time.Second,
+		Min:   time.Second,
+		Max:   3 * time.Second,
+	}, timerLastReported(snap.Timers()["brrr+"]))
+
+	// An interval without any values is reported as such.
+	s.reportLoopRun()
+	snap = snapshotter.Snapshot()
+	assert.Equal(t, TimerAggregates{}, timerLastReported(snap.Timers()["brrr+"]))
+}
+
+func TestTimerAggregatesOnlyOnceSnapshotted(t *testing.T) {
+	tm := newTimer("t", nil, NullStatsReporter, nil)
+	tm.Record(time.Second)
+	tm.report()
+	assert.Equal(t, TimerAggregates{}, tm.aggregates.lastReported())
+
+	assert.Equal(t, TimerAggregates{}, tm.snapshotAggregates())
+	tm.Record(time.Second)
+	tm.report()
+	assert.Equal(t, TimerAggregates{
+		Count: 1,
+		Sum:   time.Second,
+		Min:   time.Second,
+		Max:   time.Second,
+	}, tm.snapshotAggregates())
+}
+
+func TestTimerAggregatesFromAllocationOnceSnapshotted(t *testing.T) {
+	s, closer := NewRootScope(ScopeOptions{Reporter: NullStatsReporter}, 0)
+	defer closer.Close()
+	snapshotter := s.(Snapshotter)
+
+	snapshotter.Snapshot()
+	tm := s.Tagged(map[string]string{"a": "b"}).Timer("t")
+	tm.Record(time.Second)
+	s.(*scope).reportLoopRun()
+
+	snap := snapshotter.Snapshot()
+	assert.Equal(t, TimerAggregates{
+		Count: 1,
+		Sum:   time.Second,
+		Min:   time.Second,
+		Max:   time.Second,
+	}, timerLastReported(snap.Timers()["t+a=b"]))
+}
+
+func TestTimerAggregatesConcurrentReport(t *testing.T) {
+	tm := newTimer("t", nil, NullStatsReporter, nil)
+	tm.snapshotAggregates()
+
+	var (
+		wg    sync.WaitGroup
+		count int64
+	)
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 1; j <= 1000; j++ {
+				tm.Record(time.Duration(j))
+			}
+		}()
+	}
+
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+	for finished := false; !finished; {
+		select {
+		case <-done:
+			finished = true
+		default:
+		}
+		tm.report()
+		agg := tm.snapshotAggregates()
+		count += agg.Count
+		if agg.Count > 0 {
+			// The bounds of an interval are those of its values.
+			require.True(t, agg.Min >= 1 && agg.Min <= agg.Max, agg)
+		}
+	}
+	assert.EqualValues(t, 4000, count)
+}
+
 func TestCapabilities(t *testing.T) {
 	r := newTestStatsReporter()
 	s, closer := NewRootScope(ScopeOptions{Reporter: r, skipInternalMetrics: true}, 0)
@@ -1235,3 +1383,87 @@ func TestScopeFlushOnClose(t *testing.T) {
 	assert.EqualValues(t, 1, counters["foo"].val)
 	assert.NoError(t, closer.Close())
 }
+
+type releasingMetric struct {
+	name     string
+	released *[]string
+}
+
+func (m releasingMetric) ReportCount(value int64)            {}
+func (m releasingMetric) ReportGauge(value float64)          {}
+func (m releasingMetric) ReportTimer(interval time.Duration) {}
+func (m releasingMetric) ReportSamples(value int64)          {}
+
+func (m releasingMetric) ValueBucket(lower, upper float64) CachedHistogramBucket {
+	return m
+}
+
+func (m releasingMetric) DurationBucket(lower, upper time.Duration) CachedHistogramBucket {
+	return m
+}
+
+func (m releasingMetric) Release() {
+	*m.released = append(*m.released, m.name)
+}
+
+type releasingReporter struct {
+	released []string
+}
+
+func (r *releasingReporter) metric(name string) releasingMetric {
+	return releasingMetric{name: name, released: &r.released}
+}
+
+func (r *releasingReporter) AllocateCounter(name string, tags map[string]string) CachedCount {
+	return r.metric(name)
+}
+
+func (r *releasingReporter) AllocateGauge(name string, tags map[string]string) CachedGauge {
+	return r.metric(name)
+}
+
+func (r *releasingReporter) AllocateTimer(name string, tags map[string]string) CachedTimer {
+	return r.metric(name)
+}
+
+func (r *releasingReporter) AllocateHistogram(
+	name string,
+	tags map[string]string,
+	buckets Buckets,
+) CachedHistogram {
+	return r.metric(name)
+}
+
+func (r *releasingReporter) Capabilities() Capabilities {
+	return capabilitiesReportingTagging
+}
+
+func (r *releasingReporter) Flush() {}
+
+func TestCachedMetricsReleasedOnSubscopeClose(t *testing.T) {
+	r := &releasingReporter{}
+	root, closer := NewRootScope(ScopeOptions{
+		CachedReporter:      r,
+		skipInternalMetrics: true,
+	}, 0)
+
+	sub := root.Tagged(map[string]string{"a": "b"})
+	sub.Counter("c").Inc(1)
+	sub.Gauge("g").Update(1)
+	sub.Timer("t").Record(time.Second)
+	sub.Histogram("h", ValueBuckets{1}).RecordValue(1)
+	root.Counter("root").Inc(1)
+
+	s := root.(*scope)
+	s.reportRegistry()
+	assert.Empty(t, r.released)
+
+	require.NoError(t, sub.(*scope).Close())
+	s.reportRegistry()
+	assert.ElementsMatch(t, []string{"c", "g", "t", "h"}, r.released)
+
+	// Closing the root scope does not release the metrics, the reporter
+	// is closed right after a last report.
+	require.NoError(t, closer.Close())
+	assert.Len(t, r.released, 4)
+}
@@ -0,0 +1,334 @@
+// Copyright (c) 2022 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package tally
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"time"
+)
+
+// MetricType is the type of a metric.
+type MetricType int
+
+// The metric types tracked by a scope.
+const (
+	CounterType MetricType = iota + 1
+	GaugeType
+	TimerType
+	HistogramType
+)
+
+func (t MetricType) String() string {
+	switch t {
+	case CounterType:
+		return "counter"
+	case GaugeType:
+		return "gauge"
+	case TimerType:
+		return "timer"
+	case HistogramType:
+		return "histogram"
+	}
+	return "unknown"
+}
+
+// SeriesDiff describes how a single series differs between two snapshots.
+// Deltas are computed as the second snapshot's value less the first's, with
+// a missing series treated as having zero values.
+type SeriesDiff struct {
+	Type MetricType
+	Name string
+	Tags map[string]string
+
+	// CounterDelta is the change in value of a counter.
+	CounterDelta int64
+
+	// GaugeDelta is the change in value of a gauge.
+	GaugeDelta float64
+
+	// TimerCountDelta is the change in the number of values of a timer.
+	TimerCountDelta int
+
+	// ValueBucketDeltas are the non-zero changes in samples per bucket upper
+	// bound of a value histogram.
+	ValueBucketDeltas map[float64]int64
+
+	// DurationBucketDeltas are the non-zero changes in samples per bucket
+	// upper bound of a duration histogram.
+	DurationBucketDeltas map[time.Duration]int64
+}
+
+// SnapshotDiff is the difference between two snapshots.
+type SnapshotDiff struct {
+	// Added are series present only in the second snapshot.
+	Added []SeriesDiff
+
+	// Removed are series present only in the first snapshot.
+	Removed []SeriesDiff
+
+	// Changed are series present in both snapshots with different values.
+	Changed []SeriesDiff
+}
+
+// Empty returns whether the two snapshots held the same series and values.
+func (d SnapshotDiff) Empty() bool {
+	return len(d.Added) == 0 && len(d.Removed) == 0 && len(d.Changed) == 0
+}
+
+// String returns a human readable description of the diff, one series per
+// line prefixed with "+" when added, "-" when removed and "~" when changed.
+func (d SnapshotDiff) String() string {
+	var lines []string
+	for _, s := range d.Added {
+		lines = append(lines, "+ "+s.String())
+	}
+	for _, s := range d.Removed {
+		lines = append(lines, "- "+s.String())
+	}
+	for _, s := range d.Changed {
+		lines = append(lines, "~ "+s.String())
+	}
+
+	sort.Slice(lines, func(i, j int) bool {
+		// Order by series rather than by change marker.
+		return lines[i][2:] < lines[j][2:]
+	})
+	return strings.Join(lines, "\n")
+}
+
+func (s SeriesDiff) String() string {
+	var b strings.Builder
+	b.WriteString(s.Type.String())
+	b.WriteByte(' ')
+	b.WriteString(s.Name)
+	b.WriteByte('{')
+	for i, k := range sortedTagKeys(s.Tags) {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		b.WriteString(k)
+		b.WriteByte('=')
+		b.WriteString(s.Tags[k])
+	}
+	b.WriteString("} ")
+
+	switch s.Type {
+	case CounterType:
+		fmt.Fprintf(&b, "%+d", s.CounterDelta)
+	case GaugeType:
+		fmt.Fprintf(&b, "%+g", s.GaugeDelta)
+	case TimerType:
+		fmt.Fprintf(&b, "%+d values", s.TimerCountDelta)
+	case HistogramType:
+		var parts []string
+		for _, bound := range sortedValueBounds(s.ValueBucketDeltas) {
+			parts = append(parts, fmt.Sprintf("%g:%+d", bound, s.ValueBucketDeltas[bound]))
+		}
+		for _, bound := range sortedDurationBounds(s.DurationBucketDeltas) {
+			parts = append(parts, fmt.Sprintf("%v:%+d", bound, s.DurationBucketDeltas[bound]))
+		}
+		b.WriteString("[" + strings.Join(parts, " ") + "]")
+	}
+	return b.String()
+}
+
+// Diff returns the series added, removed and changed between snapshots a
+// and b. Either snapshot may be nil, in which case it is treated as empty.
+func Diff(a, b Snapshot) SnapshotDiff {
+	if a == nil {
+		a = newSnapshot()
+	}
+	if b == nil {
+		b = newSnapshot()
+	}
+
+	var d SnapshotDiff
+
+	ac, bc := a.Counters(), b.Counters()
+	for _, key := range sortedCounterKeys(bc) {
+		s := bc[key]
+		prev, ok := ac[key]
+		diff := SeriesDiff{Type: CounterType, Name: s.Name(), Tags: s.Tags()}
+		if !ok {
+			diff.CounterDelta = s.Value()
+			d.Added = append(d.Added, diff)
+		} else if delta := s.Value() - prev.Value(); delta != 0 {
+			diff.CounterDelta = delta
+			d.Changed = append(d.Changed, diff)
+		}
+	}
+	for _, key := range sortedCounterKeys(ac) {
+		if s := ac[key]; bc[key] == nil {
+			d.Removed = append(d.Removed, SeriesDiff{
+				Type:         CounterType,
+				Name:         s.Name(),
+				Tags:         s.Tags(),
+				CounterDelta: -s.Value(),
+			})
+		}
+	}
+
+	ag, bg := a.Gauges(), b.Gauges()
+	for _, key := range sortedGaugeKeys(bg) {
+		s := bg[key]
+		prev, ok := ag[key]
+		diff := SeriesDiff{Type: GaugeType, Name: s.Name(), Tags: s.Tags()}
+		if !ok {
+			diff.GaugeDelta = s.Value()
+			d.Added = append(d.Added, diff)
+		} else if math.Float64bits(s.Value()) != math.Float64bits(prev.Value()) {
+			diff.GaugeDelta = s.Value() - prev.Value()
+			d.Changed = append(d.Changed, diff)
+		}
+	}
+	for _, key := range sortedGaugeKeys(ag) {
+		if s := ag[key]; bg[key] == nil {
+			d.Removed = append(d.Removed, SeriesDiff{
+				Type:       GaugeType,
+				Name:       s.Name(),
+				Tags:       s.Tags(),
+				GaugeDelta: -s.Value(),
+			})
+		}
+	}
+
+	at, bt := a.Timers(), b.Timers()
+	for _, key := range sortedTimerKeys(bt) {
+		s := bt[key]
+		prev, ok := at[key]
+		diff := SeriesDiff{Type: TimerType, Name: s.Name(), Tags: s.Tags()}
+		if !ok {
+			diff.TimerCountDelta = len(s.Values())
+			d.Added = append(d.Added, diff)
+		} else if !durationsEqual(s.Values(), prev.Values()) {
+			diff.TimerCountDelta = len(s.Values()) - len(prev.Values())
+			d.Changed = append(d.Changed, diff)
+		}
+	}
+	for _, key := range sortedTimerKeys(at) {
+		if s := at[key]; bt[key] == nil {
+			d.Removed = append(d.Removed, SeriesDiff{
+				Type:            TimerType,
+				Name:            s.Name(),
+				Tags:            s.Tags(),
+				TimerCountDelta: -len(s.Values()),
+			})
+		}
+	}
+
+	ah, bh := a.Histograms(), b.Histograms()
+	for _, key := range sortedHistogramKeys(bh) {
+		s := bh[key]
+		var prevValues map[float64]int64
+		var prevDurations map[time.Duration]int64
+		prev, ok := ah[key]
+		if ok {
+			prevValues, prevDurations = prev.Values(), prev.Durations()
+		}
+
+		diff := SeriesDiff{
+			Type:                 HistogramType,
+			Name:                 s.Name(),
+			Tags:                 s.Tags(),
+			ValueBucketDeltas:    valueBucketDeltas(prevValues, s.Values()),
+			DurationBucketDeltas: durationBucketDeltas(prevDurations, s.Durations()),
+		}
+		if !ok {
+			d.Added = append(d.Added, diff)
+		} else if len(diff.ValueBucketDeltas) > 0 || len(diff.DurationBucketDeltas) > 0 {
+			d.Changed = append(d.Changed, diff)
+		}
+	}
+	for _, key := range sortedHistogramKeys(ah) {
+		if s := ah[key]; bh[key] == nil {
+			d.Removed = append(d.Removed, SeriesDiff{
+				Type:                 HistogramType,
+				Name:                 s.Name(),
+				Tags:                 s.Tags(),
+				ValueBucketDeltas:    valueBucketDeltas(s.Values(), nil),
+				DurationBucketDeltas: durationBucketDeltas(s.Durations(), nil),
+			})
+		}
+	}
+
+	return d
+}
+
+func durationsEqual(a, b []time.Duration) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func valueBucketDeltas(a, b map[float64]int64) map[float64]int64 {
+	var deltas map[float64]int64
+	add := func(bound float64, delta int64) {
+		if delta == 0 {
+			return
+		}
+		if deltas == nil {
+			deltas = make(map[float64]int64)
+		}
+		deltas[bound] = delta
+	}
+
+	for bound, v := range b {
+		add(bound, v-a[bound])
+	}
+	for bound, v := range a {
+		if _, ok := b[bound]; !ok {
+			add(bound, -v)
+		}
+	}
+	return deltas
+}
+
+func durationBucketDeltas(a, b map[time.Duration]int64) map[time.Duration]int64 {
+	var deltas map[time.Duration]int64
+	add := func(bound time.Duration, delta int64) {
+		if delta == 0 {
+			return
+		}
+		if deltas == nil {
+			deltas = make(map[time.Duration]int64)
+		}
+		deltas[bound] = delta
+	}
+
+	for bound, v := range b {
+		add(bound, v-a[bound])
+	}
+	for bound, v := range a {
+		if _, ok := b[bound]; !ok {
+			add(bound, -v)
+		}
+	}
+	return deltas
+}
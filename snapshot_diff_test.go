@@ -0,0 +1,79 @@
+// Copyright (c) 2022 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package tally
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDiff(t *testing.T) {
+	s := NewTestScope("", nil)
+	s.Counter("unchanged").Inc(1)
+	s.Counter("changed").Inc(1)
+	s.Gauge("gauge").Update(5)
+	s.Timer("timer").Record(time.Second)
+	s.Histogram("values", ValueBuckets{1, 2}).RecordValue(2)
+	a := s.Snapshot()
+
+	s.Counter("changed").Inc(2)
+	s.Counter("added").Inc(4)
+	s.Gauge("gauge").Update(3)
+	s.Timer("timer").Record(time.Second)
+	s.Histogram("values", nil).RecordValue(2)
+	s.Histogram("values", nil).RecordValue(0)
+	s.Histogram("durations", DurationBuckets{time.Second}).RecordDuration(time.Millisecond)
+	b := s.Snapshot()
+
+	d := Diff(a, b)
+	require.Len(t, d.Added, 2)
+	assert.Equal(t, SeriesDiff{Type: CounterType, Name: "added", Tags: map[string]string{}, CounterDelta: 4}, d.Added[0])
+	assert.Equal(t, HistogramType, d.Added[1].Type)
+	assert.Equal(t, map[time.Duration]int64{time.Second: 1}, d.Added[1].DurationBucketDeltas)
+	assert.Empty(t, d.Removed)
+
+	require.Len(t, d.Changed, 4)
+	assert.Equal(t, int64(2), d.Changed[0].CounterDelta)
+	assert.Equal(t, float64(-2), d.Changed[1].GaugeDelta)
+	assert.Equal(t, 1, d.Changed[2].TimerCountDelta)
+	assert.Equal(t, map[float64]int64{1: 1, 2: 1}, d.Changed[3].ValueBucketDeltas)
+
+	assert.Equal(t, ""+
+		"+ counter added{} +4\n"+
+		"~ counter changed{} +2\n"+
+		"~ gauge gauge{} -2\n"+
+		"+ histogram durations{} [1s:+1]\n"+
+		"~ histogram values{} [1:+1 2:+1]\n"+
+		"~ timer timer{} +1 values",
+		d.String())
+
+	reverse := Diff(b, a)
+	require.Len(t, reverse.Removed, 2)
+	assert.Equal(t, int64(-4), reverse.Removed[0].CounterDelta)
+	assert.Equal(t, map[time.Duration]int64{time.Second: -1}, reverse.Removed[1].DurationBucketDeltas)
+
+	assert.True(t, Diff(a, a).Empty())
+	assert.Len(t, Diff(nil, a).Added, 5)
+	assert.Len(t, Diff(a, nil).Removed, 5)
+}
@@ -0,0 +1,577 @@
+// Copyright (c) 2022 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package tally
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"time"
+)
+
+const (
+	snapshotBinaryVersion = byte(1)
+)
+
+var (
+	snapshotBinaryMagic = []byte("TLSN")
+
+	errSnapshotBadMagic   = errors.New("snapshot: invalid binary encoding header")
+	errSnapshotBadVersion = errors.New("snapshot: unsupported binary encoding version")
+	errSnapshotBadLength  = errors.New("snapshot: length exceeds encoded data")
+)
+
+// MarshalSnapshotJSON encodes a snapshot as JSON. Series are written in a
+// deterministic order so that the output is suitable for golden files.
+func MarshalSnapshotJSON(s Snapshot) ([]byte, error) {
+	var js jsonSnapshot
+
+	for _, key := range sortedCounterKeys(s.Counters()) {
+		c := s.Counters()[key]
+		js.Counters = append(js.Counters, jsonCounter{
+			Name:  c.Name(),
+			Tags:  c.Tags(),
+			Value: c.Value(),
+		})
+	}
+	for _, key := range sortedGaugeKeys(s.Gauges()) {
+		g := s.Gauges()[key]
+		js.Gauges = append(js.Gauges, jsonGauge{
+			Name:  g.Name(),
+			Tags:  g.Tags(),
+			Value: jsonFloat(g.Value()),
+		})
+	}
+	for _, key := range sortedTimerKeys(s.Timers()) {
+		t := s.Timers()[key]
+		jt := jsonTimer{
+			Name:   t.Name(),
+			Tags:   t.Tags(),
+			Values: t.Values(),
+		}
+		if agg := timerLastReported(t); agg != (TimerAggregates{}) {
+			jt.LastReported = &agg
+		}
+		js.Timers = append(js.Timers, jt)
+	}
+	for _, key := range sortedHistogramKeys(s.Histograms()) {
+		h := s.Histograms()[key]
+		jh := jsonHistogram{
+			Name: h.Name(),
+			Tags: h.Tags(),
+		}
+		for _, b := range sortedValueBounds(h.Values()) {
+			jh.Values = append(jh.Values, jsonValueBucket{
+				UpperBound: jsonFloat(b),
+				Samples:    h.Values()[b],
+			})
+		}
+		for _, b := range sortedDurationBounds(h.Durations()) {
+			jh.Durations = append(jh.Durations, jsonDurationBucket{
+				UpperBound: b,
+				Samples:    h.Durations()[b],
+			})
+		}
+		js.Histograms = append(js.Histograms, jh)
+	}
+
+	return json.Marshal(js)
+}
+
+// UnmarshalSnapshotJSON decodes a snapshot encoded with MarshalSnapshotJSON.
+func UnmarshalSnapshotJSON(data []byte) (Snapshot, error) {
+	var js jsonSnapshot
+	if err := json.Unmarshal(data, &js); err != nil {
+		return nil, err
+	}
+
+	snap := newSnapshot()
+	for _, c := range js.Counters {
+		snap.counters[KeyForPrefixedStringMap(c.Name, c.Tags)] = &counterSnapshot{
+			name:  c.Name,
+			tags:  c.Tags,
+			value: c.Value,
+		}
+	}
+	for _, g := range js.Gauges {
+		snap.gauges[KeyForPrefixedStringMap(g.Name, g.Tags)] = &gaugeSnapshot{
+			name:  g.Name,
+			tags:  g.Tags,
+			value: float64(g.Value),
+		}
+	}
+	for _, t := range js.Timers {
+		ts := &timerSnapshot{
+			name:   t.Name,
+			tags:   t.Tags,
+			values: t.Values,
+		}
+		if t.LastReported != nil {
+			ts.lastReported = *t.LastReported
+		}
+		snap.timers[KeyForPrefixedStringMap(t.Name, t.Tags)] = ts
+	}
+	for _, h := range js.Histograms {
+		hs := &histogramSnapshot{
+			name: h.Name,
+			tags: h.Tags,
+		}
+		if len(h.Values) > 0 {
+			hs.values = make(map[float64]int64, len(h.Values))
+			for _, b := range h.Values {
+				hs.values[float64(b.UpperBound)] = b.Samples
+			}
+		}
+		if len(h.Durations) > 0 {
+			hs.durations = make(map[time.Duration]int64, len(h.Durations))
+			for _, b := range h.Durations {
+				hs.durations[b.UpperBound] = b.Samples
+			}
+		}
+		snap.histograms[KeyForPrefixedStringMap(h.Name, h.Tags)] = hs
+	}
+
+	return snap, nil
+}
+
+type jsonSnapshot struct {
+	Counters   []jsonCounter   `json:"counters,omitempty"`
+	Gauges     []jsonGauge     `json:"gauges,omitempty"`
+	Timers     []jsonTimer     `json:"timers,omitempty"`
+	Histograms []jsonHistogram `json:"histograms,omitempty"`
+}
+
+type jsonCounter struct {
+	Name  string            `json:"name"`
+	Tags  map[string]string `json:"tags,omitempty"`
+	Value int64             `json:"value"`
+}
+
+type jsonGauge struct {
+	Name  string            `json:"name"`
+	Tags  map[string]string `json:"tags,omitempty"`
+	Value jsonFloat         `json:"value"`
+}
+
+type jsonTimer struct {
+	Name         string            `json:"name"`
+	Tags         map[string]string `json:"tags,omitempty"`
+	Values       []time.Duration   `json:"values"`
+	LastReported *TimerAggregates  `json:"lastReported,omitempty"`
+}
+
+type jsonHistogram struct {
+	Name      string               `json:"name"`
+	Tags      map[string]string    `json:"tags,omitempty"`
+	Values    []jsonValueBucket    `json:"values,omitempty"`
+	Durations []jsonDurationBucket `json:"durations,omitempty"`
+}
+
+type jsonValueBucket struct {
+	UpperBound jsonFloat `json:"upperBound"`
+	Samples    int64     `json:"samples"`
+}
+
+type jsonDurationBucket struct {
+	UpperBound time.Duration `json:"upperBound"`
+	Samples    int64         `json:"samples"`
+}
+
+// jsonFloat is a float64 that encodes NaN and infinities as strings, as
+// they cannot be represented by JSON numbers.
+type jsonFloat float64
+
+func (f jsonFloat) MarshalJSON() ([]byte, error) {
+	v := float64(f)
+	switch {
+	case math.IsNaN(v):
+		return []byte(`"NaN"`), nil
+	case math.IsInf(v, 1):
+		return []byte(`"+Inf"`), nil
+	case math.IsInf(v, -1):
+		return []byte(`"-Inf"`), nil
+	}
+	return json.Marshal(v)
+}
+
+func (f *jsonFloat) UnmarshalJSON(data []byte) error {
+	if len(data) > 0 && data[0] == '"' {
+		var s string
+		if err := json.Unmarshal(data, &s); err != nil {
+			return err
+		}
+		v, err := strconv.ParseFloat(s, 64)
+		if err != nil {
+			return err
+		}
+		*f = jsonFloat(v)
+		return nil
+	}
+
+	var v float64
+	if err := json.Unmarshal(data, &v); err != nil {
+		return err
+	}
+	*f = jsonFloat(v)
+	return nil
+}
+
+// MarshalSnapshotBinary encodes a snapshot in a compact binary format.
+// Series are written in a deterministic order.
+func MarshalSnapshotBinary(s Snapshot) ([]byte, error) {
+	e := snapshotEncoder{}
+	e.buf.Write(snapshotBinaryMagic)
+	e.buf.WriteByte(snapshotBinaryVersion)
+
+	e.uvarint(uint64(len(s.Counters())))
+	for _, key := range sortedCounterKeys(s.Counters()) {
+		c := s.Counters()[key]
+		e.series(c.Name(), c.Tags())
+		e.varint(c.Value())
+	}
+
+	e.uvarint(uint64(len(s.Gauges())))
+	for _, key := range sortedGaugeKeys(s.Gauges()) {
+		g := s.Gauges()[key]
+		e.series(g.Name(), g.Tags())
+		e.float(g.Value())
+	}
+
+	e.uvarint(uint64(len(s.Timers())))
+	for _, key := range sortedTimerKeys(s.Timers()) {
+		t := s.Timers()[key]
+		e.series(t.Name(), t.Tags())
+		e.uvarint(uint64(len(t.Values())))
+		for _, v := range t.Values() {
+			e.varint(int64(v))
+		}
+		agg := timerLastReported(t)
+		e.varint(agg.Count)
+		e.varint(int64(agg.Sum))
+		e.varint(int64(agg.Min))
+		e.varint(int64(agg.Max))
+	}
+
+	e.uvarint(uint64(len(s.Histograms())))
+	for _, key := range sortedHistogramKeys(s.Histograms()) {
+		h := s.Histograms()[key]
+		e.series(h.Name(), h.Tags())
+		e.uvarint(uint64(len(h.Values())))
+		for _, b := range sortedValueBounds(h.Values()) {
+			e.float(b)
+			e.varint(h.Values()[b])
+		}
+		e.uvarint(uint64(len(h.Durations())))
+		for _, b := range sortedDurationBounds(h.Durations()) {
+			e.varint(int64(b))
+			e.varint(h.Durations()[b])
+		}
+	}
+
+	return e.buf.Bytes(), nil
+}
+
+// UnmarshalSnapshotBinary decodes a snapshot encoded with
+// MarshalSnapshotBinary.
+func UnmarshalSnapshotBinary(data []byte) (Snapshot, error) {
+	if len(data) < len(snapshotBinaryMagic)+1 ||
+		!bytes.Equal(data[:len(snapshotBinaryMagic)], snapshotBinaryMagic) {
+		return nil, errSnapshotBadMagic
+	}
+	version := data[len(snapshotBinaryMagic)]
+	if version != snapshotBinaryVersion {
+		return nil, fmt.Errorf("%w: %d", errSnapshotBadVersion, version)
+	}
+
+	var (
+		d    = snapshotDecoder{r: bytes.NewReader(data[len(snapshotBinaryMagic)+1:])}
+		snap = newSnapshot()
+	)
+
+	n := d.length()
+	for i := 0; i < n && d.err == nil; i++ {
+		name, tags := d.series()
+		snap.counters[KeyForPrefixedStringMap(name, tags)] = &counterSnapshot{
+			name:  name,
+			tags:  tags,
+			value: d.varint(),
+		}
+	}
+
+	n = d.length()
+	for i := 0; i < n && d.err == nil; i++ {
+		name, tags := d.series()
+		snap.gauges[KeyForPrefixedStringMap(name, tags)] = &gaugeSnapshot{
+			name:  name,
+			tags:  tags,
+			value: d.float(),
+		}
+	}
+
+	n = d.length()
+	for i := 0; i < n && d.err == nil; i++ {
+		name, tags := d.series()
+		values := make([]time.Duration, d.length())
+		for j := range values {
+			values[j] = time.Duration(d.varint())
+		}
+		snap.timers[KeyForPrefixedStringMap(name, tags)] = &timerSnapshot{
+			name:   name,
+			tags:   tags,
+			values: values,
+			lastReported: TimerAggregates{
+				Count: d.varint(),
+				Sum:   time.Duration(d.varint()),
+				Min:   time.Duration(d.varint()),
+				Max:   time.Duration(d.varint()),
+			},
+		}
+	}
+
+	n = d.length()
+	for i := 0; i < n && d.err == nil; i++ {
+		name, tags := d.series()
+		hs := &histogramSnapshot{
+			name: name,
+			tags: tags,
+		}
+		if m := d.length(); m > 0 {
+			hs.values = make(map[float64]int64, m)
+			for j := 0; j < m && d.err == nil; j++ {
+				b := d.float()
+				hs.values[b] = d.varint()
+			}
+		}
+		if m := d.length(); m > 0 {
+			hs.durations = make(map[time.Duration]int64, m)
+			for j := 0; j < m && d.err == nil; j++ {
+				b := time.Duration(d.varint())
+				hs.durations[b] = d.varint()
+			}
+		}
+		snap.histograms[KeyForPrefixedStringMap(name, tags)] = hs
+	}
+
+	if d.err != nil {
+		return nil, d.err
+	}
+	return snap, nil
+}
+
+type snapshotEncoder struct {
+	buf     bytes.Buffer
+	scratch [binary.MaxVarintLen64]byte
+}
+
+func (e *snapshotEncoder) uvarint(v uint64) {
+	n := binary.PutUvarint(e.scratch[:], v)
+	e.buf.Write(e.scratch[:n])
+}
+
+func (e *snapshotEncoder) varint(v int64) {
+	n := binary.PutVarint(e.scratch[:], v)
+	e.buf.Write(e.scratch[:n])
+}
+
+func (e *snapshotEncoder) float(v float64) {
+	binary.LittleEndian.PutUint64(e.scratch[:8], math.Float64bits(v))
+	e.buf.Write(e.scratch[:8])
+}
+
+func (e *snapshotEncoder) string(s string) {
+	e.uvarint(uint64(len(s)))
+	e.buf.WriteString(s)
+}
+
+func (e *snapshotEncoder) series(name string, tags map[string]string) {
+	e.string(name)
+	e.uvarint(uint64(len(tags)))
+	for _, k := range sortedTagKeys(tags) {
+		e.string(k)
+		e.string(tags[k])
+	}
+}
+
+// snapshotDecoder reads values written by snapshotEncoder, recording the
+// first error encountered and returning zero values from then on.
+type snapshotDecoder struct {
+	r   *bytes.Reader
+	err error
+}
+
+func (d *snapshotDecoder) uvarint() uint64 {
+	if d.err != nil {
+		return 0
+	}
+	v, err := binary.ReadUvarint(d.r)
+	if err != nil {
+		d.err = unexpectedEOF(err)
+	}
+	return v
+}
+
+func (d *snapshotDecoder) varint() int64 {
+	if d.err != nil {
+		return 0
+	}
+	v, err := binary.ReadVarint(d.r)
+	if err != nil {
+		d.err = unexpectedEOF(err)
+	}
+	return v
+}
+
+// length reads a length, ensuring that it cannot exceed the remaining data
+// as every element is encoded as at least one byte.
+func (d *snapshotDecoder) length() int {
+	v := d.uvarint()
+	if d.err == nil && v > uint64(d.r.Len()) {
+		d.err = errSnapshotBadLength
+	}
+	if d.err != nil {
+		return 0
+	}
+	return int(v)
+}
+
+func (d *snapshotDecoder) float() float64 {
+	if d.err != nil {
+		return 0
+	}
+	var b [8]byte
+	if _, err := io.ReadFull(d.r, b[:]); err != nil {
+		d.err = unexpectedEOF(err)
+		return 0
+	}
+	return math.Float64frombits(binary.LittleEndian.Uint64(b[:]))
+}
+
+func (d *snapshotDecoder) string() string {
+	n := d.length()
+	if d.err != nil || n == 0 {
+		return ""
+	}
+	b := make([]byte, n)
+	if _, err := io.ReadFull(d.r, b); err != nil {
+		d.err = unexpectedEOF(err)
+		return ""
+	}
+	return string(b)
+}
+
+func (d *snapshotDecoder) series() (string, map[string]string) {
+	name := d.string()
+	n := d.length()
+	if n == 0 {
+		return name, nil
+	}
+	tags := make(map[string]string, n)
+	for i := 0; i < n && d.err == nil; i++ {
+		k := d.string()
+		tags[k] = d.string()
+	}
+	return name, tags
+}
+
+func unexpectedEOF(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
+
+func sortedCounterKeys(m map[string]CounterSnapshot) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func sortedGaugeKeys(m map[string]GaugeSnapshot) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func sortedTimerKeys(m map[string]TimerSnapshot) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func sortedHistogramKeys(m map[string]HistogramSnapshot) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func sortedTagKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func sortedValueBounds(m map[float64]int64) []float64 {
+	bounds := make([]float64, 0, len(m))
+	for b := range m {
+		bounds = append(bounds, b)
+	}
+	sort.Float64s(bounds)
+	return bounds
+}
+
+func sortedDurationBounds(m map[time.Duration]int64) []time.Duration {
+	bounds := make([]time.Duration, 0, len(m))
+	for b := range m {
+		bounds = append(bounds, b)
+	}
+	sort.Slice(bounds, func(i, j int) bool { return bounds[i] < bounds[j] })
+	return bounds
+}
+
+// timerLastReported returns the aggregates of a timer snapshot, if it has
+// any.
+func timerLastReported(t TimerSnapshot) TimerAggregates {
+	if agg, ok := t.(TimerAggregatesSnapshot); ok {
+		return agg.LastReported()
+	}
+	return TimerAggregates{}
+}
@@ -0,0 +1,172 @@
+// Copyright (c) 2022 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package tally
+
+import (
+	"errors"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newEncodingTestSnapshot() Snapshot {
+	s := NewTestScope("foo", map[string]string{"env": "test"})
+	s.Counter("beep").Inc(3)
+	s.Tagged(map[string]string{"region": "east"}).Counter("beep").Inc(-1)
+	s.Gauge("boop").Update(1.5)
+	s.Gauge("inf").Update(math.Inf(1))
+	s.Timer("latency").Record(time.Millisecond)
+	s.Timer("latency").Record(time.Second)
+	s.Timer("unused")
+	s.Histogram("values", MustMakeLinearValueBuckets(0, 10, 3)).RecordValue(15)
+	s.Histogram("durations", DurationBuckets{time.Second}).RecordDuration(time.Minute)
+
+	snap := s.Snapshot().(*snapshot)
+	snap.timers["reported+"] = &timerSnapshot{
+		name: "reported",
+		lastReported: TimerAggregates{
+			Count: 2,
+			Sum:   3 * time.Second,
+			Min:   time.Second,
+			Max:   2 * time.Second,
+		},
+	}
+	return snap
+}
+
+func assertSnapshotsEqual(t *testing.T, expected, actual Snapshot) {
+	require.Equal(t, len(expected.Counters()), len(actual.Counters()))
+	for key, c := range expected.Counters() {
+		require.Contains(t, actual.Counters(), key)
+		a := actual.Counters()[key]
+		assert.Equal(t, c.Name(), a.Name())
+		assert.Equal(t, c.Tags(), a.Tags())
+		assert.Equal(t, c.Value(), a.Value())
+	}
+
+	require.Equal(t, len(expected.Gauges()), len(actual.Gauges()))
+	for key, g := range expected.Gauges() {
+		require.Contains(t, actual.Gauges(), key)
+		a := actual.Gauges()[key]
+		assert.Equal(t, g.Name(), a.Name())
+		assert.Equal(t, g.Tags(), a.Tags())
+		assert.Equal(t, g.Value(), a.Value())
+	}
+
+	require.Equal(t, len(expected.Timers()), len(actual.Timers()))
+	for key, tm := range expected.Timers() {
+		require.Contains(t, actual.Timers(), key)
+		a := actual.Timers()[key]
+		assert.Equal(t, tm.Name(), a.Name())
+		assert.Equal(t, tm.Tags(), a.Tags())
+		assert.Equal(t, len(tm.Values()), len(a.Values()))
+		assert.ElementsMatch(t, tm.Values(), a.Values())
+		assert.Equal(t, timerLastReported(tm), timerLastReported(a))
+	}
+
+	require.Equal(t, len(expected.Histograms()), len(actual.Histograms()))
+	for key, h := range expected.Histograms() {
+		require.Contains(t, actual.Histograms(), key)
+		a := actual.Histograms()[key]
+		assert.Equal(t, h.Name(), a.Name())
+		assert.Equal(t, h.Tags(), a.Tags())
+		assert.Equal(t, h.Values(), a.Values())
+		assert.Equal(t, h.Durations(), a.Durations())
+	}
+
+	assert.True(t, Diff(expected, actual).Empty())
+}
+
+func TestSnapshotJSONRoundTrip(t *testing.T) {
+	snap := newEncodingTestSnapshot()
+
+	data, err := MarshalSnapshotJSON(snap)
+	require.NoError(t, err)
+	assert.Contains(t, string(data), `"value":"+Inf"`)
+	assert.Contains(t, string(data),
+		`"lastReported":{"count":2,"sum":3000000000,"min":1000000000,"max":2000000000}`)
+
+	again, err := MarshalSnapshotJSON(snap)
+	require.NoError(t, err)
+	assert.Equal(t, string(data), string(again))
+
+	decoded, err := UnmarshalSnapshotJSON(data)
+	require.NoError(t, err)
+	assertSnapshotsEqual(t, snap, decoded)
+
+	_, err = UnmarshalSnapshotJSON([]byte(`{"gauges":[{"value":"bad"}]}`))
+	assert.Error(t, err)
+}
+
+func TestSnapshotJSONGolden(t *testing.T) {
+	s := NewTestScope("", nil)
+	s.Counter("a").Inc(1)
+	s.Tagged(map[string]string{"k": "v"}).Gauge("b").Update(2)
+
+	data, err := MarshalSnapshotJSON(s.Snapshot())
+	require.NoError(t, err)
+	assert.Equal(t,
+		`{"counters":[{"name":"a","value":1}],`+
+			`"gauges":[{"name":"b","tags":{"k":"v"},"value":2}]}`,
+		string(data))
+}
+
+func TestSnapshotBinaryRoundTrip(t *testing.T) {
+	snap := newEncodingTestSnapshot()
+
+	data, err := MarshalSnapshotBinary(snap)
+	require.NoError(t, err)
+
+	again, err := MarshalSnapshotBinary(snap)
+	require.NoError(t, err)
+	assert.Equal(t, data, again)
+
+	decoded, err := UnmarshalSnapshotBinary(data)
+	require.NoError(t, err)
+	assertSnapshotsEqual(t, snap, decoded)
+
+	empty, err := MarshalSnapshotBinary(newSnapshot())
+	require.NoError(t, err)
+	decoded, err = UnmarshalSnapshotBinary(empty)
+	require.NoError(t, err)
+	assert.Empty(t, decoded.Counters())
+}
+
+func TestSnapshotBinaryInvalid(t *testing.T) {
+	data, err := MarshalSnapshotBinary(newEncodingTestSnapshot())
+	require.NoError(t, err)
+
+	_, err = UnmarshalSnapshotBinary([]byte("nope"))
+	assert.Equal(t, errSnapshotBadMagic, err)
+
+	bad := append([]byte{}, data...)
+	bad[len(snapshotBinaryMagic)] = 42
+	_, err = UnmarshalSnapshotBinary(bad)
+	assert.True(t, errors.Is(err, errSnapshotBadVersion))
+
+	for i := len(snapshotBinaryMagic) + 1; i < len(data); i++ {
+		_, err = UnmarshalSnapshotBinary(data[:i])
+		assert.Error(t, err, "truncated at %d", i)
+	}
+}
@@ -150,6 +150,8 @@ type timer struct {
 	reporter    StatsReporter
 	cachedTimer CachedTimer
 	unreported  timerValues
+	aggregating int32
+	aggregates  timerAggregates
 }
 
 type timerValues struct {
@@ -176,6 +178,9 @@ func newTimer(
 }
 
 func (t *timer) Record(interval time.Duration) {
+	if atomic.LoadInt32(&t.aggregating) == 1 {
+		t.aggregates.record(interval)
+	}
 	if t.cachedTimer != nil {
 		t.cachedTimer.ReportTimer(interval)
 	} else {
@@ -200,6 +205,57 @@ func (t *timer) snapshot() []time.Duration {
 	return snap
 }
 
+func (t *timer) report() {
+	if atomic.LoadInt32(&t.aggregating) == 1 {
+		t.aggregates.roll()
+	}
+}
+
+// snapshotAggregates returns the aggregates of the last reported interval,
+// and has the timer compute aggregates from now on if it did not already,
+// so that only timers that are snapshotted pay for them.
+func (t *timer) snapshotAggregates() TimerAggregates {
+	if atomic.CompareAndSwapInt32(&t.aggregating, 0, 1) {
+		return TimerAggregates{}
+	}
+	return t.aggregates.lastReported()
+}
+
+// timerAggregates accumulates the count, sum, min and max of the values
+// recorded by a timer for the current reporting interval, and retains
+// them for the last reported interval.
+type timerAggregates struct {
+	sync.Mutex
+	current TimerAggregates
+	last    TimerAggregates
+}
+
+func (a *timerAggregates) record(interval time.Duration) {
+	a.Lock()
+	if a.current.Count == 0 || interval < a.current.Min {
+		a.current.Min = interval
+	}
+	if a.current.Count == 0 || interval > a.current.Max {
+		a.current.Max = interval
+	}
+	a.current.Count++
+	a.current.Sum += interval
+	a.Unlock()
+}
+
+func (a *timerAggregates) roll() {
+	a.Lock()
+	a.last = a.current
+	a.current = TimerAggregates{}
+	a.Unlock()
+}
+
+func (a *timerAggregates) lastReported() TimerAggregates {
+	a.Lock()
+	defer a.Unlock()
+	return a.last
+}
+
 type timerNoReporterSink struct {
 	sync.RWMutex
 	timer *timer
@@ -256,6 +312,13 @@ func (r *timerNoReporterSink) Capabilities() Capabilities {
 func (r *timerNoReporterSink) Flush() {
 }
 
+// releaseCached releases a cached metric if it is a CachedMetricReleaser.
+func releaseCached(cached interface{}) {
+	if releaser, ok := cached.(CachedMetricReleaser); ok {
+		releaser.Release()
+	}
+}
+
 type sampleCounter struct {
 	counter      *counter
 	cachedBucket CachedHistogramBucket
@@ -269,6 +332,8 @@ type histogram struct {
 	specification Buckets
 	buckets       []histogramBucket
 	samples       []sampleCounter
+	cached        CachedHistogram
+	recorder      CachedHistogramRecorder
 }
 
 type histogramType int
@@ -294,6 +359,11 @@ func newHistogram(
 		specification: storage.buckets,
 		buckets:       storage.hbuckets,
 		samples:       make([]sampleCounter, len(storage.hbuckets)),
+		cached:        cachedHistogram,
+	}
+
+	if recorder, ok := cachedHistogram.(CachedHistogramRecorder); ok {
+		h.recorder = recorder
 	}
 
 	for i := range h.samples {
@@ -369,6 +439,11 @@ func (h *histogram) RecordValue(value float64) {
 		return
 	}
 
+	if h.recorder != nil {
+		h.recorder.RecordValue(value)
+		return
+	}
+
 	// Find the highest inclusive of the bucket upper bound
 	// and emit directly to it. Since we use BucketPairs to derive
 	// buckets there will always be an inclusive bucket as
@@ -384,6 +459,11 @@ func (h *histogram) RecordDuration(value time.Duration) {
 		return
 	}
 
+	if h.recorder != nil {
+		h.recorder.RecordDuration(value)
+		return
+	}
+
 	// Find the highest inclusive of the bucket upper bound
 	// and emit directly to it. Since we use BucketPairs to derive
 	// buckets there will always be an inclusive bucket as
@@ -170,3 +170,58 @@ func TestHistogramDurationSamples(t *testing.T) {
 	assert.Equal(t, 5, r.durationSamples[60*time.Millisecond])
 	assert.Equal(t, buckets, r.buckets)
 }
+
+type recordingCachedHistogram struct {
+	values    []float64
+	durations []time.Duration
+	buckets   int
+}
+
+func (h *recordingCachedHistogram) ValueBucket(
+	bucketLowerBound, bucketUpperBound float64,
+) CachedHistogramBucket {
+	h.buckets++
+	return nil
+}
+
+func (h *recordingCachedHistogram) DurationBucket(
+	bucketLowerBound, bucketUpperBound time.Duration,
+) CachedHistogramBucket {
+	h.buckets++
+	return nil
+}
+
+func (h *recordingCachedHistogram) RecordValue(value float64) {
+	h.values = append(h.values, value)
+}
+
+func (h *recordingCachedHistogram) RecordDuration(value time.Duration) {
+	h.durations = append(h.durations, value)
+}
+
+func TestHistogramCachedRecorder(t *testing.T) {
+	r := newStatsTestReporter()
+	recorder := &recordingCachedHistogram{}
+	buckets := MustMakeLinearValueBuckets(0, 10, 10)
+	storage := newBucketStorage(valueHistogramType, buckets)
+	h := newHistogram(valueHistogramType, "h1", nil, r, storage, recorder)
+
+	h.RecordValue(3.5)
+	h.RecordValue(42)
+	h.cachedReport()
+
+	assert.Equal(t, []float64{3.5, 42}, recorder.values)
+	for i := range h.samples {
+		assert.Equal(t, int64(0), h.samples[i].counter.snapshot())
+	}
+
+	recorder = &recordingCachedHistogram{}
+	durationBuckets := MustMakeLinearDurationBuckets(0, time.Millisecond, 10)
+	storage = newBucketStorage(durationHistogramType, durationBuckets)
+	h = newHistogram(durationHistogramType, "h2", nil, r, storage, recorder)
+
+	h.RecordDuration(1500 * time.Microsecond)
+	h.cachedReport()
+
+	assert.Equal(t, []time.Duration{1500 * time.Microsecond}, recorder.durations)
+}
@@ -0,0 +1,218 @@
+// Copyright (c) 2022 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package statsd
+
+import (
+	"errors"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultNetwork is the default network of the native client.
+	DefaultNetwork = "udp"
+
+	// DefaultAddress is the default address of the native client.
+	DefaultAddress = "127.0.0.1:8125"
+
+	// DefaultUDPMaxPacketSize is the default max packet size over UDP,
+	// fitting an ethernet MTU of 1500 bytes less the IP and UDP headers.
+	DefaultUDPMaxPacketSize = 1432
+
+	// DefaultUDSMaxPacketSize is the default max packet size over a Unix
+	// domain socket, matching the DogStatsD agent default buffer size.
+	DefaultUDSMaxPacketSize = 8192
+
+	// DefaultWriteTimeout is the default timeout of writing a packet.
+	DefaultWriteTimeout = 100 * time.Millisecond
+)
+
+var (
+	errUnsupportedNetwork = errors.New("network must be udp, udp4, udp6 or unixgram")
+	errAlreadyClosed      = errors.New("reporter already closed")
+)
+
+// packetWriter buffers statsd lines into packets of at most a max size,
+// separated by newlines. A packet is sent when the next line does not
+// fit into it or when flushed.
+type packetWriter struct {
+	network       string
+	address       string
+	maxPacketSize int
+	writeTimeout  time.Duration
+	onError       func(err error)
+
+	sync.Mutex
+	conn   net.Conn
+	buf    []byte
+	closed bool
+}
+
+func newPacketWriter(
+	network string,
+	address string,
+	maxPacketSize int,
+	writeTimeout time.Duration,
+	onError func(err error),
+) (*packetWriter, error) {
+	switch network {
+	case "udp", "udp4", "udp6", "unixgram":
+	default:
+		return nil, errUnsupportedNetwork
+	}
+
+	conn, err := net.Dial(network, address)
+	if err != nil {
+		return nil, err
+	}
+
+	return &packetWriter{
+		network:       network,
+		address:       address,
+		maxPacketSize: maxPacketSize,
+		writeTimeout:  writeTimeout,
+		onError:       onError,
+		conn:          conn,
+		buf:           make([]byte, 0, maxPacketSize),
+	}, nil
+}
+
+func (w *packetWriter) writeInt(prefix []byte, value int64, suffix []byte) {
+	w.Lock()
+	start := w.beginLine()
+	w.buf = append(w.buf, prefix...)
+	w.buf = strconv.AppendInt(w.buf, value, 10)
+	w.buf = append(w.buf, suffix...)
+	w.endLine(start)
+	w.Unlock()
+}
+
+func (w *packetWriter) writeFloat(prefix []byte, value float64, suffix []byte) {
+	w.Lock()
+	start := w.beginLine()
+	w.buf = append(w.buf, prefix...)
+	w.buf = strconv.AppendFloat(w.buf, value, 'f', -1, 64)
+	w.buf = append(w.buf, suffix...)
+	w.endLine(start)
+	w.Unlock()
+}
+
+func (w *packetWriter) writeString(prefix []byte, value string, suffix []byte) {
+	w.Lock()
+	start := w.beginLine()
+	w.buf = append(w.buf, prefix...)
+	w.buf = append(w.buf, value...)
+	w.buf = append(w.buf, suffix...)
+	w.endLine(start)
+	w.Unlock()
+}
+
+// beginLine returns where the line being written starts, including its
+// separator from the previous line. Must be called with the lock held.
+func (w *packetWriter) beginLine() int {
+	start := len(w.buf)
+	if start > 0 {
+		w.buf = append(w.buf, '\n')
+	}
+	return start
+}
+
+// endLine sends the buffered packet if the line just written made it
+// exceed the max packet size. Must be called with the lock held.
+func (w *packetWriter) endLine(start int) {
+	if len(w.buf) <= w.maxPacketSize {
+		return
+	}
+
+	if start > 0 {
+		// Send the lines before this one and move it to the front.
+		w.send(w.buf[:start])
+		n := copy(w.buf, w.buf[start+1:])
+		w.buf = w.buf[:n]
+	}
+
+	if len(w.buf) > w.maxPacketSize {
+		// A line exceeding the max packet size is sent on its own.
+		w.send(w.buf)
+		w.buf = w.buf[:0]
+	}
+}
+
+func (w *packetWriter) flush() {
+	w.Lock()
+	w.flushWithLock()
+	w.Unlock()
+}
+
+func (w *packetWriter) flushWithLock() {
+	if len(w.buf) > 0 {
+		w.send(w.buf)
+		w.buf = w.buf[:0]
+	}
+}
+
+// send writes a packet, redialing if a previous write failed, which
+// happens when the agent behind a Unix domain socket restarts. Must be
+// called with the lock held.
+func (w *packetWriter) send(packet []byte) {
+	if w.closed {
+		return
+	}
+
+	if w.conn == nil {
+		conn, err := net.Dial(w.network, w.address)
+		if err != nil {
+			w.onError(err)
+			return
+		}
+		w.conn = conn
+	}
+
+	if w.writeTimeout > 0 {
+		if err := w.conn.SetWriteDeadline(time.Now().Add(w.writeTimeout)); err != nil {
+			w.onError(err)
+		}
+	}
+
+	if _, err := w.conn.Write(packet); err != nil {
+		w.onError(err)
+		w.conn.Close()
+		w.conn = nil
+	}
+}
+
+func (w *packetWriter) close() error {
+	w.Lock()
+	defer w.Unlock()
+
+	if w.closed {
+		return errAlreadyClosed
+	}
+
+	w.flushWithLock()
+	w.closed = true
+	if w.conn == nil {
+		return nil
+	}
+	return w.conn.Close()
+}
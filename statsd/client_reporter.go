@@ -0,0 +1,368 @@
+// Copyright (c) 2022 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package statsd
+
+import (
+	"io"
+	"math/rand"
+	"strconv"
+	"time"
+
+	tally "github.com/uber-go/tally/v4"
+	"github.com/uber-go/tally/v4/internal/cache"
+)
+
+// ClientReporter is a statsd reporter backed by the native client, it
+// formats the lines of cached metrics once so that reporting them does
+// not allocate.
+type ClientReporter interface {
+	Reporter
+	tally.CachedStatsReporter
+	io.Closer
+}
+
+// ClientOptions is a set of options for the native client reporter.
+type ClientOptions struct {
+	// Network is the network to send packets on, one of "udp", "udp4",
+	// "udp6" or "unixgram" for a DogStatsD Unix domain socket. By
+	// default this will be set to DefaultNetwork.
+	Network string
+
+	// Address is the address of the statsd server, or the path of the
+	// socket with "unixgram". By default this will be set to
+	// DefaultAddress.
+	Address string
+
+	// Prefix if not empty is prepended to all metric names with a dot.
+	Prefix string
+
+	// MaxPacketSize is the max size of a packet of newline separated
+	// lines. By default this will be set to DefaultUDPMaxPacketSize, or
+	// DefaultUDSMaxPacketSize with "unixgram".
+	MaxPacketSize int
+
+	// WriteTimeout is the timeout of writing a packet, by default this
+	// will be set to DefaultWriteTimeout.
+	WriteTimeout time.Duration
+
+	// SampleRate is the metrics emission sample rate. If you
+	// do not set this value it will be set to 1.
+	SampleRate float32
+
+	// HistogramBucketNamePrecision is the precision to use when
+	// formatting the metric name with the histogram bucket bound values.
+	// By default this will be set to the const DefaultHistogramBucketPrecision.
+	HistogramBucketNamePrecision uint
+
+	// TagFormat is how tags are encoded, by default tags are dropped.
+	TagFormat TagFormat
+
+	// TimerType is the statsd metric type timers are sent as, by default
+	// timings. The histogram and distribution types are only supported
+	// by DogStatsD.
+	TimerType TimerType
+
+	// OnError is called with any error encountered writing packets, by
+	// default errors are ignored.
+	OnError func(err error)
+}
+
+type clientReporter struct {
+	writer     *packetWriter
+	prefix     string
+	sampleRate float32
+	rateSuffix string
+	precision  int
+	tagFormat  TagFormat
+	timerType  string
+
+	stringInterner *cache.StringInterner
+	tagSetCache    *cache.TagSetCache
+}
+
+// NewClientReporter returns a new statsd reporter that buffers lines into
+// packets sent on Flush, without depending on a third party client.
+func NewClientReporter(opts ClientOptions) (ClientReporter, error) {
+	if opts.Network == "" {
+		opts.Network = DefaultNetwork
+	}
+	if opts.Address == "" {
+		opts.Address = DefaultAddress
+	}
+	if opts.MaxPacketSize <= 0 {
+		opts.MaxPacketSize = DefaultUDPMaxPacketSize
+		if opts.Network == "unixgram" {
+			opts.MaxPacketSize = DefaultUDSMaxPacketSize
+		}
+	}
+	if opts.WriteTimeout <= 0 {
+		opts.WriteTimeout = DefaultWriteTimeout
+	}
+	var nilSampleRate float32
+	if opts.SampleRate == nilSampleRate {
+		opts.SampleRate = 1.0
+	}
+	if opts.HistogramBucketNamePrecision == 0 {
+		opts.HistogramBucketNamePrecision = DefaultHistogramBucketNamePrecision
+	}
+	if opts.OnError == nil {
+		opts.OnError = func(err error) {}
+	}
+
+	writer, err := newPacketWriter(opts.Network, opts.Address,
+		opts.MaxPacketSize, opts.WriteTimeout, opts.OnError)
+	if err != nil {
+		return nil, err
+	}
+
+	r := &clientReporter{
+		writer:         writer,
+		sampleRate:     opts.SampleRate,
+		precision:      int(opts.HistogramBucketNamePrecision),
+		tagFormat:      opts.TagFormat,
+		timerType:      opts.TimerType.String(),
+		stringInterner: cache.NewStringInterner(),
+		tagSetCache:    cache.NewTagSetCache(),
+	}
+	if opts.Prefix != "" {
+		r.prefix = opts.Prefix + "."
+	}
+	if opts.SampleRate < 1 {
+		r.rateSuffix = "|@" + strconv.FormatFloat(float64(opts.SampleRate), 'f', -1, 32)
+	}
+	return r, nil
+}
+
+// clientMetric is a metric with its line preformatted around the value.
+type clientMetric struct {
+	reporter *clientReporter
+	prefix   []byte
+	suffix   []byte
+}
+
+func (r *clientReporter) newMetric(
+	name string,
+	tags map[string]string,
+	metricType string,
+) clientMetric {
+	encoded := r.encodedTags(tags)
+
+	prefix := make([]byte, 0, len(r.prefix)+len(name)+len(encoded)+1)
+	prefix = append(prefix, r.prefix...)
+	prefix = append(prefix, name...)
+	if !r.tagFormat.lineSuffix() {
+		prefix = append(prefix, encoded...)
+	}
+	prefix = append(prefix, ':')
+
+	suffix := make([]byte, 0, len(metricType)+len(r.rateSuffix)+len(encoded)+1)
+	suffix = append(suffix, '|')
+	suffix = append(suffix, metricType...)
+	suffix = append(suffix, r.rateSuffix...)
+	if r.tagFormat.lineSuffix() {
+		suffix = append(suffix, encoded...)
+	}
+
+	return clientMetric{reporter: r, prefix: prefix, suffix: suffix}
+}
+
+func (r *clientReporter) encodedTags(tags map[string]string) string {
+	if r.tagFormat == NoTags || len(tags) == 0 {
+		return ""
+	}
+
+	key := cache.TagMapKey(tags)
+	if encoded, ok := r.tagSetCache.Get(key); ok {
+		return encoded
+	}
+	return r.tagSetCache.Set(key, r.stringInterner.Intern(encodeTags(r.tagFormat, tags)))
+}
+
+func (r *clientReporter) sample() bool {
+	return r.sampleRate >= 1 || rand.Float32() < r.sampleRate
+}
+
+func (m clientMetric) reportInt(value int64) {
+	if m.reporter.sample() {
+		m.reporter.writer.writeInt(m.prefix, value, m.suffix)
+	}
+}
+
+func (m clientMetric) reportFloat(value float64) {
+	if m.reporter.sample() {
+		m.reporter.writer.writeFloat(m.prefix, value, m.suffix)
+	}
+}
+
+func (m clientMetric) reportString(value string) {
+	if m.reporter.sample() {
+		m.reporter.writer.writeString(m.prefix, value, m.suffix)
+	}
+}
+
+func (r *clientReporter) ReportCounter(name string, tags map[string]string, value int64) {
+	r.newMetric(name, tags, "c").reportInt(value)
+}
+
+func (r *clientReporter) ReportGauge(name string, tags map[string]string, value float64) {
+	r.newMetric(name, tags, "g").reportFloat(value)
+}
+
+func (r *clientReporter) ReportTimer(name string, tags map[string]string, interval time.Duration) {
+	r.newMetric(name, tags, r.timerType).reportFloat(durationMillis(interval))
+}
+
+func (r *clientReporter) ReportDistribution(name string, tags map[string]string, value float64) {
+	r.newMetric(name, tags, "d").reportFloat(value)
+}
+
+func (r *clientReporter) ReportHistogram(name string, tags map[string]string, value float64) {
+	r.newMetric(name, tags, "h").reportFloat(value)
+}
+
+func (r *clientReporter) ReportSet(name string, tags map[string]string, value string) {
+	r.newMetric(name, tags, "s").reportString(value)
+}
+
+func (r *clientReporter) ReportHistogramValueSamples(
+	name string,
+	tags map[string]string,
+	buckets tally.Buckets,
+	bucketLowerBound,
+	bucketUpperBound float64,
+	samples int64,
+) {
+	r.newMetric(
+		valueBucketName(name, bucketLowerBound, bucketUpperBound, r.precision),
+		tags, "c").reportInt(samples)
+}
+
+func (r *clientReporter) ReportHistogramDurationSamples(
+	name string,
+	tags map[string]string,
+	buckets tally.Buckets,
+	bucketLowerBound,
+	bucketUpperBound time.Duration,
+	samples int64,
+) {
+	r.newMetric(
+		durationBucketName(name, bucketLowerBound, bucketUpperBound),
+		tags, "c").reportInt(samples)
+}
+
+func (r *clientReporter) AllocateCounter(
+	name string,
+	tags map[string]string,
+) tally.CachedCount {
+	return cachedClientCounter{r.newMetric(name, tags, "c")}
+}
+
+func (r *clientReporter) AllocateGauge(
+	name string,
+	tags map[string]string,
+) tally.CachedGauge {
+	return cachedClientGauge{r.newMetric(name, tags, "g")}
+}
+
+func (r *clientReporter) AllocateTimer(
+	name string,
+	tags map[string]string,
+) tally.CachedTimer {
+	return cachedClientTimer{r.newMetric(name, tags, r.timerType)}
+}
+
+func (r *clientReporter) AllocateHistogram(
+	name string,
+	tags map[string]string,
+	buckets tally.Buckets,
+) tally.CachedHistogram {
+	return cachedClientHistogram{reporter: r, name: name, tags: tags}
+}
+
+func (r *clientReporter) Capabilities() tally.Capabilities {
+	return r
+}
+
+func (r *clientReporter) Reporting() bool {
+	return true
+}
+
+func (r *clientReporter) Tagging() bool {
+	return r.tagFormat != NoTags
+}
+
+func (r *clientReporter) Flush() {
+	r.writer.flush()
+}
+
+func (r *clientReporter) Close() error {
+	return r.writer.close()
+}
+
+type cachedClientCounter struct {
+	metric clientMetric
+}
+
+func (c cachedClientCounter) ReportCount(value int64) {
+	c.metric.reportInt(value)
+}
+
+type cachedClientGauge struct {
+	metric clientMetric
+}
+
+func (g cachedClientGauge) ReportGauge(value float64) {
+	g.metric.reportFloat(value)
+}
+
+type cachedClientTimer struct {
+	metric clientMetric
+}
+
+func (t cachedClientTimer) ReportTimer(interval time.Duration) {
+	t.metric.reportFloat(durationMillis(interval))
+}
+
+type cachedClientHistogram struct {
+	reporter *clientReporter
+	name     string
+	tags     map[string]string
+}
+
+func (h cachedClientHistogram) ValueBucket(
+	bucketLowerBound, bucketUpperBound float64,
+) tally.CachedHistogramBucket {
+	return cachedClientCounter{h.reporter.newMetric(
+		valueBucketName(h.name, bucketLowerBound, bucketUpperBound, h.reporter.precision),
+		h.tags, "c")}
+}
+
+func (h cachedClientHistogram) DurationBucket(
+	bucketLowerBound, bucketUpperBound time.Duration,
+) tally.CachedHistogramBucket {
+	return cachedClientCounter{h.reporter.newMetric(
+		durationBucketName(h.name, bucketLowerBound, bucketUpperBound),
+		h.tags, "c")}
+}
+
+func (c cachedClientCounter) ReportSamples(value int64) {
+	c.metric.reportInt(value)
+}
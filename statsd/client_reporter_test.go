@@ -0,0 +1,154 @@
+// Copyright (c) 2022 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package statsd
+
+import (
+	"math"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	tally "github.com/uber-go/tally/v4"
+)
+
+func newTestClientReporter(t *testing.T, opts ClientOptions) (ClientReporter, func() string) {
+	conn, read := newTestPacketConn(t, "udp", "127.0.0.1:0")
+	opts.Address = conn.LocalAddr().String()
+	opts.OnError = func(err error) { require.NoError(t, err) }
+
+	r, err := NewClientReporter(opts)
+	require.NoError(t, err)
+	t.Cleanup(func() { r.Close() })
+	return r, read
+}
+
+func TestClientReporter(t *testing.T) {
+	r, read := newTestClientReporter(t, ClientOptions{
+		Prefix:    "svc",
+		TagFormat: DogStatsDTags,
+	})
+	assert.True(t, r.Capabilities().Tagging())
+
+	tags := map[string]string{"env": "prod"}
+	r.ReportCounter("requests", tags, 3)
+	r.ReportGauge("load", tags, 0.75)
+	r.ReportTimer("latency", tags, 1500*time.Microsecond)
+	r.ReportSet("users", nil, "alice")
+	r.ReportHistogramValueSamples("size", nil, nil, 0, 2.5, 4)
+	r.ReportHistogramDurationSamples("wait", nil, nil, 0, time.Second, 1)
+	r.Flush()
+
+	assert.Equal(t, "svc.requests:3|c|#env:prod\n"+
+		"svc.load:0.75|g|#env:prod\n"+
+		"svc.latency:1.5|ms|#env:prod\n"+
+		"svc.users:alice|s\n"+
+		"svc.size.0.000000-2.500000:4|c\n"+
+		"svc.wait.0s-1s:1|c", read())
+}
+
+func TestClientReporterCached(t *testing.T) {
+	r, read := newTestClientReporter(t, ClientOptions{
+		TagFormat:                    InfluxDBTags,
+		TimerType:                    DistributionTimerType,
+		SampleRate:                   1,
+		HistogramBucketNamePrecision: 1,
+	})
+
+	tags := map[string]string{"env": "prod"}
+	r.AllocateCounter("requests", tags).ReportCount(2)
+	r.AllocateGauge("load", tags).ReportGauge(1.25)
+	r.AllocateTimer("latency", tags).ReportTimer(2 * time.Millisecond)
+	histogram := r.AllocateHistogram("size", tags, nil)
+	histogram.ValueBucket(-math.MaxFloat64, 1).ReportSamples(5)
+	histogram.DurationBucket(time.Second, time.Duration(math.MaxInt64)).ReportSamples(6)
+	r.Flush()
+
+	assert.Equal(t, "requests,env=prod:2|c\n"+
+		"load,env=prod:1.25|g\n"+
+		"latency,env=prod:2|d\n"+
+		"size.-infinity-1.0,env=prod:5|c\n"+
+		"size.1s-infinity,env=prod:6|c", read())
+}
+
+func TestClientReporterSampleRate(t *testing.T) {
+	r, read := newTestClientReporter(t, ClientOptions{
+		TagFormat:  DogStatsDTags,
+		SampleRate: 0.5,
+	})
+
+	counter := r.AllocateCounter("requests", map[string]string{"env": "prod"})
+	for i := 0; i < 100; i++ {
+		counter.ReportCount(1)
+	}
+	r.Flush()
+
+	line := strings.SplitN(read(), "\n", 2)[0]
+	assert.Equal(t, "requests:1|c|@0.5|#env:prod", line)
+}
+
+func TestClientReporterCachedNoAllocations(t *testing.T) {
+	r, _ := newTestClientReporter(t, ClientOptions{TagFormat: DogStatsDTags})
+
+	tags := map[string]string{"env": "prod"}
+	counter := r.AllocateCounter("requests", tags)
+	gauge := r.AllocateGauge("load", tags)
+	timer := r.AllocateTimer("latency", tags)
+	bucket := r.AllocateHistogram("size", tags, nil).ValueBucket(0, 1)
+
+	allocs := testing.AllocsPerRun(1000, func() {
+		counter.ReportCount(1)
+		gauge.ReportGauge(0.5)
+		timer.ReportTimer(time.Millisecond)
+		bucket.ReportSamples(1)
+	})
+	assert.Equal(t, float64(0), allocs)
+}
+
+func TestClientReporterWithScope(t *testing.T) {
+	r, read := newTestClientReporter(t, ClientOptions{})
+	scope, closer := tally.NewRootScope(tally.ScopeOptions{
+		CachedReporter: r,
+	}, 0)
+
+	scope.Counter("requests").Inc(1)
+	scope.Gauge("load").Update(2.5)
+	require.NoError(t, closer.Close())
+
+	packet := read()
+	assert.Contains(t, packet, "requests:1|c")
+	assert.Contains(t, packet, "load:2.5|g")
+	assert.Equal(t, errAlreadyClosed, r.Close())
+}
+
+func BenchmarkClientReporterCachedCounter(b *testing.B) {
+	r, err := NewClientReporter(ClientOptions{TagFormat: DogStatsDTags})
+	require.NoError(b, err)
+	defer r.Close()
+
+	counter := r.AllocateCounter("requests", map[string]string{"env": "prod"})
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		counter.ReportCount(int64(i))
+	}
+}
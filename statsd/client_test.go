@@ -0,0 +1,93 @@
+// Copyright (c) 2022 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package statsd
+
+import (
+	"net"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestPacketConn(t *testing.T, network, address string) (net.PacketConn, func() string) {
+	conn, err := net.ListenPacket(network, address)
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+
+	buf := make([]byte, 65536)
+	return conn, func() string {
+		require.NoError(t, conn.SetReadDeadline(time.Now().Add(time.Second)))
+		n, _, err := conn.ReadFrom(buf)
+		require.NoError(t, err)
+		return string(buf[:n])
+	}
+}
+
+func TestPacketWriterBatches(t *testing.T) {
+	conn, read := newTestPacketConn(t, "udp", "127.0.0.1:0")
+	w, err := newPacketWriter("udp", conn.LocalAddr().String(), 20, time.Second,
+		func(err error) { require.NoError(t, err) })
+	require.NoError(t, err)
+
+	prefix, suffix := []byte("a:"), []byte("|c")
+	w.writeInt(prefix, 1, suffix)
+	w.writeInt(prefix, 2, suffix)
+	w.writeInt(prefix, 3, suffix)
+	w.writeInt(prefix, 4, suffix)
+
+	// The fourth line does not fit in 20 bytes with the first three.
+	assert.Equal(t, "a:1|c\na:2|c\na:3|c", read())
+
+	w.flush()
+	assert.Equal(t, "a:4|c", read())
+
+	// A line longer than the max packet size is sent on its own.
+	w.writeInt(prefix, 5, suffix)
+	w.writeString([]byte("long:"), strings.Repeat("x", 30), []byte("|s"))
+	assert.Equal(t, "a:5|c", read())
+	assert.Equal(t, "long:"+strings.Repeat("x", 30)+"|s", read())
+
+	require.NoError(t, w.close())
+	assert.Equal(t, errAlreadyClosed, w.close())
+}
+
+func TestPacketWriterUnixgram(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "dsd.socket")
+	_, read := newTestPacketConn(t, "unixgram", path)
+
+	w, err := newPacketWriter("unixgram", path, DefaultUDSMaxPacketSize,
+		time.Second, func(err error) { require.NoError(t, err) })
+	require.NoError(t, err)
+
+	w.writeFloat([]byte("load:"), 0.25, []byte("|g"))
+	w.flush()
+	assert.Equal(t, "load:0.25|g", read())
+	require.NoError(t, w.close())
+}
+
+func TestPacketWriterUnsupportedNetwork(t *testing.T) {
+	_, err := newPacketWriter("tcp", "127.0.0.1:8125", 1, 0, nil)
+	assert.Equal(t, errUnsupportedNetwork, err)
+}
@@ -21,13 +21,13 @@
 package statsd
 
 import (
-	"fmt"
 	"math"
 	"strconv"
 	"time"
 
 	"github.com/cactus/go-statsd-client/statsd"
 	tally "github.com/uber-go/tally/v4"
+	"github.com/uber-go/tally/v4/internal/cache"
 )
 
 const (
@@ -37,10 +37,52 @@ const (
 	DefaultHistogramBucketNamePrecision = uint(6)
 )
 
+// TimerType describes the statsd metric type timers are sent as.
+type TimerType int
+
+const (
+	// TimingTimerType sends timers as timings ("ms").
+	TimingTimerType TimerType = iota
+
+	// HistogramTimerType sends timers as DogStatsD histograms ("h").
+	HistogramTimerType
+
+	// DistributionTimerType sends timers as DogStatsD distributions ("d").
+	DistributionTimerType
+)
+
+func (t TimerType) String() string {
+	switch t {
+	case HistogramTimerType:
+		return "h"
+	case DistributionTimerType:
+		return "d"
+	}
+	return "ms"
+}
+
+// Reporter is a statsd backed tally reporter, with methods for reporting
+// the statsd metric types that have no tally equivalent.
+type Reporter interface {
+	tally.StatsReporter
+
+	// ReportDistribution reports a value of a DogStatsD distribution.
+	ReportDistribution(name string, tags map[string]string, value float64)
+
+	// ReportHistogram reports a value of a DogStatsD histogram.
+	ReportHistogram(name string, tags map[string]string, value float64)
+
+	// ReportSet reports a value of a set, counting unique values.
+	ReportSet(name string, tags map[string]string, value string)
+}
+
 type cactusStatsReporter struct {
-	statter    statsd.Statter
-	sampleRate float32
-	bucketFmt  string
+	statter     statsd.Statter
+	sampleRate  float32
+	precision   int
+	tagFormat   TagFormat
+	timerType   TimerType
+	tagSetCache *cache.TagSetCache
 }
 
 // Options is a set of options for the tally reporter.
@@ -53,11 +95,19 @@ type Options struct {
 	// formatting the metric name with the histogram bucket bound values.
 	// By default this will be set to the const DefaultHistogramBucketPrecision.
 	HistogramBucketNamePrecision uint
+
+	// TagFormat is how tags are encoded, by default tags are dropped.
+	TagFormat TagFormat
+
+	// TimerType is the statsd metric type timers are sent as, by default
+	// timings. The histogram and distribution types are only supported
+	// by DogStatsD.
+	TimerType TimerType
 }
 
 // NewReporter wraps a statsd.Statter for use with tally. Use either
 // statsd.NewClient or statsd.NewBufferedClient.
-func NewReporter(statsd statsd.Statter, opts Options) tally.StatsReporter {
+func NewReporter(statsd statsd.Statter, opts Options) Reporter {
 	var nilSampleRate float32
 	if opts.SampleRate == nilSampleRate {
 		opts.SampleRate = 1.0
@@ -66,22 +116,85 @@ func NewReporter(statsd statsd.Statter, opts Options) tally.StatsReporter {
 		opts.HistogramBucketNamePrecision = DefaultHistogramBucketNamePrecision
 	}
 	return &cactusStatsReporter{
-		statter:    statsd,
-		sampleRate: opts.SampleRate,
-		bucketFmt:  "%." + strconv.Itoa(int(opts.HistogramBucketNamePrecision)) + "f",
+		statter:     statsd,
+		sampleRate:  opts.SampleRate,
+		precision:   int(opts.HistogramBucketNamePrecision),
+		tagFormat:   opts.TagFormat,
+		timerType:   opts.TimerType,
+		tagSetCache: cache.NewTagSetCache(),
 	}
 }
 
 func (r *cactusStatsReporter) ReportCounter(name string, tags map[string]string, value int64) {
-	r.statter.Inc(name, value, r.sampleRate)
+	if r.tagFormat == NoTags {
+		r.statter.Inc(name, value, r.sampleRate)
+		return
+	}
+	r.submit(name, tags, strconv.FormatInt(value, 10), "c")
 }
 
 func (r *cactusStatsReporter) ReportGauge(name string, tags map[string]string, value float64) {
-	r.statter.Gauge(name, int64(value), r.sampleRate)
+	if r.tagFormat == NoTags {
+		r.statter.Gauge(name, int64(value), r.sampleRate)
+		return
+	}
+	r.submit(name, tags, strconv.FormatFloat(value, 'f', -1, 64), "g")
 }
 
 func (r *cactusStatsReporter) ReportTimer(name string, tags map[string]string, interval time.Duration) {
-	r.statter.TimingDuration(name, interval, r.sampleRate)
+	if r.tagFormat == NoTags && r.timerType == TimingTimerType {
+		r.statter.TimingDuration(name, interval, r.sampleRate)
+		return
+	}
+	r.submit(name, tags, strconv.FormatFloat(durationMillis(interval), 'f', -1, 64), r.timerType.String())
+}
+
+func (r *cactusStatsReporter) ReportDistribution(name string, tags map[string]string, value float64) {
+	r.submit(name, tags, strconv.FormatFloat(value, 'f', -1, 64), "d")
+}
+
+func (r *cactusStatsReporter) ReportHistogram(name string, tags map[string]string, value float64) {
+	r.submit(name, tags, strconv.FormatFloat(value, 'f', -1, 64), "h")
+}
+
+func (r *cactusStatsReporter) ReportSet(name string, tags map[string]string, value string) {
+	r.submit(name, tags, value, "s")
+}
+
+// submit sends a preformatted value with the tags encoded according to
+// the tag format.
+func (r *cactusStatsReporter) submit(
+	name string,
+	tags map[string]string,
+	value string,
+	metricType string,
+) {
+	encoded := r.encodedTags(tags)
+	if r.tagFormat.lineSuffix() {
+		// The sample rate must precede the tags, so sample here rather
+		// than have the client append the rate after them.
+		if r.sampleRate < 1 {
+			if !statsd.DefaultSampler(r.sampleRate) {
+				return
+			}
+			metricType += "|@" + strconv.FormatFloat(float64(r.sampleRate), 'f', -1, 32)
+		}
+		r.statter.Raw(name, value+"|"+metricType+encoded, 1)
+		return
+	}
+	r.statter.Raw(name+encoded, value+"|"+metricType, r.sampleRate)
+}
+
+func (r *cactusStatsReporter) encodedTags(tags map[string]string) string {
+	if r.tagFormat == NoTags || len(tags) == 0 {
+		return ""
+	}
+
+	key := cache.TagMapKey(tags)
+	if encoded, ok := r.tagSetCache.Get(key); ok {
+		return encoded
+	}
+	return r.tagSetCache.Set(key, encodeTags(r.tagFormat, tags))
 }
 
 func (r *cactusStatsReporter) ReportHistogramValueSamples(
@@ -92,11 +205,9 @@ func (r *cactusStatsReporter) ReportHistogramValueSamples(
 	bucketUpperBound float64,
 	samples int64,
 ) {
-	r.statter.Inc(
-		fmt.Sprintf("%s.%s-%s", name,
-			r.valueBucketString(bucketLowerBound),
-			r.valueBucketString(bucketUpperBound)),
-		samples, r.sampleRate)
+	r.ReportCounter(
+		valueBucketName(name, bucketLowerBound, bucketUpperBound, r.precision),
+		tags, samples)
 }
 
 func (r *cactusStatsReporter) ReportHistogramDurationSamples(
@@ -107,35 +218,46 @@ func (r *cactusStatsReporter) ReportHistogramDurationSamples(
 	bucketUpperBound time.Duration,
 	samples int64,
 ) {
-	r.statter.Inc(
-		fmt.Sprintf("%s.%s-%s", name,
-			r.durationBucketString(bucketLowerBound),
-			r.durationBucketString(bucketUpperBound)),
-		samples, r.sampleRate)
+	r.ReportCounter(
+		durationBucketName(name, bucketLowerBound, bucketUpperBound),
+		tags, samples)
+}
+
+// valueBucketName returns the name of the counter of a value bucket.
+func valueBucketName(name string, lower, upper float64, precision int) string {
+	return name + "." + valueBucketString(lower, precision) +
+		"-" + valueBucketString(upper, precision)
 }
 
-func (r *cactusStatsReporter) valueBucketString(
-	upperBound float64,
-) string {
-	if upperBound == math.MaxFloat64 {
+func valueBucketString(bound float64, precision int) string {
+	if bound == math.MaxFloat64 {
 		return "infinity"
 	}
-	if upperBound == -math.MaxFloat64 {
+	if bound == -math.MaxFloat64 {
 		return "-infinity"
 	}
-	return fmt.Sprintf(r.bucketFmt, upperBound)
+	return strconv.FormatFloat(bound, 'f', precision, 64)
+}
+
+// durationBucketName returns the name of the counter of a duration bucket.
+func durationBucketName(name string, lower, upper time.Duration) string {
+	return name + "." + durationBucketString(lower) +
+		"-" + durationBucketString(upper)
 }
 
-func (r *cactusStatsReporter) durationBucketString(
-	upperBound time.Duration,
-) string {
-	if upperBound == time.Duration(math.MaxInt64) {
+func durationBucketString(bound time.Duration) string {
+	if bound == time.Duration(math.MaxInt64) {
 		return "infinity"
 	}
-	if upperBound == time.Duration(math.MinInt64) {
+	if bound == time.Duration(math.MinInt64) {
 		return "-infinity"
 	}
-	return upperBound.String()
+	return bound.String()
+}
+
+// durationMillis returns a duration in fractional milliseconds.
+func durationMillis(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
 }
 
 func (r *cactusStatsReporter) Capabilities() tally.Capabilities {
@@ -147,7 +269,7 @@ func (r *cactusStatsReporter) Reporting() bool {
 }
 
 func (r *cactusStatsReporter) Tagging() bool {
-	return false
+	return r.tagFormat != NoTags
 }
 
 func (r *cactusStatsReporter) Flush() {
@@ -21,9 +21,13 @@
 package statsd
 
 import (
+	"net"
 	"testing"
+	"time"
 
+	"github.com/cactus/go-statsd-client/statsd"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func TestCapabilities(t *testing.T) {
@@ -31,3 +35,111 @@ func TestCapabilities(t *testing.T) {
 	assert.True(t, r.Capabilities().Reporting())
 	assert.False(t, r.Capabilities().Tagging())
 }
+
+func newTestStatter(t *testing.T) (statsd.Statter, func() string) {
+	statter, read, _ := newTestStatterWithTimeout(t, time.Second)
+	return statter, read
+}
+
+func newTestStatterWithTimeout(
+	t *testing.T,
+	timeout time.Duration,
+) (statsd.Statter, func() string, func() string) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+
+	statter, err := statsd.NewClientWithConfig(&statsd.ClientConfig{
+		Address: conn.LocalAddr().String(),
+	})
+	require.NoError(t, err)
+	t.Cleanup(func() { statter.Close() })
+
+	buf := make([]byte, 1024)
+	read := func() string {
+		require.NoError(t, conn.SetReadDeadline(time.Now().Add(timeout)))
+		n, _, err := conn.ReadFrom(buf)
+		require.NoError(t, err)
+		return string(buf[:n])
+	}
+	readAvailable := func() string {
+		require.NoError(t, conn.SetReadDeadline(time.Now().Add(timeout)))
+		n, _, err := conn.ReadFrom(buf)
+		if err != nil {
+			return ""
+		}
+		return string(buf[:n])
+	}
+	return statter, read, readAvailable
+}
+
+func TestReporterTagFormats(t *testing.T) {
+	tags := map[string]string{"region": "us:east", "env": "prod"}
+
+	tests := []struct {
+		format   TagFormat
+		expected string
+	}{
+		{NoTags, "requests:3|c"},
+		{DogStatsDTags, "requests:3|c|#env:prod,region:us_east"},
+		{InfluxDBTags, "requests,env=prod,region=us_east:3|c"},
+		{SignalFxTags, "requests[env=prod,region=us_east]:3|c"},
+		{LibratoTags, "requests#env=prod,region=us_east:3|c"},
+		{GraphiteTags, "requests;env=prod;region=us_east:3|c"},
+	}
+	for _, tt := range tests {
+		statter, read := newTestStatter(t)
+		r := NewReporter(statter, Options{TagFormat: tt.format})
+		assert.Equal(t, tt.format != NoTags, r.Capabilities().Tagging())
+
+		r.ReportCounter("requests", tags, 3)
+		assert.Equal(t, tt.expected, read())
+
+		// Encoded tags are cached, the second report must be identical.
+		r.ReportCounter("requests", tags, 3)
+		assert.Equal(t, tt.expected, read())
+	}
+}
+
+func TestReporterTaggedTypes(t *testing.T) {
+	tags := map[string]string{"env": "prod"}
+	statter, read := newTestStatter(t)
+	r := NewReporter(statter, Options{
+		TagFormat: DogStatsDTags,
+		TimerType: DistributionTimerType,
+	})
+
+	r.ReportGauge("load", tags, 1.5)
+	assert.Equal(t, "load:1.5|g|#env:prod", read())
+
+	r.ReportTimer("latency", tags, 1500*time.Microsecond)
+	assert.Equal(t, "latency:1.5|d|#env:prod", read())
+
+	r.ReportDistribution("size", tags, 42)
+	assert.Equal(t, "size:42|d|#env:prod", read())
+
+	r.ReportHistogram("size", tags, 7)
+	assert.Equal(t, "size:7|h|#env:prod", read())
+
+	r.ReportSet("users", tags, "alice")
+	assert.Equal(t, "users:alice|s|#env:prod", read())
+
+	r.ReportHistogramValueSamples("hist", tags, nil, 0, 10, 2)
+	assert.Equal(t, "hist.0.000000-10.000000:2|c|#env:prod", read())
+}
+
+func TestReporterSampleRateWithTags(t *testing.T) {
+	statter, _, readAvailable := newTestStatterWithTimeout(t, 10*time.Millisecond)
+	r := NewReporter(statter, Options{
+		TagFormat:  DogStatsDTags,
+		SampleRate: 0.5,
+	})
+
+	// Sampled out lines are never sent, report until one is.
+	var line string
+	for i := 0; i < 100 && line == ""; i++ {
+		r.ReportSet("users", map[string]string{"env": "prod"}, "bob")
+		line = readAvailable()
+	}
+	assert.Equal(t, "users:bob|s|@0.5|#env:prod", line)
+}
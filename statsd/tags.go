@@ -0,0 +1,114 @@
+// Copyright (c) 2022 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package statsd
+
+import (
+	"sort"
+	"strings"
+)
+
+// TagFormat describes how tags are encoded in statsd lines.
+type TagFormat int
+
+const (
+	// NoTags drops all tags, as the original statsd protocol has no
+	// support for them.
+	NoTags TagFormat = iota
+
+	// DogStatsDTags encodes tags the DogStatsD way, as a suffix of the
+	// line: "name:1|c|#k1:v1,k2:v2".
+	DogStatsDTags
+
+	// InfluxDBTags encodes tags the way the InfluxDB (Telegraf) statsd
+	// input expects: "name,k1=v1,k2=v2:1|c".
+	InfluxDBTags
+
+	// SignalFxTags encodes tags as SignalFx dimensions:
+	// "name[k1=v1,k2=v2]:1|c".
+	SignalFxTags
+
+	// LibratoTags encodes tags the way the Librato statsd backend
+	// expects: "name#k1=v1,k2=v2:1|c".
+	LibratoTags
+
+	// GraphiteTags encodes tags with the Graphite 1.1 tagged series
+	// syntax: "name;k1=v1;k2=v2:1|c".
+	GraphiteTags
+)
+
+// tagReplacer replaces characters that delimit names, values, types and
+// tags in any of the formats.
+var tagReplacer = strings.NewReplacer(
+	":", "_", "|", "_", "@", "_", "#", "_", ",", "_",
+	"=", "_", ";", "_", "[", "_", "]", "_", " ", "_", "\n", "_",
+)
+
+// lineSuffix returns whether tags are a suffix of the line rather than
+// of the metric name.
+func (f TagFormat) lineSuffix() bool {
+	return f == DogStatsDTags
+}
+
+// encodeTags encodes tags in the given format, sorted by key so that
+// the same tags always result in the same series.
+func encodeTags(format TagFormat, tags map[string]string) string {
+	if format == NoTags || len(tags) == 0 {
+		return ""
+	}
+
+	keys := make([]string, 0, len(tags))
+	for k := range tags {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var (
+		sb       strings.Builder
+		start    string
+		pairSep  string
+		valueSep = "="
+		end      string
+	)
+	switch format {
+	case DogStatsDTags:
+		start, pairSep, valueSep = "|#", ",", ":"
+	case InfluxDBTags:
+		start, pairSep = ",", ","
+	case SignalFxTags:
+		start, pairSep, end = "[", ",", "]"
+	case LibratoTags:
+		start, pairSep = "#", ","
+	case GraphiteTags:
+		start, pairSep = ";", ";"
+	}
+
+	sb.WriteString(start)
+	for i, k := range keys {
+		if i > 0 {
+			sb.WriteString(pairSep)
+		}
+		sb.WriteString(tagReplacer.Replace(k))
+		sb.WriteString(valueSep)
+		sb.WriteString(tagReplacer.Replace(tags[k]))
+	}
+	sb.WriteString(end)
+	return sb.String()
+}
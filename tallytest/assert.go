@@ -0,0 +1,471 @@
+// Copyright (c) 2022 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+// Package tallytest provides assertions over tally snapshots for use in
+// unit tests.
+package tallytest
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	tally "github.com/uber-go/tally/v4"
+)
+
+const (
+	_maxCloseMatches = 5
+)
+
+// TestingT is the subset of testing.TB used by the assertions.
+type TestingT interface {
+	Errorf(format string, args ...interface{})
+}
+
+type tHelper interface {
+	Helper()
+}
+
+type metricKind string
+
+const (
+	counterKind   metricKind = "counter"
+	gaugeKind     metricKind = "gauge"
+	timerKind     metricKind = "timer"
+	histogramKind metricKind = "histogram"
+)
+
+// series is the name and tags of a single snapshot entry.
+type series struct {
+	kind metricKind
+	name string
+	tags map[string]string
+}
+
+func (s series) String() string {
+	return s.name + formatTags(s.tags)
+}
+
+// AssertCounter asserts that the counter with the given name and tags has
+// the expected value in the snapshot of s.
+func AssertCounter(
+	t TestingT,
+	s tally.Snapshotter,
+	name string,
+	tags map[string]string,
+	expected int64,
+) bool {
+	if h, ok := t.(tHelper); ok {
+		h.Helper()
+	}
+
+	snap := s.Snapshot()
+	c, ok := findCounter(snap, name, tags)
+	if !ok {
+		return notFound(t, snap, counterKind, name, tags)
+	}
+	if c.Value() != expected {
+		t.Errorf("counter %s: expected %d, actual %d",
+			series{name: name, tags: tags}, expected, c.Value())
+		return false
+	}
+	return true
+}
+
+// AssertGauge asserts that the gauge with the given name and tags has
+// the expected value in the snapshot of s.
+func AssertGauge(
+	t TestingT,
+	s tally.Snapshotter,
+	name string,
+	tags map[string]string,
+	expected float64,
+) bool {
+	if h, ok := t.(tHelper); ok {
+		h.Helper()
+	}
+
+	snap := s.Snapshot()
+	g, ok := findGauge(snap, name, tags)
+	if !ok {
+		return notFound(t, snap, gaugeKind, name, tags)
+	}
+	if g.Value() != expected {
+		t.Errorf("gauge %s: expected %v, actual %v",
+			series{name: name, tags: tags}, expected, g.Value())
+		return false
+	}
+	return true
+}
+
+// AssertTimerCount asserts that the timer with the given name and tags
+// has recorded the expected number of values in the snapshot of s.
+func AssertTimerCount(
+	t TestingT,
+	s tally.Snapshotter,
+	name string,
+	tags map[string]string,
+	expected int,
+) bool {
+	if h, ok := t.(tHelper); ok {
+		h.Helper()
+	}
+
+	snap := s.Snapshot()
+	tm, ok := findTimer(snap, name, tags)
+	if !ok {
+		return notFound(t, snap, timerKind, name, tags)
+	}
+	if n := len(tm.Values()); n != expected {
+		t.Errorf("timer %s: expected %d values, actual %d",
+			series{name: name, tags: tags}, expected, n)
+		return false
+	}
+	return true
+}
+
+// AssertHistogramBucket asserts that the bucket with the given upper bound
+// of the value histogram with the given name and tags has the expected
+// number of samples in the snapshot of s.
+func AssertHistogramBucket(
+	t TestingT,
+	s tally.Snapshotter,
+	name string,
+	tags map[string]string,
+	upperBound float64,
+	expected int64,
+) bool {
+	if h, ok := t.(tHelper); ok {
+		h.Helper()
+	}
+
+	snap := s.Snapshot()
+	hs, ok := findHistogram(snap, name, tags)
+	if !ok {
+		return notFound(t, snap, histogramKind, name, tags)
+	}
+	actual, ok := hs.Values()[upperBound]
+	if !ok {
+		t.Errorf("histogram %s: no value bucket with upper bound %v, buckets: %v",
+			series{name: name, tags: tags}, upperBound, valueBounds(hs.Values()))
+		return false
+	}
+	if actual != expected {
+		t.Errorf("histogram %s: bucket %v: expected %d samples, actual %d",
+			series{name: name, tags: tags}, upperBound, expected, actual)
+		return false
+	}
+	return true
+}
+
+// AssertHistogramDurationBucket asserts that the bucket with the given upper
+// bound of the duration histogram with the given name and tags has the
+// expected number of samples in the snapshot of s.
+func AssertHistogramDurationBucket(
+	t TestingT,
+	s tally.Snapshotter,
+	name string,
+	tags map[string]string,
+	upperBound time.Duration,
+	expected int64,
+) bool {
+	if h, ok := t.(tHelper); ok {
+		h.Helper()
+	}
+
+	snap := s.Snapshot()
+	hs, ok := findHistogram(snap, name, tags)
+	if !ok {
+		return notFound(t, snap, histogramKind, name, tags)
+	}
+	actual, ok := hs.Durations()[upperBound]
+	if !ok {
+		t.Errorf("histogram %s: no duration bucket with upper bound %v, buckets: %v",
+			series{name: name, tags: tags}, upperBound, durationBounds(hs.Durations()))
+		return false
+	}
+	if actual != expected {
+		t.Errorf("histogram %s: bucket %v: expected %d samples, actual %d",
+			series{name: name, tags: tags}, upperBound, expected, actual)
+		return false
+	}
+	return true
+}
+
+// AssertNoMetric asserts that no metric of any type with the given name and
+// tags exists in the snapshot of s. If tags is nil then no metric with the
+// given name may exist regardless of its tags.
+func AssertNoMetric(
+	t TestingT,
+	s tally.Snapshotter,
+	name string,
+	tags map[string]string,
+) bool {
+	if h, ok := t.(tHelper); ok {
+		h.Helper()
+	}
+
+	var found []string
+	for _, ser := range allSeries(s.Snapshot()) {
+		if ser.name != name {
+			continue
+		}
+		if tags != nil && !tagsEqual(ser.tags, tags) {
+			continue
+		}
+		found = append(found, fmt.Sprintf("%s %s", ser.kind, ser))
+	}
+
+	if len(found) > 0 {
+		sort.Strings(found)
+		t.Errorf("expected no metric %s, found: %s",
+			series{name: name, tags: tags}, strings.Join(found, ", "))
+		return false
+	}
+	return true
+}
+
+func findCounter(
+	snap tally.Snapshot,
+	name string,
+	tags map[string]string,
+) (tally.CounterSnapshot, bool) {
+	for _, c := range snap.Counters() {
+		if c.Name() == name && tagsEqual(c.Tags(), tags) {
+			return c, true
+		}
+	}
+	return nil, false
+}
+
+func findGauge(
+	snap tally.Snapshot,
+	name string,
+	tags map[string]string,
+) (tally.GaugeSnapshot, bool) {
+	for _, g := range snap.Gauges() {
+		if g.Name() == name && tagsEqual(g.Tags(), tags) {
+			return g, true
+		}
+	}
+	return nil, false
+}
+
+func findTimer(
+	snap tally.Snapshot,
+	name string,
+	tags map[string]string,
+) (tally.TimerSnapshot, bool) {
+	for _, tm := range snap.Timers() {
+		if tm.Name() == name && tagsEqual(tm.Tags(), tags) {
+			return tm, true
+		}
+	}
+	return nil, false
+}
+
+func findHistogram(
+	snap tally.Snapshot,
+	name string,
+	tags map[string]string,
+) (tally.HistogramSnapshot, bool) {
+	for _, h := range snap.Histograms() {
+		if h.Name() == name && tagsEqual(h.Tags(), tags) {
+			return h, true
+		}
+	}
+	return nil, false
+}
+
+func allSeries(snap tally.Snapshot) []series {
+	var result []series
+	for _, c := range snap.Counters() {
+		result = append(result, series{counterKind, c.Name(), c.Tags()})
+	}
+	for _, g := range snap.Gauges() {
+		result = append(result, series{gaugeKind, g.Name(), g.Tags()})
+	}
+	for _, tm := range snap.Timers() {
+		result = append(result, series{timerKind, tm.Name(), tm.Tags()})
+	}
+	for _, h := range snap.Histograms() {
+		result = append(result, series{histogramKind, h.Name(), h.Tags()})
+	}
+	return result
+}
+
+// notFound reports a missing metric along with the closest matching series
+// of the same type to help spot mistyped names and tags.
+func notFound(
+	t TestingT,
+	snap tally.Snapshot,
+	kind metricKind,
+	name string,
+	tags map[string]string,
+) bool {
+	if h, ok := t.(tHelper); ok {
+		h.Helper()
+	}
+
+	want := series{kind: kind, name: name, tags: tags}
+	matches := closeMatches(snap, want)
+	if len(matches) == 0 {
+		t.Errorf("%s %s not found", kind, want)
+		return false
+	}
+
+	t.Errorf("%s %s not found, close matches:\n\t%s",
+		kind, want, strings.Join(matches, "\n\t"))
+	return false
+}
+
+func closeMatches(snap tally.Snapshot, want series) []string {
+	type candidate struct {
+		desc     string
+		distance int
+	}
+
+	var (
+		maxDistance = len(want.name)/3 + 2
+		candidates  []candidate
+	)
+	for _, ser := range allSeries(snap) {
+		if ser.kind != want.kind {
+			continue
+		}
+
+		d := levenshtein(ser.name, want.name) + tagsDistance(ser.tags, want.tags)
+		if ser.name != want.name && levenshtein(ser.name, want.name) > maxDistance {
+			continue
+		}
+		candidates = append(candidates, candidate{desc: ser.String(), distance: d})
+	}
+
+	sort.Slice(candidates, func(i, j int) bool {
+		if candidates[i].distance != candidates[j].distance {
+			return candidates[i].distance < candidates[j].distance
+		}
+		return candidates[i].desc < candidates[j].desc
+	})
+	if len(candidates) > _maxCloseMatches {
+		candidates = candidates[:_maxCloseMatches]
+	}
+
+	result := make([]string, 0, len(candidates))
+	for _, c := range candidates {
+		result = append(result, c.desc)
+	}
+	return result
+}
+
+// tagsDistance returns the number of tags that differ between a and b.
+func tagsDistance(a, b map[string]string) int {
+	d := 0
+	for k, v := range a {
+		if bv, ok := b[k]; !ok || bv != v {
+			d++
+		}
+	}
+	for k := range b {
+		if _, ok := a[k]; !ok {
+			d++
+		}
+	}
+	return d
+}
+
+func tagsEqual(a, b map[string]string) bool {
+	return len(a) == len(b) && tagsDistance(a, b) == 0
+}
+
+func levenshtein(a, b string) int {
+	prev := make([]int, len(b)+1)
+	curr := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+
+	for i := 1; i <= len(a); i++ {
+		curr[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			curr[j] = minInt(prev[j]+1, curr[j-1]+1, prev[j-1]+cost)
+		}
+		prev, curr = curr, prev
+	}
+	return prev[len(b)]
+}
+
+func minInt(values ...int) int {
+	m := values[0]
+	for _, v := range values[1:] {
+		if v < m {
+			m = v
+		}
+	}
+	return m
+}
+
+func formatTags(tags map[string]string) string {
+	if len(tags) == 0 {
+		return "{}"
+	}
+
+	keys := make([]string, 0, len(tags))
+	for k := range tags {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var b strings.Builder
+	b.WriteByte('{')
+	for i, k := range keys {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		b.WriteString(k)
+		b.WriteByte('=')
+		b.WriteString(tags[k])
+	}
+	b.WriteByte('}')
+	return b.String()
+}
+
+func valueBounds(values map[float64]int64) []float64 {
+	bounds := make([]float64, 0, len(values))
+	for b := range values {
+		bounds = append(bounds, b)
+	}
+	sort.Float64s(bounds)
+	return bounds
+}
+
+func durationBounds(durations map[time.Duration]int64) []time.Duration {
+	bounds := make([]time.Duration, 0, len(durations))
+	for b := range durations {
+		bounds = append(bounds, b)
+	}
+	sort.Slice(bounds, func(i, j int) bool { return bounds[i] < bounds[j] })
+	return bounds
+}
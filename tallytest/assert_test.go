@@ -0,0 +1,185 @@
+// Copyright (c) 2022 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package tallytest
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	tally "github.com/uber-go/tally/v4"
+)
+
+type recordingT struct {
+	errors []string
+}
+
+func (t *recordingT) Errorf(format string, args ...interface{}) {
+	t.errors = append(t.errors, fmt.Sprintf(format, args...))
+}
+
+func newPopulatedScope() tally.TestScope {
+	s := tally.NewTestScope("svc", map[string]string{"env": "test"})
+	s.Counter("requests").Inc(3)
+	s.Tagged(map[string]string{"region": "east"}).Counter("requests").Inc(2)
+	s.Gauge("queue").Update(4.5)
+	s.Timer("latency").Record(time.Millisecond)
+	s.Timer("latency").Record(time.Second)
+	s.Histogram("sizes", tally.ValueBuckets{1, 10}).RecordValue(5)
+	s.Histogram("waits", tally.DurationBuckets{time.Second}).RecordDuration(time.Millisecond)
+	return s
+}
+
+func TestAssertionsPass(t *testing.T) {
+	var (
+		s    = newPopulatedScope()
+		tags = map[string]string{"env": "test"}
+		rt   = &recordingT{}
+	)
+
+	assert.True(t, AssertCounter(rt, s, "svc.requests", tags, 3))
+	assert.True(t, AssertCounter(rt, s, "svc.requests",
+		map[string]string{"env": "test", "region": "east"}, 2))
+	assert.True(t, AssertGauge(rt, s, "svc.queue", tags, 4.5))
+	assert.True(t, AssertTimerCount(rt, s, "svc.latency", tags, 2))
+	assert.True(t, AssertHistogramBucket(rt, s, "svc.sizes", tags, 10, 1))
+	assert.True(t, AssertHistogramDurationBucket(rt, s, "svc.waits", tags, time.Second, 1))
+	assert.True(t, AssertNoMetric(rt, s, "svc.missing", nil))
+	assert.True(t, AssertNoMetric(rt, s, "svc.requests",
+		map[string]string{"env": "prod"}))
+	assert.Empty(t, rt.errors)
+}
+
+func TestAssertionsFail(t *testing.T) {
+	var (
+		s    = newPopulatedScope()
+		tags = map[string]string{"env": "test"}
+	)
+
+	tests := []struct {
+		name     string
+		assert   func(t TestingT) bool
+		contains []string
+	}{
+		{
+			name: "counter value",
+			assert: func(t TestingT) bool {
+				return AssertCounter(t, s, "svc.requests", tags, 4)
+			},
+			contains: []string{"counter svc.requests{env=test}: expected 4, actual 3"},
+		},
+		{
+			name: "mistyped counter name",
+			assert: func(t TestingT) bool {
+				return AssertCounter(t, s, "svc.request", tags, 3)
+			},
+			contains: []string{
+				"counter svc.request{env=test} not found, close matches:",
+				"\tsvc.requests{env=test}\n\tsvc.requests{env=test,region=east}",
+			},
+		},
+		{
+			name: "mistyped tags",
+			assert: func(t TestingT) bool {
+				return AssertGauge(t, s, "svc.queue", map[string]string{"env": "tset"}, 4.5)
+			},
+			contains: []string{"close matches:\n\tsvc.queue{env=test}"},
+		},
+		{
+			name: "no close matches",
+			assert: func(t TestingT) bool {
+				return AssertTimerCount(t, s, "unrelated", tags, 1)
+			},
+			contains: []string{"timer unrelated{env=test} not found"},
+		},
+		{
+			name: "timer count",
+			assert: func(t TestingT) bool {
+				return AssertTimerCount(t, s, "svc.latency", tags, 1)
+			},
+			contains: []string{"expected 1 values, actual 2"},
+		},
+		{
+			name: "histogram missing bucket",
+			assert: func(t TestingT) bool {
+				return AssertHistogramBucket(t, s, "svc.sizes", tags, 5, 1)
+			},
+			contains: []string{"no value bucket with upper bound 5"},
+		},
+		{
+			name: "histogram bucket samples",
+			assert: func(t TestingT) bool {
+				return AssertHistogramDurationBucket(t, s, "svc.waits", tags, time.Second, 2)
+			},
+			contains: []string{"bucket 1s: expected 2 samples, actual 1"},
+		},
+		{
+			name: "metric exists",
+			assert: func(t TestingT) bool {
+				return AssertNoMetric(t, s, "svc.requests", nil)
+			},
+			contains: []string{
+				"found: counter svc.requests{env=test,region=east}, " +
+					"counter svc.requests{env=test}",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rt := &recordingT{}
+			assert.False(t, tt.assert(rt))
+			require.Len(t, rt.errors, 1)
+			for _, c := range tt.contains {
+				assert.Contains(t, rt.errors[0], c)
+			}
+		})
+	}
+}
+
+func TestSnapshotDiff(t *testing.T) {
+	s := tally.NewTestScope("", nil)
+	s.Counter("a").Inc(1)
+	s.Gauge("b").Update(1)
+	before := s.Snapshot()
+
+	s.Counter("a").Inc(2)
+	s.Counter("c").Inc(1)
+	after := s.Snapshot()
+
+	assert.Equal(t, "", SnapshotDiff(before, before))
+	assert.Equal(t,
+		"~ counter a{} +2\n+ counter c{} +1",
+		SnapshotDiff(before, after))
+
+	rt := &recordingT{}
+	assert.True(t, AssertCounterDelta(rt, before, after, "a", nil, 2))
+	assert.True(t, AssertCounterDelta(rt, before, after, "c", nil, 1))
+	assert.Empty(t, rt.errors)
+
+	assert.False(t, AssertCounterDelta(rt, before, after, "a", nil, 1))
+	assert.False(t, AssertSnapshotsEqual(rt, before, after))
+	require.Len(t, rt.errors, 2)
+	assert.Contains(t, rt.errors[0], "expected delta 1, actual 2 (1 -> 3)")
+	assert.Contains(t, rt.errors[1], "snapshots differ:\n~ counter a{} +2")
+}
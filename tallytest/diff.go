@@ -0,0 +1,80 @@
+// Copyright (c) 2022 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package tallytest
+
+import (
+	tally "github.com/uber-go/tally/v4"
+)
+
+// SnapshotDiff returns a human readable description of the series that were
+// added, removed or changed between before and after, one per line, as
+// formatted by tally.Diff. It returns an empty string if the snapshots hold
+// the same values.
+func SnapshotDiff(before, after tally.Snapshot) string {
+	return tally.Diff(before, after).String()
+}
+
+// AssertSnapshotsEqual asserts that expected and actual hold the same series
+// and values, failing with a diff of the two otherwise.
+func AssertSnapshotsEqual(t TestingT, expected, actual tally.Snapshot) bool {
+	if h, ok := t.(tHelper); ok {
+		h.Helper()
+	}
+
+	if diff := SnapshotDiff(expected, actual); diff != "" {
+		t.Errorf("snapshots differ:\n%s", diff)
+		return false
+	}
+	return true
+}
+
+// AssertCounterDelta asserts that the counter with the given name and tags
+// changed by the expected delta between the before and after snapshots.
+// A counter missing from before is treated as zero.
+func AssertCounterDelta(
+	t TestingT,
+	before tally.Snapshot,
+	after tally.Snapshot,
+	name string,
+	tags map[string]string,
+	expected int64,
+) bool {
+	if h, ok := t.(tHelper); ok {
+		h.Helper()
+	}
+
+	a, ok := findCounter(after, name, tags)
+	if !ok {
+		return notFound(t, after, counterKind, name, tags)
+	}
+
+	var prev int64
+	if b, ok := findCounter(before, name, tags); ok {
+		prev = b.Value()
+	}
+
+	if delta := a.Value() - prev; delta != expected {
+		t.Errorf("counter %s: expected delta %d, actual %d (%d -> %d)",
+			series{name: name, tags: tags}, expected, delta, prev, a.Value())
+		return false
+	}
+	return true
+}